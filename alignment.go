@@ -0,0 +1,111 @@
+package apkparser
+
+import (
+	"archive/zip"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Result of the alignment checks of ZipReader.CheckAlignment, the lists are sorted.
+type AlignmentReport struct {
+	// android:extractNativeLibs of the <application>, true if not set. When false, the native
+	// libraries are loaded directly from the APK and must be stored uncompressed and page aligned.
+	ExtractNativeLibs bool
+
+	// Uncompressed entries whose data doesn't start at a 4-byte boundary, as zipalign requires.
+	Misaligned []string
+	// Uncompressed .so files in lib/ whose data doesn't start at a 4 KiB page boundary.
+	Not4kAligned []string
+	// Uncompressed .so files in lib/ whose data doesn't start at a 16 KiB boundary, they can't be loaded
+	// from the APK on devices with 16 KiB pages.
+	Not16kAligned []string
+	// Compressed .so files in lib/.
+	CompressedLibs []string
+}
+
+// Returns true if the APK passes the checks of zipalign and, when the native libraries are not extracted,
+// of the installer for 4 KiB page devices.
+func (r *AlignmentReport) Ok() bool {
+	if len(r.Misaligned) != 0 {
+		return false
+	}
+	return r.ExtractNativeLibs || (len(r.Not4kAligned) == 0 && len(r.CompressedLibs) == 0)
+}
+
+// Same as Ok, but for devices with 16 KiB pages.
+func (r *AlignmentReport) Ok16k() bool {
+	return r.Ok() && (r.ExtractNativeLibs || len(r.Not16kAligned) == 0)
+}
+
+// Checks alignment of the entries, with extractNativeLibs from the manifest. Only the entries Android
+// installs are checked in case of duplicate names.
+func (zr *ZipReader) CheckAlignment(extractNativeLibs bool) *AlignmentReport {
+	res := &AlignmentReport{ExtractNativeLibs: extractNativeLibs}
+	for name, f := range zr.File {
+		if f.IsDir {
+			continue
+		}
+
+		e, err := installedEntry(f)
+		if err != nil {
+			continue
+		}
+
+		isLib := strings.HasPrefix(name, "lib/") && path.Ext(name) == ".so"
+		if e.Method != zip.Store {
+			if isLib {
+				res.CompressedLibs = append(res.CompressedLibs, name)
+			}
+			continue
+		}
+
+		if e.Offset%4 != 0 {
+			res.Misaligned = append(res.Misaligned, name)
+		}
+		if isLib && e.Offset%4096 != 0 {
+			res.Not4kAligned = append(res.Not4kAligned, name)
+		}
+		if isLib && e.Offset%16384 != 0 {
+			res.Not16kAligned = append(res.Not16kAligned, name)
+		}
+	}
+
+	for _, l := range [][]string{res.Misaligned, res.Not4kAligned, res.Not16kAligned, res.CompressedLibs} {
+		sort.Strings(l)
+	}
+	return res
+}
+
+// Returns the entry of f Android installs.
+func installedEntry(f *ZipReaderFile) (e ZipEntryData, err error) {
+	defer recoverPanic(&err)
+
+	entries, err := f.SubEntries()
+	if err != nil {
+		return e, err
+	}
+
+	installer, _, _ := f.AndroidEntry()
+	if installer < 0 || installer >= len(entries) {
+		return e, fmt.Errorf("No entry of %s is installed.", f.Name)
+	}
+	return entries[installer], nil
+}
+
+// Checks alignment of the entries of the APK, with android:extractNativeLibs from its manifest.
+func (p *ApkParser) CheckAlignment() (*AlignmentReport, error) {
+	manifest, err := p.ParseManifestTree()
+	if err != nil {
+		return nil, err
+	}
+
+	extract := true
+	for _, app := range manifest.ChildrenNamed("application") {
+		if app.AndroidAttr("extractNativeLibs") == "false" {
+			extract = false
+		}
+	}
+	return p.zip.CheckAlignment(extract), nil
+}
@@ -0,0 +1,157 @@
+package apkparser
+
+import (
+	"archive/zip"
+	"errors"
+	"sort"
+)
+
+// Maximum number of bytes of each duplicate entry compared by AnalyzeAnomalies.
+const maxDuplicateDigestSize = 64 * 1024 * 1024
+
+// Explicit signals of obfuscation and tampering found in an APK, see AnalyzeAnomalies.
+type AnomalyReport struct {
+	// The central directory is unreadable, the entries were found by scanning for central directory
+	// records or local headers.
+	BrokenZip bool
+	// Names present more than once in the zip.
+	DuplicateEntries []string
+	// Subset of DuplicateEntries whose entries have different content.
+	DivergentDuplicates []string
+	// Entries with stored names which are not clean, e.g. "./AndroidManifest.xml".
+	AlteredEntryNames []ZipAlteredName
+	// Groups of names which differ only by case, see ZipReader.CaseCollisions.
+	CaseCollisions [][]string
+
+	// There is no AndroidManifest.xml in the zip.
+	ManifestMissing bool
+	// Number of AndroidManifest.xml entries in the zip, Android uses only one of them.
+	ManifestEntries int
+	// AndroidManifest.xml uses compression method other than store or deflate. Android
+	// treats it as deflate, but other tools fail to extract it.
+	ManifestFakeCompression bool
+	// AndroidManifest.xml is in plaintext instead of the binary form.
+	PlainTextManifest bool
+	// Attribute resource ids in the manifest which are not in the android package (0x01).
+	ForeignAttributeIds []uint32
+
+	// Anomalies Android recovers from, reported by the respective parsers.
+	ZipWarnings       []Warning
+	ManifestWarnings  []Warning
+	ResourcesWarnings []Warning
+
+	ManifestErr  error
+	ResourcesErr error
+}
+
+// Looks for anomalies commonly used by obfuscators and malware to break analysis tools
+// while keeping the APK installable. This method will not Close() the zip.
+func AnalyzeAnomalies(zr *ZipReader) *AnomalyReport {
+	report := &AnomalyReport{
+		AlteredEntryNames: zr.AlteredNames,
+		CaseCollisions:    zr.CaseCollisions(),
+		ZipWarnings:       zr.Warnings,
+	}
+
+	for _, w := range zr.Warnings {
+		if w.Kind == WarnBrokenZip {
+			report.BrokenZip = true
+		}
+	}
+
+	for name, f := range zr.File {
+		if f.subEntryCount() > 1 {
+			report.DuplicateEntries = append(report.DuplicateEntries, name)
+		}
+	}
+	sort.Strings(report.DuplicateEntries)
+
+	if len(report.DuplicateEntries) != 0 {
+		report.DivergentDuplicates = zr.DivergentDuplicates(maxDuplicateDigestSize)
+	}
+
+	if resFile := zr.File["resources.arsc"]; resFile != nil {
+		report.ResourcesErr = analyzeResources(resFile, report)
+	}
+
+	manifest := zr.File["AndroidManifest.xml"]
+	if manifest == nil {
+		report.ManifestMissing = true
+		return report
+	}
+
+	report.ManifestEntries = manifest.subEntryCount()
+	for _, method := range manifest.subEntryMethods() {
+		if method != zip.Store && method != zip.Deflate {
+			report.ManifestFakeCompression = true
+		}
+	}
+
+	report.ManifestErr = analyzeManifest(manifest, report)
+	report.PlainTextManifest = errors.Is(report.ManifestErr, ErrPlainTextManifest)
+	return report
+}
+
+// Returns true if any anomaly was found.
+func (r *AnomalyReport) HasAnomalies() bool {
+	return r.BrokenZip || len(r.DuplicateEntries) != 0 || len(r.AlteredEntryNames) != 0 || len(r.CaseCollisions) != 0 ||
+		r.ManifestMissing || r.ManifestEntries > 1 || r.ManifestFakeCompression || r.PlainTextManifest ||
+		len(r.ForeignAttributeIds) != 0 || len(r.ZipWarnings) != 0 || len(r.ManifestWarnings) != 0 ||
+		len(r.ResourcesWarnings) != 0
+}
+
+func analyzeResources(f *ZipReaderFile, report *AnomalyReport) error {
+	if err := f.Open(); err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err := ParseResourceTableEx(f, &ParseOptions{Warnings: &report.ResourcesWarnings})
+	return err
+}
+
+func analyzeManifest(f *ZipReaderFile, report *AnomalyReport) (err error) {
+	defer recoverPanic(&err)
+
+	if err := f.Open(); err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lastErr error
+	for f.Next() {
+		var warnings []Warning
+		x := newBinxmlParseInfo(&ManifestVisitor{}, nil, &ParseOptions{Warnings: &warnings})
+		if lastErr = x.parse(f); lastErr != nil {
+			continue
+		}
+
+		report.ManifestWarnings = warnings
+		for _, id := range x.resourceIds {
+			if id>>24 != 0x01 {
+				report.ForeignAttributeIds = append(report.ForeignAttributeIds, id)
+			}
+		}
+		return nil
+	}
+	return lastErr
+}
+
+func (zr *ZipReaderFile) subEntryCount() int {
+	if zr.zipEntry != nil {
+		return 1 + len(zr.zipDuplicates)
+	}
+	return len(zr.entries)
+}
+
+func (zr *ZipReaderFile) subEntryMethods() []uint16 {
+	if zr.zipEntry != nil {
+		return append([]uint16{zr.zipEntryMethod}, zr.zipDupMethods...)
+	}
+
+	res := make([]uint16, 0, len(zr.entries))
+	for _, e := range zr.entries {
+		res = append(res, e.method)
+	}
+	return res
+}
@@ -2,10 +2,11 @@
 package apkparser
 
 import (
-	"fmt"
+	"bytes"
+	"context"
 	"io"
 	"os"
-	"runtime/debug"
+	"strings"
 )
 
 type ApkParser struct {
@@ -14,6 +15,7 @@ type ApkParser struct {
 
 	encoder   ManifestEncoder
 	resources *ResourceTable
+	opts      *ParseOptions
 }
 
 // Calls ParseApkReader
@@ -26,6 +28,21 @@ func ParseApk(path string, encoder ManifestEncoder) (zipErr, resourcesErr, manif
 	return ParseApkReader(f, encoder)
 }
 
+// Same as ParseApk, but aborts with ctx.Err() once the ctx is done.
+func ParseApkCtx(ctx context.Context, path string, encoder ManifestEncoder) (zipErr, resourcesErr, manifestErr error) {
+	return ParseApkEx(path, encoder, &ParseOptions{Context: ctx})
+}
+
+// Same as ParseApk, but the zip, resources and manifest are parsed with opts, which can be nil.
+func ParseApkEx(path string, encoder ManifestEncoder, opts *ParseOptions) (zipErr, resourcesErr, manifestErr error) {
+	f, zipErr := os.Open(path)
+	if zipErr != nil {
+		return
+	}
+	defer f.Close()
+	return ParseApkReaderEx(f, encoder, opts)
+}
+
 // Parse APK's Manifest, including resolving refences to resource values.
 // encoder expects an XML encoder instance, like Encoder from encoding/xml package.
 //
@@ -42,6 +59,23 @@ func ParseApkReader(r io.ReadSeeker, encoder ManifestEncoder) (zipErr, resources
 	return
 }
 
+// Same as ParseApkReader, but the zip, resources and manifest are parsed with opts, which can be nil.
+func ParseApkReaderEx(r io.ReadSeeker, encoder ManifestEncoder, opts *ParseOptions) (zipErr, resourcesErr, manifestErr error) {
+	zip, zipErr := OpenZipReaderEx(r, opts)
+	if zipErr != nil {
+		return
+	}
+	defer zip.Close()
+
+	resourcesErr, manifestErr = ParseApkWithZipEx(zip, encoder, opts)
+	return
+}
+
+// Same as ParseApkReader, but with the APK held in memory.
+func ParseApkBytes(data []byte, encoder ManifestEncoder) (zipErr, resourcesErr, manifestErr error) {
+	return ParseApkReader(bytes.NewReader(data), encoder)
+}
+
 // Parse APK's Manifest, including resolving refences to resource values.
 // encoder expects an XML encoder instance, like Encoder from encoding/xml package.
 //
@@ -50,9 +84,15 @@ func ParseApkReader(r io.ReadSeeker, encoder ManifestEncoder) (zipErr, resources
 //
 // The manifest will be parsed even when resourcesErr != nil, just without reference resolving.
 func ParseApkWithZip(zip *ZipReader, encoder ManifestEncoder) (resourcesErr, manifestErr error) {
+	return ParseApkWithZipEx(zip, encoder, nil)
+}
+
+// Same as ParseApkWithZip, but the resources and manifest are parsed with opts, which can be nil.
+func ParseApkWithZipEx(zip *ZipReader, encoder ManifestEncoder, opts *ParseOptions) (resourcesErr, manifestErr error) {
 	p := ApkParser{
 		zip:     zip,
 		encoder: encoder,
+		opts:    opts,
 	}
 
 	resourcesErr = p.parseResources()
@@ -73,45 +113,86 @@ func NewParser(zip *ZipReader, encoder ManifestEncoder) (parser *ApkParser, reso
 	return
 }
 
+// Same as NewParser, but the resources and all XML files are parsed with opts, which can be nil.
+//
+// This method will not Close() the zip, you are still the owner.
+func NewParserEx(zip *ZipReader, encoder ManifestEncoder, opts *ParseOptions) (parser *ApkParser, resourcesErr error) {
+	parser = &ApkParser{
+		zip:     zip,
+		encoder: encoder,
+		opts:    opts,
+	}
+	resourcesErr = parser.parseResources()
+	return
+}
+
 func (p *ApkParser) parseResources() (err error) {
 	if p.resources != nil {
 		return nil
 	}
 
+	defer recoverPanic(&err)
+
+	// References can still be resolved from the mapping, but the error is reported anyway.
 	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("Panic: %v\n%s", r, string(debug.Stack()))
+		if p.resources == nil && p.opts.resourceMapping() != nil {
+			p.resources = NewResourceTableFromMapping(p.opts.resourceMapping())
 		}
 	}()
 
+	// Plain os.ErrNotExist, as callers compare it directly.
 	resourcesFile := p.zip.File["resources.arsc"]
 	if resourcesFile == nil {
 		return os.ErrNotExist
 	}
 
 	if err := resourcesFile.Open(); err != nil {
-		return fmt.Errorf("Failed to open resources.arsc: %s", err.Error())
+		return &ZipEntryError{Name: "resources.arsc", Err: err}
 	}
 	defer resourcesFile.Close()
 
-	p.resources, err = ParseResourceTable(resourcesFile)
-	return
+	p.resources, err = ParseResourceTableEx(resourcesFile, p.opts)
+	if p.resources != nil {
+		p.resources.files = p.zip
+	}
+	if err != nil {
+		return &ZipEntryError{Name: "resources.arsc", Err: err}
+	}
+	return nil
+}
+
+// Returns the parsed resources.arsc, can be nil if it is missing or failed to parse and there's
+// no ParseOptions.ResourceMapping.
+func (p *ApkParser) Resources() *ResourceTable {
+	return p.resources
 }
 
 func (p *ApkParser) ParseXml(name string) error {
+	return p.parseXmlWith(name, callerEncoder(p.encoder))
+}
+
+// Panics of encoder are recovered by parseXml, so they must be wrapped by callerEncoder if it is the caller's.
+func (p *ApkParser) parseXmlWith(name string, encoder ManifestEncoder) error {
+	return p.parseXmlEntries(name, func(r io.Reader) error {
+		return parseXml(r, encoder, p.resources, p.opts)
+	})
+}
+
+// Calls parse for the entries with the name until one is parsed successfully.
+func (p *ApkParser) parseXmlEntries(name string, parse func(r io.Reader) error) error {
 	file := p.zip.File[name]
 	if file == nil {
-		return fmt.Errorf("Failed to find %s in APK!", name)
+		return &ZipEntryError{Name: name, Err: os.ErrNotExist}
 	}
 
 	if err := file.Open(); err != nil {
-		return err
+		return &ZipEntryError{Name: name, Err: err}
 	}
 	defer file.Close()
 
 	var lastErr error
 	for file.Next() {
-		if err := ParseXml(file, p.encoder, p.resources); err == nil {
+		if err := parse(file); err == nil {
 			return nil
 		} else {
 			lastErr = err
@@ -122,5 +203,24 @@ func (p *ApkParser) ParseXml(name string) error {
 		return lastErr
 	}
 
-	return fmt.Errorf("Failed to parse %s, last error: %v", name, lastErr)
+	return &ZipEntryError{Name: name, Err: lastErr}
+}
+
+// Decodes all binary XML files in dir (for example "res/") and its subdirectories with the
+// parser's encoder and resources, calling cb after each file with the parsing result.
+// The encoder can be reset in cb to get the files separately.
+func (p *ApkParser) ParseAllXml(dir string, cb func(name string, err error)) {
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+
+	seen := make(map[*ZipReaderFile]bool)
+	for _, f := range p.zip.FilesOrdered {
+		if seen[f] || f.IsDir || !strings.HasPrefix(f.Name, dir) || !strings.HasSuffix(f.Name, ".xml") {
+			continue
+		}
+		seen[f] = true
+
+		cb(f.Name, p.ParseXml(f.Name))
+	}
 }
@@ -1,17 +1,32 @@
 package apkparser_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/md5"
 	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"github.com/avast/apkparser"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Example() {
@@ -109,3 +124,4271 @@ func TestPlainManifest(t *testing.T) {
 		}
 	}
 }
+
+func TestPlainManifestFallback(t *testing.T) {
+	plain := `<?xml version="1.0" encoding="utf-8"?>
+<!-- comment -->
+<manifest xmlns:android="http://schemas.android.com/apk/res/android" package="com.example">
+	<uses-permission android:name="android.permission.INTERNET"/>
+</manifest>`
+
+	var warnings []apkparser.Warning
+	opts := &apkparser.ParseOptions{PlainTextFallback: true, Warnings: &warnings}
+
+	var names []string
+	visitor := &apkparser.ManifestVisitor{
+		StartElement: func(el *apkparser.TypedStartElement) error {
+			for _, a := range el.Attr {
+				val, err := el.GetString(a.Value.(apkparser.StringIndex))
+				if err != nil {
+					return err
+				}
+				names = append(names, el.Name.Local+":"+a.Name.Space+":"+a.Name.Local+"="+val)
+			}
+			return nil
+		},
+	}
+
+	if err := apkparser.ParseXmlEx(strings.NewReader(plain), visitor, nil, opts); err != nil {
+		t.Fatalf("failed to parse plaintext: %s", err.Error())
+	}
+
+	expected := []string{
+		"manifest::package=com.example",
+		"uses-permission:http://schemas.android.com/apk/res/android:name=android.permission.INTERNET",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected attributes %q", names)
+	}
+
+	if len(warnings) != 1 || warnings[0].Kind != apkparser.WarnPlainTextXml {
+		t.Fatalf("unexpected warnings %v", warnings)
+	}
+
+	var buf bytes.Buffer
+	if err := apkparser.ParseXmlEx(strings.NewReader(plain), xml.NewEncoder(&buf), nil, opts); err != nil {
+		t.Fatalf("failed to parse plaintext: %s", err.Error())
+	}
+
+	if !strings.Contains(buf.String(), `name="android.permission.INTERNET"`) || strings.Contains(buf.String(), "comment") {
+		t.Fatalf("unexpected output %s", buf.String())
+	}
+}
+
+func TestManifestVisitor(t *testing.T) {
+	in, err := os.Open("testdata/98d2e837b8f3ac41e74b86b2d532972955e5352197a893206ecd9650f678ae31.bin")
+	if err != nil {
+		t.Fatalf("failed to open file: %s", err.Error())
+	}
+	defer in.Close()
+
+	var versionCode interface{}
+	var pkg string
+	visitor := &apkparser.ManifestVisitor{
+		StartElement: func(el *apkparser.TypedStartElement) error {
+			if el.Name.Local != "manifest" {
+				return nil
+			}
+			for _, attr := range el.Attr {
+				switch attr.Name.Local {
+				case "versionCode":
+					versionCode = attr.Value
+				case "package":
+					idx, ok := attr.Value.(apkparser.StringIndex)
+					if !ok {
+						t.Fatalf("package attribute is %T, expected StringIndex", attr.Value)
+					}
+					pkg, err = el.GetString(idx)
+					if err != nil {
+						t.Fatalf("failed to get package string: %s", err.Error())
+					}
+				}
+			}
+			return apkparser.ErrEndParsing
+		},
+	}
+
+	if err := apkparser.ParseXml(in, visitor, nil); err != nil {
+		t.Fatalf("failed to parse manifest: %s", err.Error())
+	}
+
+	if versionCode != int32(4) {
+		t.Fatalf("unexpected versionCode %#v", versionCode)
+	}
+	if pkg != "name.tbx.erndy" {
+		t.Fatalf("unexpected package %q", pkg)
+	}
+}
+
+func TestSpecialAttrIndexes(t *testing.T) {
+	data := testAxml(&testAxmlNode{
+		name: "LinearLayout",
+		children: []*testAxmlNode{{
+			name: "view",
+			attrs: []testAxmlAttr{
+				{name: "android:layout_width", value: "match_parent"},
+				{name: "class", value: "com.example.CustomView"},
+				{name: "android:id", typ: 0x01, data: 0x7f080001},
+				{name: "style", typ: 0x01, data: 0x7f0c0002},
+			},
+			special: [3]uint16{3, 2, 9},
+		}},
+	})
+
+	var got [][3]int
+	visitor := &apkparser.ManifestVisitor{
+		StartElement: func(el *apkparser.TypedStartElement) error {
+			got = append(got, [3]int{el.IdIndex, el.ClassIndex, el.StyleIndex})
+			return nil
+		},
+	}
+
+	if err := apkparser.ParseXml(bytes.NewReader(data), visitor, nil); err != nil {
+		t.Fatalf("failed to parse xml: %s", err.Error())
+	}
+
+	// The style index is out of range.
+	expected := [][3]int{{0, 0, 0}, {3, 2, 0}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected indexes %v", got)
+	}
+}
+
+func TestComplexAttrValues(t *testing.T) {
+	data := testAxml(&testAxmlNode{
+		name: "manifest",
+		attrs: []testAxmlAttr{
+			{name: "width", typ: 0x05, data: 16<<8 | 1},
+			{name: "text", typ: 0x05, data: 192<<8 | 1<<4 | 2},
+			{name: "margin", typ: 0x05, data: 0xFFFFF800},
+			{name: "pivot", typ: 0x06, data: 64<<8 | 1<<4 | 1},
+			{name: "weird", typ: 0x05, data: 1<<8 | 0xF},
+			{name: "color", typ: 0x1d, data: 0xFFFF0000},
+		},
+	})
+
+	var buf bytes.Buffer
+	if err := apkparser.ParseXml(bytes.NewReader(data), xml.NewEncoder(&buf), nil); err != nil {
+		t.Fatalf("failed to parse xml: %s", err.Error())
+	}
+
+	for _, expected := range []string{
+		`width="16.0dip"`, `text="1.5sp"`, `margin="-8.0px"`, `pivot="50.0%p"`, `weird="271"`, `color="#ff0000"`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("missing %s in %s", expected, buf.String())
+		}
+	}
+}
+
+func TestCanonicalManifest(t *testing.T) {
+	parse := func(root *testAxmlNode) *apkparser.ManifestElement {
+		tree, err := apkparser.ParseXmlTree(bytes.NewReader(testAxml(root)), nil)
+		if err != nil {
+			t.Fatalf("failed to parse xml: %s", err.Error())
+		}
+		return tree
+	}
+
+	a := parse(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "package", value: "com.example"}, {name: "platformBuildVersionCode", value: "30"}},
+		children: []*testAxmlNode{{
+			name:  "application",
+			attrs: []testAxmlAttr{{name: "android:theme", typ: 0x01, data: 0x7f0f0005}, {name: "android:label", value: "A & B"}},
+			text:  "\n  ",
+		}},
+	})
+	b := parse(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "platformBuildVersionCode", value: "33"}, {name: "package", value: "com.example"}},
+		children: []*testAxmlNode{{
+			name:  "application",
+			attrs: []testAxmlAttr{{name: "android:label", value: "A & B"}, {name: "android:theme", typ: 0x01, data: 0x7f0f0005}},
+		}},
+	})
+
+	var buf bytes.Buffer
+	if err := a.WriteCanonical(&buf, nil); err != nil {
+		t.Fatalf("failed to write canonical xml: %s", err.Error())
+	}
+
+	expected := `<manifest xmlns:android="http://schemas.android.com/apk/res/android" package="com.example">
+    <application android:label="A &amp; B" android:theme="@7f0f0005"/>
+</manifest>
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected canonical xml:\n%s", buf.String())
+	}
+
+	if a.CanonicalHash(nil) != b.CanonicalHash(nil) {
+		t.Fatalf("hashes of equivalent manifests differ")
+	}
+
+	b.Children[0].Attr[0].Value = "C"
+	if a.CanonicalHash(nil) == b.CanonicalHash(nil) {
+		t.Fatalf("hashes of different manifests are equal")
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	parse := func(root *testAxmlNode) *apkparser.ManifestElement {
+		tree, err := apkparser.ParseXmlTree(bytes.NewReader(testAxml(root)), nil)
+		if err != nil {
+			t.Fatalf("failed to parse xml: %s", err.Error())
+		}
+		return tree
+	}
+
+	a := parse(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "package", value: "com.example"}, {name: "android:versionName", value: "1.0"}},
+		children: []*testAxmlNode{
+			{name: "uses-permission", attrs: []testAxmlAttr{{name: "android:name", value: "android.permission.CAMERA"}}},
+			{name: "application", children: []*testAxmlNode{
+				{name: "activity", attrs: []testAxmlAttr{{name: "android:name", value: ".Main"}, {name: "android:exported", value: "true"}}},
+			}},
+		},
+	})
+	b := parse(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "package", value: "com.example"}, {name: "android:versionName", value: "2.0"}},
+		children: []*testAxmlNode{
+			{name: "uses-feature", attrs: []testAxmlAttr{{name: "android:glEsVersion", value: "0x20000"}}},
+			{name: "application", children: []*testAxmlNode{
+				{name: "activity", attrs: []testAxmlAttr{{name: "android:name", value: ".Main"}, {name: "android:theme", value: "@7f0f0005"}}},
+			}},
+		},
+	})
+
+	expected := []apkparser.ManifestChange{
+		{Kind: apkparser.AttrChanged, Path: "/manifest", Attr: "android:versionName", Old: "1.0", New: "2.0"},
+		{Kind: apkparser.AttrRemoved, Path: "/manifest/application/activity[.Main]", Attr: "android:exported", Old: "true"},
+		{Kind: apkparser.AttrAdded, Path: "/manifest/application/activity[.Main]", Attr: "android:theme", New: "@7f0f0005"},
+		{Kind: apkparser.ElementAdded, Path: "/manifest/uses-feature#0"},
+		{Kind: apkparser.ElementRemoved, Path: "/manifest/uses-permission[android.permission.CAMERA]"},
+	}
+	if changes := apkparser.DiffManifests(a, b); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+
+	if changes := apkparser.DiffManifests(a, a); len(changes) != 0 {
+		t.Fatalf("unexpected changes of identical manifests %+v", changes)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	strict := &apkparser.ParseOptions{Strict: true}
+
+	in, err := os.Open("testdata/98d2e837b8f3ac41e74b86b2d532972955e5352197a893206ecd9650f678ae31.bin")
+	if err != nil {
+		t.Fatalf("failed to open file: %s", err.Error())
+	}
+	defer in.Close()
+
+	if err := apkparser.ParseXmlEx(in, xml.NewEncoder(ioutil.Discard), nil, strict); err != nil {
+		t.Fatalf("strict mode rejected a valid manifest: %s", err.Error())
+	}
+
+	// This one has invalid top chunk id, which Android doesn't care about.
+	in, err = os.Open("testdata/a3ee88cf1492237a1be846df824f9de30a6f779973fe3c41c7d7ed0be644ba37.bin")
+	if err != nil {
+		t.Fatalf("failed to open file: %s", err.Error())
+	}
+	defer in.Close()
+
+	err = apkparser.ParseXmlEx(in, xml.NewEncoder(ioutil.Discard), nil, strict)
+	if !errors.Is(err, apkparser.ErrStrictMode) {
+		t.Fatalf("strict mode accepted invalid top chunk id, got '%v'", err)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	in, err := os.Open("testdata/a3ee88cf1492237a1be846df824f9de30a6f779973fe3c41c7d7ed0be644ba37.bin")
+	if err != nil {
+		t.Fatalf("failed to open file: %s", err.Error())
+	}
+	defer in.Close()
+
+	var warnings []apkparser.Warning
+	opts := &apkparser.ParseOptions{Warnings: &warnings}
+	if err := apkparser.ParseXmlEx(in, xml.NewEncoder(ioutil.Discard), nil, opts); err != nil {
+		t.Fatalf("failed to parse manifest: %s", err.Error())
+	}
+
+	for _, w := range warnings {
+		if w.Kind == apkparser.WarnInvalidChunkId {
+			return
+		}
+	}
+	t.Fatalf("invalid top chunk id was not reported, got %v", warnings)
+}
+
+func TestAnomalyReport(t *testing.T) {
+	manifest, err := ioutil.ReadFile("testdata/98d2e837b8f3ac41e74b86b2d532972955e5352197a893206ecd9650f678ae31.bin")
+	if err != nil {
+		t.Fatalf("failed to read file: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"AndroidManifest.xml", "./AndroidManifest.xml", ""} {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %s", err.Error())
+		}
+		fw.Write(manifest)
+	}
+	w.Close()
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	report := apkparser.AnalyzeAnomalies(zr)
+	if report.ManifestErr != nil {
+		t.Fatalf("failed to parse manifest: %s", report.ManifestErr.Error())
+	}
+	if report.ManifestEntries != 2 || len(report.DuplicateEntries) != 1 || !report.HasAnomalies() {
+		t.Fatalf("duplicate manifest was not reported: %+v", report)
+	}
+
+	if len(report.DivergentDuplicates) != 0 {
+		t.Fatalf("identical duplicates reported as divergent: %v", report.DivergentDuplicates)
+	}
+
+	altered := []apkparser.ZipAlteredName{{RawName: "./AndroidManifest.xml", Name: "AndroidManifest.xml"}}
+	if !reflect.DeepEqual(report.AlteredEntryNames, altered) || zr.File["AndroidManifest.xml"].RawName != "AndroidManifest.xml" {
+		t.Fatalf("altered name was not reported: %+v", report.AlteredEntryNames)
+	}
+}
+
+func TestAnomalyReportDuplicateFakeCompression(t *testing.T) {
+	manifest, err := ioutil.ReadFile("testdata/98d2e837b8f3ac41e74b86b2d532972955e5352197a893206ecd9650f678ae31.bin")
+	if err != nil {
+		t.Fatalf("failed to read file: %s", err.Error())
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i := 0; i < 2; i++ {
+		fw, _ := w.Create("AndroidManifest.xml")
+		fw.Write(manifest)
+	}
+	w.Close()
+
+	// Only the duplicate has the fake method, in both its local header and central directory record.
+	data := buf.Bytes()
+	local := bytes.LastIndex(data[:bytes.Index(data, []byte("PK\x01\x02"))], []byte("PK\x03\x04"))
+	binary.LittleEndian.PutUint16(data[local+8:], 0x1234)
+	central := bytes.LastIndex(data, []byte("PK\x01\x02"))
+	binary.LittleEndian.PutUint16(data[central+10:], 0x1234)
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	report := apkparser.AnalyzeAnomalies(zr)
+	if report.ManifestEntries != 2 || !report.ManifestFakeCompression {
+		t.Fatalf("fake compression of the duplicate was not reported: %+v", report)
+	}
+}
+
+func TestParseXmlCtx(t *testing.T) {
+	in, err := os.Open("testdata/98d2e837b8f3ac41e74b86b2d532972955e5352197a893206ecd9650f678ae31.bin")
+	if err != nil {
+		t.Fatalf("failed to open file: %s", err.Error())
+	}
+	defer in.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := apkparser.ParseXmlCtx(ctx, in, xml.NewEncoder(ioutil.Discard), nil); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got '%v'", err)
+	}
+}
+
+func TestMaxAllocBytes(t *testing.T) {
+	in, err := os.Open("testdata/98d2e837b8f3ac41e74b86b2d532972955e5352197a893206ecd9650f678ae31.bin")
+	if err != nil {
+		t.Fatalf("failed to open file: %s", err.Error())
+	}
+	defer in.Close()
+
+	var limitErr *apkparser.AllocLimitError
+	opts := &apkparser.ParseOptions{MaxAllocBytes: 1024}
+	if err := apkparser.ParseXmlEx(in, xml.NewEncoder(ioutil.Discard), nil, opts); !errors.As(err, &limitErr) {
+		t.Fatalf("expected AllocLimitError, got '%v'", err)
+	}
+}
+
+func TestLazyStrings(t *testing.T) {
+	parse := func(fn string, opts *apkparser.ParseOptions) []byte {
+		in, err := os.Open(fn)
+		if err != nil {
+			t.Fatalf("failed to open file: %s", err.Error())
+		}
+		defer in.Close()
+
+		var buf bytes.Buffer
+		if err := apkparser.ParseXmlEx(in, xml.NewEncoder(&buf), nil, opts); err != nil {
+			t.Fatalf("failed to parse %s: %s", fn, err.Error())
+		}
+		return buf.Bytes()
+	}
+
+	variants := []*apkparser.ParseOptions{
+		{LazyStrings: true},
+		{StringCacheSize: -1},
+		{StringCacheSize: 2},
+		{PredecodeStrings: true},
+	}
+
+	files, _ := filepath.Glob("testdata/*.bin")
+	for _, fn := range files {
+		expected := parse(fn, nil)
+		for _, opts := range variants {
+			if !bytes.Equal(expected, parse(fn, opts)) {
+				t.Fatalf("string options %+v produced different output for %s", *opts, fn)
+			}
+		}
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+	manifest, err := ioutil.ReadFile("testdata/98d2e837b8f3ac41e74b86b2d532972955e5352197a893206ecd9650f678ae31.bin")
+	if err != nil {
+		t.Fatalf("failed to read file: %s", err.Error())
+	}
+
+	apkPath := filepath.Join(t.TempDir(), "test.apk")
+	out, err := os.Create(apkPath)
+	if err != nil {
+		t.Fatalf("failed to create apk: %s", err.Error())
+	}
+	w := zip.NewWriter(out)
+	fw, _ := w.Create("AndroidManifest.xml")
+	fw.Write(manifest)
+	w.Close()
+	out.Close()
+
+	paths := []string{apkPath, filepath.Join(t.TempDir(), "missing.apk"), apkPath}
+	seen := make([]bool, len(paths))
+	apkparser.ParseBatch(paths, 2, func(res apkparser.Result) {
+		seen[res.Index] = true
+		if res.PanicErr != nil {
+			t.Fatalf("%s panicked: %s", res.Path, res.PanicErr.Error())
+		}
+
+		if res.Index == 1 {
+			if res.ZipErr == nil {
+				t.Fatalf("missing apk did not produce an error")
+			}
+		} else if res.ManifestErr != nil || !bytes.Contains(res.Manifest, []byte(`package="name.tbx.erndy"`)) {
+			t.Fatalf("failed to parse %s: %v", res.Path, res.ManifestErr)
+		}
+	})
+
+	for i, s := range seen {
+		if !s {
+			t.Fatalf("no result for %s", paths[i])
+		}
+	}
+
+	before := runtime.NumGoroutine()
+	many := make([]string, 64)
+	for i := range many {
+		many[i] = apkPath
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != "cb" {
+				t.Fatalf("unexpected panic value %v", r)
+			}
+		}()
+		apkparser.ParseBatch(many, 4, func(res apkparser.Result) {
+			panic("cb")
+		})
+	}()
+
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Fatalf("ParseBatch left %d goroutines running after cb panicked", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestResourceEntries(t *testing.T) {
+	res, err := apkparser.ParseResourceTableBytes(testArscSimple())
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	id, err := res.GetResourceId("@string/app_name")
+	if err != nil || id != 0x7f010000 {
+		t.Fatalf("unexpected id 0x%08x for app_name: %v", id, err)
+	}
+
+	if id, err := res.GetResourceId("@com.example:string/other"); err != nil || id != 0x7f010001 {
+		t.Fatalf("unexpected id 0x%08x for other: %v", id, err)
+	}
+
+	if _, err := res.GetResourceId("@string/missing"); err == nil {
+		t.Fatalf("missing resource was found")
+	}
+
+	entries, err := res.GetResourceEntries(id)
+	if err != nil {
+		t.Fatalf("failed to get entries: %s", err.Error())
+	}
+
+	var variants []string
+	for _, e := range entries {
+		val, _ := e.GetValue().String()
+		variants = append(variants, e.Config.String()+"="+val)
+	}
+	if got := strings.Join(variants, ","); got != "=Example,de-v21=Beispiel" {
+		t.Fatalf("unexpected variants %s", got)
+	}
+
+	for _, e := range entries {
+		if e.Package != "com.example" || e.ResourceType != "string" || e.Key != "app_name" {
+			t.Fatalf("unexpected entry %s:%s/%s", e.Package, e.ResourceType, e.Key)
+		}
+	}
+	if !entries[0].Config.IsDefault() || entries[1].Config.IsDefault() ||
+		entries[1].Config.LanguageString() != "de" || entries[1].Config.SdkVersion != 21 {
+		t.Fatalf("unexpected configs %+v, %+v", entries[0].Config, entries[1].Config)
+	}
+
+	if entries, err := res.GetResourceEntries(0x7f010001); err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected entries of other: %d, %v", len(entries), err)
+	}
+
+	if id, err := res.GetResourceId("string/app_name"); err != nil || id != 0x7f010000 {
+		t.Fatalf("unexpected id 0x%08x for app_name without @: %v", id, err)
+	}
+
+	for _, name := range []string{"@app_name", "@com.missing:string/app_name", "@drawable/app_name"} {
+		if _, err := res.GetResourceId(name); err == nil {
+			t.Fatalf("invalid name %s was found", name)
+		}
+	}
+
+	for _, id := range []uint32{0x7e010000, 0x7f020000, 0x7f010002} {
+		if _, err := res.GetResourceEntries(id); err == nil {
+			t.Fatalf("entries of invalid id 0x%08x were found", id)
+		}
+	}
+}
+
+func TestResourceConfigString(t *testing.T) {
+	cases := []struct {
+		config   apkparser.ResourceConfig
+		expected string
+	}{
+		{apkparser.ResourceConfig{}, ""},
+		{apkparser.ResourceConfig{Mcc: 310, Mnc: 4}, "mcc310-mnc4"},
+		{apkparser.ResourceConfig{Language: [2]byte{'e', 'n'}, Country: [2]byte{'U', 'S'}}, "en-rUS"},
+		{apkparser.ResourceConfig{Language: [2]byte{'s', 'r'}, LocaleScript: [4]byte{'L', 'a', 't', 'n'}}, "b+sr+Latn"},
+		// "fil" packed into 15 bits
+		{apkparser.ResourceConfig{Language: [2]byte{0xad, 0x05}}, "b+fil"},
+		{apkparser.ResourceConfig{ScreenLayout: 0x80 | 0x03, SmallestScreenWidthDp: 600}, "ldrtl-sw600dp-large"},
+		{apkparser.ResourceConfig{Orientation: 2, UiMode: apkparser.UiModeTypeCar | apkparser.UiModeNightYes}, "land-car-night"},
+		{apkparser.ResourceConfig{Density: apkparser.DensityXXHigh, SdkVersion: 26}, "xxhdpi-v26"},
+		{apkparser.ResourceConfig{Density: 560}, "560dpi"},
+		{apkparser.ResourceConfig{Density: apkparser.DensityAny, Touchscreen: 3, Keyboard: 2}, "anydpi-finger-qwerty"},
+	}
+
+	for _, c := range cases {
+		if got := c.config.String(); got != c.expected {
+			t.Fatalf("expected %q, got %q for %+v", c.expected, got, c.config)
+		}
+		if c.config.IsDefault() != (c.expected == "") {
+			t.Fatalf("unexpected IsDefault for %q", c.expected)
+		}
+	}
+}
+
+type testCountingReaderAt struct {
+	r     io.ReaderAt
+	reads int
+	limit int64 // reads past it fail if it's not 0
+}
+
+func (c *testCountingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	if c.limit != 0 && off+int64(len(p)) > c.limit {
+		return 0, errors.New("read past the limit")
+	}
+	return c.r.ReadAt(p, off)
+}
+
+func TestStreamedResourceTable(t *testing.T) {
+	data := testArscSimple()
+	// The table ends with the entries of a type chunk, which are not read while parsing.
+	source := &testCountingReaderAt{r: bytes.NewReader(data), limit: int64(len(data) - 8)}
+
+	res, err := apkparser.ParseResourceTableReaderAt(source, int64(len(data)), nil)
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+	source.limit = 0
+
+	if id, err := res.GetResourceId("@string/other"); err != nil || id != 0x7f010001 {
+		t.Fatalf("unexpected id 0x%08x for other: %v", id, err)
+	}
+
+	reads := source.reads
+	entries, err := res.GetResourceEntries(0x7f010000)
+	if err != nil {
+		t.Fatalf("failed to get entries: %s", err.Error())
+	}
+
+	var variants []string
+	for _, e := range entries {
+		val, _ := e.GetValue().String()
+		variants = append(variants, e.Config.String()+"="+val)
+	}
+	if got := strings.Join(variants, ","); got != "=Example,de-v21=Beispiel" {
+		t.Fatalf("unexpected variants %s", got)
+	}
+
+	if source.reads == reads {
+		t.Fatalf("entries were not read from the source")
+	}
+}
+
+func TestLazyResourceTypes(t *testing.T) {
+	data := testArscSimple()
+	opts := &apkparser.ParseOptions{LazyResourceTypes: true}
+
+	eager, err := apkparser.ParseResourceTableBytes(data)
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	for _, parse := range []func() (*apkparser.ResourceTable, error){
+		func() (*apkparser.ResourceTable, error) {
+			return apkparser.ParseResourceTableEx(bytes.NewReader(data), opts)
+		},
+		func() (*apkparser.ResourceTable, error) {
+			return apkparser.ParseResourceTableReaderAt(bytes.NewReader(data), int64(len(data)), opts)
+		},
+	} {
+		res, err := parse()
+		if err != nil {
+			t.Fatalf("failed to parse lazy resources: %s", err.Error())
+		}
+
+		entries, err := res.GetResourceEntries(0x7f010000)
+		if err != nil || len(entries) != 2 || entries[1].Config.String() != "de-v21" {
+			t.Fatalf("unexpected entries %v: %v", entries, err)
+		}
+
+		if !reflect.DeepEqual(res.Configs(), eager.Configs()) {
+			t.Fatalf("unexpected configs %v, expected %v", res.Configs(), eager.Configs())
+		}
+	}
+
+	// Only the start of the type chunks is read while parsing, not the config of the last one.
+	last := bytes.LastIndex(data, []byte{0x01, 0x02, 0x54, 0x00})
+	source := &testCountingReaderAt{r: bytes.NewReader(data), limit: int64(last + 20)}
+	res, err := apkparser.ParseResourceTableReaderAt(source, int64(len(data)), opts)
+	if err != nil {
+		t.Fatalf("failed to parse lazy resources: %s", err.Error())
+	}
+	source.limit = 0
+
+	if !reflect.DeepEqual(res.Configs(), eager.Configs()) {
+		t.Fatalf("unexpected configs %v, expected %v", res.Configs(), eager.Configs())
+	}
+}
+
+func TestResourceMapping(t *testing.T) {
+	original, err := apkparser.ParseResourceTableBytes(testArscSimple())
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	mapping := original.Mapping()
+	if m := mapping[0x7f010001]; m.Package != "com.example" || m.Type != "string" || m.Key != "other" || m.Value != "Other" {
+		t.Fatalf("unexpected mapping of other: %+v", m)
+	}
+	mapping[0x7f010002] = apkparser.MappedResource{Type: "string", Key: "stripped", Value: "Gone"}
+
+	// The same table with obfuscated key names and the second entry stripped.
+	arsc := testArsc{
+		strings: []string{"Example"},
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"string"},
+			keys:  []string{"a"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 0}},
+				}),
+			},
+		}},
+	}
+
+	res, err := apkparser.ParseResourceTableEx(bytes.NewReader(arsc.bytes()), &apkparser.ParseOptions{ResourceMapping: mapping})
+	if err != nil {
+		t.Fatalf("failed to parse obfuscated resources: %s", err.Error())
+	}
+
+	if name, err := res.GetResourceName(0x7f010000); err != nil || name != "@string:com.example.app_name" {
+		t.Fatalf("unexpected name %s: %v", name, err)
+	}
+
+	if id, err := res.GetResourceId("@string/app_name"); err != nil || id != 0x7f010000 {
+		t.Fatalf("unexpected id 0x%08x for app_name: %v", id, err)
+	}
+
+	e, err := res.GetResourceEntry(0x7f010000)
+	if err != nil || e.Key != "app_name" {
+		t.Fatalf("unexpected entry %+v: %v", e, err)
+	}
+	if val, _ := e.GetValue().String(); val != "Example" {
+		t.Fatalf("unexpected value %s of app_name", val)
+	}
+
+	for _, table := range []*apkparser.ResourceTable{res, apkparser.NewResourceTableFromMapping(mapping)} {
+		for id, expected := range map[uint32]string{0x7f010001: "Other", 0x7f010002: "Gone"} {
+			e, err := table.GetResourceEntry(id)
+			if err != nil {
+				t.Fatalf("failed to get mapped entry 0x%08x: %s", id, err.Error())
+			}
+			if val, _ := e.GetValue().String(); val != expected {
+				t.Fatalf("unexpected value %s of 0x%08x, expected %s", val, id, expected)
+			}
+		}
+	}
+
+	if _, err := res.GetResourceEntry(0x7f010003); err == nil {
+		t.Fatalf("unmapped missing entry was found")
+	}
+}
+
+func TestReferenceDepth(t *testing.T) {
+	ref := func(key, id uint32) *testArscEntry {
+		return &testArscEntry{key: key, value: testArscValue{typ: uint8(apkparser.AttrTypeReference), data: id}}
+	}
+
+	arsc := testArsc{
+		strings: []string{"Deep"},
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"string"},
+			keys:  []string{"loop_a", "loop_b", "alias", "deep"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0, 0, 0, 0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					ref(0, 0x7f010001),
+					ref(1, 0x7f010000),
+					ref(2, 0x7f010003),
+					{key: 3, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 0}},
+				}),
+			},
+		}},
+	}
+	res, err := apkparser.ParseResourceTableBytes(arsc.bytes())
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	data := testAxml(&testAxmlNode{
+		name: "manifest",
+		attrs: []testAxmlAttr{
+			{name: "label", typ: uint8(apkparser.AttrTypeReference), data: 0x7f010002},
+			{name: "loop", typ: uint8(apkparser.AttrTypeReference), data: 0x7f010000},
+		},
+	})
+
+	for _, tc := range []struct {
+		depth int
+		label string
+	}{{0, "Deep"}, {1, "@7f010003"}, {2, "Deep"}} {
+		var warnings []apkparser.Warning
+		root := &testRootAttrEncoder{}
+		if err := apkparser.ParseXmlEx(bytes.NewReader(data), root, res, &apkparser.ParseOptions{MaxReferenceDepth: tc.depth, Warnings: &warnings}); err != nil {
+			t.Fatalf("failed to parse xml: %s", err.Error())
+		}
+
+		if label := root.attrs["label"]; label != tc.label {
+			t.Fatalf("unexpected label %s with depth %d, expected %s", label, tc.depth, tc.label)
+		}
+
+		loops := 0
+		for _, w := range warnings {
+			if w.Kind == apkparser.WarnReferenceLoop {
+				loops++
+			}
+		}
+		if expected := map[bool]int{true: 1, false: 0}[tc.depth == 0]; loops != expected {
+			t.Fatalf("unexpected %d reference loop warnings with depth %d: %v", loops, tc.depth, warnings)
+		}
+	}
+
+	if _, err := res.GetIconPng(0x7f010000); err != nil {
+		t.Fatalf("failed to get icon entry: %s", err.Error())
+	}
+	if w := res.Warnings(); len(w) == 0 || w[len(w)-1].Kind != apkparser.WarnReferenceLoop {
+		t.Fatalf("icon reference loop not reported: %v", w)
+	}
+}
+
+func TestParseApkEx(t *testing.T) {
+	apkPath := writeTestApk(t, map[string][]byte{
+		"AndroidManifest.xml": testAxml(&testAxmlNode{
+			name:  "manifest",
+			attrs: []testAxmlAttr{{name: "label", typ: uint8(apkparser.AttrTypeReference), data: 0x7f010000}},
+		}),
+		"resources.arsc": testArscSimple(),
+	})
+
+	for locale, expected := range map[string]string{"": "Example", "fr": "Example", "de": "Beispiel", "de-rAT": "Beispiel"} {
+		enc := &testRootAttrEncoder{}
+		zipErr, resErr, manErr := apkparser.ParseApkEx(apkPath, enc, &apkparser.ParseOptions{Locale: locale})
+		if zipErr != nil || resErr != nil || manErr != nil {
+			t.Fatalf("failed to parse apk: %v %v %v", zipErr, resErr, manErr)
+		}
+
+		if label := enc.attrs["label"]; label != expected {
+			t.Fatalf("unexpected label %s for locale %q, expected %s", label, locale, expected)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if zipErr, _, _ := apkparser.ParseApkEx(apkPath, &testRootAttrEncoder{}, &apkparser.ParseOptions{Context: ctx}); !errors.Is(zipErr, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", zipErr)
+	}
+}
+
+func TestDisplayPreference(t *testing.T) {
+	icon := func(path uint32) []*testArscEntry {
+		return []*testArscEntry{{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: path}}}
+	}
+
+	arsc := testArsc{
+		strings: []string{"res/drawable/icon.png", "res/drawable-hdpi/icon.png", "res/drawable-xxhdpi/icon.png", "res/drawable-night-xhdpi/icon.png"},
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"drawable"},
+			keys:  []string{"icon"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscDisplayConfig(0, 0), icon(0)),
+				testArscType(1, testArscDisplayConfig(apkparser.DensityHigh, 0), icon(1)),
+				testArscType(1, testArscDisplayConfig(apkparser.DensityXXHigh, 0), icon(2)),
+				testArscType(1, testArscDisplayConfig(apkparser.DensityXHigh, apkparser.UiModeNightYes), icon(3)),
+			},
+		}},
+	}
+	res, err := apkparser.ParseResourceTableBytes(arsc.bytes())
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	data := testAxml(&testAxmlNode{
+		name:  "application",
+		attrs: []testAxmlAttr{{name: "android:icon", typ: uint8(apkparser.AttrTypeReference), data: 0x7f010000}},
+	})
+
+	for _, tc := range []struct {
+		density  uint16
+		uiMode   uint8
+		expected string
+	}{
+		{apkparser.DensityXHigh, apkparser.UiModeNightNo, "res/drawable-xxhdpi/icon.png"},
+		{apkparser.DensityXXXHigh, apkparser.UiModeNightNo, "res/drawable-xxhdpi/icon.png"},
+		{apkparser.DensityLow, apkparser.UiModeNightNo, "res/drawable/icon.png"},
+		{apkparser.DensityHigh, 0, "res/drawable-hdpi/icon.png"},
+		{apkparser.DensityMedium, apkparser.UiModeNightYes, "res/drawable-night-xhdpi/icon.png"},
+	} {
+		enc := &testRootAttrEncoder{}
+		if err := apkparser.ParseXmlEx(bytes.NewReader(data), enc, res, &apkparser.ParseOptions{Density: tc.density, UiMode: tc.uiMode}); err != nil {
+			t.Fatalf("failed to parse xml: %s", err.Error())
+		}
+
+		if icon := enc.attrs["icon"]; icon != tc.expected {
+			t.Fatalf("unexpected icon %s for density %d and ui mode 0x%x, expected %s", icon, tc.density, tc.uiMode, tc.expected)
+		}
+	}
+}
+
+func TestResourceFiles(t *testing.T) {
+	arsc := testArsc{
+		strings: []string{"res/drawable/icon.png", "r/a/b.png", "not a file"},
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"drawable", "string"},
+			keys:  []string{"icon", "label"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscDisplayConfig(0, 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 0}},
+				}),
+				testArscType(1, testArscDisplayConfig(apkparser.DensityHigh, 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 1}},
+				}),
+				testArscTypeSpec(2, []uint32{0}),
+				testArscType(2, testArscConfig("", 0), []*testArscEntry{
+					{key: 1, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 2}},
+				}),
+			},
+		}},
+	}
+	res, err := apkparser.ParseResourceTableBytes(arsc.bytes())
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	files, err := res.GetResourceFiles(0x7f010000)
+	if err != nil {
+		t.Fatalf("failed to get files: %s", err.Error())
+	}
+
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Type+":"+f.Config.String()+":"+f.Path)
+	}
+	if strings.Join(got, ",") != "drawable::res/drawable/icon.png,drawable:hdpi:r/a/b.png" {
+		t.Fatalf("unexpected files %v", got)
+	}
+
+	if _, err := res.GetResourceFiles(0x7f020000); err == nil {
+		t.Fatalf("string resource reported as file")
+	}
+}
+
+type testPanicEncoder struct{}
+
+func (testPanicEncoder) EncodeToken(t xml.Token) error {
+	panic("boom")
+}
+
+func (testPanicEncoder) Flush() error {
+	return nil
+}
+
+// Panics of the caller's callbacks are not turned into *PanicError.
+func TestCallbackPanic(t *testing.T) {
+	expectPanic := func(name string, f func() error) {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("%s: unexpected panic %v", name, r)
+			}
+		}()
+		err := f()
+		t.Fatalf("%s: returned %v instead of panicking", name, err)
+	}
+
+	data := testAxml(&testAxmlNode{name: "manifest"})
+	apk := writeTestApk(t, map[string][]byte{"AndroidManifest.xml": data})
+
+	expectPanic("ParseXmlEx", func() error {
+		return apkparser.ParseXmlEx(bytes.NewReader(data), testPanicEncoder{}, nil, nil)
+	})
+
+	zr, err := apkparser.OpenZip(apk)
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zr.Close()
+
+	parser, _ := apkparser.NewParser(zr, testPanicEncoder{})
+	expectPanic("ApkParser.ParseXml", func() error {
+		return parser.ParseXml("AndroidManifest.xml")
+	})
+
+	f, err := os.Open(apk)
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer f.Close()
+
+	expectPanic("WalkZipReader", func() error {
+		return apkparser.WalkZipReader(f, func(f *apkparser.ZipReaderFile) bool {
+			panic("boom")
+		})
+	})
+
+	expectPanic("HashEntries", func() error {
+		opts := &apkparser.HashOptions{Extra: map[string]func() hash.Hash{"boom": func() hash.Hash { panic("boom") }}}
+		return zr.HashEntries(opts)["AndroidManifest.xml"].Err
+	})
+}
+
+// ManifestEncoder collecting attributes of the root element.
+type testRootAttrEncoder struct {
+	attrs map[string]string
+}
+
+func (e *testRootAttrEncoder) EncodeToken(t xml.Token) error {
+	if start, ok := t.(xml.StartElement); ok && e.attrs == nil {
+		e.attrs = make(map[string]string)
+		for _, a := range start.Attr {
+			e.attrs[a.Name.Local] = a.Value
+		}
+	}
+	return nil
+}
+
+func (e *testRootAttrEncoder) Flush() error {
+	return nil
+}
+
+func TestOverlayable(t *testing.T) {
+	arsc := testArsc{
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"string"},
+			keys:  []string{"app_name"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeIntDec), data: 1}},
+				}),
+				testArscOverlayable("Theme", "overlay://theme", []testArscPolicy{
+					{flags: 0x1, ids: []uint32{0x7f010000}},
+					{flags: 0x6, ids: []uint32{0x7f010001, 0x7f010002}},
+				}),
+			},
+		}},
+	}
+
+	res, err := apkparser.ParseResourceTable(bytes.NewReader(arsc.bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	if len(res.Overlayables()) != 1 {
+		t.Fatalf("expected one overlayable, got %d", len(res.Overlayables()))
+	}
+
+	o, policy := res.GetOverlayable(0x7f010002)
+	if o == nil || o.Name != "Theme" || o.Actor != "overlay://theme" || o.Package != "com.example" {
+		t.Fatalf("unexpected overlayable %+v", o)
+	}
+
+	if policy.String() != "system|vendor" {
+		t.Fatalf("unexpected policy %s", policy.String())
+	}
+
+	if o, _ := res.GetOverlayable(0x7f020000); o != nil {
+		t.Fatalf("resource should not be overlayable")
+	}
+}
+
+func TestStagedAlias(t *testing.T) {
+	arsc := testArsc{
+		strings: []string{"Example"},
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"string"},
+			keys:  []string{"app_name"},
+			chunks: [][]byte{
+				testArscStagedAlias([][2]uint32{{0x7f0f0000, 0x7f010000}}),
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 0}},
+				}),
+			},
+		}},
+	}
+
+	res, err := apkparser.ParseResourceTable(bytes.NewReader(arsc.bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	if res.StagedAliases()[0x7f0f0000] != 0x7f010000 {
+		t.Fatalf("unexpected staged aliases %v", res.StagedAliases())
+	}
+
+	entry, err := res.GetResourceEntry(0x7f0f0000)
+	if err != nil {
+		t.Fatalf("failed to resolve the staged id: %s", err.Error())
+	}
+
+	if val, _ := entry.GetValue().String(); val != "Example" {
+		t.Fatalf("unexpected value %s", val)
+	}
+}
+
+func TestCompactEntries(t *testing.T) {
+	for _, flags := range []uint8{0, 0x01, 0x02} {
+		entries := []*testArscEntry{
+			{key: 0, compact: true, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 0}},
+			nil,
+			{key: 1, compact: true, value: testArscValue{typ: uint8(apkparser.AttrTypeIntDec), data: 42}},
+		}
+
+		arsc := testArsc{
+			strings: []string{"Example"},
+			packages: []*testArscPackage{{
+				id:    0x7f,
+				name:  "com.example",
+				types: []string{"string"},
+				keys:  []string{"app_name", "answer", "missing"},
+				chunks: [][]byte{
+					testArscTypeSpec(1, []uint32{0, 0, 0}),
+					testArscTypeFlags(1, flags, testArscConfig("", 0), entries),
+				},
+			}},
+		}
+
+		res, err := apkparser.ParseResourceTable(bytes.NewReader(arsc.bytes()))
+		if err != nil {
+			t.Fatalf("flags 0x%x: failed to parse resources: %s", flags, err.Error())
+		}
+
+		for id, expected := range map[uint32]string{0x7f010000: "Example", 0x7f010002: "42"} {
+			entry, err := res.GetResourceEntry(id)
+			if err != nil {
+				t.Fatalf("flags 0x%x: failed to get 0x%08x: %s", flags, id, err.Error())
+			}
+
+			if val, _ := entry.GetValue().String(); val != expected {
+				t.Fatalf("flags 0x%x: unexpected value %s of 0x%08x", flags, val, id)
+			}
+		}
+
+		if _, err := res.GetResourceEntry(0x7f010001); err == nil {
+			t.Fatalf("flags 0x%x: missing entry was found", flags)
+		}
+
+		if id, err := res.GetResourceId("string/answer"); err != nil || id != 0x7f010002 {
+			t.Fatalf("flags 0x%x: unexpected id 0x%08x: %v", flags, id, err)
+		}
+	}
+}
+
+func TestDynamicReferences(t *testing.T) {
+	stringType := func(key, str uint32) [][]byte {
+		return [][]byte{
+			testArscTypeSpec(1, []uint32{0}),
+			testArscType(1, testArscConfig("", 0), []*testArscEntry{
+				{key: key, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: str}},
+			}),
+		}
+	}
+
+	arsc := testArsc{
+		strings: []string{"App", "Library"},
+		packages: []*testArscPackage{
+			{
+				id:     0x7f,
+				name:   "com.example",
+				types:  []string{"string"},
+				keys:   []string{"app_name"},
+				chunks: append([][]byte{testArscLibrary(map[uint32]string{0x10: "com.example.lib"})}, stringType(0, 0)...),
+			},
+			{
+				id:     0,
+				name:   "com.example.lib",
+				types:  []string{"string"},
+				keys:   []string{"lib_name"},
+				chunks: stringType(0, 1),
+			},
+		},
+	}
+
+	res, err := apkparser.ParseResourceTable(bytes.NewReader(arsc.bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	if libs := res.SharedLibraries(); len(libs) != 1 || libs[0].PackageId != 0x10 || libs[0].PackageName != "com.example.lib" {
+		t.Fatalf("unexpected shared libraries %v", libs)
+	}
+
+	for id, expected := range map[uint32]string{0x00010000: "App", 0x10010000: "Library"} {
+		entry, err := res.GetResourceEntry(id)
+		if err != nil {
+			t.Fatalf("failed to resolve 0x%08x: %s", id, err.Error())
+		}
+
+		if val, _ := entry.GetValue().String(); val != expected {
+			t.Fatalf("unexpected value %s of 0x%08x", val, id)
+		}
+	}
+}
+
+func TestIconDynamicReference(t *testing.T) {
+	pkg := func(id uint32, name string, str uint32, chunks ...[]byte) *testArscPackage {
+		return &testArscPackage{
+			id:    id,
+			name:  name,
+			types: []string{"drawable"},
+			keys:  []string{"icon"},
+			chunks: append(chunks,
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: str}},
+				}),
+			),
+		}
+	}
+
+	// The runtime id of com.example.lib is the build time id of com.example.other,
+	// resolving the id twice would end up in the wrong package.
+	arsc := testArsc{
+		strings: []string{"app.png", "lib.png", "other.png"},
+		packages: []*testArscPackage{
+			pkg(0x7f, "com.example", 0, testArscLibrary(map[uint32]string{0x10: "com.example.lib", 0x11: "com.example.other"})),
+			pkg(0x11, "com.example.lib", 1),
+			pkg(0x12, "com.example.other", 2),
+		},
+	}
+
+	res, err := apkparser.ParseResourceTable(bytes.NewReader(arsc.bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	for _, config := range []apkparser.ResourceConfigOption{apkparser.ConfigFirst, apkparser.ConfigPngIcon} {
+		entry, err := res.GetResourceEntryEx(0x10010000, config)
+		if err != nil {
+			t.Fatalf("config %d: failed to resolve the icon: %s", config, err.Error())
+		}
+
+		if val, _ := entry.GetValue().String(); val != "lib.png" {
+			t.Fatalf("config %d: unexpected icon %s", config, val)
+		}
+	}
+}
+
+func TestMultiplePackages(t *testing.T) {
+	pkg := func(id uint32, name string, str uint32) *testArscPackage {
+		return &testArscPackage{
+			id:    id,
+			name:  name,
+			types: []string{"string"},
+			keys:  []string{"title"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: str}},
+				}),
+			},
+		}
+	}
+
+	arsc := testArsc{
+		strings:  []string{"App", "Feature"},
+		packages: []*testArscPackage{pkg(0x7f, "com.example", 0), pkg(0x80, "com.example.feature", 1)},
+	}
+
+	res, err := apkparser.ParseResourceTable(bytes.NewReader(arsc.bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	if pkgs := res.Packages(); len(pkgs) != 2 || pkgs[0].Name != "com.example" || pkgs[1].Id != 0x80 {
+		t.Fatalf("unexpected packages %v", pkgs)
+	}
+
+	for name, expected := range map[string]uint32{
+		"@string/title":                     0x7f010000,
+		"@com.example.feature:string/title": 0x80010000,
+	} {
+		if id, err := res.GetResourceId(name); err != nil || id != expected {
+			t.Fatalf("unexpected id 0x%08x of %s: %v", id, name, err)
+		}
+	}
+
+	if _, err := res.GetResourceId("@com.missing:string/title"); err == nil {
+		t.Fatalf("resource in missing package was found")
+	}
+}
+
+func TestColorValues(t *testing.T) {
+	colors := []struct {
+		typ      uint8
+		data     uint32
+		expected string
+	}{
+		{apkparser.AttrTypeIntColorArgb8, 0x80112233, "#80112233"},
+		{apkparser.AttrTypeIntColorRgb8, 0xff112233, "#112233"},
+		{apkparser.AttrTypeIntColorArgb4, 0x88112233, "#8123"},
+		{apkparser.AttrTypeIntColorRgb4, 0xff112233, "#123"},
+	}
+
+	var entries []*testArscEntry
+	var specs []uint32
+	for i, c := range colors {
+		entries = append(entries, &testArscEntry{key: uint32(i), value: testArscValue{typ: c.typ, data: c.data}})
+		specs = append(specs, 0)
+	}
+
+	arsc := testArsc{
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"color"},
+			keys:  []string{"argb8", "rgb8", "argb4", "rgb4"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, specs),
+				testArscType(1, testArscConfig("", 0), entries),
+			},
+		}},
+	}
+
+	res, err := apkparser.ParseResourceTable(bytes.NewReader(arsc.bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	for i, c := range colors {
+		entry, err := res.GetResourceEntry(0x7f010000 | uint32(i))
+		if err != nil {
+			t.Fatalf("failed to get color %d: %s", i, err.Error())
+		}
+
+		if val, _ := entry.GetValue().String(); val != c.expected {
+			t.Fatalf("unexpected color %s, expected %s", val, c.expected)
+		}
+	}
+}
+
+func TestBags(t *testing.T) {
+	str := func(idx uint32) testArscValue {
+		return testArscValue{typ: uint8(apkparser.AttrTypeString), data: idx}
+	}
+
+	arsc := testArsc{
+		strings: []string{"one apple", "%d apples", "first", "second"},
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"plurals", "array"},
+			keys:  []string{"apples", "items"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{{
+					key: 0,
+					bag: []testArscBagItem{
+						{name: apkparser.BagQuantityOne, value: str(0)},
+						{name: apkparser.BagQuantityOther, value: str(1)},
+					},
+				}}),
+				testArscTypeSpec(2, []uint32{0}),
+				testArscType(2, testArscConfig("", 0), []*testArscEntry{{
+					key:    1,
+					parent: 0x7f010000,
+					bag: []testArscBagItem{
+						{name: 0x02000000, value: str(2)},
+						{name: 0x02000001, value: str(3)},
+					},
+				}}),
+			},
+		}},
+	}
+
+	res, err := apkparser.ParseResourceTable(bytes.NewReader(arsc.bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	dump := func(id uint32) string {
+		entry, err := res.GetResourceEntry(id)
+		if err != nil {
+			t.Fatalf("failed to get 0x%08x: %s", id, err.Error())
+		}
+
+		if !entry.IsComplex() {
+			t.Fatalf("0x%08x is not complex", id)
+		}
+
+		var items []string
+		bag := entry.GetBag()
+		for i := range bag {
+			val, _ := bag[i].Value.String()
+			items = append(items, res.GetBagItemName(&bag[i])+"="+val)
+		}
+		return fmt.Sprintf("%x:%s", entry.GetParent(), strings.Join(items, ","))
+	}
+
+	if got := dump(0x7f010000); got != "0:one=one apple,other=%d apples" {
+		t.Fatalf("unexpected plurals %s", got)
+	}
+
+	if got := dump(0x7f020000); got != "7f010000:[0]=first,[1]=second" {
+		t.Fatalf("unexpected array %s", got)
+	}
+}
+
+func writeTestApk(t *testing.T, files map[string][]byte) string {
+	path := filepath.Join(t.TempDir(), "test.apk")
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create apk: %s", err.Error())
+	}
+	defer out.Close()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := zip.NewWriter(out)
+	for _, name := range names {
+		fw, _ := w.Create(name)
+		fw.Write(files[name])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to write apk: %s", err.Error())
+	}
+	return path
+}
+
+func TestParseAllXml(t *testing.T) {
+	manifest, err := ioutil.ReadFile("testdata/98d2e837b8f3ac41e74b86b2d532972955e5352197a893206ecd9650f678ae31.bin")
+	if err != nil {
+		t.Fatalf("failed to read file: %s", err.Error())
+	}
+
+	apkPath := writeTestApk(t, map[string][]byte{
+		"AndroidManifest.xml":  manifest,
+		"res/layout/main.xml":  manifest,
+		"res/xml/plain.xml":    []byte("<?xml version=\"1.0\"?><config/>"),
+		"res/raw/data.bin":     manifest,
+		"assets/not-under.xml": manifest,
+	})
+
+	zr, err := apkparser.OpenZip(apkPath)
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zr.Close()
+
+	var buf bytes.Buffer
+	parser, _ := apkparser.NewParser(zr, xml.NewEncoder(&buf))
+
+	var results []string
+	parser.ParseAllXml("res", func(name string, err error) {
+		results = append(results, fmt.Sprintf("%s:%v:%v", name, err == nil, bytes.Contains(buf.Bytes(), []byte("name.tbx.erndy"))))
+		buf.Reset()
+	})
+
+	if got := strings.Join(results, ","); got != "res/layout/main.xml:true:true,res/xml/plain.xml:false:false" {
+		t.Fatalf("unexpected results %s", got)
+	}
+}
+
+func TestNetworkSecurityConfig(t *testing.T) {
+	manifest := testAxml(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "package", value: "com.example"}},
+		children: []*testAxmlNode{{
+			name:  "application",
+			attrs: []testAxmlAttr{{name: "android:networkSecurityConfig", value: "res/xml/nsc.xml"}},
+		}},
+	})
+
+	nsc := testAxml(&testAxmlNode{
+		name: "network-security-config",
+		children: []*testAxmlNode{
+			{
+				name:  "base-config",
+				attrs: []testAxmlAttr{{name: "cleartextTrafficPermitted", typ: 0x12, data: 0}},
+				children: []*testAxmlNode{{
+					name: "trust-anchors",
+					children: []*testAxmlNode{
+						{name: "certificates", attrs: []testAxmlAttr{{name: "src", value: "system"}}},
+					},
+				}},
+			},
+			{
+				name: "domain-config",
+				children: []*testAxmlNode{
+					{name: "domain", attrs: []testAxmlAttr{{name: "includeSubdomains", typ: 0x12, data: 1}}, text: "example.com"},
+					{
+						name:  "pin-set",
+						attrs: []testAxmlAttr{{name: "expiration", value: "2030-01-01"}},
+						children: []*testAxmlNode{
+							{name: "pin", attrs: []testAxmlAttr{{name: "digest", value: "SHA-256"}}, text: "AAAA"},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	apkPath := writeTestApk(t, map[string][]byte{
+		"AndroidManifest.xml": manifest,
+		"res/xml/nsc.xml":     nsc,
+	})
+
+	zr, err := apkparser.OpenZip(apkPath)
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zr.Close()
+
+	parser, _ := apkparser.NewParser(zr, nil)
+	config, err := parser.ParseNetworkSecurityConfig()
+	if err != nil {
+		t.Fatalf("failed to parse config: %s", err.Error())
+	}
+
+	if config.BaseConfig == nil || config.BaseConfig.CleartextTrafficPermitted == nil || *config.BaseConfig.CleartextTrafficPermitted {
+		t.Fatalf("unexpected base config %+v", config.BaseConfig)
+	}
+
+	if len(config.BaseConfig.TrustAnchors) != 1 || config.BaseConfig.TrustAnchors[0].Src != "system" {
+		t.Fatalf("unexpected trust anchors %+v", config.BaseConfig.TrustAnchors)
+	}
+
+	if len(config.DomainConfigs) != 1 {
+		t.Fatalf("unexpected domain configs %+v", config.DomainConfigs)
+	}
+
+	dc := config.DomainConfigs[0]
+	if len(dc.Domains) != 1 || dc.Domains[0].Name != "example.com" || !dc.Domains[0].IncludeSubdomains {
+		t.Fatalf("unexpected domains %+v", dc.Domains)
+	}
+
+	if dc.PinSet == nil || dc.PinSet.Expiration != "2030-01-01" || len(dc.PinSet.Pins) != 1 || dc.PinSet.Pins[0].Value != "AAAA" {
+		t.Fatalf("unexpected pin set %+v", dc.PinSet)
+	}
+}
+
+func TestMetaData(t *testing.T) {
+	metaData := func(name, attr, value string) *testAxmlNode {
+		return &testAxmlNode{name: "meta-data", attrs: []testAxmlAttr{{name: "android:name", value: name}, {name: "android:" + attr, value: value}}}
+	}
+
+	manifest := testAxml(&testAxmlNode{
+		name: "manifest",
+		children: []*testAxmlNode{{
+			name: "application",
+			children: []*testAxmlNode{
+				metaData("com.example.key", "value", "secret"),
+				{
+					name:     "provider",
+					attrs:    []testAxmlAttr{{name: "android:name", value: "androidx.core.content.FileProvider"}},
+					children: []*testAxmlNode{metaData("android.support.FILE_PROVIDER_PATHS", "resource", "res/xml/paths.xml")},
+				},
+			},
+		}},
+	})
+
+	paths := testAxml(&testAxmlNode{
+		name: "paths",
+		children: []*testAxmlNode{
+			{name: "external-path", attrs: []testAxmlAttr{{name: "name", value: "ext"}, {name: "path", value: "."}}},
+		},
+	})
+
+	apkPath := writeTestApk(t, map[string][]byte{
+		"AndroidManifest.xml": manifest,
+		"res/xml/paths.xml":   paths,
+	})
+
+	zr, err := apkparser.OpenZip(apkPath)
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zr.Close()
+
+	parser, _ := apkparser.NewParser(zr, nil)
+	entries, err := parser.MetaData(true)
+	if err != nil {
+		t.Fatalf("failed to get meta-data: %s", err.Error())
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("unexpected meta-data count %d", len(entries))
+	}
+
+	if e := entries[0]; e.Name != "com.example.key" || e.Value != "secret" || e.ComponentType != "application" || e.Component != "" || e.Xml != nil {
+		t.Fatalf("unexpected application meta-data %+v", e)
+	}
+
+	e := entries[1]
+	if e.ComponentType != "provider" || e.Component != "androidx.core.content.FileProvider" || e.Resource != "res/xml/paths.xml" || e.XmlErr != nil {
+		t.Fatalf("unexpected provider meta-data %+v", e)
+	}
+	if e.Xml == nil || len(e.Xml.Find("external-path")) != 1 || e.Xml.Find("external-path")[0].AttrValue("", "name") != "ext" {
+		t.Fatalf("unexpected decoded xml %+v", e.Xml)
+	}
+
+	if entries, _ := parser.MetaData(false); len(entries) != 2 || entries[1].Xml != nil {
+		t.Fatalf("xml decoded when not requested")
+	}
+}
+
+func TestObfuscatedResourceFiles(t *testing.T) {
+	// AndResGuard-like table, the drawable type is renamed and the files have no extensions.
+	arsc := testArsc{
+		strings: []string{"r/a/c", "r/a/d"},
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"a"},
+			keys:  []string{"b"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscDisplayConfig(0, 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 0}},
+				}),
+				testArscType(1, testArscDisplayConfig(apkparser.DensityHigh, 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 1}},
+				}),
+			},
+		}},
+	}
+
+	apkPath := writeTestApk(t, map[string][]byte{
+		"AndroidManifest.xml": testAxml(&testAxmlNode{
+			name:  "manifest",
+			attrs: []testAxmlAttr{{name: "android:icon", typ: uint8(apkparser.AttrTypeReference), data: 0x7f010000}},
+		}),
+		"resources.arsc": arsc.bytes(),
+		"r/a/c":          testAxml(&testAxmlNode{name: "adaptive-icon"}),
+		"r/a/d":          []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"),
+	})
+
+	zip, err := apkparser.OpenZip(apkPath)
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zip.Close()
+
+	for _, sniff := range []bool{false, true} {
+		enc := &testRootAttrEncoder{}
+		parser, err := apkparser.NewParserEx(zip, enc, &apkparser.ParseOptions{SniffResourceFiles: sniff})
+		if err != nil {
+			t.Fatalf("failed to parse resources: %s", err.Error())
+		}
+
+		// Without sniffing, there's no .png and the first value is used.
+		expectedIcon, expectedFiles := "r/a/c", "r/a/c:,r/a/d:"
+		if sniff {
+			expectedIcon, expectedFiles = "r/a/d", "r/a/c:xml,r/a/d:png"
+		}
+
+		if err := parser.ParseXml("AndroidManifest.xml"); err != nil {
+			t.Fatalf("failed to parse manifest: %s", err.Error())
+		}
+		if icon := enc.attrs["icon"]; icon != expectedIcon {
+			t.Fatalf("sniff %v: unexpected icon %s", sniff, icon)
+		}
+
+		files, err := parser.Resources().GetResourceFiles(0x7f010000)
+		if err != nil {
+			t.Fatalf("failed to get files: %s", err.Error())
+		}
+
+		var got []string
+		for _, f := range files {
+			got = append(got, f.Path+":"+f.Format)
+		}
+		if strings.Join(got, ",") != expectedFiles {
+			t.Fatalf("sniff %v: unexpected files %v", sniff, got)
+		}
+	}
+}
+
+func TestPaddedResourceStructures(t *testing.T) {
+	// Headers and entries bigger than the known structures, as if newer versions added fields.
+	arsc := testArsc{
+		strings:     []string{"Example", "Other"},
+		poolPadding: 4,
+		packages: []*testArscPackage{{
+			id:          0x7f,
+			name:        "com.example",
+			types:       []string{"string"},
+			keys:        []string{"app_name", "other"},
+			poolPadding: 8,
+			chunks: [][]byte{
+				testArscPadHeader(testArscTypeSpec(1, []uint32{0x4, 0}), 8),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 0}, padding: 8},
+					{key: 1, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 1}},
+				}),
+			},
+		}},
+	}
+
+	var warnings []apkparser.Warning
+	res, err := apkparser.ParseResourceTableEx(bytes.NewReader(arsc.bytes()), &apkparser.ParseOptions{Warnings: &warnings})
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings %v", warnings)
+	}
+
+	for id, expected := range map[uint32]string{0x7f010000: "Example", 0x7f010001: "Other"} {
+		e, err := res.GetResourceEntry(id)
+		if err != nil {
+			t.Fatalf("failed to get 0x%08x: %s", id, err.Error())
+		}
+		if val, _ := e.GetValue().String(); val != expected || e.Key == "" {
+			t.Fatalf("unexpected value %s of %s, expected %s", val, e.Key, expected)
+		}
+	}
+
+	if spec, err := res.GetResourceSpec(0x7f010000); err != nil || spec.Changes != apkparser.ConfigChangeLocale {
+		t.Fatalf("unexpected spec %+v: %v", spec, err)
+	}
+}
+
+func TestResourceSpecs(t *testing.T) {
+	value := []*testArscEntry{{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeIntDec), data: 1}}}
+	arsc := testArsc{
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"integer"},
+			keys:  []string{"a", "b"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0x40000004, 0x1100}),
+				testArscType(1, testArscConfig("", 0), value),
+			},
+		}},
+	}
+	res, err := apkparser.ParseResourceTableBytes(arsc.bytes())
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	spec, err := res.GetResourceSpec(0x7f010000)
+	if err != nil {
+		t.Fatalf("failed to get spec: %s", err.Error())
+	}
+	if spec.Changes != apkparser.ConfigChangeLocale || !spec.Public || spec.StagedApi {
+		t.Fatalf("unexpected spec %+v", spec)
+	}
+
+	specs := res.ResourceSpecs()
+	if len(specs) != 2 || specs[1].Id != 0x7f010001 || specs[1].Changes.String() != "density|uiMode" {
+		t.Fatalf("unexpected specs %+v", specs)
+	}
+
+	if _, err := res.GetResourceSpec(0x7f010002); err == nil {
+		t.Fatalf("expected error for entry outside of the spec")
+	}
+}
+
+func TestPublicResources(t *testing.T) {
+	value := testArscValue{typ: uint8(apkparser.AttrTypeIntDec), data: 1}
+	arsc := testArsc{
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"integer"},
+			keys:  []string{"spec_public", "entry_public", "private"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0x40000000, 0, 0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					{key: 0, value: value},
+					{key: 1, flags: 0x0002, value: value},
+					{key: 2, value: value},
+				}),
+			},
+		}},
+	}
+	res, err := apkparser.ParseResourceTableBytes(arsc.bytes())
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	expected := []apkparser.PublicResource{
+		{Id: 0x7f010000, Type: "integer", Key: "spec_public"},
+		{Id: 0x7f010001, Type: "integer", Key: "entry_public"},
+	}
+	if public := res.PublicResources(); !reflect.DeepEqual(public, expected) {
+		t.Fatalf("unexpected public resources %+v", public)
+	}
+
+	if e, _ := res.GetResourceEntry(0x7f010002); e == nil || e.IsPublic() {
+		t.Fatalf("private entry flagged as public")
+	}
+}
+
+func TestTypedResourceValues(t *testing.T) {
+	entry := func(key uint32, typ apkparser.AttrType, data uint32) *testArscEntry {
+		return &testArscEntry{key: key, value: testArscValue{typ: uint8(typ), data: data}}
+	}
+
+	arsc := testArsc{
+		strings: []string{"res/drawable/a.png"},
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"misc"},
+			keys:  []string{"int", "bool", "color", "float", "ref", "file"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, make([]uint32, 6)),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					entry(0, apkparser.AttrTypeIntDec, 0xFFFFFFFE),
+					entry(1, apkparser.AttrTypeIntBool, 0xFFFFFFFF),
+					entry(2, apkparser.AttrTypeIntColorRgb8, 0xFF112233),
+					entry(3, apkparser.AttrTypeFloat, math.Float32bits(1.5)),
+					entry(4, apkparser.AttrTypeReference, 0x7f010000),
+					entry(5, apkparser.AttrTypeString, 0),
+				}),
+			},
+		}},
+	}
+	res, err := apkparser.ParseResourceTableBytes(arsc.bytes())
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	get := func(id uint32) *apkparser.ResourceEntry {
+		e, err := res.GetResourceEntry(id)
+		if err != nil {
+			t.Fatalf("failed to get 0x%08x: %s", id, err.Error())
+		}
+		return e
+	}
+
+	if v, err := get(0x7f010000).Int(); v != -2 || err != nil {
+		t.Fatalf("unexpected int %d %v", v, err)
+	}
+	if v, err := get(0x7f010001).Bool(); !v || err != nil {
+		t.Fatalf("unexpected bool %v %v", v, err)
+	}
+	if v, err := get(0x7f010002).Color(); v != 0xFF112233 || err != nil {
+		t.Fatalf("unexpected color %x %v", v, err)
+	}
+	if v, err := get(0x7f010003).Float(); v != 1.5 || err != nil {
+		t.Fatalf("unexpected float %g %v", v, err)
+	}
+	if v, err := get(0x7f010004).Reference(); v != 0x7f010000 || err != nil {
+		t.Fatalf("unexpected reference %x %v", v, err)
+	}
+	if v, err := get(0x7f010005).FilePath(); v != "res/drawable/a.png" || err != nil {
+		t.Fatalf("unexpected file %q %v", v, err)
+	}
+
+	var typeErr *apkparser.ValueTypeError
+	if _, err := get(0x7f010003).Int(); !errors.As(err, &typeErr) || typeErr.Type != apkparser.AttrTypeFloat || typeErr.Expected != "int" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := get(0x7f010000).FilePath(); !errors.As(err, &typeErr) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestInMemoryApk(t *testing.T) {
+	apkPath := writeTestApk(t, map[string][]byte{
+		"AndroidManifest.xml": testAxml(&testAxmlNode{
+			name:  "manifest",
+			attrs: []testAxmlAttr{{name: "package", value: "com.example"}},
+		}),
+	})
+	data, err := ioutil.ReadFile(apkPath)
+	if err != nil {
+		t.Fatalf("failed to read apk: %s", err.Error())
+	}
+
+	zr, err := apkparser.OpenZipBytes(data)
+	if err != nil || zr.File["AndroidManifest.xml"] == nil {
+		t.Fatalf("failed to open zip: %v", err)
+	}
+	zr.Close()
+
+	var buf bytes.Buffer
+	zipErr, _, manifestErr := apkparser.ParseApkBytes(data, xml.NewEncoder(&buf))
+	if zipErr != nil || manifestErr != nil || !strings.Contains(buf.String(), `package="com.example"`) {
+		t.Fatalf("failed to parse apk: %v %v %s", zipErr, manifestErr, buf.String())
+	}
+
+	summary, err := apkparser.SummarizeReader(bytes.NewReader(data), nil)
+	if err != nil || summary.Package != "com.example" {
+		t.Fatalf("unexpected summary %+v %v", summary, err)
+	}
+}
+
+func TestOpenZipMmap(t *testing.T) {
+	apkPath := writeTestApk(t, map[string][]byte{"assets/a.txt": []byte("content")})
+
+	zr, err := apkparser.OpenZipMmap(apkPath)
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+
+	data, err := zr.File["assets/a.txt"].ReadAll(1 << 20)
+	if err != nil || string(data) != "content" {
+		t.Fatalf("unexpected content %q %v", data, err)
+	}
+
+	if err := zr.Close(); err != nil {
+		t.Fatalf("failed to close zip: %s", err.Error())
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.apk")
+	ioutil.WriteFile(empty, nil, 0644)
+	// Empty files can't be mapped, they are opened like by OpenZip.
+	zr, err = apkparser.OpenZipMmap(empty)
+	zrPlain, zipErr := apkparser.OpenZip(empty)
+	if (err == nil) != (zipErr == nil) {
+		t.Fatalf("unexpected error of empty file %v, OpenZip returned %v", err, zipErr)
+	} else if err == nil {
+		zr.Close()
+		zrPlain.Close()
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	intAttr := func(name string, value uint32) testAxmlAttr {
+		return testAxmlAttr{name: name, typ: uint8(apkparser.AttrTypeIntDec), data: value}
+	}
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	apkPath := writeTestApk(t, map[string][]byte{
+		"AndroidManifest.xml": testAxml(&testAxmlNode{
+			name: "manifest",
+			attrs: []testAxmlAttr{
+				{name: "package", value: "com.example"},
+				intAttr("android:versionCode", 42),
+				{name: "android:versionName", value: "1.2"},
+			},
+			children: []*testAxmlNode{
+				{name: "uses-sdk", attrs: []testAxmlAttr{intAttr("android:minSdkVersion", 21), intAttr("android:targetSdkVersion", 33)}},
+				{name: "uses-permission", attrs: []testAxmlAttr{{name: "android:name", value: "android.permission.CAMERA"}}},
+				{name: "uses-permission-sdk-23", attrs: []testAxmlAttr{{name: "android:name", value: "android.permission.INTERNET"}}},
+				{
+					name: "application",
+					attrs: []testAxmlAttr{
+						{name: "android:label", typ: uint8(apkparser.AttrTypeReference), data: 0x7f010000},
+						{name: "android:icon", value: "res/icon.png"},
+					},
+					children: []*testAxmlNode{
+						{name: "activity"},
+						{name: "activity-alias"},
+						{name: "service"},
+						{name: "provider"},
+					},
+				},
+			},
+		}),
+		"resources.arsc":           testArscSimple(),
+		"res/icon.png":             png,
+		"classes.dex":              []byte("dex"),
+		"classes2.dex":             []byte("dex"),
+		"lib/x86/libfoo.so":        []byte("so"),
+		"lib/arm64-v8a/libfoo.so":  []byte("so"),
+		"assets/classes3.dex":      []byte("dex"),
+		"lib/arm64-v8a/readme.txt": []byte("txt"),
+	})
+
+	s, err := apkparser.Summarize(apkPath)
+	if err != nil {
+		t.Fatalf("failed to summarize: %s", err.Error())
+	}
+
+	if s.Package != "com.example" || s.VersionCode != 42 || s.VersionName != "1.2" || s.MinSdk != 21 || s.TargetSdk != 33 || s.MaxSdk != 0 {
+		t.Fatalf("unexpected manifest values %+v", s)
+	}
+	if s.Label != "Example" || s.IconPath != "res/icon.png" || !bytes.Equal(s.Icon, png) || s.ResourcesErr != nil {
+		t.Fatalf("unexpected label or icon %+v", s)
+	}
+	if !reflect.DeepEqual(s.Permissions, []string{"android.permission.CAMERA", "android.permission.INTERNET"}) {
+		t.Fatalf("unexpected permissions %v", s.Permissions)
+	}
+	if s.Activities != 2 || s.Services != 1 || s.Receivers != 0 || s.Providers != 1 {
+		t.Fatalf("unexpected component counts %+v", s)
+	}
+	if !reflect.DeepEqual(s.NativeAbis, []string{"arm64-v8a", "x86"}) || s.DexCount != 2 || s.FileCount != 9 || s.UncompressedSize == 0 {
+		t.Fatalf("unexpected file statistics %+v", s)
+	}
+}
+
+func TestErrorTypes(t *testing.T) {
+	parse := func(data []byte) error {
+		return apkparser.ParseXml(bytes.NewReader(data), xml.NewEncoder(ioutil.Discard), nil)
+	}
+
+	manifest := testAxml(&testAxmlNode{name: "manifest", attrs: []testAxmlAttr{{name: "package", value: "com.example"}}})
+	if err := parse(manifest[:len(manifest)-30]); !errors.Is(err, apkparser.ErrTruncatedChunk) {
+		t.Fatalf("expected ErrTruncatedChunk, got '%v'", err)
+	}
+
+	tag := make([]byte, 20)
+	binary.LittleEndian.PutUint32(tag, 0xFFFFFFFF)
+	binary.LittleEndian.PutUint32(tag[4:], 5)
+	binary.LittleEndian.PutUint16(tag[8:], 0x14)
+	binary.LittleEndian.PutUint16(tag[10:], 0x14)
+	badName := testArscChunk(0x0102, 16, []byte{1, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF}, tag)
+	if err := parse(testArscChunk(0x0003, 8, nil, append(testArscStringPool([]string{"a"}), badName...))); !errors.Is(err, apkparser.ErrBadStringIndex) {
+		t.Fatalf("expected ErrBadStringIndex, got '%v'", err)
+	}
+
+	var unknownErr *apkparser.UnknownChunkError
+	unknown := testArscChunk(0x0150, 16, []byte{1, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF}, nil)
+	if err := parse(testArscChunk(0x0003, 8, nil, append(testArscStringPool([]string{"a"}), unknown...))); !errors.As(err, &unknownErr) || unknownErr.Id != 0x0150 {
+		t.Fatalf("expected UnknownChunkError, got '%v'", err)
+	}
+
+	apkPath := writeTestApk(t, map[string][]byte{"AndroidManifest.xml": manifest})
+	zr, err := apkparser.OpenZip(apkPath)
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zr.Close()
+
+	var entryErr *apkparser.ZipEntryError
+	parser, resErr := apkparser.NewParser(zr, xml.NewEncoder(ioutil.Discard))
+	if resErr != os.ErrNotExist {
+		t.Fatalf("expected missing resources.arsc, got '%v'", resErr)
+	}
+	if err := parser.ParseXml("res/missing.xml"); !errors.As(err, &entryErr) || entryErr.Name != "res/missing.xml" {
+		t.Fatalf("expected ZipEntryError, got '%v'", err)
+	}
+}
+
+func TestSkipUnknownChunks(t *testing.T) {
+	manifest := testAxml(&testAxmlNode{name: "manifest", attrs: []testAxmlAttr{{name: "package", value: "com.example"}}})
+
+	// Inserted right after the string pool, with a header like the XML nodes and some payload.
+	poolEnd := 8 + binary.LittleEndian.Uint32(manifest[12:])
+	unknown := testArscChunk(0x0150, 16, []byte{1, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF}, []byte("payload!"))
+	data := append(append(append([]byte{}, manifest[:poolEnd]...), unknown...), manifest[poolEnd:]...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)))
+
+	var unknownErr *apkparser.UnknownChunkError
+	if _, err := apkparser.ParseXmlTree(bytes.NewReader(data), nil); !errors.As(err, &unknownErr) {
+		t.Fatalf("expected UnknownChunkError, got '%v'", err)
+	}
+
+	var warnings []apkparser.Warning
+	tree, err := apkparser.ParseXmlTreeEx(bytes.NewReader(data), nil, &apkparser.ParseOptions{SkipUnknownChunks: true, Warnings: &warnings})
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err.Error())
+	}
+	if tree.AttrValue("", "package") != "com.example" {
+		t.Fatalf("unexpected tree %+v", tree)
+	}
+	if len(warnings) != 1 || warnings[0].Kind != apkparser.WarnUnknownChunk {
+		t.Fatalf("unexpected warnings %v", warnings)
+	}
+}
+
+func TestPartialResults(t *testing.T) {
+	manifest := testAxml(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "package", value: "com.example"}},
+		children: []*testAxmlNode{
+			{name: "uses-sdk"},
+			{name: "application", children: []*testAxmlNode{{name: "activity"}, {name: "service"}}},
+		},
+	})
+	truncated := manifest[:len(manifest)-60]
+	opts := &apkparser.ParseOptions{PartialResults: true}
+
+	var buf bytes.Buffer
+	if err := apkparser.ParseXmlEx(bytes.NewReader(truncated), xml.NewEncoder(&buf), nil, opts); !errors.Is(err, apkparser.ErrTruncatedChunk) {
+		t.Fatalf("expected ErrTruncatedChunk, got '%v'", err)
+	}
+
+	var root struct {
+		XMLName xml.Name
+		UsesSdk *struct{} `xml:"uses-sdk"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &root); err != nil || root.XMLName.Local != "manifest" || root.UsesSdk == nil {
+		t.Fatalf("partial output is not well-formed: %v\n%s", err, buf.String())
+	}
+
+	tree, err := apkparser.ParseXmlTreeEx(bytes.NewReader(truncated), nil, opts)
+	if err == nil || tree == nil || tree.Child("uses-sdk") == nil || tree.AttrValue("", "package") != "com.example" {
+		t.Fatalf("unexpected partial tree %+v: %v", tree, err)
+	}
+
+	if tree, err := apkparser.ParseXmlTree(bytes.NewReader(truncated), nil); err == nil || tree != nil {
+		t.Fatalf("partial tree returned without the option")
+	}
+}
+
+func TestParseXmlTreeRetry(t *testing.T) {
+	broken := testAxml(&testAxmlNode{
+		name:     "manifest",
+		attrs:    []testAxmlAttr{{name: "package", value: "com.broken"}},
+		children: []*testAxmlNode{{name: "uses-sdk"}, {name: "application"}},
+	})
+	valid := testAxml(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "package", value: "com.example"}},
+	})
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, data := range [][]byte{valid, broken[:len(broken)-30]} {
+		fw, _ := w.Create("AndroidManifest.xml")
+		fw.Write(data)
+	}
+	w.Close()
+
+	// Without the central directory, the duplicates are tried from the last one, which is truncated.
+	data := bytes.ReplaceAll(buf.Bytes(), []byte("PK\x01\x02"), []byte("XXXX"))
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	parser, _ := apkparser.NewParser(zr, nil)
+	tree, err := parser.ParseManifestTree()
+	if err != nil || tree.AttrValue("", "package") != "com.example" || len(tree.Children) != 0 {
+		t.Fatalf("unexpected tree %+v: %v", tree, err)
+	}
+}
+
+func TestClassifyPermissions(t *testing.T) {
+	uses := func(name string) *testAxmlNode {
+		return &testAxmlNode{name: "uses-permission", attrs: []testAxmlAttr{{name: "android:name", value: name}}}
+	}
+
+	manifest := testAxml(&testAxmlNode{
+		name: "manifest",
+		children: []*testAxmlNode{
+			{name: "permission", attrs: []testAxmlAttr{{name: "android:name", value: "com.example.C2D"},
+				{name: "android:protectionLevel", typ: uint8(apkparser.AttrTypeIntHex), data: 0x12}}},
+			uses("android.permission.INTERNET"),
+			uses("android.permission.CAMERA"),
+			uses("android.permission.SYSTEM_ALERT_WINDOW"),
+			uses("android.permission.POST_NOTIFICATIONS"),
+			uses("com.example.C2D"),
+			uses("com.other.PERMISSION"),
+			{name: "uses-permission-sdk-23", attrs: []testAxmlAttr{{name: "android:name", value: "android.permission.CAMERA"}}},
+		},
+	})
+
+	zr, err := apkparser.OpenZip(writeTestApk(t, map[string][]byte{"AndroidManifest.xml": manifest}))
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zr.Close()
+
+	parser, _ := apkparser.NewParser(zr, nil)
+	for api, expected := range map[int]string{
+		0:  "[android.permission.INTERNET] [android.permission.CAMERA android.permission.POST_NOTIFICATIONS] [android.permission.SYSTEM_ALERT_WINDOW com.example.C2D] [com.other.PERMISSION]",
+		22: "[android.permission.INTERNET] [android.permission.CAMERA android.permission.SYSTEM_ALERT_WINDOW] [com.example.C2D] [android.permission.POST_NOTIFICATIONS com.other.PERMISSION]",
+	} {
+		res, err := parser.ClassifyPermissions(api)
+		if err != nil {
+			t.Fatalf("failed to classify permissions: %s", err.Error())
+		}
+
+		if got := fmt.Sprint(res.Normal, res.Dangerous, res.Signature, res.Unknown); got != expected {
+			t.Fatalf("unexpected classification at API %d: %s", api, got)
+		}
+	}
+}
+
+func TestDeepLinks(t *testing.T) {
+	view := &testAxmlNode{name: "action", attrs: []testAxmlAttr{{name: "android:name", value: "android.intent.action.VIEW"}}}
+	data := func(attrs ...testAxmlAttr) *testAxmlNode {
+		return &testAxmlNode{name: "data", attrs: attrs}
+	}
+
+	manifest := testAxml(&testAxmlNode{
+		name: "manifest",
+		children: []*testAxmlNode{{
+			name: "application",
+			children: []*testAxmlNode{
+				{
+					name:  "activity",
+					attrs: []testAxmlAttr{{name: "android:name", value: ".Main"}},
+					children: []*testAxmlNode{
+						{
+							name:  "intent-filter",
+							attrs: []testAxmlAttr{{name: "android:autoVerify", typ: 0x12, data: 0xFFFFFFFF}},
+							children: []*testAxmlNode{
+								view,
+								data(testAxmlAttr{name: "android:scheme", value: "http"}),
+								data(testAxmlAttr{name: "android:scheme", value: "https"}),
+								data(testAxmlAttr{name: "android:host", value: "example.com"}),
+								data(testAxmlAttr{name: "android:pathPrefix", value: "/app"}),
+							},
+						},
+						{
+							name: "intent-filter",
+							children: []*testAxmlNode{
+								{name: "action", attrs: []testAxmlAttr{{name: "android:name", value: "android.intent.action.SEND"}}},
+								data(testAxmlAttr{name: "android:scheme", value: "ignored"}),
+							},
+						},
+					},
+				},
+				{
+					name:  "activity-alias",
+					attrs: []testAxmlAttr{{name: "android:name", value: ".Alias"}},
+					children: []*testAxmlNode{{
+						name: "intent-filter",
+						children: []*testAxmlNode{
+							view,
+							data(testAxmlAttr{name: "android:scheme", value: "myapp"}),
+						},
+					}, {
+						name: "intent-filter",
+						children: []*testAxmlNode{
+							view,
+							data(testAxmlAttr{name: "android:scheme", value: "https"},
+								testAxmlAttr{name: "android:host", value: "example.com"},
+								testAxmlAttr{name: "android:pathPrefix", value: "/app"}),
+						},
+					}},
+				},
+			},
+		}},
+	})
+
+	apkPath := writeTestApk(t, map[string][]byte{"AndroidManifest.xml": manifest})
+	zr, err := apkparser.OpenZip(apkPath)
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zr.Close()
+
+	parser, _ := apkparser.NewParser(zr, nil)
+	links, err := parser.DeepLinks()
+	if err != nil {
+		t.Fatalf("failed to get deep links: %s", err.Error())
+	}
+
+	var res []string
+	for _, l := range links {
+		res = append(res, fmt.Sprintf("%s %v %s", l.String(), l.AutoVerify, strings.Join(l.Activities, "|")))
+	}
+
+	expected := []string{
+		"http://example.com/app* true .Main",
+		"https://example.com/app* true .Main|.Alias",
+		"myapp:// false .Alias",
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("unexpected links %q", res)
+	}
+}
+
+func TestMergeSplitManifests(t *testing.T) {
+	parseTree := func(root *testAxmlNode) *apkparser.ManifestElement {
+		apkPath := writeTestApk(t, map[string][]byte{"AndroidManifest.xml": testAxml(root)})
+		zr, err := apkparser.OpenZip(apkPath)
+		if err != nil {
+			t.Fatalf("failed to open apk: %s", err.Error())
+		}
+		defer zr.Close()
+
+		parser, _ := apkparser.NewParser(zr, nil)
+		tree, err := parser.ParseManifestTree()
+		if err != nil {
+			t.Fatalf("failed to parse manifest: %s", err.Error())
+		}
+		return tree
+	}
+
+	named := func(name, value string, attrs ...testAxmlAttr) *testAxmlNode {
+		return &testAxmlNode{name: name, attrs: append([]testAxmlAttr{{name: "android:name", value: value}}, attrs...)}
+	}
+
+	base := parseTree(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "package", value: "com.example"}},
+		children: []*testAxmlNode{
+			named("uses-feature", "android.hardware.camera", testAxmlAttr{name: "android:required", typ: 0x12, data: 0}),
+			named("uses-permission", "android.permission.INTERNET"),
+			{name: "application", children: []*testAxmlNode{named("activity", ".Main")}},
+		},
+	})
+
+	split := parseTree(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "package", value: "com.example"}, {name: "split", value: "feature"}},
+		children: []*testAxmlNode{
+			named("uses-feature", "android.hardware.camera"),
+			named("uses-feature", "android.hardware.nfc"),
+			named("uses-permission", "android.permission.INTERNET"),
+			named("uses-permission", "android.permission.CAMERA"),
+			{name: "application", children: []*testAxmlNode{
+				named("activity", ".Main"),
+				named("activity", ".Feature"),
+				named("service", ".FeatureService"),
+				named("meta-data", "ignored"),
+			}},
+		},
+	})
+
+	merged := apkparser.MergeSplitManifests(base, split)
+
+	var summary []string
+	for _, c := range merged.Children {
+		summary = append(summary, c.Name.Local+":"+c.AndroidAttr("name")+":"+c.AndroidAttr("required"))
+	}
+	for _, c := range merged.Child("application").Children {
+		summary = append(summary, c.Name.Local+":"+c.AndroidAttr("name"))
+	}
+
+	expected := []string{
+		"uses-feature:android.hardware.camera:true",
+		"uses-permission:android.permission.INTERNET:",
+		"application::",
+		"uses-feature:android.hardware.nfc:",
+		"uses-permission:android.permission.CAMERA:",
+		"activity:.Main",
+		"activity:.Feature",
+		"service:.FeatureService",
+	}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Fatalf("unexpected merged manifest %q", summary)
+	}
+
+	if base.Children[0].AndroidAttr("required") != "false" || len(base.Child("application").Children) != 1 {
+		t.Fatalf("base manifest was modified")
+	}
+}
+
+func TestBrokenZipHeaders(t *testing.T) {
+	modified := time.Date(2020, 5, 17, 10, 30, 20, 0, time.UTC)
+	content := bytes.Repeat([]byte("hello world "), 100)
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, method := range []uint16{zip.Deflate, zip.Store} {
+		fw, _ := w.CreateHeader(&zip.FileHeader{
+			Name:     fmt.Sprintf("file%d.txt", method),
+			Method:   method,
+			Modified: modified,
+		})
+		fw.Write(content)
+	}
+	w.Close()
+
+	// damage the end of central directory record and the central directory
+	data := buf.Bytes()[:buf.Len()-10]
+	data = bytes.ReplaceAll(data, []byte("PK\x01\x02"), []byte("XXXX"))
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	if len(zr.Warnings) == 0 || zr.Warnings[0].Kind != apkparser.WarnBrokenZip {
+		t.Fatalf("expected broken zip warning, got %v", zr.Warnings)
+	}
+
+	for _, name := range []string{"file8.txt", "file0.txt"} {
+		f := zr.File[name]
+		if f == nil {
+			t.Fatalf("%s not found", name)
+		}
+
+		hdr := f.ZipHeader()
+		if hdr == nil {
+			t.Fatalf("%s: no header", name)
+		}
+
+		if hdr.UncompressedSize64 != uint64(len(content)) || hdr.CRC32 != crc32.ChecksumIEEE(content) || !hdr.Modified.Equal(modified) {
+			t.Fatalf("%s: unexpected header %+v", name, hdr)
+		}
+
+		// stored entries are read until the end of file
+		read, err := f.ReadAll(1 << 20)
+		if err != nil || !bytes.HasPrefix(read, content) {
+			t.Fatalf("%s: failed to read: %v", name, err)
+		}
+
+		if uint64(len(data)) < hdr.CompressedSize64 || hdr.CompressedSize64 == 0 {
+			t.Fatalf("%s: unexpected compressed size %d", name, hdr.CompressedSize64)
+		}
+	}
+}
+
+type testCountingReadSeeker struct {
+	io.ReadSeeker
+	read int64
+}
+
+func (s *testCountingReadSeeker) Read(p []byte) (int, error) {
+	n, err := s.ReadSeeker.Read(p)
+	s.read += int64(n)
+	return n, err
+}
+
+func TestBrokenZipUnsignedDescriptors(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i := 0; i < 100; i++ {
+		fw, _ := w.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("file%d.bin", i), Method: zip.Store})
+		fw.Write(bytes.Repeat([]byte{'a'}, 64*1024))
+	}
+	w.Close()
+
+	// No central directory and no descriptor signatures, the entries are found by their local headers.
+	data := buf.Bytes()[:bytes.Index(buf.Bytes(), []byte("PK\x01\x02"))]
+	data = bytes.ReplaceAll(data, []byte("PK\x07\x08"), []byte("pk\x07\x08"))
+
+	r := &testCountingReadSeeker{ReadSeeker: bytes.NewReader(data)}
+	zr, err := apkparser.OpenZipReader(r)
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	if len(zr.File) != 100 {
+		t.Fatalf("unexpected file count %d", len(zr.File))
+	}
+
+	// Looking for the descriptors doesn't go past the next entry.
+	if r.read > 10*int64(len(data)) {
+		t.Fatalf("read %d bytes of %d", r.read, len(data))
+	}
+}
+
+func TestRecoveredCentralDirectory(t *testing.T) {
+	content := []byte("stored content")
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, _ := w.CreateHeader(&zip.FileHeader{Name: "a.txt", Method: zip.Store})
+	fw.Write(content)
+	fw, _ = w.Create("dir/b.txt")
+	fw.Write(content)
+	w.Close()
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(buf.Bytes()[:buf.Len()-10]))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	var kinds []apkparser.WarningKind
+	for _, w := range zr.Warnings {
+		kinds = append(kinds, w.Kind)
+	}
+	if !reflect.DeepEqual(kinds, []apkparser.WarningKind{apkparser.WarnBrokenZip, apkparser.WarnRecoveredCentralDirectory}) {
+		t.Fatalf("unexpected warnings %v", zr.Warnings)
+	}
+
+	if len(zr.FilesOrdered) != 2 || zr.FilesOrdered[0].Name != "a.txt" || zr.FilesOrdered[1].Name != "dir/b.txt" {
+		t.Fatalf("unexpected files %v", zr.FilesOrdered)
+	}
+
+	for _, f := range zr.FilesOrdered {
+		read, err := f.ReadAll(1 << 20)
+		if err != nil || !bytes.Equal(read, content) {
+			t.Fatalf("%s: unexpected content %q: %v", f.Name, read, err)
+		}
+
+		if hdr := f.ZipHeader(); hdr == nil || hdr.CRC32 != crc32.ChecksumIEEE(content) {
+			t.Fatalf("%s: unexpected header %+v", f.Name, hdr)
+		}
+	}
+}
+
+func TestRecoveredCentralDirectoryBogusRecord(t *testing.T) {
+	// A central directory signature in the data whose name, extra and comment run past the end.
+	bogus := make([]byte, 46)
+	copy(bogus, "PK\x01\x02")
+	for _, off := range []int{28, 30, 32} {
+		binary.LittleEndian.PutUint16(bogus[off:], 0xFFFF)
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, _ := w.CreateHeader(&zip.FileHeader{Name: "bogus.bin", Method: zip.Store})
+	fw.Write(bogus)
+	fw, _ = w.CreateHeader(&zip.FileHeader{Name: "a.txt", Method: zip.Store})
+	fw.Write([]byte("stored content"))
+	w.Close()
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(buf.Bytes()[:buf.Len()-10]))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	var kinds []apkparser.WarningKind
+	for _, w := range zr.Warnings {
+		kinds = append(kinds, w.Kind)
+	}
+	if !reflect.DeepEqual(kinds, []apkparser.WarningKind{apkparser.WarnBrokenZip, apkparser.WarnRecoveredCentralDirectory}) {
+		t.Fatalf("unexpected warnings %v", zr.Warnings)
+	}
+
+	if len(zr.FilesOrdered) != 2 || zr.FilesOrdered[0].Name != "bogus.bin" || zr.FilesOrdered[1].Name != "a.txt" {
+		t.Fatalf("unexpected files %v", zr.FilesOrdered)
+	}
+}
+
+func TestZipDataOffset(t *testing.T) {
+	content := []byte("stored content")
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, _ := w.CreateHeader(&zip.FileHeader{Name: "a.txt", Method: zip.Store})
+	fw.Write(content)
+	w.Close()
+
+	valid := buf.Bytes()
+	broken := bytes.ReplaceAll(valid[:len(valid)-10], []byte("PK\x01\x02"), []byte("XXXX"))
+
+	for i, data := range [][]byte{valid, valid[:len(valid)-10], broken} {
+		zr, err := apkparser.OpenZipReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%d: failed to open zip: %s", i, err.Error())
+		}
+
+		f := zr.File["a.txt"]
+		offset, err := f.DataOffset()
+		if err != nil || !bytes.HasPrefix(data[offset:], content) {
+			t.Fatalf("%d: unexpected offset %d: %v", i, offset, err)
+		}
+
+		entries, err := f.SubEntries()
+		if err != nil || len(entries) != 1 || entries[0].Offset != offset || entries[0].Method != zip.Store ||
+			entries[0].CompressedSize != int64(len(content)) {
+			t.Fatalf("%d: unexpected entries %+v: %v", i, entries, err)
+		}
+		zr.Close()
+	}
+}
+
+func TestWalkZipReader(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"a.txt", "./b.txt", "a.txt", "c.txt"} {
+		fw, _ := w.Create(name)
+		fw.Write([]byte("content of " + name))
+	}
+	w.Close()
+
+	walk := func(data []byte, limit int) []string {
+		var res []string
+		err := apkparser.WalkZipReader(bytes.NewReader(data), func(f *apkparser.ZipReaderFile) bool {
+			content, err := f.ReadAll(1 << 20)
+			if err != nil {
+				t.Fatalf("%s: failed to read: %s", f.Name, err.Error())
+			}
+			res = append(res, f.Name+"="+string(content))
+			return len(res) < limit
+		})
+		if err != nil {
+			t.Fatalf("failed to walk: %s", err.Error())
+		}
+		return res
+	}
+
+	expected := []string{"a.txt=content of a.txt", "b.txt=content of ./b.txt", "a.txt=content of a.txt", "c.txt=content of c.txt"}
+	if res := walk(buf.Bytes(), 10); !reflect.DeepEqual(res, expected) {
+		t.Fatalf("unexpected entries %q", res)
+	}
+
+	if res := walk(buf.Bytes(), 2); !reflect.DeepEqual(res, expected[:2]) {
+		t.Fatalf("unexpected entries %q", res)
+	}
+
+	broken := bytes.ReplaceAll(buf.Bytes(), []byte("PK\x05\x06"), []byte("XXXX"))
+	if res := walk(broken, 10); !reflect.DeepEqual(res, expected) {
+		t.Fatalf("unexpected entries of broken zip %q", res)
+	}
+}
+
+func TestDivergentDuplicates(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range [][2]string{{"same", "content"}, {"same", "content"}, {"differs", "benign"}, {"differs", "malicious"}, {"single", "x"}} {
+		fw, _ := w.Create(e[0])
+		fw.Write([]byte(e[1]))
+	}
+	w.Close()
+
+	valid := buf.Bytes()
+	broken := bytes.ReplaceAll(valid, []byte("PK\x01\x02"), []byte("XXXX"))
+
+	for i, data := range [][]byte{valid, broken} {
+		zr, err := apkparser.OpenZipReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%d: failed to open zip: %s", i, err.Error())
+		}
+
+		if res := zr.DivergentDuplicates(1 << 20); !reflect.DeepEqual(res, []string{"differs"}) {
+			t.Fatalf("%d: unexpected divergent duplicates %v", i, res)
+		}
+
+		if digests := zr.File["same"].SubEntryDigests(1 << 20); len(digests) != 2 || digests[0].Sha256 != sha256.Sum256([]byte("content")) {
+			t.Fatalf("%d: unexpected digests %+v", i, digests)
+		}
+
+		differs := zr.File["differs"]
+		installer, verifier, ok := differs.AndroidEntry()
+		digests := differs.SubEntryDigests(1 << 20)
+		if ok || len(digests) != 2 || digests[installer].Sha256 != sha256.Sum256([]byte("benign")) ||
+			digests[verifier].Sha256 != sha256.Sum256([]byte("malicious")) {
+			t.Fatalf("%d: unexpected android entries %d %d %v", i, installer, verifier, ok)
+		}
+
+		if installer, verifier, ok := zr.File["single"].AndroidEntry(); installer != 0 || verifier != 0 || !ok {
+			t.Fatalf("%d: unexpected android entries of single file %d %d %v", i, installer, verifier, ok)
+		}
+		zr.Close()
+	}
+}
+
+func TestHashEntries(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range [][2]string{{"lib/", ""}, {"classes.dex", "dex content"}, {"assets/a.bin", "first"}, {"assets/a.bin", "second"}} {
+		fw, _ := w.Create(e[0])
+		fw.Write([]byte(e[1]))
+	}
+	w.Close()
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	opts := &apkparser.HashOptions{
+		MaxFileSize: 5,
+		Extra:       map[string]func() hash.Hash{"md5": md5.New},
+	}
+	digests := zr.HashEntries(opts)
+	if len(digests) != 2 {
+		t.Fatalf("unexpected digests %v", digests)
+	}
+
+	dex := digests["classes.dex"]
+	if dex.Err != nil || dex.Size != 5 || dex.Sha256 != sha256.Sum256([]byte("dex c")) {
+		t.Fatalf("unexpected dex digest %+v", dex)
+	}
+	if md5sum := md5.Sum([]byte("dex c")); !bytes.Equal(dex.Extra["md5"], md5sum[:]) {
+		t.Fatalf("unexpected md5 %x", dex.Extra["md5"])
+	}
+
+	if a := digests["assets/a.bin"]; a.Sha256 != sha256.Sum256([]byte("first")) || a.Extra["md5"] == nil {
+		t.Fatalf("unexpected digest of duplicate entry %+v", a)
+	}
+
+	var walked []string
+	apkparser.WalkZipReader(bytes.NewReader(buf.Bytes()), func(f *apkparser.ZipReaderFile) bool {
+		if !f.IsDir {
+			d := apkparser.HashZipEntry(f, nil)
+			walked = append(walked, fmt.Sprintf("%s=%x", f.Name, d.Sha256[:4]))
+		}
+		return true
+	})
+
+	full, first, second := sha256.Sum256([]byte("dex content")), sha256.Sum256([]byte("first")), sha256.Sum256([]byte("second"))
+	expected := []string{
+		fmt.Sprintf("classes.dex=%x", full[:4]),
+		fmt.Sprintf("assets/a.bin=%x", first[:4]),
+		fmt.Sprintf("assets/a.bin=%x", second[:4]),
+	}
+	if !reflect.DeepEqual(walked, expected) {
+		t.Fatalf("unexpected walked digests %q", walked)
+	}
+}
+
+// Builds a minimal ELF64 shared library with the dynamic section.
+func testElf(machine elf.Machine, soname string, needed ...string) []byte {
+	dynstr := []byte{0}
+	addStr := func(s string) uint64 {
+		off := uint64(len(dynstr))
+		dynstr = append(append(dynstr, s...), 0)
+		return off
+	}
+
+	var dynamic bytes.Buffer
+	if soname != "" {
+		binary.Write(&dynamic, binary.LittleEndian, []uint64{uint64(elf.DT_SONAME), addStr(soname)})
+	}
+	for _, n := range needed {
+		binary.Write(&dynamic, binary.LittleEndian, []uint64{uint64(elf.DT_NEEDED), addStr(n)})
+	}
+	binary.Write(&dynamic, binary.LittleEndian, []uint64{uint64(elf.DT_NULL), 0})
+
+	shstrtab := []byte("\x00.dynstr\x00.dynamic\x00.shstrtab\x00")
+
+	const hdrLen = 64
+	dynstrOff := uint64(hdrLen)
+	dynamicOff := dynstrOff + uint64(len(dynstr))
+	shstrtabOff := dynamicOff + uint64(dynamic.Len())
+	shOff := shstrtabOff + uint64(len(shstrtab))
+
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_DYN),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     shOff,
+		Ehsize:    hdrLen,
+		Shentsize: 64,
+		Shnum:     4,
+		Shstrndx:  3,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, hdr)
+	out.Write(dynstr)
+	out.Write(dynamic.Bytes())
+	out.Write(shstrtab)
+	binary.Write(&out, binary.LittleEndian, []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_STRTAB), Off: dynstrOff, Size: uint64(len(dynstr))},
+		{Name: 9, Type: uint32(elf.SHT_DYNAMIC), Off: dynamicOff, Size: uint64(dynamic.Len()), Link: 1, Entsize: 16},
+		{Name: 18, Type: uint32(elf.SHT_STRTAB), Off: shstrtabOff, Size: uint64(len(shstrtab))},
+	})
+	return out.Bytes()
+}
+
+func TestNativeLibs(t *testing.T) {
+	path := writeTestApk(t, map[string][]byte{
+		"lib/arm64-v8a/libnative.so":   testElf(elf.EM_AARCH64, "libnative.so", "libc.so", "liblog.so"),
+		"lib/armeabi-v7a/libhidden.so": testElf(elf.EM_X86_64, ""),
+		"lib/x86/readme.txt":           []byte("not an elf"),
+		"assets/libother.so":           testElf(elf.EM_AARCH64, ""),
+	})
+
+	zr, err := apkparser.OpenZip(path)
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zr.Close()
+
+	libs := zr.NativeLibs(1 << 20)
+	if len(libs) != 3 {
+		t.Fatalf("unexpected libs %+v", libs)
+	}
+
+	native := libs[0]
+	if native.Err != nil || native.Path != "lib/arm64-v8a/libnative.so" || native.Abi != "arm64-v8a" ||
+		native.Arch != "arm64" || native.Bits != 64 || native.AbiMismatch || native.Soname != "libnative.so" ||
+		!reflect.DeepEqual(native.Needed, []string{"libc.so", "liblog.so"}) || !native.Stripped {
+		t.Fatalf("unexpected lib %+v", native)
+	}
+
+	if hidden := libs[1]; hidden.Err != nil || hidden.Arch != "x86_64" || !hidden.AbiMismatch || hidden.Soname != "" {
+		t.Fatalf("unexpected lib %+v", hidden)
+	}
+
+	if libs[2].Path != "lib/x86/readme.txt" || libs[2].Err == nil {
+		t.Fatalf("unexpected lib %+v", libs[2])
+	}
+
+	if libs := zr.NativeLibs(10); !errors.Is(libs[0].Err, apkparser.ErrZipEntryTooLarge) {
+		t.Fatalf("unexpected error %v", libs[0].Err)
+	}
+
+	if libs := zr.NativeLibs(0); libs[0].Err != nil || libs[0].Arch == "" {
+		t.Fatalf("unexpected library without limit %+v", libs[0])
+	}
+}
+
+func TestScanPayloads(t *testing.T) {
+	testZip := func(name string) []byte {
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		fw, _ := w.Create(name)
+		fw.Write([]byte("content"))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	dex := []byte("dex\n035\x00rest of dex")
+	elfLib := testElf(elf.EM_AARCH64, "")
+	apk := testZip("AndroidManifest.xml")
+
+	var blob bytes.Buffer
+	blob.WriteString("innocent looking data")
+	blob.Write(dex)
+	elfOffset := blob.Len()
+	blob.Write(elfLib)
+	apkOffset := blob.Len()
+	blob.Write(apk)
+
+	path := writeTestApk(t, map[string][]byte{
+		"assets/data.bin":     blob.Bytes(),
+		"res/raw/archive.zip": testZip("readme.txt"),
+		"lib/x86/libfoo.so":   elfLib,
+		"classes.dex":         dex,
+	})
+
+	zr, err := apkparser.OpenZip(path)
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zr.Close()
+
+	expected := []apkparser.PayloadHit{
+		{Path: "assets/data.bin", Offset: 21, Kind: apkparser.PayloadDex},
+		{Path: "assets/data.bin", Offset: int64(elfOffset), Kind: apkparser.PayloadElf},
+		{Path: "assets/data.bin", Offset: int64(apkOffset), Kind: apkparser.PayloadApk},
+		{Path: "res/raw/archive.zip", Offset: 0, Kind: apkparser.PayloadZip},
+	}
+	if hits := zr.ScanPayloads(1 << 20); !reflect.DeepEqual(hits, expected) {
+		t.Fatalf("unexpected hits %+v", hits)
+	}
+}
+
+func TestJanusDex(t *testing.T) {
+	var zipBuf bytes.Buffer
+	w := zip.NewWriter(&zipBuf)
+	fw, _ := w.Create("AndroidManifest.xml")
+	fw.Write([]byte("manifest"))
+	w.Close()
+
+	hdr := make([]byte, 0x70)
+	copy(hdr, "dex\n035\x00")
+	binary.LittleEndian.PutUint32(hdr[32:], uint32(len(hdr)+zipBuf.Len()))
+	binary.LittleEndian.PutUint32(hdr[36:], 0x70)
+	binary.LittleEndian.PutUint32(hdr[40:], 0x12345678)
+	data := append(hdr, zipBuf.Bytes()...)
+	binary.LittleEndian.PutUint32(data[8:], adler32.Checksum(data[12:]))
+
+	res, err := apkparser.FindJanusDex(bytes.NewReader(data))
+	if err != nil || res == nil || res.Span != (apkparser.ZipByteRange{Offset: 0, Size: int64(len(data))}) || !res.ChecksumOk {
+		t.Fatalf("unexpected result %+v %v", res, err)
+	}
+
+	zr, err := apkparser.OpenZipReaderEx(bytes.NewReader(data), &apkparser.ParseOptions{LocateZipSpan: true})
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	if res, err := zr.JanusDex(); err != nil || res == nil || res.Span.Size != int64(len(data)) {
+		t.Fatalf("unexpected result from zip reader %+v %v", res, err)
+	}
+	zr.Close()
+
+	data[20]++
+	if res, err := apkparser.FindJanusDex(bytes.NewReader(data)); err != nil || res == nil || res.ChecksumOk {
+		t.Fatalf("unexpected result with bad checksum %+v %v", res, err)
+	}
+
+	if res, err := apkparser.FindJanusDex(bytes.NewReader(zipBuf.Bytes())); err != nil || res != nil {
+		t.Fatalf("plain zip detected as janus %+v %v", res, err)
+	}
+
+	if res, err := apkparser.FindJanusDex(bytes.NewReader(hdr)); err != nil || res != nil {
+		t.Fatalf("plain dex detected as janus %+v %v", res, err)
+	}
+}
+
+func TestDexReport(t *testing.T) {
+	testDex := func(methods uint32) []byte {
+		hdr := make([]byte, 0x70)
+		copy(hdr, "dex\n039\x00")
+		binary.LittleEndian.PutUint32(hdr[32:], 0x70)
+		binary.LittleEndian.PutUint32(hdr[36:], 0x70)
+		binary.LittleEndian.PutUint32(hdr[88:], methods)
+		binary.LittleEndian.PutUint32(hdr[96:], 3)
+		return hdr
+	}
+
+	path := writeTestApk(t, map[string][]byte{
+		"classes.dex":                  testDex(100),
+		"classes2.dex":                 testDex(20),
+		"classes4.dex":                 testDex(5),
+		"classes1.dex":                 []byte("broken"),
+		"assets/dexopt/baseline.prof":  []byte("pro"),
+		"assets/dexopt/baseline.profm": []byte("prm"),
+		"assets/base.dm":               []byte("dm"),
+	})
+
+	zr, err := apkparser.OpenZip(path)
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zr.Close()
+
+	report := zr.DexReport()
+
+	var got []string
+	for _, d := range report.Dex {
+		got = append(got, fmt.Sprintf("%s %v %s %d %d %v", d.Path, d.Loaded, d.Version, d.MethodIds, d.ClassDefs, d.Err != nil))
+	}
+	expected := []string{
+		"classes.dex true 039 100 3 false",
+		"classes2.dex true 039 20 3 false",
+		"classes1.dex false  0 0 true",
+		"classes4.dex false 039 5 3 false",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected dex files %q", got)
+	}
+
+	if report.MethodCount() != 120 || report.Dex[0].Size != 0x70 {
+		t.Fatalf("unexpected method count %d or size %d", report.MethodCount(), report.Dex[0].Size)
+	}
+
+	if report.BaselineProfile != "assets/dexopt/baseline.prof" || report.ProfileMetadata != "assets/dexopt/baseline.profm" ||
+		!reflect.DeepEqual(report.DexMetadata, []string{"assets/base.dm"}) {
+		t.Fatalf("unexpected metadata %+v", report)
+	}
+}
+
+func TestCheckAlignment(t *testing.T) {
+	manifest := testAxml(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "package", value: "com.example"}},
+		children: []*testAxmlNode{{
+			name:  "application",
+			attrs: []testAxmlAttr{{name: "android:extractNativeLibs", typ: 0x12, data: 0}},
+		}},
+	})
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	add := func(name string, method uint16, align int, data []byte) {
+		w.Flush()
+		// The data descriptor of the previous entry is written only now.
+		dataOffset := buf.Len() + 30 + len(name)
+		if buf.Len() != 0 {
+			dataOffset += 16
+		}
+
+		hdr := &zip.FileHeader{Name: name, Method: method}
+		if align > 0 {
+			hdr.Extra = make([]byte, (align-dataOffset%align)%align)
+		} else if dataOffset%2 == 0 {
+			hdr.Extra = make([]byte, 1)
+		}
+		fw, _ := w.CreateHeader(hdr)
+		fw.Write(data)
+	}
+	// Compressed entries go last, their data are finished only when the next entry is created.
+	add("resources.arsc", zip.Store, 4, []byte("arsc"))
+	add("assets/odd.bin", zip.Store, 0, []byte("odd"))
+	add("lib/arm64-v8a/lib16k.so", zip.Store, 16384, []byte("elf"))
+	add("lib/arm64-v8a/lib4k.so", zip.Store, 4096, []byte("elf"))
+	add("AndroidManifest.xml", zip.Deflate, 0, manifest)
+	add("lib/x86/libpacked.so", zip.Deflate, 0, []byte("elf"))
+	w.Close()
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	report := zr.CheckAlignment(true)
+	expected := &apkparser.AlignmentReport{
+		ExtractNativeLibs: true,
+		Misaligned:        []string{"assets/odd.bin"},
+		Not16kAligned:     []string{"lib/arm64-v8a/lib4k.so"},
+		CompressedLibs:    []string{"lib/x86/libpacked.so"},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if report.Ok() || report.Ok16k() {
+		t.Fatalf("misaligned entry passed")
+	}
+
+	parser, _ := apkparser.NewParser(zr, nil)
+	report, err = parser.CheckAlignment()
+	if err != nil {
+		t.Fatalf("failed to check alignment: %s", err.Error())
+	}
+	if report.ExtractNativeLibs {
+		t.Fatalf("extractNativeLibs not read from the manifest")
+	}
+}
+
+func TestFindSigningBlock(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, _ := w.Create("AndroidManifest.xml")
+	fw.Write([]byte("manifest"))
+	w.Close()
+	plain := buf.Bytes()
+
+	var pairs bytes.Buffer
+	binary.Write(&pairs, binary.LittleEndian, uint64(8))
+	binary.Write(&pairs, binary.LittleEndian, apkparser.SigningBlockV2)
+	pairs.WriteString("sign")
+	binary.Write(&pairs, binary.LittleEndian, uint64(4))
+	binary.Write(&pairs, binary.LittleEndian, uint32(0x71777777))
+
+	blockSize := uint64(pairs.Len() + 8 + 16)
+	var block bytes.Buffer
+	binary.Write(&block, binary.LittleEndian, blockSize)
+	block.Write(pairs.Bytes())
+	binary.Write(&block, binary.LittleEndian, blockSize)
+	block.WriteString("APK Sig Block 42")
+
+	eocd := len(plain) - 22
+	cdOffset := int(binary.LittleEndian.Uint32(plain[eocd+16:]))
+	signed := append(append(append([]byte{}, plain[:cdOffset]...), block.Bytes()...), plain[cdOffset:]...)
+	binary.LittleEndian.PutUint32(signed[eocd+block.Len()+16:], uint32(cdOffset+block.Len()))
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(signed))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	res, err := zr.SigningBlock()
+	expected := &apkparser.SigningBlock{Offset: int64(cdOffset), Size: int64(block.Len()), Ids: []uint32{apkparser.SigningBlockV2, 0x71777777}}
+	if err != nil || !reflect.DeepEqual(res, expected) {
+		t.Fatalf("unexpected signing block %+v %v", res, err)
+	}
+
+	if !res.Has(apkparser.SigningBlockV2) || res.Has(apkparser.SigningBlockV3) || apkparser.SigningBlockIdName(res.Ids[1]) != "0x71777777" {
+		t.Fatalf("unexpected ids %v", res.Ids)
+	}
+
+	if res, err := apkparser.FindSigningBlock(bytes.NewReader(plain)); res != nil || err != nil {
+		t.Fatalf("unexpected signing block of unsigned zip %+v %v", res, err)
+	}
+
+	binary.LittleEndian.PutUint64(signed[cdOffset:], blockSize+1)
+	if _, err := apkparser.FindSigningBlock(bytes.NewReader(signed)); err == nil {
+		t.Fatalf("mismatched sizes accepted")
+	}
+}
+
+func TestEmbeddedZip(t *testing.T) {
+	var inner bytes.Buffer
+	w := zip.NewWriter(&inner)
+	fw, _ := w.Create("AndroidManifest.xml")
+	fw.Write([]byte("inner manifest"))
+	w.Close()
+
+	var outer bytes.Buffer
+	w = zip.NewWriter(&outer)
+	fw, _ = w.CreateHeader(&zip.FileHeader{Name: "assets/payload.apk", Method: zip.Store})
+	fw.Write(inner.Bytes())
+	w.Close()
+
+	prefix := []byte("#!/bin/sh\nexit 0\n")
+	data := append(append(prefix, outer.Bytes()...), []byte("trailer")...)
+
+	offsets, err := apkparser.FindEmbeddedZipOffsets(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to find zips: %s", err.Error())
+	}
+
+	innerOffset := int64(bytes.Index(data, inner.Bytes()))
+	if !reflect.DeepEqual(offsets, []int64{int64(len(prefix)), innerOffset}) {
+		t.Fatalf("unexpected offsets %v", offsets)
+	}
+
+	zr, err := apkparser.OpenZipReaderAtOffset(bytes.NewReader(data), innerOffset)
+	if err != nil {
+		t.Fatalf("failed to open inner zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	content, err := zr.File["AndroidManifest.xml"].ReadAll(1 << 20)
+	if err != nil || string(content) != "inner manifest" {
+		t.Fatalf("unexpected content %q: %v", content, err)
+	}
+}
+
+func TestLocateZipSpan(t *testing.T) {
+	var apk bytes.Buffer
+	w := zip.NewWriter(&apk)
+	fw, _ := w.Create("AndroidManifest.xml")
+	fw.Write([]byte("manifest"))
+	w.Close()
+
+	// The trailer is longer than the window the end of central directory is normally looked for in.
+	prefix := []byte("MZ loader")
+	trailer := bytes.Repeat([]byte{0xAA}, 100*1024)
+	data := append(append(append([]byte{}, prefix...), apk.Bytes()...), trailer...)
+
+	zr, err := apkparser.OpenZipReaderEx(bytes.NewReader(data), &apkparser.ParseOptions{LocateZipSpan: true})
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	expected := []apkparser.ZipByteRange{
+		{Offset: 0, Size: int64(len(prefix))},
+		{Offset: int64(len(prefix) + apk.Len()), Size: int64(len(trailer))},
+	}
+	if !reflect.DeepEqual(zr.ExtraRanges, expected) {
+		t.Fatalf("unexpected extra ranges %v", zr.ExtraRanges)
+	}
+
+	for _, w := range zr.Warnings {
+		if w.Kind == apkparser.WarnBrokenZip {
+			t.Fatalf("unexpected warning %s: %s", w.Kind, w.Message)
+		}
+	}
+
+	if extra, _ := ioutil.ReadAll(zr.ExtraData(zr.ExtraRanges[0])); !bytes.Equal(extra, prefix) {
+		t.Fatalf("unexpected extra data %q", extra)
+	}
+
+	content, err := zr.File["AndroidManifest.xml"].ReadAll(1 << 20)
+	if err != nil || string(content) != "manifest" {
+		t.Fatalf("unexpected content %q: %v", content, err)
+	}
+}
+
+func TestLocateZipSpanSpoofedEnd(t *testing.T) {
+	var apk bytes.Buffer
+	w := zip.NewWriter(&apk)
+	fw, _ := w.Create("AndroidManifest.xml")
+	fw.Write([]byte("manifest"))
+	w.Close()
+
+	prefix := []byte("MZ loader")
+	data := append(append([]byte{}, prefix...), apk.Bytes()...)
+	apkEnd := len(data)
+
+	// A central directory record signature with nothing behind it, then an empty end record
+	// and one pointing at the fake record, both claiming the ZIP starts at offset 0.
+	fakeCd := len(data)
+	data = append(data, 'P', 'K', 1, 2)
+	data = append(data, make([]byte, 42)...)
+	for _, cdSize := range []int{0, 46} {
+		eocd := make([]byte, 22)
+		binary.LittleEndian.PutUint32(eocd, 0x06054b50)
+		binary.LittleEndian.PutUint32(eocd[12:], uint32(cdSize))
+		if cdSize == 0 {
+			binary.LittleEndian.PutUint32(eocd[16:], uint32(len(data)))
+		} else {
+			binary.LittleEndian.PutUint32(eocd[16:], uint32(fakeCd))
+		}
+		data = append(data, eocd...)
+	}
+
+	zr, err := apkparser.OpenZipReaderEx(bytes.NewReader(data), &apkparser.ParseOptions{LocateZipSpan: true})
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	expected := []apkparser.ZipByteRange{
+		{Offset: 0, Size: int64(len(prefix))},
+		{Offset: int64(apkEnd), Size: int64(len(data) - apkEnd)},
+	}
+	if !reflect.DeepEqual(zr.ExtraRanges, expected) {
+		t.Fatalf("unexpected extra ranges %v", zr.ExtraRanges)
+	}
+
+	content, err := zr.File["AndroidManifest.xml"].ReadAll(1 << 20)
+	if err != nil || string(content) != "manifest" {
+		t.Fatalf("unexpected content %q: %v", content, err)
+	}
+}
+
+func TestObb(t *testing.T) {
+	var obb bytes.Buffer
+	w := zip.NewWriter(&obb)
+	fw, _ := w.CreateHeader(&zip.FileHeader{Name: "data/level1.pak", Method: zip.Store})
+	fw.Write([]byte("level data"))
+	w.Close()
+
+	pkg := "com.example.game"
+	footer := make([]byte, 24+len(pkg))
+	binary.LittleEndian.PutUint32(footer, 1)
+	binary.LittleEndian.PutUint32(footer[4:], 12)
+	binary.LittleEndian.PutUint32(footer[8:], apkparser.ObbFlagSalted)
+	copy(footer[12:], "saltsalt")
+	binary.LittleEndian.PutUint32(footer[20:], uint32(len(pkg)))
+	copy(footer[24:], pkg)
+	obb.Write(footer)
+	binary.Write(&obb, binary.LittleEndian, []uint32{uint32(len(footer)), 0x01059983})
+
+	zr, info, err := apkparser.OpenObbReader(bytes.NewReader(obb.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to open obb: %s", err.Error())
+	}
+	defer zr.Close()
+
+	if info == nil || info.PackageName != pkg || info.Version != 12 || info.Flags != apkparser.ObbFlagSalted || string(info.Salt[:]) != "saltsalt" {
+		t.Fatalf("unexpected obb info %+v", info)
+	}
+
+	content, err := zr.File["data/level1.pak"].ReadAll(1 << 20)
+	if err != nil || string(content) != "level data" {
+		t.Fatalf("unexpected content %q: %v", content, err)
+	}
+
+	name := apkparser.ObbFileName{Main: true, VersionCode: 12, Package: pkg}
+	if name.Path() != "Android/obb/com.example.game/main.12.com.example.game.obb" {
+		t.Fatalf("unexpected path %s", name.Path())
+	}
+	if parsed, ok := apkparser.ParseObbFileName(name.Path()); !ok || parsed != name {
+		t.Fatalf("failed to parse %s: %+v", name.Path(), parsed)
+	}
+
+	names := []string{"main.12.com.example.game.obb", "main.15.com.example.game.obb", "patch.14.com.example.game.obb", "main.13.com.other.obb", "main.x.com.example.game.obb"}
+	if main, patch := apkparser.FindObbFiles(names, pkg, 14); main != names[0] || patch != names[2] {
+		t.Fatalf("unexpected obb files %s %s", main, patch)
+	}
+}
+
+func TestExtractAll(t *testing.T) {
+	build := func(names ...string) *apkparser.ZipReader {
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		for _, name := range names {
+			fw, _ := w.Create(name)
+			fw.Write([]byte("content of " + name))
+		}
+		w.Close()
+
+		zr, err := apkparser.OpenZipReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("failed to open zip: %s", err.Error())
+		}
+		return zr
+	}
+
+	dir := t.TempDir()
+	zr := build("AndroidManifest.xml", "res/", "res/raw/a.bin")
+	if err := zr.ExtractAll(dir, nil); err != nil {
+		t.Fatalf("failed to extract: %s", err.Error())
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "res", "raw", "a.bin")); err != nil || string(data) != "content of res/raw/a.bin" {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+
+	if err := zr.ExtractAll(t.TempDir(), &apkparser.ExtractOptions{MaxFileSize: 5}); !errors.Is(err, apkparser.ErrZipEntryTooLarge) {
+		t.Fatalf("size limit was not applied: %v", err)
+	}
+
+	evil := build("../../evil.txt")
+	dir = t.TempDir()
+	if err := evil.ExtractAll(filepath.Join(dir, "out"), nil); !errors.Is(err, apkparser.ErrUnsafeZipEntry) {
+		t.Fatalf("zip slip was not refused: %v", err)
+	}
+
+	if err := evil.ExtractAll(filepath.Join(dir, "out"), &apkparser.ExtractOptions{SanitizeNames: true}); err != nil {
+		t.Fatalf("failed to extract sanitized: %s", err.Error())
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "out", "evil.txt")); err != nil {
+		t.Fatalf("sanitized file was not extracted: %s", err.Error())
+	}
+
+	colliding := build("b.txt", "../b.txt")
+	if err := colliding.ExtractAll(t.TempDir(), &apkparser.ExtractOptions{SanitizeNames: true}); !errors.Is(err, apkparser.ErrZipEntryCollision) {
+		t.Fatalf("collision of sanitized names was not refused: %v", err)
+	}
+
+	// existing symlinked parent directory must not be followed
+	dir, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "res")); err != nil {
+		t.Skipf("symlinks not supported: %s", err.Error())
+	}
+	if err := zr.ExtractAll(dir, nil); !errors.Is(err, apkparser.ErrUnsafeZipEntry) {
+		t.Fatalf("symlinked parent was followed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "raw", "a.bin")); err == nil {
+		t.Fatalf("file was written outside of the directory")
+	}
+}
+
+func TestExtractAllBrokenZip(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"dir/", "dir/x.txt"} {
+		fw, _ := w.Create(name)
+		fw.Write([]byte(strings.TrimPrefix(name, "dir/")))
+	}
+	w.Close()
+
+	// entries are found by scanning local headers
+	data := bytes.ReplaceAll(buf.Bytes(), []byte("PK\x01\x02"), []byte("XXXX"))
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	if !zr.File["dir"].IsDir {
+		t.Fatalf("directory entry not recognized")
+	}
+
+	dir := t.TempDir()
+	if err := zr.ExtractAll(dir, nil); err != nil {
+		t.Fatalf("failed to extract: %s", err.Error())
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "dir", "x.txt")); err != nil || !strings.HasPrefix(string(data), "x.txt") {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+}
+
+func TestParseXmlBytes(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/98d2e837b8f3ac41e74b86b2d532972955e5352197a893206ecd9650f678ae31.bin")
+	if err != nil {
+		t.Fatalf("failed to read file: %s", err.Error())
+	}
+
+	var fromBytes, fromReader bytes.Buffer
+	if err := apkparser.ParseXmlBytes(data, xml.NewEncoder(&fromBytes), nil); err != nil {
+		t.Fatalf("failed to parse bytes: %s", err.Error())
+	}
+	if err := apkparser.ParseXml(bytes.NewReader(data), xml.NewEncoder(&fromReader), nil); err != nil {
+		t.Fatalf("failed to parse reader: %s", err.Error())
+	}
+
+	if fromBytes.Len() == 0 || !bytes.Equal(fromBytes.Bytes(), fromReader.Bytes()) {
+		t.Fatalf("unexpected output %q", fromBytes.String())
+	}
+}
+
+func TestParseXmlTree(t *testing.T) {
+	data := testAxml(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "package", value: "com.example"}},
+		children: []*testAxmlNode{{
+			name: "application",
+			children: []*testAxmlNode{
+				{name: "activity", attrs: []testAxmlAttr{{name: "android:name", value: ".A"}}},
+				{name: "activity", attrs: []testAxmlAttr{{name: "android:name", value: ".B"}}, text: "text"},
+				{name: "service", attrs: []testAxmlAttr{{name: "android:name", value: ".S"}}},
+			},
+		}},
+	})
+
+	root, err := apkparser.ParseXmlTree(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to parse tree: %s", err.Error())
+	}
+
+	if root.Name.Local != "manifest" || root.AttrValue("", "package") != "com.example" {
+		t.Fatalf("unexpected root %+v", root)
+	}
+
+	activities := root.Find("application/activity")
+	if len(activities) != 2 || activities[0].AndroidAttr("name") != ".A" || activities[1].Text != "text" {
+		t.Fatalf("unexpected activities %+v", activities)
+	}
+}
+
+func TestUsesReport(t *testing.T) {
+	arsc := testArsc{
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"bool"},
+			keys:  []string{"camera_required"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeIntBool), data: 0}},
+				}),
+			},
+		}},
+	}
+	res, err := apkparser.ParseResourceTableBytes(arsc.bytes())
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	feature := func(attrs ...testAxmlAttr) *testAxmlNode {
+		return &testAxmlNode{name: "uses-feature", attrs: attrs}
+	}
+
+	data := testAxml(&testAxmlNode{
+		name: "manifest",
+		children: []*testAxmlNode{
+			feature(testAxmlAttr{name: "android:name", value: "android.hardware.camera"},
+				testAxmlAttr{name: "android:required", typ: uint8(apkparser.AttrTypeReference), data: 0x7f010000}),
+			feature(testAxmlAttr{name: "android:glEsVersion", typ: uint8(apkparser.AttrTypeIntHex), data: 0x00030001}),
+			{name: "feature-group", children: []*testAxmlNode{
+				feature(testAxmlAttr{name: "android:name", value: "android.hardware.nfc"}),
+			}},
+			{name: "application", children: []*testAxmlNode{
+				{name: "uses-library", attrs: []testAxmlAttr{{name: "android:name", value: "org.apache.http.legacy"},
+					{name: "android:required", typ: uint8(apkparser.AttrTypeIntBool), data: 0}}},
+				{name: "uses-native-library", attrs: []testAxmlAttr{{name: "android:name", value: "libOpenCL.so"}}},
+			}},
+		},
+	})
+
+	manifest, err := apkparser.ParseXmlTree(bytes.NewReader(data), res)
+	if err != nil {
+		t.Fatalf("failed to parse manifest: %s", err.Error())
+	}
+
+	report := apkparser.NewUsesReport(manifest)
+
+	var features []string
+	for _, f := range report.Features {
+		features = append(features, fmt.Sprintf("%s%s:%v:%d", f.Name, f.GlEsVersion, f.Required, f.FeatureGroup))
+	}
+	if got := strings.Join(features, ","); got != "android.hardware.camera:false:0,3.1:true:0,android.hardware.nfc:true:1" {
+		t.Fatalf("unexpected features %s", got)
+	}
+
+	var libraries []string
+	for _, l := range report.Libraries {
+		libraries = append(libraries, fmt.Sprintf("%s:%v:%v", l.Name, l.Native, l.Required))
+	}
+	if got := strings.Join(libraries, ","); got != "org.apache.http.legacy:false:false,libOpenCL.so:true:true" {
+		t.Fatalf("unexpected libraries %s", got)
+	}
+
+	if report.RequiresFeature("android.hardware.camera") || report.RequiresFeature("android.hardware.nfc") {
+		t.Fatalf("optional or grouped feature reported as required")
+	}
+}
+
+func TestXmlTokenReader(t *testing.T) {
+	data := testAxml(&testAxmlNode{
+		name: "manifest",
+		attrs: []testAxmlAttr{
+			{name: "package", value: "com.example"},
+			{name: "android:versionCode", typ: 0x10, data: 42},
+		},
+		children: []*testAxmlNode{
+			{name: "uses-permission", attrs: []testAxmlAttr{{name: "android:name", value: "android.permission.INTERNET"}}},
+			{name: "uses-permission", attrs: []testAxmlAttr{{name: "android:name", value: "android.permission.CAMERA"}}},
+		},
+	})
+
+	var manifest struct {
+		Package     string `xml:"package,attr"`
+		VersionCode int    `xml:"versionCode,attr"`
+		Permissions []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"uses-permission"`
+	}
+
+	dec := xml.NewTokenDecoder(apkparser.NewXmlTokenReader(bytes.NewReader(data), nil))
+	if err := dec.Decode(&manifest); err != nil {
+		t.Fatalf("failed to decode: %s", err.Error())
+	}
+
+	if manifest.Package != "com.example" || manifest.VersionCode != 42 || len(manifest.Permissions) != 2 ||
+		manifest.Permissions[1].Name != "android.permission.CAMERA" {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+
+	dec = xml.NewTokenDecoder(apkparser.NewXmlTokenReader(bytes.NewReader(data[:100]), nil))
+	if err := dec.Decode(&manifest); err == nil {
+		t.Fatalf("truncated xml was decoded")
+	}
+}
+
+func TestModifiedUtf8Strings(t *testing.T) {
+	values := map[string]string{
+		"\xed\xa0\xbd\xed\xb8\x80 smile": "\U0001F600 smile", // CESU-8 surrogate pair
+		"a\xc0\x80b":                     "a\ufffeb",         // overlong NUL, replaced like any other NUL
+		"lone \xed\xa0\xbd":              "lone \ufffe\ufffe\ufffe",
+		"plain žluťoučký":                "plain žluťoučký",
+	}
+
+	for raw, expected := range values {
+		data := testAxml(&testAxmlNode{
+			name:  "manifest",
+			attrs: []testAxmlAttr{{name: "android:label", value: raw}},
+		})
+
+		root, err := apkparser.ParseXmlTree(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("failed to parse: %s", err.Error())
+		}
+
+		if got := root.AndroidAttr("label"); got != expected {
+			t.Fatalf("%q decoded as %q, expected %q", raw, got, expected)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var reports []apkparser.Progress
+	opts := &apkparser.ParseOptions{Progress: func(p apkparser.Progress) {
+		reports = append(reports, p)
+	}}
+
+	data := testAxml(&testAxmlNode{name: "manifest", children: []*testAxmlNode{{name: "application"}}})
+	if err := apkparser.ParseXmlEx(bytes.NewReader(data), xml.NewEncoder(ioutil.Discard), nil, opts); err != nil {
+		t.Fatalf("failed to parse: %s", err.Error())
+	}
+
+	if len(reports) == 0 {
+		t.Fatalf("no progress reported")
+	}
+	for i, p := range reports {
+		if p.Stage != apkparser.ProgressXml || p.Chunks != i+1 || p.Bytes <= 0 || p.Bytes >= int64(len(data)) {
+			t.Fatalf("unexpected xml progress %d %+v", i, p)
+		} else if i > 0 && p.Bytes <= reports[i-1].Bytes {
+			t.Fatalf("xml progress doesn't advance %+v", reports)
+		}
+	}
+
+	arsc := testArsc{
+		strings: []string{"Example"},
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"string"},
+			keys:  []string{"a"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 0}},
+				}),
+			},
+		}},
+	}
+
+	reports = nil
+	if _, err := apkparser.ParseResourceTableEx(bytes.NewReader(arsc.bytes()), opts); err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	// string pool, package, and the type spec and type inside the package
+	if last := reports[len(reports)-1]; len(reports) < 4 || last.Stage != apkparser.ProgressResources || last.Chunks != len(reports) {
+		t.Fatalf("unexpected resources progress %+v", reports)
+	}
+
+	reports = nil
+	apkPath := writeTestApk(t, map[string][]byte{"assets/a.txt": []byte("a"), "assets/b.txt": []byte("b")})
+	f, err := os.Open(apkPath)
+	if err != nil {
+		t.Fatalf("failed to open: %s", err.Error())
+	}
+	defer f.Close()
+
+	zr, err := apkparser.OpenZipReaderEx(f, opts)
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	zr.Close()
+
+	if len(reports) != 2 || reports[1].Stage != apkparser.ProgressZip || reports[1].Entries != 2 {
+		t.Fatalf("unexpected zip progress %+v", reports)
+	}
+}
+
+type testLogger struct {
+	warnings []apkparser.Warning
+	skipped  []string
+}
+
+func (l *testLogger) Warning(w apkparser.Warning) {
+	l.warnings = append(l.warnings, w)
+}
+
+func (l *testLogger) Skipped(what string, size int64) {
+	l.skipped = append(l.skipped, fmt.Sprintf("%s: %d", what, size))
+}
+
+func TestLogger(t *testing.T) {
+	manifest := testAxml(&testAxmlNode{name: "manifest", attrs: []testAxmlAttr{{name: "package", value: "com.example"}}})
+
+	poolEnd := 8 + binary.LittleEndian.Uint32(manifest[12:])
+	unknown := testArscChunk(0x0150, 16, []byte{1, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF}, []byte("payload!"))
+	data := append(append(append([]byte{}, manifest[:poolEnd]...), unknown...), manifest[poolEnd:]...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)))
+
+	logger := &testLogger{}
+	if _, err := apkparser.ParseXmlTreeEx(bytes.NewReader(data), nil, &apkparser.ParseOptions{SkipUnknownChunks: true, Logger: logger}); err != nil {
+		t.Fatalf("failed to parse: %s", err.Error())
+	}
+	if len(logger.warnings) != 1 || logger.warnings[0].Kind != apkparser.WarnUnknownChunk ||
+		!reflect.DeepEqual(logger.skipped, []string{"unknown chunk 0x0150: 8"}) {
+		t.Fatalf("unexpected log %+v", logger)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < 2; i++ {
+		w, _ := zw.Create("assets/a.txt")
+		w.Write([]byte("a"))
+	}
+	zw.Close()
+
+	logger = &testLogger{}
+	zr, err := apkparser.OpenZipReaderEx(bytes.NewReader(buf.Bytes()), &apkparser.ParseOptions{Logger: logger})
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	zr.Close()
+	if !reflect.DeepEqual(logger.warnings, zr.Warnings) || len(logger.warnings) != 1 {
+		t.Fatalf("unexpected zip log %+v, zip has %+v", logger.warnings, zr.Warnings)
+	}
+
+	var out bytes.Buffer
+	std := apkparser.NewStdLogger(log.New(&out, "", 0), "abc123: ")
+	std.Warning(logger.warnings[0])
+	std.Skipped("unknown chunk 0x0150", 16)
+	if expected := "abc123: warning: duplicate zip entry: assets/a.txt\nabc123: skipped 16 bytes: unknown chunk 0x0150\n"; out.String() != expected {
+		t.Fatalf("unexpected std logger output %q", out.String())
+	}
+}
+
+func TestStats(t *testing.T) {
+	data := testAxml(&testAxmlNode{
+		name:  "manifest",
+		attrs: []testAxmlAttr{{name: "package", value: "com.example"}, {name: "android:versionName", value: "1.0"}},
+		children: []*testAxmlNode{
+			{name: "application", attrs: []testAxmlAttr{{name: "android:label", value: "Example"}}},
+		},
+	})
+
+	stats := &apkparser.Stats{}
+	opts := &apkparser.ParseOptions{Stats: stats}
+	if _, err := apkparser.ParseXmlTreeEx(bytes.NewReader(data), nil, opts); err != nil {
+		t.Fatalf("failed to parse: %s", err.Error())
+	}
+
+	// 0x0102 and 0x0103 are the element start and end chunks.
+	if stats.Elements != 2 || stats.Attrs != 3 || stats.StringPools != 1 || stats.Strings == 0 ||
+		stats.StringPoolBytes == 0 || stats.Chunks[0x0001] != 1 || stats.Chunks[0x0102] != 2 || stats.Chunks[0x0103] != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.CacheHits+stats.CacheMisses == 0 || stats.CacheHitRate() != float64(stats.CacheHits)/float64(stats.CacheHits+stats.CacheMisses) {
+		t.Fatalf("unexpected cache stats %+v", stats)
+	}
+
+	// Stats add up.
+	if _, err := apkparser.ParseXmlTreeEx(bytes.NewReader(data), nil, opts); err != nil {
+		t.Fatalf("failed to parse: %s", err.Error())
+	}
+	if stats.Elements != 4 || stats.StringPools != 2 {
+		t.Fatalf("stats didn't add up %+v", stats)
+	}
+
+	if rate := (&apkparser.Stats{}).CacheHitRate(); rate != 0 {
+		t.Fatalf("unexpected rate of empty stats %f", rate)
+	}
+}
+
+func TestReadStringPools(t *testing.T) {
+	data := testAxml(&testAxmlNode{name: "manifest", attrs: []testAxmlAttr{{name: "package", value: "com.example"}}})
+
+	pools, err := apkparser.ReadStringPools(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to read pools: %s", err.Error())
+	} else if len(pools) != 1 || pools[0].Name != "xml" || pools[0].Offset != 8 {
+		t.Fatalf("unexpected pools %+v", pools)
+	}
+
+	for _, s := range pools[0].Strings {
+		if s.Err != nil || !strings.Contains(string(data[s.Offset:]), s.Value) && !strings.Contains(string(data[s.Offset:]), utf16String(s.Value)) {
+			t.Fatalf("string %+v not found at its offset", s)
+		}
+	}
+
+	arsc := testArsc{
+		strings: []string{"Example"},
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"string"},
+			keys:  []string{"app_name"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 0}},
+				}),
+			},
+		}},
+	}
+
+	pools, err = apkparser.ReadStringPools(bytes.NewReader(arsc.bytes()))
+	if err != nil {
+		t.Fatalf("failed to read resources pools: %s", err.Error())
+	}
+
+	var names []string
+	for _, p := range pools {
+		names = append(names, fmt.Sprintf("%s: %s", p.Name, p.Strings[0].Value))
+	}
+	if expected := []string{"resources: Example", "com.example types: string", "com.example keys: app_name"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected pools %v", names)
+	}
+
+	if _, err := apkparser.ReadStringPools(strings.NewReader("<?xml version=\"1.0\"?><manifest/>")); err == nil {
+		t.Fatalf("plaintext xml was read")
+	}
+}
+
+// Returns s in UTF-16LE, like the strings in UTF-16 pools.
+func utf16String(s string) string {
+	var res []byte
+	for _, c := range s {
+		res = append(res, byte(c), byte(c>>8))
+	}
+	return string(res)
+}
+
+func TestTypedAttrRawValue(t *testing.T) {
+	data := testAxml(&testAxmlNode{
+		name: "manifest",
+		attrs: []testAxmlAttr{
+			{name: "android:versionName", value: "1.10", typ: uint8(apkparser.AttrTypeFloat), data: math.Float32bits(1.1)},
+			{name: "android:versionCode", typ: uint8(apkparser.AttrTypeIntDec), data: 7},
+			{name: "package", value: "com.example"},
+		},
+	})
+
+	var raw []string
+	visitor := &apkparser.ManifestVisitor{
+		StartElement: func(el *apkparser.TypedStartElement) error {
+			for _, a := range el.Attr {
+				if !a.HasRawValue() {
+					raw = append(raw, fmt.Sprintf("%s: none", a.Name.Local))
+					continue
+				}
+
+				s, err := el.GetString(a.RawValue)
+				if err != nil {
+					return err
+				}
+				raw = append(raw, fmt.Sprintf("%s: %s type %d", a.Name.Local, s, a.Type))
+			}
+			return nil
+		},
+	}
+
+	if err := apkparser.ParseXml(bytes.NewReader(data), visitor, nil); err != nil {
+		t.Fatalf("failed to parse: %s", err.Error())
+	}
+
+	expected := []string{"versionName: 1.10 type 4", "versionCode: none", "package: com.example type 3"}
+	if !reflect.DeepEqual(raw, expected) {
+		t.Fatalf("unexpected raw values %q", raw)
+	}
+}
+
+func TestAttrNamespaces(t *testing.T) {
+	data := testAxml(&testAxmlNode{
+		name: "activity",
+		attrs: []testAxmlAttr{
+			{name: "a", value: "Label", resId: 0x01010001},                    // obfuscated name without namespace
+			{name: "tools:ignore", value: "MissingClass", resId: 0x01010003},  // framework id in another namespace
+			{name: "app:icon", value: "@drawable/icon", resId: 0x01010002},    // same
+			{name: "app:layout_behavior", value: "com.example.Behavior"},      // no id
+			{name: "android:exported", typ: uint8(apkparser.AttrTypeIntBool)}, // no id, android namespace
+		},
+	})
+
+	root, err := apkparser.ParseXmlTree(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err.Error())
+	}
+
+	var got []string
+	for _, a := range root.Attr {
+		got = append(got, a.Name.Space+" "+a.Name.Local+"="+a.Value)
+	}
+
+	expected := []string{
+		testAndroidNs + " label=Label",
+		"http://schemas.android.com/tools ignore=MissingClass",
+		"http://schemas.android.com/apk/res-auto icon=@drawable/icon",
+		"http://schemas.android.com/apk/res-auto layout_behavior=com.example.Behavior",
+		testAndroidNs + " exported=false",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected attributes %q", got)
+	}
+}
+
+func TestAttrTypeAttribute(t *testing.T) {
+	data := testAxml(&testAxmlNode{
+		name: "TextView",
+		attrs: []testAxmlAttr{
+			{name: "android:textColor", typ: uint8(apkparser.AttrTypeAttribute), data: 0x01010098},
+			{name: "android:background", typ: uint8(apkparser.AttrTypeAttribute), data: 0x7f010005},
+		},
+	})
+
+	root, err := apkparser.ParseXmlTree(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err.Error())
+	}
+
+	var got []string
+	for _, a := range root.Attr {
+		got = append(got, a.Name.Local+"="+a.Value)
+	}
+
+	expected := []string{"textColor=?android:attr/textColor", "background=?7f010005"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected attributes %q", got)
+	}
+}
+
+func TestResolveStyle(t *testing.T) {
+	boolean := func(v uint32) testArscValue {
+		return testArscValue{typ: uint8(apkparser.AttrTypeIntBool), data: v}
+	}
+
+	arsc := testArsc{
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"style"},
+			keys:  []string{"Base", "Overlay", "Loop"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0, 0, 0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{{
+					key:    0,
+					parent: 0x01030010,
+					bag: []testArscBagItem{
+						{name: 0x01010058, value: boolean(0)}, // windowIsTranslucent
+						{name: 0x0101020d, value: boolean(0)}, // windowFullscreen
+					},
+				}, {
+					key:    1,
+					parent: 0x7f010000,
+					bag: []testArscBagItem{
+						{name: 0x01010058, value: boolean(0xFFFFFFFF)},
+					},
+				}, {
+					key:    2,
+					parent: 0x7f010002,
+					bag:    []testArscBagItem{},
+				}}),
+			},
+		}},
+	}
+
+	res, err := apkparser.ParseResourceTable(bytes.NewReader(arsc.bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse resources: %s", err.Error())
+	}
+
+	style, err := res.ResolveStyle(0x7f010001)
+	if err != nil {
+		t.Fatalf("failed to resolve style: %s", err.Error())
+	}
+
+	var items []string
+	for i := range style.Items {
+		val, _ := style.Items[i].Value.String()
+		items = append(items, fmt.Sprintf("%x=%s", style.Items[i].Name, val))
+	}
+	got := fmt.Sprintf("%x %x %s", style.Chain, style.ExternalParent, strings.Join(items, ","))
+	if expected := "[7f010001 7f010000] 1030010 1010058=true,101020d=false"; got != expected {
+		t.Fatalf("unexpected style %q", got)
+	}
+
+	if v := style.Get(0x01010058); v == nil {
+		t.Fatalf("windowIsTranslucent not found")
+	} else if s, _ := v.String(); s != "true" {
+		t.Fatalf("unexpected windowIsTranslucent %s", s)
+	}
+
+	if _, err := res.ResolveStyle(0x7f010002); err == nil {
+		t.Fatalf("cyclic style resolved")
+	}
+}
+
+func TestZipReaderFileRandomAccess(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, method := range []uint16{zip.Deflate, zip.Store} {
+		fw, _ := w.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("file%d.bin", method), Method: method})
+		fw.Write(content)
+	}
+	w.Close()
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	for _, name := range []string{"file8.bin", "file0.bin"} {
+		f := zr.File[name]
+
+		p := make([]byte, 4)
+		if n, err := f.ReadAt(p, 10); err != nil || string(p[:n]) != "abcd" {
+			t.Fatalf("%s: unexpected ReadAt %q %v", name, p[:n], err)
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			t.Fatalf("%s: seek of not opened file succeeded", name)
+		}
+
+		if err := f.Open(); err != nil {
+			t.Fatalf("%s: failed to open: %s", name, err.Error())
+		}
+
+		var got []string
+		for f.Next() {
+			io.ReadFull(f, p[:2])
+			got = append(got, string(p[:2]))
+
+			if pos, err := f.Seek(3, io.SeekCurrent); err != nil || pos != 5 {
+				t.Fatalf("%s: unexpected seek %d %v", name, pos, err)
+			}
+			io.ReadFull(f, p[:2])
+			got = append(got, string(p[:2]))
+
+			f.Seek(-3, io.SeekEnd)
+			rest, _ := ioutil.ReadAll(f)
+			got = append(got, string(rest))
+		}
+		f.Close()
+
+		if expected := []string{"01", "56", "hij"}; !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%s: unexpected reads %q", name, got)
+		}
+	}
+}
+
+func TestZipReaderFileRandomAccessLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	fw, _ := w.Create("lib/arm64-v8a/libbomb.so")
+	fw.Write(make([]byte, 64*1024))
+	w.Close()
+
+	zr, err := apkparser.OpenZipReaderEx(bytes.NewReader(buf.Bytes()), &apkparser.ParseOptions{MaxAllocBytes: 1024})
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	f := zr.File["lib/arm64-v8a/libbomb.so"]
+	if _, err := f.ReadAt(make([]byte, 4), 0); !errors.Is(err, apkparser.ErrZipEntryTooLarge) {
+		t.Fatalf("unexpected ReadAt error %v", err)
+	}
+
+	f.Open()
+	defer f.Close()
+	if !f.Next() {
+		t.Fatalf("no entry")
+	}
+	if _, err := f.Seek(1, io.SeekStart); !errors.Is(err, apkparser.ErrZipEntryTooLarge) {
+		t.Fatalf("unexpected Seek error %v", err)
+	}
+}
+
+func TestZipReaderFileOpenEntry(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, content := range []string{"first", "second entry"} {
+		fw, _ := w.Create("classes.dex")
+		fw.Write([]byte(content))
+	}
+	w.Close()
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	f := zr.File["classes.dex"]
+	if f.EntryCount() != 2 || f.Size() != 5 {
+		t.Fatalf("unexpected count %d and size %d", f.EntryCount(), f.Size())
+	}
+
+	if err := f.OpenEntry(2); err == nil {
+		t.Fatalf("invalid entry opened")
+	}
+
+	if err := f.OpenEntry(1); err != nil {
+		t.Fatalf("failed to open entry: %s", err.Error())
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil || string(data) != "second entry" || f.Size() != 12 {
+		t.Fatalf("unexpected entry %q of size %d: %v", data, f.Size(), err)
+	}
+	f.Close()
+}
+
+type testNopWriteCloser struct {
+	io.Writer
+}
+
+func (testNopWriteCloser) Close() error {
+	return nil
+}
+
+func TestRegisterDecompressor(t *testing.T) {
+	const method = 99
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	w.RegisterCompressor(method, func(out io.Writer) (io.WriteCloser, error) {
+		return testNopWriteCloser{out}, nil
+	})
+	fw, _ := w.CreateHeader(&zip.FileHeader{Name: "packed.bin", Method: method})
+	fw.Write([]byte("custom method"))
+	w.Close()
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	// read as deflate, like Android does
+	if _, err := zr.File["packed.bin"].ReadAll(1 << 20); err == nil {
+		t.Fatalf("unknown method read without decompressor")
+	}
+
+	zr.RegisterDecompressor(method, ioutil.NopCloser)
+	if data, err := zr.File["packed.bin"].ReadAll(1 << 20); err != nil || string(data) != "custom method" {
+		t.Fatalf("unexpected data %q: %v", data, err)
+	}
+}
+
+func TestZipReaderFileWriteTo(t *testing.T) {
+	content := bytes.Repeat([]byte("stored data "), 1000)
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, method := range []uint16{zip.Deflate, zip.Store} {
+		fw, _ := w.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("file%d.bin", method), Method: method})
+		fw.Write(content)
+	}
+	w.Close()
+
+	// without the central directory, the stored entry is copied from the file
+	broken := bytes.ReplaceAll(buf.Bytes(), []byte("PK\x01\x02"), []byte("XXXX"))
+	path := filepath.Join(t.TempDir(), "broken.zip")
+	if err := ioutil.WriteFile(path, broken, 0644); err != nil {
+		t.Fatalf("failed to write zip: %s", err.Error())
+	}
+
+	// change a byte of the stored entry, which is the last one, so its checksum doesn't match
+	corrupted := append([]byte{}, buf.Bytes()...)
+	corrupted[bytes.LastIndex(corrupted, []byte("stored data"))] = 'S'
+
+	for _, open := range []func() (*apkparser.ZipReader, error){
+		func() (*apkparser.ZipReader, error) { return apkparser.OpenZipReader(bytes.NewReader(buf.Bytes())) },
+		func() (*apkparser.ZipReader, error) { return apkparser.OpenZip(path) },
+	} {
+		zr, err := open()
+		if err != nil {
+			t.Fatalf("failed to open zip: %s", err.Error())
+		}
+
+		for _, name := range []string{"file8.bin", "file0.bin"} {
+			f := zr.File[name]
+			if err := f.Open(); err != nil {
+				t.Fatalf("%s: failed to open: %s", name, err.Error())
+			}
+
+			var out bytes.Buffer
+			for f.Next() {
+				if n, err := io.Copy(&out, f); err != nil || n != int64(len(content)) {
+					t.Fatalf("%s: failed to copy %d bytes: %v", name, n, err)
+				}
+			}
+			f.Close()
+
+			if !bytes.Equal(out.Bytes(), content) {
+				t.Fatalf("%s: unexpected content", name)
+			}
+		}
+		zr.Close()
+	}
+
+	zr, err := apkparser.OpenZipReader(bytes.NewReader(corrupted))
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	f := zr.File["file0.bin"]
+	f.Open()
+	f.Next()
+	if _, err := io.Copy(ioutil.Discard, f); err != zip.ErrChecksum {
+		t.Fatalf("unexpected error of corrupted entry: %v", err)
+	}
+	f.Close()
+}
+
+func TestZipGlob(t *testing.T) {
+	path := writeTestApk(t, map[string][]byte{
+		"res/xml/a.xml":       {},
+		"res/xml/b.xml":       {},
+		"res/xml/sub/c.xml":   {},
+		"res/layout/main.xml": {},
+	})
+
+	zr, err := apkparser.OpenZip(path)
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	files, err := zr.Glob("res/xml/*.xml")
+	if err != nil {
+		t.Fatalf("failed to glob: %s", err.Error())
+	}
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	if expected := []string{"res/xml/a.xml", "res/xml/b.xml"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected files %q", names)
+	}
+
+	if _, err := zr.Glob("res/[xml"); err == nil {
+		t.Fatalf("bad pattern accepted")
+	}
+}
+
+func TestCaseCollisions(t *testing.T) {
+	path := writeTestApk(t, map[string][]byte{
+		"classes.dex":         []byte("real"),
+		"Classes.dex":         []byte("decoy"),
+		"assets/Data.bin":     {},
+		"assets/data.bin":     {},
+		"assets/DATA.bin":     {},
+		"AndroidManifest.xml": {},
+	})
+
+	zr, err := apkparser.OpenZip(path)
+	if err != nil {
+		t.Fatalf("failed to open zip: %s", err.Error())
+	}
+	defer zr.Close()
+
+	expected := [][]string{{"Classes.dex", "classes.dex"}, {"assets/DATA.bin", "assets/Data.bin", "assets/data.bin"}}
+	if got := zr.CaseCollisions(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("unexpected collisions %q", got)
+	}
+
+	if report := apkparser.AnalyzeAnomalies(zr); !reflect.DeepEqual(report.CaseCollisions, expected) {
+		t.Fatalf("collisions not reported: %q", report.CaseCollisions)
+	}
+
+	if f := zr.FileFold("classes.dex"); f == nil || f.Name != "classes.dex" {
+		t.Fatalf("exact match not preferred")
+	} else if f := zr.FileFold("androidmanifest.XML"); f == nil || f.Name != "AndroidManifest.xml" {
+		t.Fatalf("case-insensitive match not found")
+	} else if zr.FileFold("missing") != nil {
+		t.Fatalf("missing file found")
+	}
+}
+
+func TestDetectFrameworks(t *testing.T) {
+	manifest := testAxml(&testAxmlNode{
+		name: "manifest",
+		children: []*testAxmlNode{{
+			name: "application",
+			children: []*testAxmlNode{
+				{name: "activity", attrs: []testAxmlAttr{{name: "android:name", value: "io.flutter.embedding.android.FlutterActivity"}}},
+				{name: "provider", attrs: []testAxmlAttr{{name: "android:name", value: "mono.MonoRuntimeProvider"}}},
+			},
+		}},
+	})
+
+	zr, err := apkparser.OpenZip(writeTestApk(t, map[string][]byte{
+		"AndroidManifest.xml":                      manifest,
+		"lib/arm64-v8a/libflutter.so":              {},
+		"lib/armeabi-v7a/libflutter.so":            {},
+		"assets/flutter_assets/AssetManifest.json": {},
+		"assets/flutter_assets/kernel_blob.bin":    {},
+		"kotlin/kotlin.kotlin_builtins":            {},
+		"META-INF/kotlinx_coroutines_core.version": {},
+	}))
+	if err != nil {
+		t.Fatalf("failed to open apk: %s", err.Error())
+	}
+	defer zr.Close()
+
+	dump := func(hits []apkparser.FrameworkHit) string {
+		var res []string
+		for _, h := range hits {
+			res = append(res, fmt.Sprintf("%s %v", h.Kind, h.Evidence))
+		}
+		return strings.Join(res, "; ")
+	}
+
+	expected := "flutter [assets/flutter_assets/ lib/*/libflutter.so]; kotlin [kotlin/ kotlin/kotlin.kotlin_builtins]"
+	if got := dump(zr.DetectFrameworks()); got != expected {
+		t.Fatalf("unexpected frameworks %s", got)
+	}
+
+	parser, _ := apkparser.NewParser(zr, nil)
+	hits, err := parser.DetectFrameworks()
+	if err != nil {
+		t.Fatalf("failed to detect frameworks: %s", err.Error())
+	}
+
+	expected = "flutter [assets/flutter_assets/ io.flutter.embedding.android.FlutterActivity lib/*/libflutter.so]; " +
+		"xamarin [mono.MonoRuntimeProvider]; kotlin [kotlin/ kotlin/kotlin.kotlin_builtins]"
+	if got := dump(hits); got != expected {
+		t.Fatalf("unexpected frameworks with manifest %s", got)
+	}
+}
+
+// Reader without io.ReaderAt, which returns one byte per Read.
+type testOneByteReadSeeker struct {
+	r *bytes.Reader
+}
+
+func (s *testOneByteReadSeeker) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return s.r.Read(p)
+}
+
+func (s *testOneByteReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return s.r.Seek(offset, whence)
+}
+
+func TestZipReadSeekerWithoutReaderAt(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		fw, _ := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		fw.Write([]byte("content of " + name))
+	}
+	w.Close()
+
+	for _, data := range [][]byte{
+		buf.Bytes(),
+		bytes.ReplaceAll(buf.Bytes(), []byte("PK\x01\x02"), []byte("XXXX")), // entries are read sequentially
+	} {
+		zr, err := apkparser.OpenZipReader(&testOneByteReadSeeker{r: bytes.NewReader(data)})
+		if err != nil {
+			t.Fatalf("failed to open zip: %s", err.Error())
+		}
+
+		a, b := zr.File["a.txt"], zr.File["b.txt"]
+		a.Open()
+		a.Next()
+		start := make([]byte, 5)
+		io.ReadFull(a, start)
+
+		// must not move the position a is read from
+		p := make([]byte, 7)
+		if n, err := b.ReadAt(p, 3); err != nil || string(p[:n]) != "tent of" {
+			t.Fatalf("unexpected ReadAt %q: %v", p[:n], err)
+		}
+
+		// stored entries found by scanning local headers are read until the end of file
+		rest, err := ioutil.ReadAll(a)
+		if got := string(start) + string(rest); err != nil || !strings.HasPrefix(got, "content of a.txt") {
+			t.Fatalf("unexpected content %q: %v", got, err)
+		}
+		a.Close()
+		zr.Close()
+	}
+}
@@ -0,0 +1,334 @@
+package apkparser_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/avast/apkparser"
+	"unicode/utf16"
+)
+
+// Builds minimal resources.arsc files for tests.
+type testArsc struct {
+	strings  []string
+	packages []*testArscPackage
+
+	// extra bytes at the end of the global string pool header
+	poolPadding int
+}
+
+type testArscPackage struct {
+	id    uint32
+	name  string
+	types []string
+	keys  []string
+
+	// raw chunks placed after the type and key string pools
+	chunks [][]byte
+
+	// extra bytes at the end of the type and key string pool headers
+	poolPadding int
+}
+
+type testArscValue struct {
+	typ  uint8
+	data uint32
+}
+
+type testArscEntry struct {
+	key   uint32
+	flags uint16
+	value testArscValue
+
+	// 8-byte encoding with the key index in place of size
+	compact bool
+
+	// extra bytes between the ResTable_entry of simple entries and their value
+	padding int
+
+	// set for complex entries
+	parent uint32
+	bag    []testArscBagItem
+}
+
+type testArscBagItem struct {
+	name  uint32
+	value testArscValue
+}
+
+func testArscChunk(typ, headerSize uint16, header, body []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, typ)
+	binary.Write(&buf, binary.LittleEndian, headerSize)
+	binary.Write(&buf, binary.LittleEndian, uint32(8+len(header)+len(body)))
+	buf.Write(header)
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func testArscStringPool(strs []string) []byte {
+	var offsets, data bytes.Buffer
+	for _, s := range strs {
+		binary.Write(&offsets, binary.LittleEndian, uint32(data.Len()))
+		u16len := len(utf16.Encode([]rune(s)))
+		data.WriteByte(byte(u16len))
+		data.WriteByte(byte(len(s)))
+		data.WriteString(s)
+		data.WriteByte(0)
+	}
+	for data.Len()%4 != 0 {
+		data.WriteByte(0)
+	}
+
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, uint32(len(strs)))
+	binary.Write(&header, binary.LittleEndian, uint32(0))     // styles
+	binary.Write(&header, binary.LittleEndian, uint32(0x100)) // utf8
+	binary.Write(&header, binary.LittleEndian, uint32(28+offsets.Len()))
+	binary.Write(&header, binary.LittleEndian, uint32(0))
+
+	return testArscChunk(0x0001, 28, header.Bytes(), append(offsets.Bytes(), data.Bytes()...))
+}
+
+// Grows the header of the chunk by n zero bytes, like fields added in a newer format version.
+// Offsets of string pool data relative to the chunk are moved too.
+func testArscPadHeader(chunk []byte, n int) []byte {
+	if n == 0 {
+		return chunk
+	}
+
+	headerSize := int(binary.LittleEndian.Uint16(chunk[2:]))
+	res := append(append(append([]byte{}, chunk[:headerSize]...), make([]byte, n)...), chunk[headerSize:]...)
+	binary.LittleEndian.PutUint16(res[2:], uint16(headerSize+n))
+	binary.LittleEndian.PutUint32(res[4:], uint32(len(res)))
+
+	if binary.LittleEndian.Uint16(chunk) == 0x0001 {
+		for _, off := range []int{20, 24} {
+			if v := binary.LittleEndian.Uint32(res[off:]); v != 0 {
+				binary.LittleEndian.PutUint32(res[off:], v+uint32(n))
+			}
+		}
+	}
+	return res
+}
+
+func (v testArscValue) bytes() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint16(8))
+	buf.WriteByte(0)
+	buf.WriteByte(v.typ)
+	binary.Write(&buf, binary.LittleEndian, v.data)
+	return buf.Bytes()
+}
+
+func (e *testArscEntry) bytes() []byte {
+	var buf bytes.Buffer
+	if e.compact {
+		binary.Write(&buf, binary.LittleEndian, uint16(e.key))
+		binary.Write(&buf, binary.LittleEndian, e.flags|0x0008|uint16(e.value.typ)<<8)
+		binary.Write(&buf, binary.LittleEndian, e.value.data)
+	} else if e.bag == nil {
+		binary.Write(&buf, binary.LittleEndian, uint16(8+e.padding))
+		binary.Write(&buf, binary.LittleEndian, e.flags)
+		binary.Write(&buf, binary.LittleEndian, e.key)
+		buf.Write(make([]byte, e.padding))
+		buf.Write(e.value.bytes())
+	} else {
+		binary.Write(&buf, binary.LittleEndian, uint16(16))
+		binary.Write(&buf, binary.LittleEndian, e.flags|0x0001)
+		binary.Write(&buf, binary.LittleEndian, e.key)
+		binary.Write(&buf, binary.LittleEndian, e.parent)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(e.bag)))
+		for _, item := range e.bag {
+			binary.Write(&buf, binary.LittleEndian, item.name)
+			buf.Write(item.value.bytes())
+		}
+	}
+	return buf.Bytes()
+}
+
+func testArscTypeSpec(id uint8, flags []uint32) []byte {
+	var header, body bytes.Buffer
+	header.WriteByte(id)
+	header.WriteByte(0)
+	binary.Write(&header, binary.LittleEndian, uint16(0))
+	binary.Write(&header, binary.LittleEndian, uint32(len(flags)))
+	for _, f := range flags {
+		binary.Write(&body, binary.LittleEndian, f)
+	}
+	return testArscChunk(0x0202, 16, header.Bytes(), body.Bytes())
+}
+
+// config is ResTable_config without the size field, nil entries are missing.
+func testArscType(id uint8, config []byte, entries []*testArscEntry) []byte {
+	return testArscTypeFlags(id, 0, config, entries)
+}
+
+// flags 0x01 is sparse encoding, 0x02 16-bit offsets.
+func testArscTypeFlags(id, flags uint8, config []byte, entries []*testArscEntry) []byte {
+	var offsets, data bytes.Buffer
+	var count int
+	for i, e := range entries {
+		if e == nil {
+			switch {
+			case flags&0x01 != 0:
+			case flags&0x02 != 0:
+				binary.Write(&offsets, binary.LittleEndian, uint16(0xFFFF))
+				count++
+			default:
+				binary.Write(&offsets, binary.LittleEndian, uint32(0xFFFFFFFF))
+				count++
+			}
+			continue
+		}
+
+		switch {
+		case flags&0x01 != 0:
+			binary.Write(&offsets, binary.LittleEndian, uint16(i))
+			binary.Write(&offsets, binary.LittleEndian, uint16(data.Len()/4))
+		case flags&0x02 != 0:
+			binary.Write(&offsets, binary.LittleEndian, uint16(data.Len()/4))
+		default:
+			binary.Write(&offsets, binary.LittleEndian, uint32(data.Len()))
+		}
+		count++
+		data.Write(e.bytes())
+	}
+	for offsets.Len()%4 != 0 {
+		offsets.WriteByte(0)
+	}
+
+	headerSize := 20 + 4 + len(config)
+	var header bytes.Buffer
+	header.WriteByte(id)
+	header.WriteByte(flags)
+	binary.Write(&header, binary.LittleEndian, uint16(0))
+	binary.Write(&header, binary.LittleEndian, uint32(count))
+	binary.Write(&header, binary.LittleEndian, uint32(headerSize+offsets.Len()))
+	binary.Write(&header, binary.LittleEndian, uint32(4+len(config)))
+	header.Write(config)
+
+	return testArscChunk(0x0201, uint16(headerSize), header.Bytes(), append(offsets.Bytes(), data.Bytes()...))
+}
+
+func (p *testArscPackage) bytes() []byte {
+	const headerSize = 288
+
+	typeStrings := testArscPadHeader(testArscStringPool(p.types), p.poolPadding)
+	keyStrings := testArscPadHeader(testArscStringPool(p.keys), p.poolPadding)
+
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, p.id)
+	var name [128]uint16
+	copy(name[:], utf16.Encode([]rune(p.name)))
+	binary.Write(&header, binary.LittleEndian, name)
+	binary.Write(&header, binary.LittleEndian, uint32(headerSize))
+	binary.Write(&header, binary.LittleEndian, uint32(len(p.types)))
+	binary.Write(&header, binary.LittleEndian, uint32(headerSize+len(typeStrings)))
+	binary.Write(&header, binary.LittleEndian, uint32(len(p.keys)))
+	binary.Write(&header, binary.LittleEndian, uint32(0))
+
+	body := append(typeStrings, keyStrings...)
+	for _, c := range p.chunks {
+		body = append(body, c...)
+	}
+	return testArscChunk(0x0200, headerSize, header.Bytes(), body)
+}
+
+func (a *testArsc) bytes() []byte {
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, uint32(len(a.packages)))
+
+	body := testArscPadHeader(testArscStringPool(a.strings), a.poolPadding)
+	for _, p := range a.packages {
+		body = append(body, p.bytes()...)
+	}
+	return testArscChunk(0x0002, 12, header.Bytes(), body)
+}
+
+// Returns ResTable_config without the size field, with only language and sdk version set.
+func testArscConfig(lang string, sdk uint16) []byte {
+	config := make([]byte, 60)
+	copy(config[4:6], lang)
+	binary.LittleEndian.PutUint16(config[20:], sdk)
+	return config
+}
+
+// Returns ResTable_config without the size field, with only density and ui mode set.
+func testArscDisplayConfig(density uint16, uiMode uint8) []byte {
+	config := make([]byte, 60)
+	binary.LittleEndian.PutUint16(config[10:], density)
+	config[25] = uiMode
+	return config
+}
+
+// A table with com.example string/app_name (0x7f010000) in default and de-v21 configs
+// and string/other (0x7f010001) only in the default one.
+func testArscSimple() []byte {
+	arsc := testArsc{
+		strings: []string{"Example", "Beispiel", "Other"},
+		packages: []*testArscPackage{{
+			id:    0x7f,
+			name:  "com.example",
+			types: []string{"string"},
+			keys:  []string{"app_name", "other"},
+			chunks: [][]byte{
+				testArscTypeSpec(1, []uint32{0x4, 0}),
+				testArscType(1, testArscConfig("", 0), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 0}},
+					{key: 1, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 2}},
+				}),
+				testArscType(1, testArscConfig("de", 21), []*testArscEntry{
+					{key: 0, value: testArscValue{typ: uint8(apkparser.AttrTypeString), data: 1}},
+					nil,
+				}),
+			},
+		}},
+	}
+	return arsc.bytes()
+}
+
+type testArscPolicy struct {
+	flags uint32
+	ids   []uint32
+}
+
+func testArscOverlayable(name, actor string, policies []testArscPolicy) []byte {
+	var header bytes.Buffer
+	var nameBuf, actorBuf [256]uint16
+	copy(nameBuf[:], utf16.Encode([]rune(name)))
+	copy(actorBuf[:], utf16.Encode([]rune(actor)))
+	binary.Write(&header, binary.LittleEndian, nameBuf)
+	binary.Write(&header, binary.LittleEndian, actorBuf)
+
+	var body []byte
+	for _, p := range policies {
+		var policyHeader, ids bytes.Buffer
+		binary.Write(&policyHeader, binary.LittleEndian, p.flags)
+		binary.Write(&policyHeader, binary.LittleEndian, uint32(len(p.ids)))
+		binary.Write(&ids, binary.LittleEndian, p.ids)
+		body = append(body, testArscChunk(0x0205, 16, policyHeader.Bytes(), ids.Bytes())...)
+	}
+	return testArscChunk(0x0204, uint16(8+header.Len()), header.Bytes(), body)
+}
+
+// aliases are pairs of staged and finalized resource ids.
+func testArscStagedAlias(aliases [][2]uint32) []byte {
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, uint32(len(aliases)))
+
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, aliases)
+	return testArscChunk(0x0206, 12, header.Bytes(), body.Bytes())
+}
+
+func testArscLibrary(libs map[uint32]string) []byte {
+	var header, body bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, uint32(len(libs)))
+	for id, name := range libs {
+		var nameBuf [128]uint16
+		copy(nameBuf[:], utf16.Encode([]rune(name)))
+		binary.Write(&body, binary.LittleEndian, id)
+		binary.Write(&body, binary.LittleEndian, nameBuf)
+	}
+	return testArscChunk(0x0203, 12, header.Bytes(), body.Bytes())
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/avast/apkparser"
+)
+
+// Prints summary of the APK in the format of aapt2 dump badging.
+func processBadging(out *output, input string, opts *optsType) int {
+	if !opts.isApk {
+		fmt.Fprintf(out.stderr, "%s: -badging only works with APKs\n", input)
+		return exitError
+	}
+
+	apkReader, err := apkparser.OpenZipEx(input, opts.parseOptions())
+	if err != nil {
+		fmt.Fprintln(out.stderr, err)
+		return exitZip
+	}
+	defer apkReader.Close()
+
+	code := exitOk
+	parser, reserr := apkparser.NewParserEx(apkReader, nil, opts.parseOptions())
+	if reserr != nil && !errors.Is(reserr, os.ErrNotExist) {
+		fmt.Fprintf(out.stderr, "%s: failed to parse resources: %s\n", input, reserr.Error())
+		code = exitResources
+	}
+
+	manifest, err := parser.ParseManifestTree()
+	if err != nil {
+		fmt.Fprintf(out.stderr, "%s: %s\n", input, err.Error())
+		return exitManifest
+	}
+
+	fmt.Fprintf(out.stdout, "package: name=%s versionCode=%s versionName=%s",
+		badgingQuote(manifest.AttrValue("", "package")),
+		badgingQuote(manifest.AndroidAttr("versionCode")),
+		badgingQuote(manifest.AndroidAttr("versionName")))
+	for _, attr := range []xml.Name{
+		{Space: androidNamespace, Local: "compileSdkVersion"},
+		{Space: androidNamespace, Local: "compileSdkVersionCodename"},
+		{Local: "platformBuildVersionName"},
+	} {
+		if val, prs := badgingAttr(manifest, attr); prs {
+			fmt.Fprintf(out.stdout, " %s=%s", attr.Local, badgingQuote(val))
+		}
+	}
+	fmt.Fprintln(out.stdout)
+
+	for _, c := range manifest.Children {
+		switch c.Name.Local {
+		case "uses-sdk":
+			for _, sdk := range [][2]string{{"minSdkVersion", "sdkVersion"}, {"targetSdkVersion", "targetSdkVersion"}, {"maxSdkVersion", "maxSdkVersion"}} {
+				if val, prs := badgingAttr(c, xml.Name{Space: androidNamespace, Local: sdk[0]}); prs {
+					fmt.Fprintf(out.stdout, "%s:%s\n", sdk[1], badgingQuote(val))
+				}
+			}
+		case "uses-permission", "uses-permission-sdk-23":
+			fmt.Fprintf(out.stdout, "%s: name=%s\n", c.Name.Local, badgingQuote(c.AndroidAttr("name")))
+		}
+	}
+
+	if app := manifest.Child("application"); app != nil {
+		label := app.AndroidAttr("label")
+		fmt.Fprintf(out.stdout, "application-label:%s\n", badgingQuote(label))
+		fmt.Fprintf(out.stdout, "application: label=%s icon=%s\n", badgingQuote(label), badgingQuote(app.AndroidAttr("icon")))
+
+		for _, activity := range app.Children {
+			if (activity.Name.Local == "activity" || activity.Name.Local == "activity-alias") && badgingIsLauncher(activity) {
+				fmt.Fprintf(out.stdout, "launchable-activity: name=%s  label=%s icon=%s\n",
+					badgingQuote(activity.AndroidAttr("name")),
+					badgingQuote(activity.AndroidAttr("label")),
+					badgingQuote(activity.AndroidAttr("icon")))
+			}
+		}
+	}
+
+	if res := parser.Resources(); res != nil {
+		locales, densities := badgingConfigs(res.Configs())
+		fmt.Fprintf(out.stdout, "locales:%s\n", badgingList(locales))
+		fmt.Fprintf(out.stdout, "densities:%s\n", badgingList(densities))
+	}
+
+	if abis := badgingNativeCode(apkReader); len(abis) != 0 {
+		fmt.Fprintf(out.stdout, "native-code:%s\n", badgingList(abis))
+	}
+	return code
+}
+
+func badgingQuote(val string) string {
+	return "'" + strings.ReplaceAll(val, "'", "\\'") + "'"
+}
+
+func badgingList(vals []string) string {
+	var res strings.Builder
+	for _, v := range vals {
+		res.WriteByte(' ')
+		res.WriteString(badgingQuote(v))
+	}
+	return res.String()
+}
+
+// Returns the value of the attribute and whether it's present, aapt prints some only when they are.
+func badgingAttr(e *apkparser.ManifestElement, name xml.Name) (string, bool) {
+	for _, a := range e.Attr {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Returns true if the activity has MAIN action and LAUNCHER category in one of its intent filters.
+func badgingIsLauncher(activity *apkparser.ManifestElement) bool {
+	for _, filter := range activity.ChildrenNamed("intent-filter") {
+		var main, launcher bool
+		for _, c := range filter.Children {
+			switch {
+			case c.Name.Local == "action" && c.AndroidAttr("name") == "android.intent.action.MAIN":
+				main = true
+			case c.Name.Local == "category" && c.AndroidAttr("name") == "android.intent.category.LAUNCHER":
+				launcher = true
+			}
+		}
+
+		if main && launcher {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns sorted locales and densities used by the configs, "--_--" being the default locale.
+func badgingConfigs(configs []apkparser.ResourceConfig) (locales, densities []string) {
+	localeSet := make(map[string]bool)
+	densitySet := make(map[int]bool)
+	for i := range configs {
+		c := &configs[i]
+
+		locale := c.LanguageString()
+		if country := c.CountryString(); country != "" {
+			locale += "-" + country
+		}
+		localeSet[locale] = true
+
+		density := int(c.Density)
+		if density == apkparser.DensityDefault {
+			density = apkparser.DensityMedium
+		}
+		densitySet[density] = true
+	}
+
+	for l := range localeSet {
+		if l != "" {
+			locales = append(locales, l)
+		}
+	}
+	sort.Strings(locales)
+	if localeSet[""] {
+		locales = append([]string{"--_--"}, locales...)
+	}
+
+	densityList := make([]int, 0, len(densitySet))
+	for d := range densitySet {
+		densityList = append(densityList, d)
+	}
+	sort.Ints(densityList)
+	for _, d := range densityList {
+		densities = append(densities, strconv.Itoa(d))
+	}
+	return
+}
+
+// Returns sorted ABIs of the native libraries in lib/<abi>/.
+func badgingNativeCode(apkReader *apkparser.ZipReader) []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, f := range apkReader.FilesOrdered {
+		parts := strings.Split(f.Name, "/")
+		if len(parts) < 3 || parts[0] != "lib" || parts[1] == "" || seen[parts[1]] {
+			continue
+		}
+		seen[parts[1]] = true
+		res = append(res, parts[1])
+	}
+	sort.Strings(res)
+	return res
+}
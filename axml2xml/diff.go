@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/avast/apkparser"
+)
+
+func parseDiffTree(out *output, input string) (*apkparser.ManifestElement, int) {
+	apkReader, err := apkparser.OpenZip(input)
+	if err != nil {
+		fmt.Fprintf(out.stderr, "%s: %s\n", input, err.Error())
+		return nil, exitZip
+	}
+	defer apkReader.Close()
+
+	parser, reserr := apkparser.NewParser(apkReader, nil)
+	if reserr != nil && !errors.Is(reserr, os.ErrNotExist) {
+		fmt.Fprintf(out.stderr, "%s: failed to parse resources: %s\n", input, reserr.Error())
+	}
+
+	manifest, err := parser.ParseManifestTree()
+	if err != nil {
+		fmt.Fprintf(out.stderr, "%s: %s\n", input, err.Error())
+		return nil, exitManifest
+	}
+	return manifest, exitOk
+}
+
+// Prints structural difference of the manifests of two APKs.
+func processDiff(out *output, inputA, inputB string) int {
+	a, codeA := parseDiffTree(out, inputA)
+	b, codeB := parseDiffTree(out, inputB)
+	if codeA != exitOk {
+		return codeA
+	} else if codeB != exitOk {
+		return codeB
+	}
+
+	fmt.Fprintf(out.stdout, "--- %s\n+++ %s\n", inputA, inputB)
+	for _, c := range apkparser.DiffManifests(a, b) {
+		switch c.Kind {
+		case apkparser.ElementRemoved:
+			fmt.Fprintf(out.stdout, "- %s\n", c.Path)
+		case apkparser.ElementAdded:
+			fmt.Fprintf(out.stdout, "+ %s\n", c.Path)
+		case apkparser.AttrRemoved:
+			fmt.Fprintf(out.stdout, "~ %s -@%s=%q\n", c.Path, c.Attr, c.Old)
+		case apkparser.AttrAdded:
+			fmt.Fprintf(out.stdout, "~ %s +@%s=%q\n", c.Path, c.Attr, c.New)
+		case apkparser.AttrChanged:
+			fmt.Fprintf(out.stdout, "~ %s @%s: %q -> %q\n", c.Path, c.Attr, c.Old, c.New)
+		}
+	}
+	return exitOk
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/avast/apkparser"
+)
+
+// Encoder which can be switched to a new one between files, see ApkParser.ParseAllXml.
+type switchingEncoder struct {
+	buf bytes.Buffer
+	enc *xml.Encoder
+}
+
+func (e *switchingEncoder) reset() {
+	e.buf.Reset()
+	e.enc = xml.NewEncoder(&e.buf)
+	e.enc.Indent("", "    ")
+}
+
+func (e *switchingEncoder) EncodeToken(t xml.Token) error {
+	return e.enc.EncodeToken(t)
+}
+
+func (e *switchingEncoder) Flush() error {
+	return e.enc.Flush()
+}
+
+// Decodes the manifest and all binary XML files in res/ of the APK to opts.dumpResDir, with the references
+// resolved. Plaintext XML files are copied as they are.
+func processDumpRes(out *output, input string, opts *optsType) int {
+	if !opts.isApk {
+		fmt.Fprintf(out.stderr, "%s: -dump-res only works with APKs\n", input)
+		return exitError
+	}
+
+	apkReader, err := apkparser.OpenZipEx(input, opts.parseOptions())
+	if err != nil {
+		fmt.Fprintln(out.stderr, err)
+		return exitZip
+	}
+	defer apkReader.Close()
+
+	code := exitOk
+	enc := &switchingEncoder{}
+	enc.reset()
+	parser, reserr := apkparser.NewParserEx(apkReader, enc, opts.parseOptions())
+	if reserr != nil && !errors.Is(reserr, os.ErrNotExist) {
+		fmt.Fprintf(out.stderr, "%s: failed to parse resources: %s\n", input, reserr.Error())
+		code = exitResources
+	}
+
+	dumpFile := func(name string, err error) {
+		dest, destErr := extractDestination(opts.dumpResDir, name)
+		if destErr != nil {
+			err = destErr
+		} else if err == apkparser.ErrPlainTextManifest {
+			err = extractFile(apkReader.File[name], dest)
+		} else if err == nil {
+			if err = os.MkdirAll(filepath.Dir(dest), 0755); err == nil {
+				err = writeFileAtomic(dest, append(enc.buf.Bytes(), '\n'))
+			}
+		}
+		enc.reset()
+
+		if err != nil {
+			fmt.Fprintf(out.stderr, "Failed to decode %s: %s\n", name, err.Error())
+			code = exitManifest
+		} else {
+			fmt.Fprintf(out.stdout, "%s -> %s\n", name, dest)
+		}
+	}
+
+	dumpFile("AndroidManifest.xml", parser.ParseXml("AndroidManifest.xml"))
+	parser.ParseAllXml("res/", dumpFile)
+	return code
+}
@@ -0,0 +1,43 @@
+package main
+
+// Exit codes of axml2xml. When more inputs fail, the code of the last failure is used.
+const (
+	exitOk        = 0
+	exitError     = 1 // invalid arguments, I/O errors and other failures
+	exitZip       = 2 // the APK could not be opened as a zip file
+	exitResources = 3 // resources.arsc failed to parse
+	exitManifest  = 4 // the manifest or other XML file failed to parse
+	exitSignature = 5 // signature verification or certificate extraction failed
+	exitTimeout   = 6 // processing of the input took longer than -timeout
+)
+
+// Short description of the exit code for summaries, like "manifest".
+func exitCodeReason(code int) string {
+	switch code {
+	case exitOk:
+		return "ok"
+	case exitZip:
+		return "zip"
+	case exitResources:
+		return "resources"
+	case exitManifest:
+		return "manifest"
+	case exitSignature:
+		return "signature"
+	case exitTimeout:
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+const exitCodesUsage = `Exit codes:
+  0  success
+  1  invalid arguments, I/O errors and other failures
+  2  the APK could not be opened as a zip file
+  3  resources.arsc failed to parse
+  4  the manifest or other XML file failed to parse
+  5  signature verification or certificate extraction failed
+  6  processing of an input took longer than -timeout
+When more inputs fail, the code of the last failure is used.
+`
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/avast/apkparser"
+)
+
+// Extracts entries matching any of the comma-separated patterns in opts.extractPaths to opts.outputDir.
+func extractFiles(out *output, apkReader *apkparser.ZipReader, opts *optsType) bool {
+	patterns := strings.Split(opts.extractPaths, ",")
+
+	ok := true
+	seen := make(map[*apkparser.ZipReaderFile]bool)
+	for _, f := range apkReader.FilesOrdered {
+		if seen[f] || f.IsDir {
+			continue
+		}
+		seen[f] = true
+
+		if !matchAnyGlob(patterns, f.Name) {
+			continue
+		}
+
+		dest, err := extractDestination(opts.outputDir, f.Name)
+		if err == nil {
+			err = extractFile(f, dest)
+		}
+
+		if err != nil {
+			fmt.Fprintf(out.stderr, "Failed to extract %s: %s\n", f.Name, err.Error())
+			ok = false
+		} else {
+			fmt.Fprintf(out.stdout, "%s -> %s\n", f.Name, dest)
+		}
+	}
+	return ok
+}
+
+// Returns path of name inside dir, or error if it would end up outside of it.
+func extractDestination(dir, name string) (string, error) {
+	dest := filepath.Join(dir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dir, dest)
+	if err != nil {
+		return "", err
+	}
+
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || rel == "." {
+		return "", fmt.Errorf("path %s is outside of the output directory", name)
+	}
+	return dest, nil
+}
+
+func extractFile(f *apkparser.ZipReaderFile, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	if err := f.Open(); err != nil {
+		return err
+	}
+	defer f.Close()
+
+	outFile, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	// Try all the entries with this name, Android would use the first one that works.
+	lastErr := io.ErrUnexpectedEOF
+	for f.Next() {
+		if _, lastErr = outFile.Seek(0, io.SeekStart); lastErr != nil {
+			break
+		}
+		if lastErr = outFile.Truncate(0); lastErr != nil {
+			break
+		}
+		if _, lastErr = io.Copy(outFile, f); lastErr == nil {
+			break
+		}
+	}
+	return lastErr
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if p != "" && matchGlob(strings.Split(p, "/"), strings.Split(name, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches path segments like path.Match, ** matches any number of segments.
+func matchGlob(pattern, name []string) bool {
+	for len(pattern) != 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Fields of the Google Play Frosting protobuf known from samples. Their meaning is reverse engineered,
+// see the description of the frosting block in apkverifier's signingblock/frosting.go.
+type frostingProto struct {
+	Version     *uint64    `json:"version,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+	MinSdk      *uint64    `json:"min_sdk,omitempty"`
+	VersionCode *uint64    `json:"version_code,omitempty"`
+}
+
+func decodeFrostingProto(data []byte) (*frostingProto, error) {
+	res := &frostingProto{}
+	for _, field := range []struct {
+		path []int
+		dest **uint64
+	}{
+		{[]int{1}, &res.Version},
+		{[]int{5, 8, 1, 1}, &res.MinSdk},
+		{[]int{5, 9, 1, 1}, &res.VersionCode},
+	} {
+		val, err := protoVarint(data, field.path...)
+		if err != nil {
+			return nil, err
+		}
+		*field.dest = val
+	}
+
+	created, err := protoVarint(data, 4)
+	if err != nil {
+		return nil, err
+	} else if created != nil {
+		t := time.UnixMilli(int64(*created)).UTC()
+		res.Created = &t
+	}
+	return res, nil
+}
+
+func printFrostingProto(out io.Writer, data []byte) {
+	info, err := decodeFrostingProto(data)
+	if err != nil {
+		fmt.Fprintln(out, "  protobuf: failed to decode,", err.Error())
+		return
+	}
+
+	if info.Version != nil {
+		fmt.Fprintln(out, "  protobuf version:", *info.Version)
+	}
+	if info.Created != nil {
+		fmt.Fprintln(out, "  created:", info.Created.Format(time.RFC3339))
+	}
+	if info.MinSdk != nil {
+		fmt.Fprintln(out, "  min sdk:", *info.MinSdk)
+	}
+	if info.VersionCode != nil {
+		fmt.Fprintln(out, "  version code:", *info.VersionCode)
+	}
+}
+
+// Returns the first varint at the path of field numbers through the nested messages, nil if it's not present.
+func protoVarint(data []byte, path ...int) (*uint64, error) {
+	for i, num := range path {
+		wireType, val, payload, err := protoFindField(data, num)
+		if err != nil || wireType < 0 {
+			return nil, err
+		}
+
+		last := i == len(path)-1
+		switch {
+		case last && wireType == 0:
+			return &val, nil
+		case !last && wireType == 2:
+			data = payload
+		default:
+			return nil, fmt.Errorf("Unexpected wire type %d of field %v.", wireType, path[:i+1])
+		}
+	}
+	return nil, nil
+}
+
+// Returns the wire type and the value (varint) or payload (length-delimited) of the first field
+// with the number, wire type -1 if there is none.
+func protoFindField(data []byte, num int) (wireType int, val uint64, payload []byte, err error) {
+	for len(data) != 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return -1, 0, nil, fmt.Errorf("Invalid protobuf field key.")
+		}
+		data = data[n:]
+
+		wireType = int(key & 7)
+		switch wireType {
+		case 0:
+			if val, n = binary.Uvarint(data); n <= 0 {
+				return -1, 0, nil, fmt.Errorf("Invalid protobuf varint.")
+			}
+			data = data[n:]
+		case 1, 5:
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if len(data) < size {
+				return -1, 0, nil, fmt.Errorf("Truncated protobuf fixed field.")
+			}
+			data = data[size:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return -1, 0, nil, fmt.Errorf("Invalid protobuf field length.")
+			}
+			payload = data[n : n+int(length)]
+			data = data[n+int(length):]
+		default:
+			return -1, 0, nil, fmt.Errorf("Unsupported protobuf wire type %d.", wireType)
+		}
+
+		if int(key>>3) == num {
+			return wireType, val, payload, nil
+		}
+	}
+	return -1, 0, nil, nil
+}
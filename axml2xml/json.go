@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/avast/apkparser"
+	"github.com/avast/apkverifier"
+	"github.com/avast/apkverifier/apilevel"
+	"github.com/avast/apkverifier/signingblock"
+)
+
+type jsonResult struct {
+	Input          string             `json:"input"`
+	Error          string             `json:"error,omitempty"`
+	ResourcesError string             `json:"resources_error,omitempty"`
+	ManifestError  string             `json:"manifest_error,omitempty"`
+	Manifest       string             `json:"manifest,omitempty"`
+	Certificates   [][]jsonCert       `json:"certificates,omitempty"`
+	Verification   []jsonVerification `json:"verification,omitempty"`
+}
+
+type jsonCert struct {
+	Picked       bool      `json:"picked,omitempty"`
+	Algo         string    `json:"algo"`
+	ValidFrom    time.Time `json:"valid_from"`
+	ValidTo      time.Time `json:"valid_to"`
+	SerialNumber string    `json:"serial_number"`
+	Md5          string    `json:"thumbprint_md5"`
+	Sha1         string    `json:"thumbprint_sha1"`
+	Sha256       string    `json:"thumbprint_sha256"`
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+}
+
+type jsonVerification struct {
+	MinSdk          string           `json:"min_sdk"`
+	MaxSdk          string           `json:"max_sdk"`
+	SigningSchemeId int              `json:"signing_scheme_id"`
+	Error           string           `json:"error,omitempty"`
+	SignerCerts     [][]jsonCert     `json:"signer_certs,omitempty"`
+	Frosting        *jsonFrosting    `json:"frosting,omitempty"`
+	SourceStamp     *jsonSourceStamp `json:"source_stamp,omitempty"`
+	ExtraBlocks     []string         `json:"extra_blocks,omitempty"`
+	Warnings        []string         `json:"warnings,omitempty"`
+	Errors          []string         `json:"errors,omitempty"`
+}
+
+type jsonFrosting struct {
+	Error          string         `json:"error,omitempty"`
+	KeySha256      string         `json:"key_sha256,omitempty"`
+	ProtobufLength int            `json:"protobuf_length"`
+	Protobuf       *frostingProto `json:"protobuf,omitempty"`
+	ProtobufError  string         `json:"protobuf_error,omitempty"`
+}
+
+type jsonSourceStamp struct {
+	SigningTime *time.Time `json:"signing_time,omitempty"`
+	Cert        *jsonCert  `json:"cert,omitempty"`
+	Errors      []string   `json:"errors,omitempty"`
+	Warnings    []string   `json:"warnings,omitempty"`
+}
+
+func processInputJson(out *output, input string, opts *optsType) int {
+	res := jsonResult{Input: input}
+
+	var code int
+	if opts.isApk {
+		code = processApkJson(input, opts, &res)
+	} else {
+		code = processFileJson(input, opts, &res)
+	}
+
+	enc := json.NewEncoder(out.stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(&res); err != nil {
+		fmt.Fprintln(out.stderr, err)
+		return exitError
+	}
+	return code
+}
+
+func processFileJson(input string, opts *optsType, res *jsonResult) int {
+	var r io.Reader
+	if input == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(input)
+		if err != nil {
+			res.Error = err.Error()
+			return exitError
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if opts.isManifest {
+		var buf bytes.Buffer
+		enc := xml.NewEncoder(&buf)
+		enc.Indent("", "    ")
+
+		err := apkparser.ParseXmlEx(r, enc, nil, opts.parseOptions())
+		res.Manifest = buf.String()
+		if err != nil {
+			res.ManifestError = err.Error()
+			return exitManifest
+		}
+	} else if _, err := apkparser.ParseResourceTableEx(r, opts.parseOptions()); err != nil {
+		res.ResourcesError = err.Error()
+		return exitResources
+	}
+	return exitOk
+}
+
+func processApkJson(input string, opts *optsType, res *jsonResult) int {
+	apkReader, err := apkparser.OpenZipEx(input, opts.parseOptions())
+	if err != nil {
+		res.Error = err.Error()
+		return exitZip
+	}
+	defer apkReader.Close()
+
+	code := exitOk
+	if opts.dumpManifest {
+		var buf bytes.Buffer
+		enc := xml.NewEncoder(&buf)
+		enc.Indent("", "    ")
+
+		parser, reserr := apkparser.NewParserEx(apkReader, enc, opts.parseOptions())
+		if reserr != nil {
+			res.ResourcesError = reserr.Error()
+			if !errors.Is(reserr, os.ErrNotExist) {
+				code = exitResources
+			}
+		}
+
+		err := parser.ParseXml(opts.xmlFileName)
+		res.Manifest = buf.String()
+		if err != nil {
+			res.ManifestError = err.Error()
+			code = exitManifest
+		}
+	}
+
+	if opts.verifyAllSignatureVersions {
+		for _, s := range allSigsSdks {
+			v := verifyApkJson(input, apkReader, s.min, s.max)
+			if v.Error != "" {
+				code = exitSignature
+			}
+			res.Verification = append(res.Verification, v)
+		}
+	} else if opts.verifyApk {
+		v := verifyApkJson(input, apkReader, -1, math.MaxInt32)
+		if v.Error != "" {
+			code = exitSignature
+		}
+		res.Verification = append(res.Verification, v)
+	} else if opts.extractCert {
+		certs, err := apkverifier.ExtractCerts(input, apkReader)
+		if err != nil {
+			res.Error = err.Error()
+			return exitSignature
+		}
+		res.Certificates = jsonCertChains(certs)
+	}
+	return code
+}
+
+func verifyApkJson(input string, apkReader *apkparser.ZipReader, minSdk, maxSdk int32) jsonVerification {
+	res, err := apkverifier.VerifyWithSdkVersion(input, apkReader, minSdk, maxSdk)
+
+	v := jsonVerification{
+		MinSdk:          apilevel.String(minSdk),
+		MaxSdk:          apilevel.String(maxSdk),
+		SigningSchemeId: res.SigningSchemeId,
+		SignerCerts:     jsonCertChains(res.SignerCerts),
+	}
+
+	if err != nil {
+		v.Error = err.Error()
+	}
+
+	if blk := res.SigningBlockResult; blk != nil {
+		if blk.Frosting != nil {
+			v.Frosting = &jsonFrosting{
+				KeySha256:      blk.Frosting.KeySha256,
+				ProtobufLength: len(blk.Frosting.ProtobufInfo),
+			}
+			if blk.Frosting.Error != nil {
+				v.Frosting.Error = blk.Frosting.Error.Error()
+			}
+
+			if len(blk.Frosting.ProtobufInfo) != 0 {
+				if info, err := decodeFrostingProto(blk.Frosting.ProtobufInfo); err != nil {
+					v.Frosting.ProtobufError = err.Error()
+				} else {
+					v.Frosting.Protobuf = info
+				}
+			}
+		}
+
+		if st := blk.SourceStamp; st != nil {
+			v.SourceStamp = jsonSourceStampResult(st)
+		}
+
+		for id := range blk.ExtraBlocks {
+			v.ExtraBlocks = append(v.ExtraBlocks, fmt.Sprintf("0x%08x: %s", uint32(id), id.String()))
+		}
+		sort.Strings(v.ExtraBlocks)
+
+		v.Warnings = blk.Warnings
+		v.Errors = jsonErrors(blk.Errors)
+	}
+	return v
+}
+
+func jsonSourceStampResult(st *signingblock.SourceStampResult) *jsonSourceStamp {
+	res := &jsonSourceStamp{
+		Errors:   jsonErrors(st.Errors),
+		Warnings: st.Warnings,
+	}
+
+	if !st.SigningTime.IsZero() {
+		res.SigningTime = &st.SigningTime
+	}
+
+	if st.Cert != nil {
+		c := jsonCertificate(st.Cert)
+		res.Cert = &c
+	}
+	return res
+}
+
+func jsonCertChains(certs [][]*x509.Certificate) [][]jsonCert {
+	_, picked := apkverifier.PickBestApkCert(certs)
+
+	var res [][]jsonCert
+	for _, chain := range certs {
+		var jsonChain []jsonCert
+		for _, cert := range chain {
+			c := jsonCertificate(cert)
+			c.Picked = cert == picked
+			jsonChain = append(jsonChain, c)
+		}
+		res = append(res, jsonChain)
+	}
+	return res
+}
+
+func jsonCertificate(cert *x509.Certificate) jsonCert {
+	var cinfo apkverifier.CertInfo
+	cinfo.Fill(cert)
+
+	return jsonCert{
+		Algo:         cert.SignatureAlgorithm.String(),
+		ValidFrom:    cinfo.ValidFrom,
+		ValidTo:      cinfo.ValidTo,
+		SerialNumber: hex.EncodeToString(cert.SerialNumber.Bytes()),
+		Md5:          cinfo.Md5,
+		Sha1:         cinfo.Sha1,
+		Sha256:       cinfo.Sha256,
+		Subject:      cinfo.Subject,
+		Issuer:       cinfo.Issuer,
+	}
+}
+
+func jsonErrors(errs []error) []string {
+	var res []string
+	for _, e := range errs {
+		res = append(res, e.Error())
+	}
+	return res
+}
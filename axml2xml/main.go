@@ -3,9 +3,12 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/avast/apkverifier/signingblock"
@@ -22,6 +25,8 @@ import (
 	"github.com/avast/apkverifier/apilevel"
 )
 
+const androidNamespace = "http://schemas.android.com/apk/res/android"
+
 type optsType struct {
 	isApk                      bool
 	isManifest                 bool
@@ -29,12 +34,37 @@ type optsType struct {
 	verifyApk                  bool
 	verifyAllSignatureVersions bool
 	dumpManifest               bool
+	canonical                  bool
 	extractCert                bool
+	json                       bool
+	diff                       bool
+	badging                    bool
+	ndjson                     bool
+	quiet                      bool
+
+	jobs    int
+	timeout time.Duration
 
 	cpuProfile        string
 	fileListPath      string
 	dumpFrostingProto string
 	xmlFileName       string
+	extractPaths      string
+	outputDir         string
+	query             string
+	outFile           string
+	outDir            string
+	failuresPath      string
+	only              string
+	dumpResDir        string
+	dumpStrings       bool
+
+	ctx context.Context // of the current input, see processInputWithTimeout
+}
+
+// Options for parsing the current input.
+func (o *optsType) parseOptions() *apkparser.ParseOptions {
+	return &apkparser.ParseOptions{Context: o.ctx}
 }
 
 type sdkLevelPair struct {
@@ -48,6 +78,10 @@ var allSigsSdks = [...]sdkLevelPair{
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Exit(runServe(os.Args[2:]))
+	}
+
 	var opts optsType
 
 	flag.BoolVar(&opts.isApk, "a", false, "The input file is an apk (default if INPUT is *.apk)")
@@ -57,10 +91,34 @@ func main() {
 	flag.BoolVar(&opts.verifyAllSignatureVersions, "allsig", false, "Verify all signature version if it is an APK.")
 	flag.BoolVar(&opts.extractCert, "e", false, "Extract the certificate without verifying it.")
 	flag.BoolVar(&opts.dumpManifest, "d", true, "Print the AndroidManifest.xml (only makes sense for APKs)")
+	flag.BoolVar(&opts.canonical, "canonical", false, "Print the XML in normalized form, which is the same for logically identical manifests")
 	flag.StringVar(&opts.cpuProfile, "cpuprofile", "", "Write cpu profiling info")
-	flag.StringVar(&opts.fileListPath, "l", "", "Process file list")
+	flag.StringVar(&opts.fileListPath, "l", "", "Process file list, printing a summary of the results at the end")
+	flag.StringVar(&opts.failuresPath, "failures", "", "Write the inputs from the file list (-l) which failed to this file, to process them again")
 	flag.StringVar(&opts.dumpFrostingProto, "dumpfrosting", "", "Dump Google Play Frosting protobuf data")
 	flag.StringVar(&opts.xmlFileName, "f", "AndroidManifest.xml", "Name of the XML file from inside apk to parse")
+	flag.StringVar(&opts.extractPaths, "x", "", "Extract entries matching comma-separated paths or globs (like lib/**) from the APK")
+	flag.StringVar(&opts.outputDir, "o", ".", "Directory to extract the -x entries to, only with -x (the printed output goes to -out or -outdir)")
+	flag.StringVar(&opts.query, "q", "", "Print all config variants of a resource from resources.arsc, by name (@string/app_name) or id (0x7f0b0001)")
+	flag.StringVar(&opts.only, "only", "", "Print just a part of the manifest, one item per line: permissions, components, intent-filters or metadata, or more of them separated by commas")
+	flag.StringVar(&opts.dumpResDir, "dump-res", "", "Decode AndroidManifest.xml and all XML files in res/ of the APK to this directory")
+	flag.BoolVar(&opts.dumpStrings, "strings", false, "Print the raw string pools of the binary XML (the -f file for APKs) or resources.arsc: pool, index, offset, encoding and value")
+	flag.BoolVar(&opts.badging, "badging", false, "Print summary of the APK like aapt2 dump badging")
+	flag.StringVar(&opts.outFile, "out", "", "Write the output to this file instead of stdout")
+	flag.StringVar(&opts.outDir, "outdir", "", "Write the output of each input to a separate file in this directory, named after the input; inputs with the same name fail")
+	flag.BoolVar(&opts.ndjson, "ndjson", false, "Print one line of JSON summary (path, status, manifest digest, package, errors) per input")
+	flag.BoolVar(&opts.quiet, "quiet", false, "Don't print anything, only report the result with the exit code")
+	flag.BoolVar(&opts.diff, "diff", false, "Print structural difference of manifests of two APKs: -diff A.apk B.apk")
+	flag.IntVar(&opts.jobs, "j", 1, "Number of inputs from the file list (-l) to process in parallel")
+	flag.DurationVar(&opts.timeout, "timeout", 0, "Give up on each input after this long, e.g. 30s (default no limit)")
+	flag.BoolVar(&opts.json, "json", false, "Print the manifest, certificates and verification results as one JSON document per input")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s serve -h\n    \tServe parsing of APKs over HTTP\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), "\n"+exitCodesUsage)
+	}
 
 	flag.Parse()
 
@@ -68,12 +126,38 @@ func main() {
 		opts.verifyApk = true
 	}
 
+	if opts.extractPaths != "" && !isFlagSet("d") {
+		opts.dumpManifest = false
+	}
+
 	if opts.fileListPath == "" && len(flag.Args()) < 1 {
 		fmt.Printf("%s INPUT\n", os.Args[0])
-		os.Exit(1)
+		os.Exit(exitError)
+	}
+
+	if opts.outFile != "" && opts.outDir != "" {
+		fmt.Println("-out and -outdir can't be used together")
+		os.Exit(exitError)
+	}
+
+	if isFlagSet("o") && opts.extractPaths == "" {
+		fmt.Println("-o is the directory for -x, use -out or -outdir for the output")
+		os.Exit(exitError)
+	}
+
+	if opts.only != "" {
+		if err := checkOnlyFlag(opts.only); err != nil {
+			fmt.Println(err)
+			os.Exit(exitError)
+		}
+	}
+
+	if opts.diff && len(flag.Args()) != 2 {
+		fmt.Printf("%s -diff A.apk B.apk\n", os.Args[0])
+		os.Exit(exitError)
 	}
 
-	exitcode := 0
+	exitcode := exitOk
 	defer func() {
 		if r := recover(); r != nil {
 			panic(r)
@@ -85,7 +169,7 @@ func main() {
 		f, err := os.Create(opts.cpuProfile)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
-			exitcode = 1
+			exitcode = exitError
 			return
 		}
 		defer f.Close()
@@ -94,40 +178,98 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	if opts.fileListPath == "" {
-		for i, input := range flag.Args() {
-			if i != 0 {
-				fmt.Println()
+	out := stdOutput
+	if opts.quiet {
+		out = &output{stdout: ioutil.Discard, stderr: ioutil.Discard}
+	} else if opts.outFile != "" {
+		var buf bytes.Buffer
+		out = &output{stdout: &buf, stderr: os.Stderr}
+		defer func() {
+			if err := writeFileAtomic(opts.outFile, buf.Bytes()); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exitcode = exitError
 			}
+		}()
+	}
 
-			if len(flag.Args()) != 1 {
-				fmt.Println("File:", input)
+	if opts.diff {
+		if code := processDiff(out, flag.Arg(0), flag.Arg(1)); code != exitOk {
+			exitcode = code
+		}
+	} else if opts.fileListPath == "" {
+		for i, input := range flag.Args() {
+			if opts.outDir == "" && !opts.json && !opts.ndjson {
+				if i != 0 {
+					fmt.Fprintln(out.stdout)
+				}
+
+				if len(flag.Args()) != 1 {
+					fmt.Fprintln(out.stdout, "File:", input)
+				}
 			}
 
-			if !processInput(input, &opts) {
-				exitcode = 1
+			if code := processInputWithTimeout(out, input, &opts); code != exitOk {
+				exitcode = code
 			}
 		}
 	} else {
 		f, err := os.Open(opts.fileListPath)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+			os.Exit(exitError)
 		}
 		defer f.Close()
 
+		var summary runSummary
 		s := bufio.NewScanner(f)
-		for s.Scan() {
-			if !processInput(s.Text(), &opts) {
-				exitcode = 1
+		if opts.jobs > 1 {
+			inputs := make(chan string)
+			go func() {
+				for s.Scan() {
+					inputs <- s.Text()
+				}
+				close(inputs)
+			}()
+
+			if code := processInputsParallel(out, inputs, &opts, opts.jobs, &summary); code != exitOk {
+				exitcode = code
+			}
+		} else {
+			for s.Scan() {
+				code := processInputWithTimeout(out, s.Text(), &opts)
+				summary.add(s.Text(), code)
+				if code != exitOk {
+					exitcode = code
+				}
+			}
+		}
+
+		summary.write(out.stderr)
+		if opts.failuresPath != "" {
+			if err := summary.writeFailures(opts.failuresPath); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exitcode = exitError
 			}
 		}
 	}
 }
 
-func processInput(input string, opts *optsType) bool {
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func processInput(out *output, input string, opts *optsType) int {
 	var r io.Reader
 
+	// The type is detected for each input, opts are shared by all of them.
+	inputOpts := *opts
+	opts = &inputOpts
 	if !opts.isApk && !opts.isManifest && !opts.isResources {
 		if strings.HasSuffix(input, ".apk") {
 			opts.isApk = true
@@ -138,281 +280,342 @@ func processInput(input string, opts *optsType) bool {
 		}
 	}
 
+	if opts.query != "" {
+		return processQuery(out, input, opts)
+	}
+
+	if opts.badging {
+		return processBadging(out, input, opts)
+	}
+
+	if opts.only != "" {
+		return processOnly(out, input, opts)
+	}
+
+	if opts.dumpResDir != "" {
+		return processDumpRes(out, input, opts)
+	}
+
+	if opts.dumpStrings {
+		return processStrings(out, input, opts)
+	}
+
+	if opts.ndjson {
+		return processInputNdjson(out, input, opts)
+	}
+
+	if opts.json {
+		return processInputJson(out, input, opts)
+	}
+
 	if opts.isApk {
-		return processApk(input, opts)
+		return processApk(out, input, opts)
 	} else {
 		if input == "-" {
 			r = os.Stdin
 		} else {
 			f, err := os.Open(input)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				return false
+				fmt.Fprintln(out.stderr, err)
+				return exitError
 			}
 			defer f.Close()
 			r = f
 		}
 
-		var err error
-		if opts.isManifest {
-			enc := xml.NewEncoder(os.Stdout)
+		if opts.isManifest && opts.canonical {
+			tree, err := apkparser.ParseXmlTreeEx(r, nil, opts.parseOptions())
+			if err == nil {
+				err = tree.WriteCanonical(out.stdout, nil)
+			}
+			if err != nil {
+				fmt.Fprintln(out.stderr, err)
+				return exitManifest
+			}
+		} else if opts.isManifest {
+			enc := xml.NewEncoder(out.stdout)
 			enc.Indent("", "    ")
 
-			err = apkparser.ParseXml(r, enc, nil)
+			err := apkparser.ParseXmlEx(r, enc, nil, opts.parseOptions())
+			fmt.Fprintln(out.stdout)
+			if err != nil {
+				fmt.Fprintln(out.stderr, err)
+				return exitManifest
+			}
 		} else {
-			_, err = apkparser.ParseResourceTable(r)
-		}
-
-		fmt.Println()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			return false
+			_, err := apkparser.ParseResourceTableEx(r, opts.parseOptions())
+			fmt.Fprintln(out.stdout)
+			if err != nil {
+				fmt.Fprintln(out.stderr, err)
+				return exitResources
+			}
 		}
 	}
-	return true
+	return exitOk
 }
 
-func processApk(input string, opts *optsType) bool {
-	enc := xml.NewEncoder(os.Stdout)
+func processApk(out *output, input string, opts *optsType) int {
+	enc := xml.NewEncoder(out.stdout)
 	enc.Indent("", "    ")
 
-	apkReader, err := apkparser.OpenZip(input)
+	apkReader, err := apkparser.OpenZipEx(input, opts.parseOptions())
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return false
+		fmt.Fprintln(out.stderr, err)
+		return exitZip
 	}
 	defer apkReader.Close()
 
+	if opts.extractPaths != "" && !extractFiles(out, apkReader, opts) {
+		return exitError
+	}
+
+	code := exitOk
 	if opts.dumpManifest {
-		parser, reserr := apkparser.NewParser(apkReader, enc)
+		parser, reserr := apkparser.NewParserEx(apkReader, enc, opts.parseOptions())
 		if reserr != nil {
-			fmt.Fprintf(os.Stderr, "\nFailed to parse resources: %s", reserr.Error())
+			fmt.Fprintf(out.stderr, "\nFailed to parse resources: %s", reserr.Error())
+			if !errors.Is(reserr, os.ErrNotExist) {
+				code = exitResources
+			}
 		}
 
-		err := parser.ParseXml(opts.xmlFileName)
+		var err error
+		if opts.canonical {
+			var tree *apkparser.ManifestElement
+			if tree, err = parser.ParseXmlTree(opts.xmlFileName); err == nil {
+				err = tree.WriteCanonical(out.stdout, parser.Resources())
+			}
+		} else {
+			err = parser.ParseXml(opts.xmlFileName)
+			fmt.Fprintln(out.stdout)
+		}
 
-		fmt.Println()
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			return false
+			fmt.Fprintln(out.stderr, err)
+			return exitManifest
 		}
 	}
 
 	if !opts.verifyApk && !opts.extractCert {
-		return true
+		return code
 	}
 
 	if opts.dumpManifest {
-		fmt.Print("\n=====================================\n")
+		fmt.Fprint(out.stdout, "\n=====================================\n")
 	}
 
 	if opts.verifyAllSignatureVersions {
 		ok := true
 		for _, s := range allSigsSdks {
-			fmt.Printf("\nVerifying for SDK range <%s;%s>", apilevel.String(s.min), apilevel.String(s.max))
-			fmt.Print("\n=====================================\n")
-			if !verifyApkWithSdkLevels(input, apkReader, opts, s.min, s.max) {
+			fmt.Fprintf(out.stdout, "\nVerifying for SDK range <%s;%s>", apilevel.String(s.min), apilevel.String(s.max))
+			fmt.Fprint(out.stdout, "\n=====================================\n")
+			if !verifyApkWithSdkLevels(out, input, apkReader, opts, s.min, s.max) {
 				ok = false
 			}
 		}
 
 		if ok {
-			fmt.Println("\nAll signatures are okay.")
+			fmt.Fprintln(out.stdout, "\nAll signatures are okay.")
+		} else {
+			return exitSignature
 		}
 
 	} else if opts.verifyApk {
-		return verifyApk(input, apkReader, opts)
+		if !verifyApk(out, input, apkReader, opts) {
+			return exitSignature
+		}
 	} else if opts.extractCert {
 		certs, err := apkverifier.ExtractCerts(input, apkReader)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error:", err)
-			return false
+			fmt.Fprintln(out.stderr, "Error:", err)
+			return exitSignature
 		}
-		printCerts(certs, "")
+		printCerts(out, certs, "")
 	}
 
-	return true
+	return code
 }
 
-func verifyApk(input string, apkReader *apkparser.ZipReader, opts *optsType) bool {
-	return verifyApkWithSdkLevels(input, apkReader, opts, -1, math.MaxInt32)
+func verifyApk(out *output, input string, apkReader *apkparser.ZipReader, opts *optsType) bool {
+	return verifyApkWithSdkLevels(out, input, apkReader, opts, -1, math.MaxInt32)
 }
 
-func verifyApkWithSdkLevels(input string, apkReader *apkparser.ZipReader, opts *optsType, minSdk, maxSdk int32) bool {
+func verifyApkWithSdkLevels(out *output, input string, apkReader *apkparser.ZipReader, opts *optsType, minSdk, maxSdk int32) bool {
 	res, err := apkverifier.VerifyWithSdkVersion(input, apkReader, minSdk, maxSdk)
 
-	fmt.Printf("Verification scheme used: v%d\n", res.SigningSchemeId)
+	fmt.Fprintf(out.stdout, "Verification scheme used: v%d\n", res.SigningSchemeId)
 
-	printCerts(res.SignerCerts, "")
+	printCerts(out, res.SignerCerts, "")
 
-	fmt.Println()
+	fmt.Fprintln(out.stdout)
 
-	printSigningBlockResult(res.SigningBlockResult, opts)
+	printSigningBlockResult(out, res.SigningBlockResult, opts)
 
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
+		fmt.Fprintln(out.stderr, "Error:", err)
 		return false
 	}
 	return true
 }
 
-func printLineage(lineage *signingblock.V3SigningLineage, indent string) {
+func printLineage(out *output, lineage *signingblock.V3SigningLineage, indent string) {
 	if lineage == nil {
 		return
 	}
 
-	fmt.Println(indent, "Signing lineage:")
+	fmt.Fprintln(out.stdout, indent, "Signing lineage:")
 	for i, n := range lineage.Nodes {
-		fmt.Printf("%sNode #%d:\n", indent, i)
-		n.Dump(os.Stdout)
-		fmt.Println()
+		fmt.Fprintf(out.stdout, "%sNode #%d:\n", indent, i)
+		n.Dump(out.stdout)
+		fmt.Fprintln(out.stdout)
 	}
 }
 
-func printSigningBlockResult(blk *signingblock.VerificationResult, opts *optsType) {
+func printSigningBlockResult(out *output, blk *signingblock.VerificationResult, opts *optsType) {
 	if blk == nil {
 		return
 	}
 
-	printLineage(blk.SigningLineage, "")
+	printLineage(out, blk.SigningLineage, "")
 
-	fmt.Printf("Google Play Store Frosting: ")
+	fmt.Fprintf(out.stdout, "Google Play Store Frosting: ")
 	if blk.Frosting != nil {
-		fmt.Println("present")
+		fmt.Fprintln(out.stdout, "present")
 		if blk.Frosting.Error == nil {
-			fmt.Printf("  verification: ok\n")
+			fmt.Fprintf(out.stdout, "  verification: ok\n")
 		} else {
-			fmt.Printf("  verification: FAILED, %s\n", blk.Frosting.Error.Error())
+			fmt.Fprintf(out.stdout, "  verification: FAILED, %s\n", blk.Frosting.Error.Error())
 		}
 
-		fmt.Println("  protobuf data length:", len(blk.Frosting.ProtobufInfo))
+		fmt.Fprintln(out.stdout, "  protobuf data length:", len(blk.Frosting.ProtobufInfo))
+		printFrostingProto(out.stdout, blk.Frosting.ProtobufInfo)
 
 		if blk.Frosting.KeySha256 != "" {
-			fmt.Println("  used key sha256:", blk.Frosting.KeySha256)
+			fmt.Fprintln(out.stdout, "  used key sha256:", blk.Frosting.KeySha256)
 		}
-		fmt.Println()
+		fmt.Fprintln(out.stdout)
 
 		if opts.dumpFrostingProto != "" {
 			if err := ioutil.WriteFile(opts.dumpFrostingProto, blk.Frosting.ProtobufInfo, 0644); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to dump Google Play Frosting protobuf: %s", err.Error())
+				fmt.Fprintf(out.stderr, "Failed to dump Google Play Frosting protobuf: %s", err.Error())
 			}
 		}
 	} else {
-		fmt.Println("missing")
+		fmt.Fprintln(out.stdout, "missing")
 	}
-	fmt.Println()
+	fmt.Fprintln(out.stdout)
 
-	fmt.Printf("Source stamp: ")
+	fmt.Fprintf(out.stdout, "Source stamp: ")
 	if st := blk.SourceStamp; st != nil {
-		fmt.Println("present")
+		fmt.Fprintln(out.stdout, "present")
 		if len(st.Errors) == 0 {
-			fmt.Printf("  verification: ok\n")
+			fmt.Fprintf(out.stdout, "  verification: ok\n")
 		} else {
-			fmt.Printf("  verification: FAILED\n")
+			fmt.Fprintf(out.stdout, "  verification: FAILED\n")
 			for _, e := range st.Errors {
-				fmt.Printf("    %s\n", e.Error())
+				fmt.Fprintf(out.stdout, "    %s\n", e.Error())
 			}
 		}
 
-		fmt.Printf("  signing time: ")
+		fmt.Fprintf(out.stdout, "  signing time: ")
 		if st.SigningTime.IsZero() {
-			fmt.Println("not present")
+			fmt.Fprintln(out.stdout, "not present")
 		} else {
-			fmt.Println(st.SigningTime.Format(time.RFC3339))
+			fmt.Fprintln(out.stdout, st.SigningTime.Format(time.RFC3339))
 		}
 
-		fmt.Printf("  certificate:")
+		fmt.Fprintf(out.stdout, "  certificate:")
 		if st.Cert == nil {
-			fmt.Printf(" none extracted\n")
+			fmt.Fprintf(out.stdout, " none extracted\n")
 		} else {
-			fmt.Println()
-			printCert("    ", st.Cert)
+			fmt.Fprintln(out.stdout)
+			printCert(out, "    ", st.Cert)
 		}
 
-		fmt.Printf("  lineage: %d\n", len(st.Lineage))
+		fmt.Fprintf(out.stdout, "  lineage: %d\n", len(st.Lineage))
 		for i, l := range st.Lineage {
-			fmt.Printf("    %d: 0x%x %s (parent %s)\n", i, l.Flags, l.Algo, l.ParentAlgo)
-			printCert("      ", l.Cert)
+			fmt.Fprintf(out.stdout, "    %d: 0x%x %s (parent %s)\n", i, l.Flags, l.Algo, l.ParentAlgo)
+			printCert(out, "      ", l.Cert)
 		}
 
-		fmt.Printf("  warnings:\n")
+		fmt.Fprintf(out.stdout, "  warnings:\n")
 		for _, e := range st.Warnings {
-			fmt.Printf("    %s\n", e)
+			fmt.Fprintf(out.stdout, "    %s\n", e)
 		}
 	} else {
-		fmt.Println("missing")
+		fmt.Fprintln(out.stdout, "missing")
 	}
-	fmt.Println()
+	fmt.Fprintln(out.stdout)
 
 	if len(blk.ExtraResults) != 0 {
-		fmt.Printf("Extra results:\n")
+		fmt.Fprintf(out.stdout, "Extra results:\n")
 		for schemeId, extraRes := range blk.ExtraResults {
-			fmt.Printf("  Scheme %d\n", schemeId)
-			printCerts(extraRes.Certs, "    ")
-			printLineage(extraRes.SigningLineage, "    ")
-			printSigningBlockErrors(extraRes, "    ")
+			fmt.Fprintf(out.stdout, "  Scheme %d\n", schemeId)
+			printCerts(out, extraRes.Certs, "    ")
+			printLineage(out, extraRes.SigningLineage, "    ")
+			printSigningBlockErrors(out, extraRes, "    ")
 		}
-		fmt.Println()
+		fmt.Fprintln(out.stdout)
 	}
 
-	fmt.Printf("Extra signing blocks: %d\n", len(blk.ExtraBlocks))
+	fmt.Fprintf(out.stdout, "Extra signing blocks: %d\n", len(blk.ExtraBlocks))
 	for id, block := range blk.ExtraBlocks {
-		fmt.Printf("    0x%08x: %s (%d bytes)\n", uint32(id), id.String(), len(block))
+		fmt.Fprintf(out.stdout, "    0x%08x: %s (%d bytes)\n", uint32(id), id.String(), len(block))
 	}
-	fmt.Println()
+	fmt.Fprintln(out.stdout)
 
-	printSigningBlockErrors(blk, "")
+	printSigningBlockErrors(out, blk, "")
 
 }
 
-func printSigningBlockErrors(blk *signingblock.VerificationResult, indent string) {
+func printSigningBlockErrors(out *output, blk *signingblock.VerificationResult, indent string) {
 	if len(blk.Warnings) != 0 {
-		fmt.Println(indent, "Warnings:")
+		fmt.Fprintln(out.stdout, indent, "Warnings:")
 		for _, w := range blk.Warnings {
-			fmt.Println(indent, " ", w)
+			fmt.Fprintln(out.stdout, indent, " ", w)
 		}
-		fmt.Println()
+		fmt.Fprintln(out.stdout)
 	}
 
 	if len(blk.Errors) > 1 {
-		fmt.Println(indent, "Additional errors:")
+		fmt.Fprintln(out.stdout, indent, "Additional errors:")
 		for i := 0; i < len(blk.Errors)-1; i++ {
-			fmt.Println(indent, " ", blk.Errors[i])
+			fmt.Fprintln(out.stdout, indent, " ", blk.Errors[i])
 		}
-		fmt.Println()
+		fmt.Fprintln(out.stdout)
 	}
 }
 
-func printCerts(certs [][]*x509.Certificate, indent string) {
+func printCerts(out *output, certs [][]*x509.Certificate, indent string) {
 	_, picked := apkverifier.PickBestApkCert(certs)
 
 	var x int
 	var cert *x509.Certificate
 	for i, ca := range certs {
 		for x, cert = range ca {
-			fmt.Println()
+			fmt.Fprintln(out.stdout)
 			if picked == cert {
-				fmt.Printf("%sChain %d, cert %d [PICKED AS BEST]:\n", indent, i, x)
+				fmt.Fprintf(out.stdout, "%sChain %d, cert %d [PICKED AS BEST]:\n", indent, i, x)
 			} else {
-				fmt.Printf("%sChain %d, cert %d:\n", indent, i, x)
+				fmt.Fprintf(out.stdout, "%sChain %d, cert %d:\n", indent, i, x)
 			}
 
-			printCert(indent+"  ", cert)
+			printCert(out, indent+"  ", cert)
 		}
 	}
 }
 
-func printCert(prefix string, cert *x509.Certificate) {
+func printCert(out *output, prefix string, cert *x509.Certificate) {
 	var cinfo apkverifier.CertInfo
 	cinfo.Fill(cert)
 
-	fmt.Printf(prefix+"algo: %s\n", cert.SignatureAlgorithm)
-	fmt.Printf(prefix+"validfrom: %s\n", cinfo.ValidFrom)
-	fmt.Printf(prefix+"validto: %s\n", cinfo.ValidTo)
-	fmt.Printf(prefix+"serialnumber: %s\n", hex.EncodeToString(cert.SerialNumber.Bytes()))
-	fmt.Printf(prefix+"thumbprint-md5: %s\n", cinfo.Md5)
-	fmt.Printf(prefix+"thumbprint-sha1: %s\n", cinfo.Sha1)
-	fmt.Printf(prefix+"thumbprint-sha256: %s\n", cinfo.Sha256)
-	fmt.Printf(prefix+"Subject:\n  %s%s\n", prefix, cinfo.Subject)
-	fmt.Printf(prefix+"Issuer:\n  %s%s\n", prefix, cinfo.Issuer)
+	fmt.Fprintf(out.stdout, prefix+"algo: %s\n", cert.SignatureAlgorithm)
+	fmt.Fprintf(out.stdout, prefix+"validfrom: %s\n", cinfo.ValidFrom)
+	fmt.Fprintf(out.stdout, prefix+"validto: %s\n", cinfo.ValidTo)
+	fmt.Fprintf(out.stdout, prefix+"serialnumber: %s\n", hex.EncodeToString(cert.SerialNumber.Bytes()))
+	fmt.Fprintf(out.stdout, prefix+"thumbprint-md5: %s\n", cinfo.Md5)
+	fmt.Fprintf(out.stdout, prefix+"thumbprint-sha1: %s\n", cinfo.Sha1)
+	fmt.Fprintf(out.stdout, prefix+"thumbprint-sha256: %s\n", cinfo.Sha256)
+	fmt.Fprintf(out.stdout, prefix+"Subject:\n  %s%s\n", prefix, cinfo.Subject)
+	fmt.Fprintf(out.stdout, prefix+"Issuer:\n  %s%s\n", prefix, cinfo.Issuer)
 }
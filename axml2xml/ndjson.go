@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/avast/apkparser"
+)
+
+type ndjsonRecord struct {
+	Path           string `json:"path"`
+	Ok             bool   `json:"ok"`
+	ManifestSha256 string `json:"manifest_sha256,omitempty"`
+	Package        string `json:"package,omitempty"`
+	VersionCode    string `json:"version_code,omitempty"`
+	VersionName    string `json:"version_name,omitempty"`
+	Error          string `json:"error,omitempty"`
+	ResourcesError string `json:"resources_error,omitempty"`
+	ManifestError  string `json:"manifest_error,omitempty"`
+}
+
+// ManifestEncoder remembering the identity attributes of the root element.
+type manifestInfoEncoder struct {
+	seenRoot                      bool
+	pkg, versionCode, versionName string
+}
+
+func (e *manifestInfoEncoder) EncodeToken(t xml.Token) error {
+	st, ok := t.(xml.StartElement)
+	if !ok || e.seenRoot {
+		return nil
+	}
+	e.seenRoot = true
+
+	for _, a := range st.Attr {
+		switch a.Name {
+		case xml.Name{Local: "package"}:
+			e.pkg = a.Value
+		case xml.Name{Space: androidNamespace, Local: "versionCode"}:
+			e.versionCode = a.Value
+		case xml.Name{Space: androidNamespace, Local: "versionName"}:
+			e.versionName = a.Value
+		}
+	}
+	return nil
+}
+
+func (e *manifestInfoEncoder) Flush() error {
+	return nil
+}
+
+// Prints a single-line JSON summary of the input.
+func processInputNdjson(out *output, input string, opts *optsType) int {
+	rec := ndjsonRecord{Path: input}
+
+	var code int
+	if opts.isApk {
+		code = ndjsonApk(input, opts, &rec)
+	} else if opts.isResources {
+		code = ndjsonResources(input, opts, &rec)
+	} else {
+		code = ndjsonFile(input, opts, &rec)
+	}
+
+	// Broken resources of an APK are reported, but the manifest is what matters.
+	rec.Ok = rec.Error == "" && rec.ManifestError == "" && (opts.isApk || rec.ResourcesError == "")
+
+	enc := json.NewEncoder(out.stdout)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(&rec); err != nil {
+		fmt.Fprintln(out.stderr, err)
+		return exitError
+	}
+	return code
+}
+
+func ndjsonApk(input string, opts *optsType, rec *ndjsonRecord) int {
+	apkReader, err := apkparser.OpenZipEx(input, opts.parseOptions())
+	if err != nil {
+		rec.Error = err.Error()
+		return exitZip
+	}
+	defer apkReader.Close()
+
+	info := &manifestInfoEncoder{}
+	parser, reserr := apkparser.NewParserEx(apkReader, info, opts.parseOptions())
+	code := exitOk
+	if reserr != nil && !errors.Is(reserr, os.ErrNotExist) {
+		rec.ResourcesError = reserr.Error()
+		code = exitResources
+	}
+
+	if err := parser.ParseXml(opts.xmlFileName); err != nil {
+		rec.ManifestError = err.Error()
+		code = exitManifest
+	}
+	rec.Package, rec.VersionCode, rec.VersionName = info.pkg, info.versionCode, info.versionName
+
+	if f := apkReader.File[opts.xmlFileName]; f != nil {
+		if err := f.Open(); err == nil {
+			defer f.Close()
+			if f.Next() {
+				rec.ManifestSha256 = ndjsonDigest(f)
+			}
+		}
+	}
+	return code
+}
+
+func ndjsonResources(input string, opts *optsType, rec *ndjsonRecord) int {
+	data, err := readInput(input)
+	if err != nil {
+		rec.Error = err.Error()
+		return exitError
+	}
+
+	if _, err := apkparser.ParseResourceTableEx(bytes.NewReader(data), opts.parseOptions()); err != nil {
+		rec.ResourcesError = err.Error()
+		return exitResources
+	}
+	return exitOk
+}
+
+func ndjsonFile(input string, opts *optsType, rec *ndjsonRecord) int {
+	data, err := readInput(input)
+	if err != nil {
+		rec.Error = err.Error()
+		return exitError
+	}
+
+	code := exitOk
+	info := &manifestInfoEncoder{}
+	if err := apkparser.ParseXmlEx(bytes.NewReader(data), info, nil, opts.parseOptions()); err != nil {
+		rec.ManifestError = err.Error()
+		code = exitManifest
+	}
+	rec.Package, rec.VersionCode, rec.VersionName = info.pkg, info.versionCode, info.versionName
+	rec.ManifestSha256 = ndjsonDigest(bytes.NewReader(data))
+	return code
+}
+
+// Reads the whole input file, or stdin if the input is "-".
+func readInput(input string) ([]byte, error) {
+	if input == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(input)
+}
+
+func ndjsonDigest(r io.Reader) string {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/avast/apkparser"
+)
+
+// Parts of the manifest -only can print.
+var onlySections = map[string]func(w io.Writer, manifest *apkparser.ManifestElement){
+	"permissions":    printOnlyPermissions,
+	"components":     printOnlyComponents,
+	"intent-filters": printOnlyIntentFilters,
+	"metadata":       printOnlyMetaData,
+}
+
+var componentTags = []string{"activity", "activity-alias", "service", "receiver", "provider"}
+
+// Returns an error if opts.only has a part not in onlySections.
+func checkOnlyFlag(only string) error {
+	for _, section := range strings.Split(only, ",") {
+		if onlySections[section] == nil {
+			return fmt.Errorf("unknown -only part %q, expected permissions, components, intent-filters or metadata", section)
+		}
+	}
+	return nil
+}
+
+// Prints just the parts of the manifest from -only, one item per line.
+func processOnly(out *output, input string, opts *optsType) int {
+	manifest, code := parseOnlyTree(out, input, opts)
+	if manifest == nil {
+		return code
+	}
+
+	for _, section := range strings.Split(opts.only, ",") {
+		onlySections[section](out.stdout, manifest)
+	}
+	return code
+}
+
+func parseOnlyTree(out *output, input string, opts *optsType) (*apkparser.ManifestElement, int) {
+	if !opts.isApk {
+		f, err := os.Open(input)
+		if err != nil {
+			fmt.Fprintln(out.stderr, err)
+			return nil, exitError
+		}
+		defer f.Close()
+
+		manifest, err := apkparser.ParseXmlTreeEx(f, nil, opts.parseOptions())
+		if err != nil {
+			fmt.Fprintf(out.stderr, "%s: %s\n", input, err.Error())
+			return nil, exitManifest
+		}
+		return manifest, exitOk
+	}
+
+	apkReader, err := apkparser.OpenZipEx(input, opts.parseOptions())
+	if err != nil {
+		fmt.Fprintln(out.stderr, err)
+		return nil, exitZip
+	}
+	defer apkReader.Close()
+
+	code := exitOk
+	parser, reserr := apkparser.NewParserEx(apkReader, nil, opts.parseOptions())
+	if reserr != nil && !errors.Is(reserr, os.ErrNotExist) {
+		fmt.Fprintf(out.stderr, "%s: failed to parse resources: %s\n", input, reserr.Error())
+		code = exitResources
+	}
+
+	manifest, err := parser.ParseXmlTree(opts.xmlFileName)
+	if err != nil {
+		fmt.Fprintf(out.stderr, "%s: %s\n", input, err.Error())
+		return nil, exitManifest
+	}
+	return manifest, code
+}
+
+// Writes tag and name, followed by the android attributes which are set, as name=value.
+func printOnlyLine(w io.Writer, e *apkparser.ManifestElement, attrs ...string) {
+	fmt.Fprintf(w, "%s %s", e.Name.Local, e.AndroidAttr("name"))
+	for _, attr := range attrs {
+		if val := e.AndroidAttr(attr); val != "" {
+			fmt.Fprintf(w, " %s=%s", attr, val)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+func printOnlyPermissions(w io.Writer, manifest *apkparser.ManifestElement) {
+	for _, c := range manifest.Children {
+		switch c.Name.Local {
+		case "uses-permission", "uses-permission-sdk-23", "uses-permission-sdk-m":
+			printOnlyLine(w, c, "maxSdkVersion", "usesPermissionFlags")
+		case "permission":
+			printOnlyLine(w, c, "protectionLevel", "permissionGroup")
+		case "permission-group", "permission-tree":
+			printOnlyLine(w, c)
+		}
+	}
+}
+
+// Returns the components of all <application> elements.
+func onlyComponents(manifest *apkparser.ManifestElement) []*apkparser.ManifestElement {
+	var res []*apkparser.ManifestElement
+	for _, app := range manifest.ChildrenNamed("application") {
+		for _, c := range app.Children {
+			for _, tag := range componentTags {
+				if c.Name.Local == tag {
+					res = append(res, c)
+				}
+			}
+		}
+	}
+	return res
+}
+
+func printOnlyComponents(w io.Writer, manifest *apkparser.ManifestElement) {
+	for _, c := range onlyComponents(manifest) {
+		printOnlyLine(w, c, "exported", "enabled", "permission", "process", "authorities", "targetActivity")
+	}
+}
+
+// Prints one line per filter: the component, then the actions, categories and data attributes.
+func printOnlyIntentFilters(w io.Writer, manifest *apkparser.ManifestElement) {
+	for _, c := range onlyComponents(manifest) {
+		for _, filter := range c.ChildrenNamed("intent-filter") {
+			fmt.Fprintf(w, "%s %s:", c.Name.Local, c.AndroidAttr("name"))
+			for _, e := range filter.Children {
+				switch e.Name.Local {
+				case "action", "category":
+					fmt.Fprintf(w, " %s=%s", e.Name.Local, e.AndroidAttr("name"))
+				case "data":
+					for _, attr := range e.Attr {
+						fmt.Fprintf(w, " %s=%s", attr.Name.Local, attr.Value)
+					}
+				}
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// Prints one line per <meta-data>: where it is, then its name and value or resource.
+func printOnlyMetaData(w io.Writer, manifest *apkparser.ManifestElement) {
+	print := func(parent string, e *apkparser.ManifestElement) {
+		for _, m := range e.ChildrenNamed("meta-data") {
+			val := m.AndroidAttr("value")
+			if val == "" {
+				val = m.AndroidAttr("resource")
+			}
+			fmt.Fprintf(w, "%s %s=%s\n", parent, m.AndroidAttr("name"), val)
+		}
+	}
+
+	for _, app := range manifest.ChildrenNamed("application") {
+		print("application", app)
+	}
+	for _, c := range onlyComponents(manifest) {
+		print(c.Name.Local+" "+c.AndroidAttr("name"), c)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Output files written to opts.outDir and their inputs, inputs with the same base name would overwrite
+// each other's output.
+var outDirFiles = struct {
+	sync.Mutex
+	inputs map[string]string
+}{inputs: make(map[string]string)}
+
+// Processes the input, writing its output to a file in opts.outDir instead if set.
+func processInputToDir(out *output, input string, opts *optsType) int {
+	return runToDir(out, input, opts, func(out *output) int {
+		return processInput(out, input, opts)
+	})
+}
+
+// Runs process with its output written to a file in opts.outDir if set, once process returns.
+// Nothing is written for inputs which timed out.
+func runToDir(out *output, input string, opts *optsType, process func(out *output) int) int {
+	if opts.outDir == "" {
+		return process(out)
+	}
+
+	dest := filepath.Join(opts.outDir, filepath.Base(input)+outputExtension(opts))
+	if other := claimOutputFile(dest, input); other != "" {
+		fmt.Fprintf(out.stderr, "%s: output %s is already written for %s\n", input, dest, other)
+		return exitError
+	}
+
+	var buf bytes.Buffer
+	code := process(&output{stdout: &buf, stderr: out.stderr})
+	if code == exitTimeout {
+		return code
+	}
+
+	if err := writeFileAtomic(dest, buf.Bytes()); err != nil {
+		fmt.Fprintf(out.stderr, "%s: failed to write output: %s\n", input, err.Error())
+		return exitError
+	}
+	return code
+}
+
+// Records that dest is written for the input, returns the other input if dest was already claimed.
+func claimOutputFile(dest, input string) string {
+	outDirFiles.Lock()
+	defer outDirFiles.Unlock()
+
+	if other, prs := outDirFiles.inputs[dest]; prs {
+		return other
+	}
+	outDirFiles.inputs[dest] = input
+	return ""
+}
+
+func outputExtension(opts *optsType) string {
+	switch {
+	case opts.json, opts.ndjson:
+		return ".json"
+	case opts.dumpManifest && !opts.verifyApk && !opts.extractCert && !opts.badging && opts.query == "" && !opts.diff:
+		return ".xml"
+	default:
+		return ".txt"
+	}
+}
+
+// Writes data to a temporary file next to path and renames it over path,
+// so that readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpName := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmpName, path)
+	}
+
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// Destination of the output of processing one input.
+type output struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var stdOutput = &output{stdout: os.Stdout, stderr: os.Stderr}
+
+type bufferedResult struct {
+	input          string
+	stdout, stderr bytes.Buffer
+	code           int
+}
+
+// Processes inputs with jobs workers. Output of each input is buffered and written at once
+// when the input is done, so outputs of different inputs don't interleave.
+func processInputsParallel(out *output, inputs <-chan string, opts *optsType, jobs int, summary *runSummary) int {
+	results := make(chan *bufferedResult, jobs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for input := range inputs {
+				res := &bufferedResult{input: input}
+				res.code = processInputWithTimeout(&output{stdout: &res.stdout, stderr: &res.stderr}, input, opts)
+				results <- res
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	code := exitOk
+	for res := range results {
+		res.stdout.WriteTo(out.stdout)
+		res.stderr.WriteTo(out.stderr)
+		summary.add(res.input, res.code)
+		if res.code != exitOk {
+			code = res.code
+		}
+	}
+	return code
+}
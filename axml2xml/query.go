@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/avast/apkparser"
+)
+
+// Looks up opts.query in resources.arsc of the input and prints all its config variants.
+func processQuery(out *output, input string, opts *optsType) int {
+	res, code := loadResourceTable(out, input, opts)
+	if code != exitOk {
+		return code
+	}
+
+	var resId uint32
+	var err error
+	if strings.HasPrefix(opts.query, "0x") {
+		id, err := strconv.ParseUint(opts.query[2:], 16, 32)
+		if err != nil {
+			fmt.Fprintf(out.stderr, "Invalid resource id %s: %s\n", opts.query, err.Error())
+			return exitError
+		}
+		resId = uint32(id)
+	} else if resId, err = res.GetResourceId(opts.query); err != nil {
+		fmt.Fprintln(out.stderr, err)
+		return exitError
+	}
+
+	name, err := res.GetResourceName(resId)
+	if err != nil {
+		name = "?"
+	}
+	fmt.Fprintf(out.stdout, "0x%08x %s\n", resId, name)
+
+	entries, err := res.GetResourceEntries(resId)
+	if len(entries) == 0 && err != nil {
+		fmt.Fprintln(out.stderr, err)
+		return exitError
+	}
+
+	for _, e := range entries {
+		config := e.Config.String()
+		if config == "" {
+			config = "(default)"
+		}
+
+		if !e.IsComplex() {
+			fmt.Fprintf(out.stdout, "  %s: %s\n", config, queryValueString(e.GetValue()))
+			continue
+		}
+
+		fmt.Fprintf(out.stdout, "  %s:", config)
+		if parent := e.GetParent(); parent != 0 {
+			fmt.Fprintf(out.stdout, " parent=@%x", parent)
+		}
+		fmt.Fprintln(out.stdout)
+
+		bag := e.GetBag()
+		for i := range bag {
+			fmt.Fprintf(out.stdout, "    %s: %s\n", res.GetBagItemName(&bag[i]), queryValueString(&bag[i].Value))
+		}
+	}
+	return exitOk
+}
+
+func queryValueString(v *apkparser.ResourceValue) string {
+	val, err := v.String()
+	if err != nil {
+		return "(" + err.Error() + ")"
+	}
+	return val
+}
+
+// Parses resources.arsc from the input, printing the errors to out.
+func loadResourceTable(out *output, input string, opts *optsType) (*apkparser.ResourceTable, int) {
+	if !opts.isApk {
+		var r io.Reader = os.Stdin
+		if input != "-" {
+			f, err := os.Open(input)
+			if err != nil {
+				fmt.Fprintln(out.stderr, err)
+				return nil, exitError
+			}
+			defer f.Close()
+			r = f
+		}
+
+		res, err := apkparser.ParseResourceTableEx(r, opts.parseOptions())
+		if err != nil {
+			fmt.Fprintf(out.stderr, "%s: %s\n", input, err.Error())
+			return nil, exitResources
+		}
+		return res, exitOk
+	}
+
+	apkReader, err := apkparser.OpenZipEx(input, opts.parseOptions())
+	if err != nil {
+		fmt.Fprintln(out.stderr, err)
+		return nil, exitZip
+	}
+	defer apkReader.Close()
+
+	res, err := parseZipResources(apkReader, opts)
+	if err != nil {
+		fmt.Fprintf(out.stderr, "%s: %s\n", input, err.Error())
+		return nil, exitResources
+	}
+	return res, exitOk
+}
+
+func parseZipResources(apkReader *apkparser.ZipReader, opts *optsType) (*apkparser.ResourceTable, error) {
+	f := apkReader.File["resources.arsc"]
+	if f == nil {
+		return nil, fmt.Errorf("resources.arsc not found")
+	}
+
+	if err := f.Open(); err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lastErr := io.ErrUnexpectedEOF
+	for f.Next() {
+		var res *apkparser.ResourceTable
+		if res, lastErr = apkparser.ParseResourceTableEx(f, opts.parseOptions()); lastErr == nil {
+			return res, nil
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Results of processing the file list (-l), printed at the end of the run.
+type runSummary struct {
+	processed int
+	failed    map[string]int // count by exitCodeReason
+	failures  []string       // failed inputs in the order they were processed
+}
+
+func (s *runSummary) add(input string, code int) {
+	s.processed++
+	if code == exitOk {
+		return
+	}
+
+	if s.failed == nil {
+		s.failed = make(map[string]int)
+	}
+	s.failed[exitCodeReason(code)]++
+	s.failures = append(s.failures, input)
+}
+
+func (s *runSummary) write(w io.Writer) {
+	fmt.Fprintf(w, "\nProcessed %d inputs: %d succeeded, %d failed\n", s.processed, s.processed-len(s.failures), len(s.failures))
+
+	reasons := make([]string, 0, len(s.failed))
+	for reason := range s.failed {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "  %s: %d\n", reason, s.failed[reason])
+	}
+}
+
+// Writes the failed inputs one per line, so that the file can be passed to -l again.
+func (s *runSummary) writeFailures(path string) error {
+	var data strings.Builder
+	for _, input := range s.failures {
+		data.WriteString(input)
+		data.WriteByte('\n')
+	}
+	return writeFileAtomic(path, []byte(data.String()))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const serveUsage = `Usage: %s serve [flags]
+
+Serves the parsing over HTTP. Endpoints take a multipart/form-data POST with the APK in the "apk" field
+and return JSON, with status 422 if the APK failed to parse or verify:
+  /parse    manifest, like -json
+  /verify   signature verification results, like -json -v
+  /badging  {"input", "badging", "error"} with the output of -badging
+
+Flags:
+`
+
+type serveOpts struct {
+	addr    string
+	maxSize int64
+	timeout time.Duration
+}
+
+// Runs the serve subcommand with its arguments, returns the exit code.
+func runServe(args []string) int {
+	var opts serveOpts
+
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&opts.addr, "addr", "localhost:8080", "Address to listen on")
+	fs.Int64Var(&opts.maxSize, "maxsize", 512<<20, "Maximum size of an uploaded APK in bytes")
+	fs.DurationVar(&opts.timeout, "timeout", time.Minute, "Give up on parsing an APK after this long, 0 means no limit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), serveUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/parse", opts.handler(func(out *output, path string, inputOpts *optsType) int {
+		inputOpts.dumpManifest = true
+		return processInputJson(out, path, inputOpts)
+	}))
+	mux.HandleFunc("/verify", opts.handler(func(out *output, path string, inputOpts *optsType) int {
+		inputOpts.verifyApk = true
+		return processInputJson(out, path, inputOpts)
+	}))
+	mux.HandleFunc("/badging", opts.handler(serveBadging))
+
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", opts.addr)
+	if err := http.ListenAndServe(opts.addr, mux); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOk
+}
+
+type serveFunc func(out *output, path string, opts *optsType) int
+
+// Returns handler which stores the uploaded APK in a temporary file and writes the output of process
+// for it as the response.
+func (s *serveOpts) handler(process serveFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST the APK as multipart/form-data in the apk field", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxSize+1<<20)
+		upload, header, err := r.FormFile("apk")
+		if err != nil {
+			http.Error(w, "failed to read the apk field: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer upload.Close()
+
+		dir, err := ioutil.TempDir("", "axml2xml")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.RemoveAll(dir)
+
+		// The name shows up as the input in the results.
+		name := filepath.Base(filepath.Clean("/" + header.Filename))
+		if name == "/" || name == "." {
+			name = "upload.apk"
+		}
+		path := filepath.Join(dir, name)
+		if err := saveUpload(path, upload, s.maxSize); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		ctx := r.Context()
+		if s.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+		}
+
+		opts := &optsType{isApk: true, xmlFileName: "AndroidManifest.xml", ctx: ctx}
+		var stdout, stderr bytes.Buffer
+		code := process(&output{stdout: &stdout, stderr: &stderr}, path, opts)
+
+		w.Header().Set("Content-Type", "application/json")
+		if code != exitOk {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		// Don't leak the temporary path in the input and error messages.
+		w.Write(bytes.ReplaceAll(stdout.Bytes(), jsonEscaped(path), jsonEscaped(name)))
+	}
+}
+
+func saveUpload(path string, r io.Reader, maxSize int64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return err
+	} else if n > maxSize {
+		return fmt.Errorf("the APK is bigger than %d bytes", maxSize)
+	}
+	return nil
+}
+
+func serveBadging(out *output, path string, opts *optsType) int {
+	var stdout, stderr bytes.Buffer
+	code := processBadging(&output{stdout: &stdout, stderr: &stderr}, path, opts)
+
+	res := struct {
+		Input   string `json:"input"`
+		Badging string `json:"badging"`
+		Error   string `json:"error,omitempty"`
+	}{path, stdout.String(), strings.TrimSpace(stderr.String())}
+
+	enc := json.NewEncoder(out.stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(&res); err != nil {
+		return exitError
+	}
+	return code
+}
+
+// Returns s escaped like in a JSON string, without the quotes.
+func jsonEscaped(s string) []byte {
+	data, _ := json.Marshal(s)
+	return data[1 : len(data)-1]
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/avast/apkparser"
+)
+
+// Prints the raw string pools of the input, one string per line: pool, index, offset in the file,
+// encoding and the quoted value. For APKs, the pools of the -f file are printed.
+func processStrings(out *output, input string, opts *optsType) int {
+	var r io.Reader = os.Stdin
+	if opts.isApk {
+		apkReader, err := apkparser.OpenZipEx(input, opts.parseOptions())
+		if err != nil {
+			fmt.Fprintln(out.stderr, err)
+			return exitZip
+		}
+		defer apkReader.Close()
+
+		f := apkReader.File[opts.xmlFileName]
+		if f == nil {
+			fmt.Fprintf(out.stderr, "%s: %s not found\n", input, opts.xmlFileName)
+			return exitManifest
+		}
+
+		if err := f.Open(); err != nil {
+			fmt.Fprintf(out.stderr, "%s: %s\n", input, err.Error())
+			return exitZip
+		}
+		defer f.Close()
+
+		if !f.Next() {
+			fmt.Fprintf(out.stderr, "%s: failed to read %s\n", input, opts.xmlFileName)
+			return exitZip
+		}
+		r = f
+	} else if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			fmt.Fprintln(out.stderr, err)
+			return exitError
+		}
+		defer f.Close()
+		r = f
+	}
+
+	pools, err := apkparser.ReadStringPools(r)
+	for _, pool := range pools {
+		encoding := "utf16"
+		if pool.Utf8 {
+			encoding = "utf8"
+		}
+
+		for _, s := range pool.Strings {
+			if s.Err != nil {
+				fmt.Fprintf(out.stdout, "%s\t%d\t0x%08x\t%s\terror: %s\n", pool.Name, s.Index, s.Offset, encoding, s.Err.Error())
+			} else {
+				fmt.Fprintf(out.stdout, "%s\t%d\t0x%08x\t%s\t%q\n", pool.Name, s.Index, s.Offset, encoding, s.Value)
+			}
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(out.stderr, "%s: %s\n", input, err.Error())
+		if opts.isResources {
+			return exitResources
+		}
+		return exitManifest
+	}
+	return exitOk
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Processes the input like processInputToDir, limited to opts.timeout if set. The parsing is aborted
+// through the context once the time is up, and if the processing is still stuck somewhere else, e.g.
+// in signature verification, it is left running in the background and the input is reported as failed.
+// The output file in opts.outDir is written only by the calling goroutine, never for timed out inputs.
+func processInputWithTimeout(out *output, input string, opts *optsType) int {
+	if opts.timeout <= 0 {
+		return processInputToDir(out, input, opts)
+	}
+
+	return runToDir(out, input, opts, func(out *output) int {
+		return processInputTimed(out, input, opts)
+	})
+}
+
+func processInputTimed(out *output, input string, opts *optsType) int {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+
+	inputOpts := *opts
+	inputOpts.ctx = ctx
+
+	res := &bufferedResult{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		res.code = processInput(&output{stdout: &res.stdout, stderr: &res.stderr}, input, &inputOpts)
+	}()
+
+	select {
+	case <-done:
+		// Failures are counted as timeouts only if the parsing was aborted because of it.
+		if res.code == exitOk || ctx.Err() == nil {
+			res.stdout.WriteTo(out.stdout)
+			res.stderr.WriteTo(out.stderr)
+			return res.code
+		}
+	case <-ctx.Done():
+	}
+
+	fmt.Fprintf(out.stderr, "%s: timed out after %s\n", input, opts.timeout)
+	return exitTimeout
+}
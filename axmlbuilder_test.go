@@ -0,0 +1,155 @@
+package apkparser_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+)
+
+// Builds minimal binary XML files for tests.
+type testAxmlNode struct {
+	name     string
+	attrs    []testAxmlAttr
+	text     string
+	children []*testAxmlNode
+	special  [3]uint16 // 1-based indexes of the id, class and style attributes
+}
+
+// Attribute with "android:", "tools:" or "app:" prefix in name is put into that namespace. Typed
+// attributes have typ set, the rest are strings. Attributes with resId are in the resource map.
+type testAxmlAttr struct {
+	name  string
+	value string
+	typ   uint8
+	data  uint32
+	resId uint32
+}
+
+const testAndroidNs = "http://schemas.android.com/apk/res/android"
+
+var testNamespaces = map[string]string{
+	"android": testAndroidNs,
+	"tools":   "http://schemas.android.com/tools",
+	"app":     "http://schemas.android.com/apk/res-auto",
+}
+
+// Splits name like "android:label" to the namespace uri and local name.
+func testAttrName(name string) (string, string) {
+	if idx := strings.IndexByte(name, ':'); idx != -1 && testNamespaces[name[:idx]] != "" {
+		return testNamespaces[name[:idx]], name[idx+1:]
+	}
+	return "", name
+}
+
+type testAxmlWriter struct {
+	strings []string
+	index   map[string]uint32
+	body    bytes.Buffer
+}
+
+func (w *testAxmlWriter) str(s string) uint32 {
+	if idx, prs := w.index[s]; prs {
+		return idx
+	}
+	idx := uint32(len(w.strings))
+	w.strings = append(w.strings, s)
+	w.index[s] = idx
+	return idx
+}
+
+func (w *testAxmlWriter) node(typ uint16, vals ...uint32) {
+	var body bytes.Buffer
+	for _, v := range vals {
+		binary.Write(&body, binary.LittleEndian, v)
+	}
+	// line number and comment
+	w.body.Write(testArscChunk(typ, 16, []byte{1, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF}, body.Bytes()))
+}
+
+func (w *testAxmlWriter) element(n *testAxmlNode) {
+	var attrs bytes.Buffer
+	for _, a := range n.attrs {
+		ns := uint32(0xFFFFFFFF)
+		uri, name := testAttrName(a.name)
+		if uri != "" {
+			ns = w.str(uri)
+		}
+
+		raw := uint32(0xFFFFFFFF)
+		typ, data := a.typ, a.data
+		if typ == 0 {
+			raw = w.str(a.value)
+			typ, data = 0x03, raw
+		} else if a.value != "" {
+			raw = w.str(a.value)
+		}
+
+		binary.Write(&attrs, binary.LittleEndian, []uint32{ns, w.str(name), raw})
+		binary.Write(&attrs, binary.LittleEndian, []uint16{8})
+		attrs.Write([]byte{0, typ})
+		binary.Write(&attrs, binary.LittleEndian, data)
+	}
+
+	var tag bytes.Buffer
+	binary.Write(&tag, binary.LittleEndian, []uint32{0xFFFFFFFF, w.str(n.name)})
+	binary.Write(&tag, binary.LittleEndian, []uint16{0x14, 0x14, uint16(len(n.attrs)), n.special[0], n.special[1], n.special[2]})
+	tag.Write(attrs.Bytes())
+	w.body.Write(testArscChunk(0x0102, 16, []byte{1, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF}, tag.Bytes()))
+
+	if n.text != "" {
+		w.node(0x0104, w.str(n.text), 0x08, 0)
+	}
+	for _, c := range n.children {
+		w.element(c)
+	}
+	w.node(0x0103, 0xFFFFFFFF, w.str(n.name))
+}
+
+func testAxml(root *testAxmlNode) []byte {
+	w := &testAxmlWriter{index: make(map[string]uint32)}
+
+	// The resource ids map to the lowest string indexes, so their names go first.
+	var ids bytes.Buffer
+	prefixes := []string{"android"}
+	var visit func(n *testAxmlNode)
+	visit = func(n *testAxmlNode) {
+		for _, a := range n.attrs {
+			uri, name := testAttrName(a.name)
+			if a.resId != 0 {
+				if idx := w.str(name); int(idx) == ids.Len()/4 {
+					binary.Write(&ids, binary.LittleEndian, a.resId)
+				}
+			}
+			if prefix := strings.SplitN(a.name, ":", 2)[0]; uri != "" && uri != testAndroidNs && !containsString(prefixes, prefix) {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+		for _, c := range n.children {
+			visit(c)
+		}
+	}
+	visit(root)
+
+	if ids.Len() != 0 {
+		w.body.Write(testArscChunk(0x0180, 8, nil, ids.Bytes()))
+	}
+	for _, prefix := range prefixes {
+		w.node(0x0100, w.str(prefix), w.str(testNamespaces[prefix]))
+	}
+	w.element(root)
+	for i := len(prefixes) - 1; i >= 0; i-- {
+		w.node(0x0101, w.str(prefixes[i]), w.str(testNamespaces[prefixes[i]]))
+	}
+
+	pool := testArscStringPool(w.strings)
+	return testArscChunk(0x0003, 8, nil, append(pool, w.body.Bytes()...))
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
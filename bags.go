@@ -0,0 +1,201 @@
+package apkparser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// Special names of bag items, the other names are attribute resource ids or array indexes.
+//
+// frameworks/base/libs/androidfw/include/androidfw/ResourceTypes.h, ResTable_map
+const (
+	BagAttrType = 0x01000000 // type of an attribute definition, see BagAttrType* flags
+	BagAttrMin  = 0x01000001 // minimum value of an integer attribute
+	BagAttrMax  = 0x01000002 // maximum value of an integer attribute
+	BagAttrL10n = 0x01000003 // localization requirements of a string attribute
+
+	BagQuantityOther = 0x01000004
+	BagQuantityZero  = 0x01000005
+	BagQuantityOne   = 0x01000006
+	BagQuantityTwo   = 0x01000007
+	BagQuantityFew   = 0x01000008
+	BagQuantityMany  = 0x01000009
+
+	bagArrayIndexBase = 0x02000000
+)
+
+var bagSpecialNames = map[uint32]string{
+	BagAttrType:      "^type",
+	BagAttrMin:       "^min",
+	BagAttrMax:       "^max",
+	BagAttrL10n:      "^l10n",
+	BagQuantityOther: "other",
+	BagQuantityZero:  "zero",
+	BagQuantityOne:   "one",
+	BagQuantityTwo:   "two",
+	BagQuantityFew:   "few",
+	BagQuantityMany:  "many",
+}
+
+// One item of complex resource entry, for example an item of string-array, plurals quantity or style attribute.
+type ResourceBagItem struct {
+	Name  uint32
+	Value ResourceValue
+}
+
+// Returns the index if this is an item of an array.
+func (i *ResourceBagItem) ArrayIndex() (int, bool) {
+	if i.Name&0xFFFF0000 != bagArrayIndexBase {
+		return 0, false
+	}
+	return int(i.Name & 0xFFFF), true
+}
+
+// Returns name of the bag item: the quantity ("one", "other"...), special name ("^type"...),
+// array index like "[2]" or the name of the attribute resource.
+func (x *ResourceTable) GetBagItemName(item *ResourceBagItem) string {
+	if name, prs := bagSpecialNames[item.Name]; prs {
+		return name
+	}
+
+	if idx, ok := item.ArrayIndex(); ok {
+		return fmt.Sprintf("[%d]", idx)
+	}
+
+	if name, err := x.GetResourceName(item.Name); err == nil {
+		return name
+	}
+	return fmt.Sprintf("@%x", item.Name)
+}
+
+// Returns the parent resource id of the complex entry (for example of style), or 0 if it has none.
+func (e *ResourceEntry) GetParent() uint32 {
+	return e.parent
+}
+
+// Returns the items of the complex entry, nil for simple entries.
+func (e *ResourceEntry) GetBag() []ResourceBagItem {
+	return e.bag
+}
+
+// Style with the items inherited from its parents, see ResourceTable.ResolveStyle.
+type ResolvedStyle struct {
+	// Ids of the style and its parents, from the style to the farthest parent in the table.
+	Chain []uint32
+	// Parent which is not in the table, usually a framework style like @android:style/Theme.Translucent,
+	// 0 if the chain ends in the table. Its items are not included.
+	ExternalParent uint32
+	// Items of the whole chain sorted by name, the nearer styles override their parents.
+	Items []ResourceBagItem
+}
+
+// Returns the value of the attribute, nil if the style doesn't set it.
+func (s *ResolvedStyle) Get(attr uint32) *ResourceValue {
+	i := sort.Search(len(s.Items), func(i int) bool { return s.Items[i].Name >= attr })
+	if i < len(s.Items) && s.Items[i].Name == attr {
+		return &s.Items[i].Value
+	}
+	return nil
+}
+
+// Follows the parents of the style (or another complex entry) and returns the flattened set of its items,
+// as the runtime applies it in a theme. Entries are taken in the first configuration, as GetResourceEntry does.
+func (x *ResourceTable) ResolveStyle(resId uint32) (res *ResolvedStyle, err error) {
+	defer recoverPanic(&err)
+
+	res = &ResolvedStyle{}
+	var bags [][]ResourceBagItem
+	visited := map[uint32]bool{}
+	for id := resId; id != 0; {
+		resolved := x.resolveId(id)
+		if visited[resolved] {
+			return nil, fmt.Errorf("Style 0x%08x has a cyclic parent 0x%08x.", resId, id)
+		}
+		visited[resolved] = true
+
+		entry, err := x.GetResourceEntry(id)
+		if err != nil {
+			if id == resId {
+				return nil, err
+			}
+			res.ExternalParent = id
+			break
+		} else if !entry.IsComplex() {
+			return nil, &ValueTypeError{Expected: "style", Type: entry.value.dataType}
+		}
+
+		res.Chain = append(res.Chain, resolved)
+		bags = append(bags, entry.bag)
+		id = entry.parent
+	}
+
+	items := map[uint32]ResourceBagItem{}
+	for i := len(bags) - 1; i >= 0; i-- {
+		for _, item := range bags[i] {
+			items[item.Name] = item
+		}
+	}
+
+	res.Items = make([]ResourceBagItem, 0, len(items))
+	for _, item := range items {
+		res.Items = append(res.Items, item)
+	}
+	sort.Slice(res.Items, func(i, j int) bool { return res.Items[i].Name < res.Items[j].Name })
+	return res, nil
+}
+
+// Parses the rest of ResTable_map_entry and the ResTable_map items following it.
+func (x *ResourceTable) parseBag(r io.Reader, res *ResourceEntry) error {
+	const mapEntrySize = 16
+
+	var vals struct {
+		Parent uint32
+		Count  uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &vals); err != nil {
+		return fmt.Errorf("Failed to read map entry: %w", truncated(err))
+	}
+
+	if res.size < mapEntrySize {
+		return fmt.Errorf("Invalid map entry size: %d!", res.size)
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, r, int64(res.size-mapEntrySize)); err != nil {
+		return fmt.Errorf("Failed to skip map entry padding: %w", truncated(err))
+	}
+
+	res.parent = vals.Parent
+	res.bag = []ResourceBagItem{}
+
+	for i := uint32(0); i < vals.Count; i++ {
+		var item struct {
+			Name  uint32
+			Size  uint16
+			Res0  uint8
+			Type  AttrType
+			Value uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &item); err != nil {
+			return fmt.Errorf("Failed to read map item %d: %w", i, truncated(err))
+		}
+
+		if item.Size < 8 {
+			return fmt.Errorf("Invalid Res_value size: %d!", item.Size)
+		} else if _, err := io.CopyN(ioutil.Discard, r, int64(item.Size-8)); err != nil {
+			return fmt.Errorf("Failed to skip map item padding: %w", truncated(err))
+		}
+
+		res.bag = append(res.bag, ResourceBagItem{
+			Name: item.Name,
+			Value: ResourceValue{
+				dataType:          item.Type,
+				data:              item.Value,
+				globalStringTable: &x.mainStrings,
+			},
+		})
+	}
+	return nil
+}
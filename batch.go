@@ -0,0 +1,150 @@
+package apkparser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Result of parsing one APK by ParseBatch.
+type Result struct {
+	// Index of the APK in the paths slice passed to ParseBatch
+	Index int
+	Path  string
+
+	// AndroidManifest.xml as indented XML text, possibly incomplete if ManifestErr != nil.
+	Manifest []byte
+
+	// Same meaning as the return values of ParseApk, panics of the parsers are returned
+	// in them as *PanicError.
+	ZipErr       error
+	ResourcesErr error
+	ManifestErr  error
+
+	// *PanicError if a panic escaped the parsers, e.g. from the XML encoder writing Manifest.
+	// The rest of the result may be incomplete.
+	PanicErr error
+}
+
+// Parses the APKs in paths with at most workers goroutines, NumCPU if workers <= 0.
+// cb is called for each APK in order of completion, always from the calling goroutine.
+// A panic while parsing one APK is reported in its Result as *PanicError and doesn't affect the others.
+// Element and attribute names are shared by all the parsed manifests instead of allocated for each.
+// If cb panics, no more APKs are parsed and the panic is propagated once the workers finish
+// the APKs they are parsing.
+func ParseBatch(paths []string, workers int, cb func(Result)) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	opts := &ParseOptions{names: newNameTable()}
+	jobs := make(chan int)
+	results := make(chan Result, workers)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				select {
+				case results <- parseBatchItem(idx, paths[idx], opts):
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+	feed:
+		for i := range paths {
+			select {
+			case jobs <- i:
+			case <-done:
+				break feed
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	defer func() {
+		close(done)
+		if r := recover(); r != nil {
+			wg.Wait()
+			panic(r)
+		}
+	}()
+
+	for res := range results {
+		cb(res)
+	}
+}
+
+func parseBatchItem(idx int, path string, opts *ParseOptions) (res Result) {
+	res.Index = idx
+	res.Path = path
+
+	var buf bytes.Buffer
+	defer func() {
+		if r := recover(); r != nil {
+			res.PanicErr = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+		res.Manifest = buf.Bytes()
+	}()
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "    ")
+
+	res.ZipErr, res.ResourcesErr, res.ManifestErr = ParseApkEx(path, enc, opts)
+	return
+}
+
+// Maximum number of names in nameTable, the names of crafted manifests could grow it without bounds.
+const maxSharedNames = 1 << 14
+
+// Element and attribute names shared by concurrent parses, so that each parsed manifest
+// doesn't hold its own copies of the same few hundred names.
+type nameTable struct {
+	mu    sync.RWMutex
+	names map[string]string
+}
+
+func newNameTable() *nameTable {
+	return &nameTable{names: make(map[string]string)}
+}
+
+// Returns the shared copy of s, s itself if it isn't in the table and the table is full.
+func (t *nameTable) intern(s string) string {
+	if t == nil {
+		return s
+	}
+
+	t.mu.RLock()
+	shared, ok := t.names[s]
+	t.mu.RUnlock()
+	if ok {
+		return shared
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if shared, ok := t.names[s]; ok {
+		return shared
+	}
+	if len(t.names) < maxSharedNames {
+		t.names[s] = s
+	}
+	return s
+}
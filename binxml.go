@@ -2,12 +2,14 @@ package apkparser
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -17,9 +19,12 @@ type binxmlParseInfo struct {
 	strings     stringTable
 	resourceIds []uint32
 	openTags    []xml.Name
+	namespaces  []string // URIs of the namespace declarations in scope
 
-	encoder ManifestEncoder
-	res     *ResourceTable
+	encoder      ManifestEncoder
+	typedEncoder TypedManifestEncoder
+	res          *ResourceTable
+	opts         *ParseOptions
 }
 
 // Some samples have manifest in plaintext, this is an error.
@@ -33,11 +38,60 @@ func ParseManifest(r io.Reader, enc ManifestEncoder, resources *ResourceTable) e
 
 // Parse the binary Xml format. The resources are optional and can be nil.
 func ParseXml(r io.Reader, enc ManifestEncoder, resources *ResourceTable) error {
-	x := binxmlParseInfo{
+	return ParseXmlEx(r, enc, resources, nil)
+}
+
+// Same as ParseXml, but aborts with ctx.Err() once the ctx is done.
+func ParseXmlCtx(ctx context.Context, r io.Reader, enc ManifestEncoder, resources *ResourceTable) error {
+	return ParseXmlEx(r, enc, resources, &ParseOptions{Context: ctx})
+}
+
+// Parse the binary Xml format with options. The resources and opts are optional and can be nil.
+// Panics of enc are propagated, unlike those of the parser.
+func ParseXmlEx(r io.Reader, enc ManifestEncoder, resources *ResourceTable, opts *ParseOptions) error {
+	return parseXml(r, callerEncoder(enc), resources, opts)
+}
+
+// Same as ParseXmlEx, but panics of enc are recovered too, for the encoders of the library.
+func parseXml(r io.Reader, enc ManifestEncoder, resources *ResourceTable, opts *ParseOptions) (err error) {
+	defer recoverPanic(&err)
+
+	x := newBinxmlParseInfo(enc, resources, opts)
+	defer func() {
+		if err != nil && x.opts.isPartialResults() {
+			x.endOpenTags()
+		}
+	}()
+	return x.parse(r)
+}
+
+// Same as ParseXml, but parses the data in memory.
+func ParseXmlBytes(data []byte, enc ManifestEncoder, resources *ResourceTable) error {
+	return ParseXml(bytes.NewReader(data), enc, resources)
+}
+
+func newBinxmlParseInfo(enc ManifestEncoder, resources *ResourceTable, opts *ParseOptions) *binxmlParseInfo {
+	x := &binxmlParseInfo{
 		encoder: enc,
 		res:     resources,
+		opts:    opts.withState(),
 	}
+	x.typedEncoder, _ = enc.(TypedManifestEncoder)
+	return x
+}
+
+// Ends the elements left open by a failed parsing, see ParseOptions.PartialResults.
+func (x *binxmlParseInfo) endOpenTags() {
+	for i := len(x.openTags) - 1; i >= 0; i-- {
+		if x.encoder.EncodeToken(xml.EndElement{Name: x.openTags[i]}) != nil {
+			break
+		}
+	}
+	x.openTags = nil
+	x.encoder.Flush()
+}
 
+func (x *binxmlParseInfo) parse(r io.Reader) error {
 	id, headerLen, totalLen, err := parseChunkHeader(r)
 	if err != nil {
 		return err
@@ -50,14 +104,23 @@ func ParseXml(r io.Reader, enc ManifestEncoder, resources *ResourceTable) error
 		binary.Write(buf, binary.LittleEndian, &totalLen)
 
 		if s := buf.String(); strings.HasPrefix(s, "<?xml ") || strings.HasPrefix(s, "<manif") {
-			return ErrPlainTextManifest
+			if !x.opts.isPlainTextFallback() {
+				return ErrPlainTextManifest
+			}
+
+			if err := x.opts.anomaly(WarnPlainTextXml, "XML is in plaintext"); err != nil {
+				return err
+			}
+			return x.parsePlainText(io.MultiReader(buf, r))
 		}
 	}
 
 	// Android doesn't care.
-	/*if id != chunkAxmlFile {
-	    return fmt.Errorf("Invalid top chunk id: 0x%08x", id)
-	}*/
+	if id != chunkAxmlFile {
+		if err := x.opts.anomaly(WarnInvalidChunkId, "Invalid top chunk id: 0x%08x", id); err != nil {
+			return err
+		}
+	}
 
 	defer x.encoder.Flush()
 
@@ -66,26 +129,48 @@ func ParseXml(r io.Reader, enc ManifestEncoder, resources *ResourceTable) error
 	var len uint32
 	var lastId uint16
 	for i := uint32(0); i < totalLen; i += len {
-		id, _, len, err = parseChunkHeader(r)
+		if err := x.opts.checkContext(); err != nil {
+			return err
+		}
+
+		id, headerLen, len, err = parseChunkHeader(r)
 		if err != nil {
-			return fmt.Errorf("Error parsing header at 0x%08x of 0x%08x %08x: %s", i, totalLen, lastId, err.Error())
+			return fmt.Errorf("Error parsing header at 0x%08x of 0x%08x %08x: %w", i, totalLen, lastId, truncated(err))
 		}
 
 		lastId = id
+		x.opts.progressChunk(ProgressXml, int64(i)+chunkHeaderSize)
+		x.opts.countChunk(id)
+
+		if len < chunkHeaderSize {
+			if err := x.opts.anomaly(WarnUnusualLayout, "Chunk: 0x%08x: invalid length %d", id, len); err != nil {
+				return err
+			}
+		} else if uint64(i)+uint64(len) > uint64(totalLen) {
+			if err := x.opts.anomaly(WarnChunkOverflow, "Chunk: 0x%08x: length %d overflows the file", id, len); err != nil {
+				return err
+			}
+		}
 
 		lm := &io.LimitedReader{R: r, N: int64(len) - 2*4}
 
 		switch id {
 		case chunkStringTable:
-			x.strings, err = parseStringTable(lm)
+			x.strings, err = parseStringTable(lm, headerLen, x.opts)
 		case chunkResourceIds:
 			err = x.parseResourceIds(lm)
 		default:
 			if (id & chunkMaskXml) == 0 {
-				err = fmt.Errorf("Unknown chunk id 0x%x", id)
+				err = x.unknownChunk(lm, id)
 				break
 			}
 
+			if headerLen != 2*chunkHeaderSize {
+				if err = x.opts.anomaly(WarnUnusualLayout, "invalid node header length %d", headerLen); err != nil {
+					break
+				}
+			}
+
 			// skip line number and unknown 0xFFFFFFFF
 			if _, err = io.CopyN(ioutil.Discard, lm, 2*4); err != nil {
 				break
@@ -103,17 +188,21 @@ func ParseXml(r io.Reader, enc ManifestEncoder, resources *ResourceTable) error
 			case chunkXmlText:
 				err = x.parseText(lm)
 			default:
-				err = fmt.Errorf("Unknown chunk id 0x%x", id)
+				err = x.unknownChunk(lm, id)
 			}
 		}
 
 		if err == ErrEndParsing {
 			break
 		} else if err != nil {
-			return fmt.Errorf("Chunk: 0x%08x: %s", id, err.Error())
+			return fmt.Errorf("Chunk: 0x%08x: %w", id, truncated(err))
 		} else if lm.N != 0 {
+			if err := x.opts.anomaly(WarnChunkNotFullyRead, "Chunk: 0x%08x: was not fully read (%d remaining)", id, lm.N); err != nil {
+				return err
+			}
+
 			// da62a1edc4d9826c8bf2ed8d5be857614f7908163269d80f9d4ad9ee4d12405e
-			io.CopyN(ioutil.Discard, lm, lm.N)
+			x.opts.skip(lm, "rest of chunk 0x%04x", id)
 			//return fmt.Errorf("Chunk: 0x%08x: was not fully read (%d remaining)", id, lm.N)
 		}
 	}
@@ -121,11 +210,27 @@ func ParseXml(r io.Reader, enc ManifestEncoder, resources *ResourceTable) error
 	return x.encoder.Flush()
 }
 
+// Android skips chunks it doesn't know, which is done only with ParseOptions.SkipUnknownChunks.
+func (x *binxmlParseInfo) unknownChunk(r *io.LimitedReader, id uint16) error {
+	if !x.opts.isSkipUnknownChunks() {
+		return &UnknownChunkError{Id: id}
+	}
+
+	if err := x.opts.anomaly(WarnUnknownChunk, "Unknown chunk id 0x%x", id); err != nil {
+		return err
+	}
+	return x.opts.skip(r, "unknown chunk 0x%04x", id)
+}
+
 func (x *binxmlParseInfo) parseResourceIds(r *io.LimitedReader) error {
 	if (r.N % 4) != 0 {
 		return fmt.Errorf("Invalid chunk size!")
 	}
 
+	if err := x.opts.alloc(r.N); err != nil {
+		return err
+	}
+
 	count := uint32(r.N / 4)
 	var id uint32
 	for i := uint32(0); i < count; i++ {
@@ -158,64 +263,110 @@ func (x *binxmlParseInfo) parseNsStart(r *io.LimitedReader) error {
 		return err
 	}
 
-	// TODO: what to do with this?
-	_ = ns
+	x.namespaces = append(x.namespaces, ns.Space)
 	return nil
 }
 
 func (x *binxmlParseInfo) parseNsEnd(r *io.LimitedReader) error {
-	if _, err := io.CopyN(ioutil.Discard, r, 2*4); err != nil {
-		return fmt.Errorf("error skipping: %s", err.Error())
+	var idx [2]uint32 // prefix, uri
+	if err := binary.Read(r, binary.LittleEndian, &idx); err != nil {
+		return fmt.Errorf("error skipping: %w", truncated(err))
 	}
 
-	// TODO: what to do with this?
+	// The ends are not always balanced, the innermost declaration of the uri ends.
+	uri, err := x.strings.get(idx[1])
+	if err != nil {
+		return nil
+	}
+	for i := len(x.namespaces) - 1; i >= 0; i-- {
+		if x.namespaces[i] == uri {
+			x.namespaces = append(x.namespaces[:i], x.namespaces[i+1:]...)
+			break
+		}
+	}
 	return nil
 }
 
+// Returns true if the uri is declared by a namespace start in scope.
+func (x *binxmlParseInfo) isDeclaredNamespace(uri string) bool {
+	for _, ns := range x.namespaces {
+		if ns == uri {
+			return true
+		}
+	}
+	return false
+}
+
 func (x *binxmlParseInfo) parseTagStart(r *io.LimitedReader) error {
 	var namespaceIdx, nameIdx uint32
 	var attrStart, attrSize, attrCount uint16
 
 	if err := binary.Read(r, binary.LittleEndian, &namespaceIdx); err != nil {
-		return fmt.Errorf("error reading namespace idx: %s", err.Error())
+		return fmt.Errorf("error reading namespace idx: %w", truncated(err))
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &nameIdx); err != nil {
-		return fmt.Errorf("error reading name idx: %s", err.Error())
+		return fmt.Errorf("error reading name idx: %w", truncated(err))
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &attrStart); err != nil {
-		return fmt.Errorf("error reading attrStart: %s", err.Error())
+		return fmt.Errorf("error reading attrStart: %w", truncated(err))
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &attrSize); err != nil {
-		return fmt.Errorf("error reading attrSize: %s", err.Error())
+		return fmt.Errorf("error reading attrSize: %w", truncated(err))
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &attrCount); err != nil {
-		return fmt.Errorf("error reading classAttr: %s", err.Error())
+		return fmt.Errorf("error reading classAttr: %w", truncated(err))
 	}
 
-	io.CopyN(io.Discard, r, 2*3) // discard idIndex, classIndex, styleIndex
+	x.opts.countElement(int(attrCount))
+
+	var specialIdx [3]uint16 // idIndex, classIndex, styleIndex
+	if err := binary.Read(r, binary.LittleEndian, &specialIdx); err != nil {
+		return fmt.Errorf("error reading idIndex: %w", truncated(err))
+	}
+
+	if attrStart != 0x14 || (attrCount != 0 && uintptr(attrSize) != unsafe.Sizeof(ResAttr{})) {
+		if err := x.opts.anomaly(WarnUnusualLayout, "unusual attribute layout (start %d, size %d)", attrStart, attrSize); err != nil {
+			return err
+		}
+	}
 
 	namespace, err := x.strings.get(namespaceIdx)
 	if err != nil {
-		return fmt.Errorf("error decoding namespace: %s", err.Error())
+		return fmt.Errorf("error decoding namespace: %w", truncated(err))
 	}
 
 	name, err := x.strings.get(nameIdx)
 	if err != nil {
-		return fmt.Errorf("error decoding name: %s", err.Error())
+		return fmt.Errorf("error decoding name: %w", truncated(err))
 	}
 
 	tok := xml.StartElement{
-		Name: xml.Name{Local: name, Space: namespace},
+		Name: xml.Name{Local: x.opts.intern(name), Space: x.opts.intern(namespace)},
+	}
+
+	var typedTok *TypedStartElement
+	if x.typedEncoder != nil {
+		typedTok = &TypedStartElement{
+			Name:    tok.Name,
+			strings: &x.strings,
+		}
+
+		// The indexes are 1-based, out of range ones are ignored.
+		for i, dst := range []*int{&typedTok.IdIndex, &typedTok.ClassIndex, &typedTok.StyleIndex} {
+			if specialIdx[i] <= attrCount {
+				*dst = int(specialIdx[i])
+			}
+		}
 	}
 
 	var attr ResAttr
 	for i := uint16(0); i < attrCount; i++ {
 		if err := binary.Read(r, binary.LittleEndian, &attr); err != nil {
-			return fmt.Errorf("error reading attrData: %s", err.Error())
+			return fmt.Errorf("error reading attrData: %w", truncated(err))
 		}
 
 		if uintptr(attrSize) > unsafe.Sizeof(attr) {
@@ -235,69 +386,110 @@ func (x *binxmlParseInfo) parseTagStart(r *io.LimitedReader) error {
 		// Sample: a3ee88cf1492237a1be846df824f9de30a6f779973fe3c41c7d7ed0be644ba37
 		//
 		// In general, android doesn't care about namespaces, but if a resource ID is used, it has to have been
-		// in the android: namespace, so we fix that up.
+		// in the android: namespace, so we fix that up. That is unless the attribute is in another declared
+		// namespace like tools: or app:, aapt doesn't give framework IDs to those, the namespace and the name
+		// from the string table are kept for them.
 
 		// frameworks/base/core/jni/android_util_AssetManager.cpp android_content_AssetManager_retrieveAttributes
 		// frameworks/base/core/java/android/content/pm/PackageParser.java parsePackageSplitNames
-		var attrName string
+		attrNameSpace, err := x.strings.get(attr.NamespaceId)
+		if err != nil {
+			return fmt.Errorf("error decoding attrNamespaceIdx: %w", truncated(err))
+		}
+
+		otherNamespace := attrNameSpace != "" && attrNameSpace != androidNamespace && x.isDeclaredNamespace(attrNameSpace)
+
+		var idName, attrName string
 		if attr.NameIdx < uint32(len(x.resourceIds)) {
-			attrName = getAttributteName(x.resourceIds[attr.NameIdx])
+			idName = getAttributteName(x.resourceIds[attr.NameIdx])
+		}
+		if !otherNamespace {
+			attrName = idName
 		}
 
 		var attrNameFromStrings string
 		if attrName == "" || name == "manifest" {
 			attrNameFromStrings, err = x.strings.get(attr.NameIdx)
 			if err != nil {
-				if attrName == "" {
-					return fmt.Errorf("error decoding attrNameIdx: %s", err.Error())
+				if attrName == "" && idName == "" {
+					return fmt.Errorf("error decoding attrNameIdx: %w", truncated(err))
+				} else if attrName == "" {
+					// The ID is all there is, the attribute is treated as android's after all.
+					attrName, otherNamespace = idName, false
 				}
 			} else if attrName != "" && attrNameFromStrings != "package" && !strings.HasPrefix(attrNameFromStrings, "platformBuildVersion") {
 				attrNameFromStrings = ""
 			}
 		}
 
-		attrNameSpace, err := x.strings.get(attr.NamespaceId)
-		if err != nil {
-			return fmt.Errorf("error decoding attrNamespaceIdx: %s", err.Error())
-		}
-
 		if attrNameFromStrings != "" {
 			attrName = attrNameFromStrings
-		} else if attrNameSpace == "" {
-			attrNameSpace = "http://schemas.android.com/apk/res/android"
+		} else if !otherNamespace {
+			attrNameSpace = androidNamespace
 		}
 
 		resultAttr := xml.Attr{
-			Name: xml.Name{Local: attrName, Space: attrNameSpace},
+			Name: xml.Name{Local: x.opts.intern(attrName), Space: x.opts.intern(attrNameSpace)},
+		}
+
+		if typedTok != nil {
+			typedTok.Attr = append(typedTok.Attr, TypedAttr{
+				Name:     resultAttr.Name,
+				Type:     attr.Res.Type,
+				RawData:  attr.Res.Data,
+				RawValue: StringIndex(attr.RawValueIdx),
+				Value:    typedAttrValue(attr.Res.Type, attr.Res.Data),
+			})
+			continue
 		}
 
 		switch attr.Res.Type {
 		case AttrTypeString:
 			resultAttr.Value, err = x.strings.get(attr.RawValueIdx)
 			if err != nil {
+				if serr := x.opts.anomaly(WarnBadStringIndex, "error decoding attrStringIdx: %s", err.Error()); serr != nil {
+					return serr
+				}
+
 				// da62a1edc4d9826c8bf2ed8d5be857614f7908163269d80f9d4ad9ee4d12405e
 				resultAttr.Value = fmt.Sprintf("#%d", attr.RawValueIdx)
 				err = nil
-				//return fmt.Errorf("error decoding attrStringIdx: %s", err.Error())
+				//return fmt.Errorf("error decoding attrStringIdx: %w", truncated(err))
 			}
 		case AttrTypeIntBool:
 			resultAttr.Value = strconv.FormatBool(attr.Res.Data != 0)
 		case AttrTypeIntHex:
 			resultAttr.Value = fmt.Sprintf("0x%x", attr.Res.Data)
+		case AttrTypeIntColorArgb8, AttrTypeIntColorRgb8, AttrTypeIntColorArgb4, AttrTypeIntColorRgb4:
+			resultAttr.Value = formatColor(attr.Res.Type, attr.Res.Data)
 		case AttrTypeFloat:
 			val := (*float32)(unsafe.Pointer(&attr.Res.Data))
 			resultAttr.Value = fmt.Sprintf("%g", *val)
-		case AttrTypeReference:
+		case AttrTypeDimension, AttrTypeFraction:
+			var ok bool
+			if resultAttr.Value, ok = formatComplex(attr.Res.Type, attr.Res.Data); !ok {
+				resultAttr.Value = strconv.FormatInt(int64(int32(attr.Res.Data)), 10)
+			}
+		case AttrTypeReference, AttrTypeDynamicReference:
 			isValidString := false
 			if x.res != nil {
 				var e *ResourceEntry
-				if resultAttr.Name.Local == "icon" || resultAttr.Name.Local == "roundIcon" {
+				if (resultAttr.Name.Local == "icon" || resultAttr.Name.Local == "roundIcon") && !x.opts.hasDisplayPreference() {
 					e, err = x.res.GetIconPng(attr.Res.Data)
 				} else {
-					e, err = x.res.GetResourceEntry(attr.Res.Data)
+					e, err = x.res.getPreferredEntry(attr.Res.Data, x.opts)
 				}
 
 				if err == nil {
+					// The first hop is the lookup of the attribute's reference itself.
+					var loop uint32
+					e, loop = x.res.followReferences(attr.Res.Data, e, x.opts.maxReferenceDepth()-1, x.opts)
+					if loop != 0 {
+						if err := x.opts.anomaly(WarnReferenceLoop, "Reference loop at 0x%08x in attribute %s", loop, resultAttr.Name.Local); err != nil {
+							return err
+						}
+					}
+
 					resultAttr.Value, err = e.value.String()
 					isValidString = err == nil
 				}
@@ -306,6 +498,9 @@ func (x *binxmlParseInfo) parseTagStart(r *io.LimitedReader) error {
 			if !isValidString && resultAttr.Value == "" {
 				resultAttr.Value = fmt.Sprintf("@%x", attr.Res.Data)
 			}
+		case AttrTypeAttribute, AttrTypeDynamicAttribute:
+			// The value depends on the theme, only the attribute can be named.
+			resultAttr.Value = formatAttrReference(x.res, attr.Res.Data)
 		default:
 			resultAttr.Value = strconv.FormatInt(int64(int32(attr.Res.Data)), 10)
 		}
@@ -314,31 +509,57 @@ func (x *binxmlParseInfo) parseTagStart(r *io.LimitedReader) error {
 
 	x.openTags = append(x.openTags, tok.Name)
 
+	if typedTok != nil {
+		return x.typedEncoder.EncodeTypedStart(typedTok)
+	}
 	return x.encoder.EncodeToken(tok)
 }
 
+func typedAttrValue(typ AttrType, data uint32) interface{} {
+	switch typ {
+	case AttrTypeNull:
+		return nil
+	case AttrTypeString:
+		return StringIndex(data)
+	case AttrTypeReference, AttrTypeAttribute, AttrTypeDynamicReference, AttrTypeDynamicAttribute:
+		return ResourceReference(data)
+	case AttrTypeIntBool:
+		return data != 0
+	case AttrTypeFloat:
+		return math.Float32frombits(data)
+	case AttrTypeIntHex, AttrTypeIntColorArgb8, AttrTypeIntColorRgb8,
+		AttrTypeIntColorArgb4, AttrTypeIntColorRgb4:
+		return data
+	default:
+		return int32(data)
+	}
+}
+
 func (x *binxmlParseInfo) parseTagEnd(r *io.LimitedReader) error {
 	var namespaceIdx, nameIdx uint32
 	if err := binary.Read(r, binary.LittleEndian, &namespaceIdx); err != nil {
-		return fmt.Errorf("error reading namespace idx: %s", err.Error())
+		return fmt.Errorf("error reading namespace idx: %w", truncated(err))
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &nameIdx); err != nil {
-		return fmt.Errorf("error reading name idx: %s", err.Error())
+		return fmt.Errorf("error reading name idx: %w", truncated(err))
 	}
 
 	namespace, err := x.strings.get(namespaceIdx)
 	if err != nil {
-		return fmt.Errorf("error decoding namespace: %s", err.Error())
+		return fmt.Errorf("error decoding namespace: %w", truncated(err))
 	}
 
 	name, err := x.strings.get(nameIdx)
 	if err != nil {
 		// 4D8029A256A7FC3571BC497F9B6D1D734A5F2D4D95E032A47AE86F2C6812DCEB
 		if len(x.openTags) != 0 {
+			if err := x.opts.anomaly(WarnBadStringIndex, "error decoding name: %s", err.Error()); err != nil {
+				return err
+			}
 			name = x.openTags[len(x.openTags)-1].Local
 		} else {
-			return fmt.Errorf("error decoding name: %s", err.Error())
+			return fmt.Errorf("error decoding name: %w", truncated(err))
 		}
 	}
 
@@ -352,16 +573,16 @@ func (x *binxmlParseInfo) parseTagEnd(r *io.LimitedReader) error {
 func (x *binxmlParseInfo) parseText(r *io.LimitedReader) error {
 	var idx uint32
 	if err := binary.Read(r, binary.LittleEndian, &idx); err != nil {
-		return fmt.Errorf("error reading idx: %s", err.Error())
+		return fmt.Errorf("error reading idx: %w", truncated(err))
 	}
 
 	text, err := x.strings.get(idx)
 	if err != nil {
-		return fmt.Errorf("error decoding idx: %s", err.Error())
+		return fmt.Errorf("error decoding idx: %w", truncated(err))
 	}
 
 	if _, err := io.CopyN(ioutil.Discard, r, 2*4); err != nil {
-		return fmt.Errorf("error skipping: %s", err.Error())
+		return fmt.Errorf("error skipping: %w", truncated(err))
 	}
 
 	return x.encoder.EncodeToken(xml.CharData(text))
@@ -0,0 +1,182 @@
+package apkparser
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Attributes written by the build tools, which differ between builds of the same manifest.
+var canonicalSkippedAttrs = map[xml.Name]bool{
+	{Local: "platformBuildVersionCode"}:                           true,
+	{Local: "platformBuildVersionName"}:                           true,
+	{Space: androidNamespace, Local: "compileSdkVersion"}:         true,
+	{Space: androidNamespace, Local: "compileSdkVersionCodename"}: true,
+}
+
+// Writes the tree in a normalized form, so that logically identical manifests from differently built
+// APKs produce the same output:
+//   - attributes are sorted, namespace declarations and build tool attributes like platformBuildVersionCode are dropped
+//   - the android namespace is always "android", other namespaces get prefixes ns0, ns1... by their URI,
+//     all declared on the root element
+//   - references left unresolved, like "@7f0f0005" of styles, are replaced by names like "@com.example:style/AppTheme"
+//     if the resources, which can be nil, have them
+//   - text is trimmed and elements are indented by 4 spaces, one per line
+//
+// The order of elements is kept.
+func (e *ManifestElement) WriteCanonical(w io.Writer, resources *ResourceTable) error {
+	c := canonicalWriter{
+		w:          bufio.NewWriter(w),
+		resources:  resources,
+		namespaces: map[string]string{androidNamespace: "android"},
+	}
+
+	var uris []string
+	e.walkNamespaces(func(uri string) {
+		if _, prs := c.namespaces[uri]; !prs {
+			c.namespaces[uri] = ""
+			uris = append(uris, uri)
+		}
+	})
+
+	sort.Strings(uris)
+	for i, uri := range uris {
+		c.namespaces[uri] = fmt.Sprintf("ns%d", i)
+	}
+
+	c.element(e, 0, append([]string{androidNamespace}, uris...))
+	if c.err != nil {
+		return c.err
+	}
+	return c.w.Flush()
+}
+
+// Returns hex encoded SHA-256 of the canonical form of the tree, see WriteCanonical.
+func (e *ManifestElement) CanonicalHash(resources *ResourceTable) string {
+	h := sha256.New()
+	e.WriteCanonical(h, resources)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Returns hash of the canonical form of AndroidManifest.xml with the resources of the APK,
+// equal for APKs with logically identical manifests. See ManifestElement.WriteCanonical.
+func (p *ApkParser) ManifestHash() (string, error) {
+	manifest, err := p.ParseManifestTree()
+	if err != nil {
+		return "", err
+	}
+	return manifest.CanonicalHash(p.resources), nil
+}
+
+func (e *ManifestElement) walkNamespaces(f func(uri string)) {
+	if e.Name.Space != "" {
+		f(e.Name.Space)
+	}
+	for _, a := range e.Attr {
+		if a.Name.Space != "" && !isXmlnsAttr(a.Name) {
+			f(a.Name.Space)
+		}
+	}
+	for _, c := range e.Children {
+		c.walkNamespaces(f)
+	}
+}
+
+func isXmlnsAttr(name xml.Name) bool {
+	return name.Space == "xmlns" || (name.Space == "" && name.Local == "xmlns")
+}
+
+type canonicalWriter struct {
+	w          *bufio.Writer
+	resources  *ResourceTable
+	namespaces map[string]string
+	err        error
+}
+
+func (c *canonicalWriter) printf(format string, args ...interface{}) {
+	if c.err == nil {
+		_, c.err = fmt.Fprintf(c.w, format, args...)
+	}
+}
+
+func (c *canonicalWriter) escaped(s string) {
+	if c.err == nil {
+		c.err = xml.EscapeText(c.w, []byte(s))
+	}
+}
+
+func (c *canonicalWriter) name(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return c.namespaces[name.Space] + ":" + name.Local
+}
+
+// Declares the namespaces in declare on this element.
+func (c *canonicalWriter) element(e *ManifestElement, depth int, declare []string) {
+	indent := strings.Repeat("    ", depth)
+	c.printf("%s<%s", indent, c.name(e.Name))
+
+	for _, uri := range declare {
+		c.printf(" xmlns:%s=\"", c.namespaces[uri])
+		c.escaped(uri)
+		c.printf("\"")
+	}
+
+	attrs := make([]xml.Attr, 0, len(e.Attr))
+	for _, a := range e.Attr {
+		if !isXmlnsAttr(a.Name) && !canonicalSkippedAttrs[a.Name] {
+			attrs = append(attrs, a)
+		}
+	}
+	sort.SliceStable(attrs, func(i, j int) bool {
+		return c.name(attrs[i].Name) < c.name(attrs[j].Name)
+	})
+
+	for _, a := range attrs {
+		c.printf(" %s=\"", c.name(a.Name))
+		c.escaped(c.value(a.Value))
+		c.printf("\"")
+	}
+
+	text := strings.TrimSpace(e.Text)
+	if text == "" && len(e.Children) == 0 {
+		c.printf("/>\n")
+		return
+	}
+
+	c.printf(">\n")
+	if text != "" {
+		c.printf("%s    ", indent)
+		c.escaped(text)
+		c.printf("\n")
+	}
+	for _, child := range e.Children {
+		c.element(child, depth+1, nil)
+	}
+	c.printf("%s</%s>\n", indent, c.name(e.Name))
+}
+
+// Replaces unresolved reference like "@7f0f0005" or "?7f040001" with its name.
+func (c *canonicalWriter) value(val string) string {
+	if c.resources == nil || len(val) < 2 || (val[0] != '@' && val[0] != '?') {
+		return val
+	}
+
+	resId, err := strconv.ParseUint(val[1:], 16, 32)
+	if err != nil {
+		return val
+	}
+
+	entry, err := c.resources.GetResourceEntry(uint32(resId))
+	if err != nil || entry.ResourceType == "" || entry.Key == "" {
+		return val
+	}
+	return fmt.Sprintf("%c%s:%s/%s", val[0], entry.Package, entry.ResourceType, entry.Key)
+}
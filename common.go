@@ -2,7 +2,10 @@ package apkparser
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
 // frameworks/base/libs/androidfw/include/androidfw/ResourceTypes.h
@@ -17,6 +20,10 @@ const (
 	chunkTableTypeSpec = 0x0202
 	chunkTableLibrary  = 0x0203
 
+	chunkTableOverlayable       = 0x0204
+	chunkTableOverlayablePolicy = 0x0205
+	chunkTableStagedAlias       = 0x0206
+
 	chunkMaskXml     = 0x0100
 	chunkXmlNsStart  = 0x0100
 	chunkXmlNsEnd    = 0x0101
@@ -27,6 +34,8 @@ const (
 	chunkHeaderSize = (2 + 2 + 4)
 )
 
+const androidNamespace = "http://schemas.android.com/apk/res/android"
+
 type ResAttr struct {
 	NamespaceId uint32
 	NameIdx     uint32
@@ -49,6 +58,8 @@ const (
 	AttrTypeAttribute              = 0x02
 	AttrTypeString                 = 0x03
 	AttrTypeFloat                  = 0x04
+	AttrTypeDimension              = 0x05
+	AttrTypeFraction               = 0x06
 	AttrTypeIntDec                 = 0x10
 	AttrTypeIntHex                 = 0x11
 	AttrTypeIntBool                = 0x12
@@ -56,8 +67,80 @@ const (
 	AttrTypeIntColorRgb8           = 0x1d
 	AttrTypeIntColorArgb4          = 0x1e
 	AttrTypeIntColorRgb4           = 0x1f
+
+	// References to shared library resources, their package id is assigned at runtime.
+	AttrTypeDynamicReference = 0x07
+	AttrTypeDynamicAttribute = 0x08
+)
+
+// Formats reference to a theme attribute like aapt, "?android:attr/textColor" or "?com.example:attr/accent",
+// or "?7f010005" if it is not in the resources, which can be nil.
+func formatAttrReference(res *ResourceTable, id uint32) string {
+	if id>>16 == 0x0101 {
+		if name := getAttributteName(id); name != "" {
+			return "?android:attr/" + name
+		}
+	}
+
+	if res != nil {
+		if e, err := res.GetResourceEntry(id); err == nil && e.ResourceType != "" && e.Key != "" {
+			return fmt.Sprintf("?%s:%s/%s", e.Package, e.ResourceType, e.Key)
+		}
+	}
+	return fmt.Sprintf("?%x", id)
+}
+
+// Formats color value as aapt does. The data are always stored as 0xAARRGGBB, the type
+// only says which format the color was written in.
+func formatColor(typ AttrType, data uint32) string {
+	nibble := func(shift uint) uint32 {
+		return (data >> shift) & 0xF
+	}
+
+	switch typ {
+	case AttrTypeIntColorRgb8:
+		return fmt.Sprintf("#%06x", data&0xFFFFFF)
+	case AttrTypeIntColorArgb4:
+		return fmt.Sprintf("#%x%x%x%x", nibble(28), nibble(20), nibble(12), nibble(4))
+	case AttrTypeIntColorRgb4:
+		return fmt.Sprintf("#%x%x%x", nibble(20), nibble(12), nibble(4))
+	default:
+		return fmt.Sprintf("#%08x", data)
+	}
+}
+
+var (
+	dimensionUnits = []string{"px", "dip", "sp", "pt", "in", "mm"}
+	fractionUnits  = []string{"%", "%p"}
 )
 
+// Formats dimension or fraction value like TypedValue.coerceToString does, e.g. "16.0dip" or "50.0%p".
+// The data are a 24-bit signed mantissa, 2-bit radix and 4-bit unit. Returns false for unknown units.
+func formatComplex(typ AttrType, data uint32) (string, bool) {
+	units := dimensionUnits
+	if typ == AttrTypeFraction {
+		units = fractionUnits
+	}
+
+	unit := data & 0xF
+	if int(unit) >= len(units) {
+		return "", false
+	}
+
+	// frameworks/base/core/java/android/util/TypedValue.java complexToFloat
+	radixShift := []uint{0, 7, 15, 23}[(data>>4)&0x3]
+	val := float64(int32(data)>>8) / float64(uint32(1)<<radixShift)
+	if typ == AttrTypeFraction {
+		val *= 100
+	}
+
+	res := strconv.FormatFloat(val, 'f', -1, 32)
+	if !strings.Contains(res, ".") {
+		res += ".0"
+	}
+	return res + units[unit], true
+}
+
 func parseChunkHeader(r io.Reader) (id, headerLen uint16, len uint32, err error) {
 	if err = binary.Read(r, binary.LittleEndian, &id); err != nil {
 		return
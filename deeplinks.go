@@ -0,0 +1,208 @@
+package apkparser
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// URI handled by an activity, collected from its VIEW intent filters.
+type DeepLink struct {
+	Scheme string
+	Host   string
+	Port   string
+	Path   string
+	// The <data> attribute of the Path: "path", "pathPrefix", "pathPattern", "pathSuffix"
+	// or "pathAdvancedPattern". Empty if the filter matches all paths.
+	PathMatch string
+
+	// True if declared in an intent filter with android:autoVerify="true", i.e. it is
+	// a verified http(s) App Link.
+	AutoVerify bool
+	// Names of the activities and activity aliases handling the link.
+	Activities []string
+}
+
+// Returns true for http and https links.
+func (l *DeepLink) IsWebLink() bool {
+	return l.Scheme == "http" || l.Scheme == "https"
+}
+
+// Returns the links in the URI form, e.g. "https://example.com:8080/path*".
+func (l *DeepLink) String() string {
+	var res strings.Builder
+	res.WriteString(l.Scheme)
+	res.WriteString("://")
+	res.WriteString(l.Host)
+	if l.Port != "" {
+		res.WriteByte(':')
+		res.WriteString(l.Port)
+	}
+	res.WriteString(l.Path)
+	if l.PathMatch == "pathPrefix" {
+		res.WriteByte('*')
+	}
+	return res.String()
+}
+
+// Returns the deep links, both custom scheme and http(s) ones including the verified App Links,
+// declared in the manifest's intent filters with the android.intent.action.VIEW action.
+// Links are de-duplicated by scheme, host, port and path, the duplicates are merged into one entry
+// with all of their activities.
+func (p *ApkParser) DeepLinks() ([]*DeepLink, error) {
+	collector := &deepLinkCollector{
+		index: make(map[deepLinkKey]*DeepLink),
+	}
+	if err := p.parseXmlWith("AndroidManifest.xml", collector); err != nil {
+		return nil, err
+	}
+	return collector.links, nil
+}
+
+type deepLinkKey struct {
+	scheme, host, port, path, pathMatch string
+}
+
+type deepLinkPath struct {
+	match, value string
+}
+
+type intentFilterData struct {
+	view       bool
+	autoVerify bool
+	schemes    []string
+	hosts      []deepLinkKey // just host and port
+	paths      []deepLinkPath
+}
+
+// ManifestEncoder gathering the <data> elements of intent filters.
+type deepLinkCollector struct {
+	depth     int
+	component string
+	filter    *intentFilterData
+
+	links []*DeepLink
+	index map[deepLinkKey]*DeepLink
+}
+
+func (c *deepLinkCollector) EncodeToken(t xml.Token) error {
+	switch tok := t.(type) {
+	case xml.StartElement:
+		c.depth++
+		switch {
+		case c.depth == 3 && (tok.Name.Local == "activity" || tok.Name.Local == "activity-alias"):
+			c.component = deepLinkAttr(&tok, "name")
+		case c.depth == 4 && tok.Name.Local == "intent-filter" && c.component != "":
+			c.filter = &intentFilterData{
+				autoVerify: deepLinkAttr(&tok, "autoVerify") == "true",
+			}
+		case c.depth == 5 && c.filter != nil:
+			c.addFilterElement(&tok)
+		}
+	case xml.EndElement:
+		switch c.depth {
+		case 3:
+			c.component = ""
+		case 4:
+			if c.filter != nil {
+				c.addLinks(c.filter)
+				c.filter = nil
+			}
+		}
+		c.depth--
+	}
+	return nil
+}
+
+func (c *deepLinkCollector) Flush() error {
+	return nil
+}
+
+func (c *deepLinkCollector) addFilterElement(tok *xml.StartElement) {
+	f := c.filter
+	switch tok.Name.Local {
+	case "action":
+		if deepLinkAttr(tok, "name") == "android.intent.action.VIEW" {
+			f.view = true
+		}
+	case "data":
+		if scheme := deepLinkAttr(tok, "scheme"); scheme != "" {
+			f.schemes = append(f.schemes, scheme)
+		}
+		if host := deepLinkAttr(tok, "host"); host != "" {
+			f.hosts = append(f.hosts, deepLinkKey{host: host, port: deepLinkAttr(tok, "port")})
+		}
+		for _, match := range []string{"path", "pathPrefix", "pathPattern", "pathSuffix", "pathAdvancedPattern"} {
+			if path := deepLinkAttr(tok, match); path != "" {
+				f.paths = append(f.paths, deepLinkPath{match: match, value: path})
+			}
+		}
+	}
+}
+
+// Android combines all <data> elements of the filter, so every scheme is matched
+// with every host and every path.
+func (c *deepLinkCollector) addLinks(f *intentFilterData) {
+	if !f.view {
+		return
+	}
+
+	hosts := f.hosts
+	if len(hosts) == 0 {
+		hosts = []deepLinkKey{{}}
+	}
+
+	paths := f.paths
+	if len(paths) == 0 {
+		paths = []deepLinkPath{{}}
+	}
+
+	for _, scheme := range f.schemes {
+		for _, host := range hosts {
+			for _, path := range paths {
+				key := deepLinkKey{
+					scheme:    scheme,
+					host:      host.host,
+					port:      host.port,
+					path:      path.value,
+					pathMatch: path.match,
+				}
+
+				link := c.index[key]
+				if link == nil {
+					link = &DeepLink{
+						Scheme:    key.scheme,
+						Host:      key.host,
+						Port:      key.port,
+						Path:      key.path,
+						PathMatch: key.pathMatch,
+					}
+					c.index[key] = link
+					c.links = append(c.links, link)
+				}
+
+				link.AutoVerify = link.AutoVerify || f.autoVerify
+				if !deepLinkHasActivity(link, c.component) {
+					link.Activities = append(link.Activities, c.component)
+				}
+			}
+		}
+	}
+}
+
+func deepLinkHasActivity(link *DeepLink, component string) bool {
+	for _, a := range link.Activities {
+		if a == component {
+			return true
+		}
+	}
+	return false
+}
+
+func deepLinkAttr(tok *xml.StartElement, name string) string {
+	for _, a := range tok.Attr {
+		if a.Name.Space == androidNamespace && a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package apkparser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// One classes*.dex file of the APK, see ZipReader.DexReport.
+type DexFile struct {
+	// Name in the APK, like "classes2.dex".
+	Path string
+	// False if the runtime doesn't load the file, because the numbering has a gap before it, or
+	// the name is not in the classes.dex, classes2.dex, classes3.dex... sequence, like classes1.dex.
+	Loaded bool
+
+	// Uncompressed size, from the DEX header if the ZIP doesn't say.
+	Size int64
+	// Format version from the magic, like "035" or "039".
+	Version string
+
+	// Counts from the DEX header.
+	StringIds int
+	TypeIds   int
+	FieldIds  int
+	MethodIds int
+	ClassDefs int
+
+	// Error reading the header, the counts are not set if it is not nil.
+	Err error
+}
+
+// DEX files and ahead-of-time compilation metadata of the APK.
+type DexReport struct {
+	// Loaded files in the loading order, then the rest sorted by name.
+	Dex []*DexFile
+
+	// Baseline profile used for AOT compilation at install time, assets/dexopt/baseline.prof,
+	// empty if not present. Its metadata assets/dexopt/baseline.profm is in ProfileMetadata.
+	BaselineProfile string
+	ProfileMetadata string
+	// Files with the .dm extension, DEX metadata archives which are normally installed next to
+	// the APK rather than inside it.
+	DexMetadata []string
+}
+
+// Sum of MethodIds over the loaded files.
+func (r *DexReport) MethodCount() int {
+	var res int
+	for _, d := range r.Dex {
+		if d.Loaded {
+			res += d.MethodIds
+		}
+	}
+	return res
+}
+
+// Returns the DEX files and AOT metadata of the APK. Only headers of the DEX files are read.
+func (zr *ZipReader) DexReport() *DexReport {
+	res := &DexReport{}
+	for name, f := range zr.File {
+		switch {
+		case f.IsDir:
+		case dexNameRe.MatchString(name):
+			res.Dex = append(res.Dex, newDexFile(f))
+		case name == "assets/dexopt/baseline.prof":
+			res.BaselineProfile = name
+		case name == "assets/dexopt/baseline.profm":
+			res.ProfileMetadata = name
+		case path.Ext(name) == ".dm":
+			res.DexMetadata = append(res.DexMetadata, name)
+		}
+	}
+	sort.Strings(res.DexMetadata)
+
+	// The runtime loads classes.dex, classes2.dex... until the first missing one.
+	present := make(map[string]bool, len(res.Dex))
+	for _, d := range res.Dex {
+		present[d.Path] = true
+	}
+	order := make(map[string]int)
+	for i := 1; present[dexFileName(i)]; i++ {
+		order[dexFileName(i)] = i
+	}
+
+	for _, d := range res.Dex {
+		_, d.Loaded = order[d.Path]
+	}
+
+	sort.Slice(res.Dex, func(i, j int) bool {
+		a, b := res.Dex[i], res.Dex[j]
+		if a.Loaded != b.Loaded {
+			return a.Loaded
+		} else if a.Loaded {
+			return order[a.Path] < order[b.Path]
+		}
+		return a.Path < b.Path
+	})
+	return res
+}
+
+func dexFileName(idx int) string {
+	if idx == 1 {
+		return "classes.dex"
+	}
+	return "classes" + strconv.Itoa(idx) + ".dex"
+}
+
+func newDexFile(f *ZipReaderFile) *DexFile {
+	res := &DexFile{Path: f.Name}
+	defer recoverPanic(&res.Err)
+
+	if hdr := f.ZipHeader(); hdr != nil {
+		res.Size = int64(hdr.UncompressedSize64)
+	}
+
+	hdr, err := f.ReadAll(dexHeaderSize)
+	if err != nil {
+		res.Err = err
+		return res
+	} else if len(hdr) < dexHeaderSize || !dexMagicRe.Match(hdr[:8]) {
+		res.Err = fmt.Errorf("Invalid DEX header.")
+		return res
+	}
+
+	res.Version = string(hdr[4:7])
+	if res.Size == 0 {
+		res.Size = int64(binary.LittleEndian.Uint32(hdr[32:]))
+	}
+
+	res.StringIds = int(binary.LittleEndian.Uint32(hdr[56:]))
+	res.TypeIds = int(binary.LittleEndian.Uint32(hdr[64:]))
+	res.FieldIds = int(binary.LittleEndian.Uint32(hdr[80:]))
+	res.MethodIds = int(binary.LittleEndian.Uint32(hdr[88:]))
+	res.ClassDefs = int(binary.LittleEndian.Uint32(hdr[96:]))
+	return res
+}
@@ -3,6 +3,7 @@ package apkparser
 import (
 	"encoding/xml"
 	"errors"
+	"math"
 )
 
 // Return this error from EncodeToken to tell apkparser to finish parsing,
@@ -14,3 +15,102 @@ type ManifestEncoder interface {
 	EncodeToken(t xml.Token) error
 	Flush() error
 }
+
+// Optional extension of ManifestEncoder. If the encoder passed to ParseXml implements it,
+// start elements are passed to EncodeTypedStart with the raw typed attribute values
+// instead of to EncodeToken with pre-formatted strings. End elements and text are still
+// passed to EncodeToken.
+type TypedManifestEncoder interface {
+	ManifestEncoder
+	EncodeTypedStart(el *TypedStartElement) error
+}
+
+// Reference to a resource, used for AttrTypeReference and AttrTypeAttribute values.
+// Resolve it with ResourceTable.GetResourceEntry.
+type ResourceReference uint32
+
+// Index into the string table of the XML file, used for AttrTypeString values.
+// Resolve it with TypedStartElement.GetString.
+type StringIndex uint32
+
+// Value of TypedAttr.RawValue when the attribute has no raw string.
+const NoRawValue StringIndex = math.MaxUint32
+
+// Start element with attribute values as they are stored in the binary XML.
+type TypedStartElement struct {
+	Name xml.Name
+	Attr []TypedAttr
+
+	// 1-based indexes into Attr of the android:id, class and style attributes, which Android
+	// looks up by these instead of by name in layouts. 0 if the element has none.
+	IdIndex, ClassIndex, StyleIndex int
+
+	strings *stringTable
+}
+
+// Attribute with its value as it is stored in the binary XML.
+type TypedAttr struct {
+	Name    xml.Name
+	Type    AttrType
+	RawData uint32
+
+	// Index of the string aapt stored along the typed value, e.g. "1.0" of a version name compiled
+	// as a float. NoRawValue if there is none, which is common for values compiled from resources.
+	RawValue StringIndex
+
+	// RawData converted to a Go type according to Type:
+	//   AttrTypeNull: nil
+	//   AttrTypeString: StringIndex
+	//   AttrTypeReference, AttrTypeAttribute: ResourceReference
+	//   AttrTypeIntBool: bool
+	//   AttrTypeFloat: float32
+	//   AttrTypeIntHex, AttrTypeIntColor*: uint32
+	//   everything else: int32
+	Value interface{}
+}
+
+// Returns the string from the XML's string table.
+func (el *TypedStartElement) GetString(idx StringIndex) (string, error) {
+	if el.strings == nil {
+		return "", errors.New("No string table available.")
+	}
+	return el.strings.get(uint32(idx))
+}
+
+// Returns true if the attribute has a raw string besides the typed value.
+func (a *TypedAttr) HasRawValue() bool {
+	return a.RawValue != NoRawValue
+}
+
+// SAX-like callback API for the binary XML, implements TypedManifestEncoder.
+// Any of the callbacks can be nil, in which case the respective tokens are skipped.
+type ManifestVisitor struct {
+	StartElement func(el *TypedStartElement) error
+	EndElement   func(name xml.Name) error
+	CharData     func(text string) error
+}
+
+func (v *ManifestVisitor) EncodeTypedStart(el *TypedStartElement) error {
+	if v.StartElement == nil {
+		return nil
+	}
+	return v.StartElement(el)
+}
+
+func (v *ManifestVisitor) EncodeToken(t xml.Token) error {
+	switch tok := t.(type) {
+	case xml.EndElement:
+		if v.EndElement != nil {
+			return v.EndElement(tok.Name)
+		}
+	case xml.CharData:
+		if v.CharData != nil {
+			return v.CharData(string(tok))
+		}
+	}
+	return nil
+}
+
+func (v *ManifestVisitor) Flush() error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package apkparser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Returned (wrapped) when the data ends in the middle of a chunk or another structure. Such errors
+// also match the io.EOF or io.ErrUnexpectedEOF the reading failed with.
+var ErrTruncatedChunk = errors.New("truncated chunk")
+
+// Returned (wrapped) when a string index points outside of the string pool.
+var ErrBadStringIndex = errors.New("bad string index")
+
+// Returned (wrapped) for chunks the parser can't skip, e.g. unknown chunks in binary XML.
+type UnknownChunkError struct {
+	Id uint16
+}
+
+func (e *UnknownChunkError) Error() string {
+	return fmt.Sprintf("Unknown chunk id 0x%x", e.Id)
+}
+
+// Returned when a file of the APK is missing (Err is os.ErrNotExist) or fails to open or parse.
+// A missing resources.arsc is reported as plain os.ErrNotExist.
+type ZipEntryError struct {
+	Name string
+	Err  error
+}
+
+func (e *ZipEntryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, e.Err.Error())
+}
+
+func (e *ZipEntryError) Unwrap() error {
+	return e.Err
+}
+
+// Returned by the typed getters of ResourceEntry, like Int, when the value has another type.
+type ValueTypeError struct {
+	// Expected type, e.g. "int" or "color".
+	Expected string
+	// Actual type of the value, not meaningful if Complex is true.
+	Type AttrType
+	// The entry is complex, i.e. its values are in the bag.
+	Complex bool
+}
+
+func (e *ValueTypeError) Error() string {
+	if e.Complex {
+		return fmt.Sprintf("Complex resource entry is not %s.", e.Expected)
+	}
+	return fmt.Sprintf("Resource value of type 0x%02x is not %s.", uint8(e.Type), e.Expected)
+}
+
+// Error matching one of the sentinel errors above, keeping the message and the wrapped error.
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
+
+// Marks errors of reads which hit the end of data as ErrTruncatedChunk, returns other errors as they are.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &classifiedError{class: ErrTruncatedChunk, err: err}
+	}
+	return err
+}
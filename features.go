@@ -0,0 +1,109 @@
+package apkparser
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Hardware or software feature declared with <uses-feature>.
+type UsesFeature struct {
+	// For example "android.hardware.camera", empty for OpenGL ES version requirements.
+	Name string
+	// Required OpenGL ES version like "3.1", empty for named features.
+	GlEsVersion string
+	// Feature version from android:version, empty if not set.
+	Version string
+	// False if declared with android:required="false", i.e. the app can work without it.
+	Required bool
+	// 1-based index of the <feature-group> the feature is declared in, 0 if it is not in any.
+	// The app needs all required features of at least one group.
+	FeatureGroup int
+}
+
+// Shared library declared with <uses-library> or <uses-native-library>.
+type UsesLibrary struct {
+	Name string
+	// True for <uses-native-library>, i.e. a native library provided by the vendor.
+	Native bool
+	// False if declared with android:required="false".
+	Required bool
+}
+
+// Features and libraries the app depends on, see ApkParser.UsesReport.
+type UsesReport struct {
+	Features  []*UsesFeature
+	Libraries []*UsesLibrary
+}
+
+// Returns the features and shared libraries declared in the manifest, with resource references
+// resolved by the resources of the APK.
+func (p *ApkParser) UsesReport() (*UsesReport, error) {
+	manifest, err := p.ParseManifestTree()
+	if err != nil {
+		return nil, err
+	}
+	return NewUsesReport(manifest), nil
+}
+
+// Returns the features and shared libraries declared in the manifest tree, for example
+// the one returned by MergeSplitManifests.
+func NewUsesReport(manifest *ManifestElement) *UsesReport {
+	res := &UsesReport{}
+	for _, feature := range manifest.ChildrenNamed("uses-feature") {
+		res.Features = append(res.Features, newUsesFeature(feature, 0))
+	}
+
+	for i, group := range manifest.ChildrenNamed("feature-group") {
+		for _, feature := range group.ChildrenNamed("uses-feature") {
+			res.Features = append(res.Features, newUsesFeature(feature, i+1))
+		}
+	}
+
+	for _, app := range manifest.ChildrenNamed("application") {
+		for _, c := range app.Children {
+			if c.Name.Local != "uses-library" && c.Name.Local != "uses-native-library" {
+				continue
+			}
+
+			res.Libraries = append(res.Libraries, &UsesLibrary{
+				Name:     c.AndroidAttr("name"),
+				Native:   c.Name.Local == "uses-native-library",
+				Required: c.AndroidAttr("required") != "false",
+			})
+		}
+	}
+	return res
+}
+
+// Returns true if the feature is declared as required outside of feature groups.
+func (r *UsesReport) RequiresFeature(name string) bool {
+	for _, f := range r.Features {
+		if f.Name == name && f.Required && f.FeatureGroup == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func newUsesFeature(e *ManifestElement, group int) *UsesFeature {
+	res := &UsesFeature{
+		Name:         e.AndroidAttr("name"),
+		Version:      e.AndroidAttr("version"),
+		Required:     e.AndroidAttr("required") != "false",
+		FeatureGroup: group,
+	}
+
+	if version := e.AndroidAttr("glEsVersion"); version != "" {
+		res.GlEsVersion = formatGlEsVersion(version)
+	}
+	return res
+}
+
+// The version is an integer with the major version in the upper 16 bits, e.g. 0x00030001 is 3.1.
+func formatGlEsVersion(value string) string {
+	v, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return value
+	}
+	return fmt.Sprintf("%d.%d", v>>16, v&0xFFFF)
+}
@@ -0,0 +1,195 @@
+package apkparser
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// App framework, see ZipReader.DetectFrameworks.
+type FrameworkKind int
+
+const (
+	FrameworkFlutter FrameworkKind = iota
+	FrameworkReactNative
+	FrameworkUnity
+	FrameworkCordova
+	FrameworkXamarin
+	FrameworkKotlin
+	FrameworkCompose // Jetpack Compose
+)
+
+func (k FrameworkKind) String() string {
+	switch k {
+	case FrameworkFlutter:
+		return "flutter"
+	case FrameworkReactNative:
+		return "react-native"
+	case FrameworkUnity:
+		return "unity"
+	case FrameworkCordova:
+		return "cordova"
+	case FrameworkXamarin:
+		return "xamarin"
+	case FrameworkKotlin:
+		return "kotlin"
+	case FrameworkCompose:
+		return "compose"
+	default:
+		return fmt.Sprintf("FrameworkKind(%d)", int(k))
+	}
+}
+
+// Framework the app is built with and what it was detected by.
+type FrameworkHit struct {
+	Kind FrameworkKind
+	// Sorted entries, entry prefixes like "assets/flutter_assets/" and, when detected from the manifest,
+	// class names of the components and meta-data names.
+	Evidence []string
+}
+
+type frameworkRule struct {
+	kind     FrameworkKind
+	libs     []string // native libraries in lib/<abi>/
+	names    []string // whole entry names
+	prefixes []string
+	suffixes []string
+	classes  []string // prefixes of the names of the manifest components and meta-data
+}
+
+var frameworkRules = []frameworkRule{
+	{
+		kind:     FrameworkFlutter,
+		libs:     []string{"libflutter.so"},
+		prefixes: []string{"assets/flutter_assets/"},
+		classes:  []string{"io.flutter."},
+	},
+	{
+		kind:    FrameworkReactNative,
+		libs:    []string{"libreactnativejni.so", "libreact_nativemodule_core.so", "libhermes.so"},
+		names:   []string{"assets/index.android.bundle"},
+		classes: []string{"com.facebook.react."},
+	},
+	{
+		kind:     FrameworkUnity,
+		libs:     []string{"libunity.so", "libil2cpp.so"},
+		prefixes: []string{"assets/bin/Data/"},
+		classes:  []string{"com.unity3d."},
+	},
+	{
+		kind:    FrameworkCordova,
+		names:   []string{"assets/www/cordova.js", "assets/www/cordova_plugins.js"},
+		classes: []string{"org.apache.cordova."},
+	},
+	{
+		kind:     FrameworkXamarin,
+		libs:     []string{"libmonodroid.so", "libmonosgen-2.0.so", "libxamarin-app.so"},
+		prefixes: []string{"assemblies/"},
+		classes:  []string{"mono.", "crc64"}, // Java wrappers of .NET classes are in crc64<hash> packages
+	},
+	{
+		kind:     FrameworkKotlin,
+		prefixes: []string{"kotlin/"},
+		suffixes: []string{".kotlin_module", ".kotlin_builtins"},
+	},
+	{
+		kind:     FrameworkCompose,
+		prefixes: []string{"META-INF/androidx.compose."},
+		classes:  []string{"androidx.compose."},
+	},
+}
+
+// Detects frameworks the app is built with from characteristic entries and native libraries,
+// sorted by kind. See ApkParser.DetectFrameworks, which checks the manifest too.
+func (zr *ZipReader) DetectFrameworks() []FrameworkHit {
+	return detectFrameworks(zr, nil)
+}
+
+// Same as ZipReader.DetectFrameworks, but also looks for components and meta-data of the frameworks
+// in the manifest.
+func (p *ApkParser) DetectFrameworks() ([]FrameworkHit, error) {
+	manifest, err := p.ParseManifestTree()
+	if err != nil {
+		return nil, err
+	}
+	return detectFrameworks(p.zip, manifest), nil
+}
+
+func detectFrameworks(zr *ZipReader, manifest *ManifestElement) []FrameworkHit {
+	evidence := make(map[FrameworkKind]map[string]bool)
+	add := func(kind FrameworkKind, what string) {
+		if evidence[kind] == nil {
+			evidence[kind] = make(map[string]bool)
+		}
+		evidence[kind][what] = true
+	}
+
+	for name, f := range zr.File {
+		if f.IsDir {
+			continue
+		}
+
+		isLib := strings.HasPrefix(name, "lib/") && strings.Count(name, "/") == 2
+		for i := range frameworkRules {
+			rule := &frameworkRules[i]
+			if isLib && containsString(rule.libs, path.Base(name)) {
+				add(rule.kind, "lib/*/"+path.Base(name))
+			}
+			if containsString(rule.names, name) {
+				add(rule.kind, name)
+			}
+			for _, prefix := range rule.prefixes {
+				if strings.HasPrefix(name, prefix) {
+					add(rule.kind, prefix)
+				}
+			}
+			for _, suffix := range rule.suffixes {
+				if strings.HasSuffix(name, suffix) {
+					add(rule.kind, name)
+				}
+			}
+		}
+	}
+
+	if manifest != nil {
+		for _, app := range manifest.ChildrenNamed("application") {
+			classes := []string{app.AndroidAttr("name")}
+			for _, c := range app.Children {
+				classes = append(classes, c.AndroidAttr("name"))
+			}
+
+			for _, class := range classes {
+				for i := range frameworkRules {
+					for _, prefix := range frameworkRules[i].classes {
+						if class != "" && strings.HasPrefix(class, prefix) {
+							add(frameworkRules[i].kind, class)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	var res []FrameworkHit
+	for kind, what := range evidence {
+		hit := FrameworkHit{Kind: kind}
+		for w := range what {
+			hit.Evidence = append(hit.Evidence, w)
+		}
+		sort.Strings(hit.Evidence)
+		res = append(res, hit)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Kind < res[j].Kind })
+	return res
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
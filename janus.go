@@ -0,0 +1,79 @@
+package apkparser
+
+import (
+	"encoding/binary"
+	"hash/adler32"
+	"io"
+)
+
+// DEX at the start of a file which is a valid ZIP at the same time, the polyglot of the Janus
+// vulnerability (CVE-2017-13156). Android before 8.0 verified only the v1 signature of the ZIP part,
+// while the runtime executed the DEX part.
+type JanusDex struct {
+	// Span of the DEX in the file, it always starts at offset 0. The size is from the DEX header.
+	Span ZipByteRange
+	// True if the Adler-32 checksum from the header matches, which the runtime requires.
+	ChecksumOk bool
+}
+
+const dexHeaderSize = 0x70
+
+// Returns the DEX part if the reader is both a DEX and a ZIP, nil if it is not such polyglot.
+func FindJanusDex(r io.ReadSeeker) (res *JanusDex, err error) {
+	defer recoverPanic(&err)
+
+	f := &readAtWrapper{r}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, ok := findCentralDirectory(f, size); !ok {
+		return nil, nil
+	}
+	return findJanusDex(f, size)
+}
+
+// Same as FindJanusDex for the reader the ZIP was opened from. Must not be used after the ZipReader is closed.
+func (zr *ZipReader) JanusDex() (res *JanusDex, err error) {
+	defer recoverPanic(&err)
+
+	f := zr.outerReader
+	if f == nil {
+		f = &readAtWrapper{zr.zipFileReader}
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	return findJanusDex(f, size)
+}
+
+func findJanusDex(f *readAtWrapper, size int64) (*JanusDex, error) {
+	if size < dexHeaderSize {
+		return nil, nil
+	}
+
+	var hdr [dexHeaderSize]byte
+	if _, err := f.ReadAt(hdr[:], 0); err != nil {
+		return nil, err
+	}
+
+	fileSize := int64(binary.LittleEndian.Uint32(hdr[32:]))
+	if !dexMagicRe.Match(hdr[:8]) || binary.LittleEndian.Uint32(hdr[36:]) != dexHeaderSize ||
+		binary.LittleEndian.Uint32(hdr[40:]) != 0x12345678 || fileSize < dexHeaderSize || fileSize > size {
+		return nil, nil
+	}
+
+	// The checksum covers everything after itself.
+	h := adler32.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 12, fileSize-12)); err != nil {
+		return nil, err
+	}
+
+	return &JanusDex{
+		Span:       ZipByteRange{Offset: 0, Size: fileSize},
+		ChecksumOk: h.Sum32() == binary.LittleEndian.Uint32(hdr[8:]),
+	}, nil
+}
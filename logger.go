@@ -0,0 +1,58 @@
+package apkparser
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+)
+
+// Receives what the parser recovered from or left out, see ParseOptions.Logger. The methods are called
+// from the parsing goroutine.
+type Logger interface {
+	// Called with each recovered anomaly, the same ones ParseOptions.Warnings and ZipReader.Warnings get.
+	Warning(w Warning)
+	// Called when size bytes of the input are skipped without being parsed, e.g. an unknown chunk.
+	Skipped(what string, size int64)
+}
+
+type stdLogger struct {
+	l      *log.Logger
+	prefix string
+}
+
+// Returns a Logger printing to l, or to the standard logger if l is nil. The prefix, e.g. a hash
+// of the parsed sample, starts every line.
+func NewStdLogger(l *log.Logger, prefix string) Logger {
+	if l == nil {
+		l = log.Default()
+	}
+	return &stdLogger{l: l, prefix: prefix}
+}
+
+func (s *stdLogger) Warning(w Warning) {
+	s.l.Printf("%swarning: %s", s.prefix, w.String())
+}
+
+func (s *stdLogger) Skipped(what string, size int64) {
+	s.l.Printf("%sskipped %d bytes: %s", s.prefix, size, what)
+}
+
+func (o *ParseOptions) logger() Logger {
+	if o == nil {
+		return nil
+	}
+	return o.Logger
+}
+
+// Discards the rest of r and reports it to the Logger.
+func (o *ParseOptions) skip(r *io.LimitedReader, format string, args ...interface{}) error {
+	if l := o.logger(); l != nil && r.N > 0 {
+		l.Skipped(fmt.Sprintf(format, args...), r.N)
+	}
+	if s := o.stats(); s != nil {
+		s.BytesSkipped += r.N
+	}
+	_, err := io.CopyN(ioutil.Discard, r, r.N)
+	return err
+}
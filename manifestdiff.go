@@ -0,0 +1,154 @@
+package apkparser
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+)
+
+// Kind of a ManifestChange.
+type ManifestChangeKind int
+
+const (
+	ElementAdded ManifestChangeKind = iota
+	ElementRemoved
+	AttrAdded
+	AttrRemoved
+	AttrChanged
+)
+
+func (k ManifestChangeKind) String() string {
+	switch k {
+	case ElementAdded:
+		return "element added"
+	case ElementRemoved:
+		return "element removed"
+	case AttrAdded:
+		return "attribute added"
+	case AttrRemoved:
+		return "attribute removed"
+	case AttrChanged:
+		return "attribute changed"
+	default:
+		return fmt.Sprintf("ManifestChangeKind(%d)", int(k))
+	}
+}
+
+// One difference between two manifests, see DiffManifests.
+type ManifestChange struct {
+	Kind ManifestChangeKind
+	// Path of the element, like "/manifest/application/activity[com.example.MainActivity]". Elements are
+	// identified by their android:name, unnamed ones by their order among unnamed siblings
+	// of the same tag, like "/manifest/uses-feature#0". Elements present only once, like <application>,
+	// by just their tag.
+	Path string
+	// Attribute name like "android:exported" or "package", empty for element changes.
+	Attr string
+	// Value in the first manifest, empty for added attributes and element changes.
+	Old string
+	// Value in the second manifest, empty for removed attributes and element changes.
+	New string
+}
+
+// Elements present only once in a manifest, identified just by their tag.
+var diffSingletonTags = map[string]bool{
+	"application":        true,
+	"uses-sdk":           true,
+	"supports-screens":   true,
+	"compatible-screens": true,
+}
+
+// Returns structural differences between two manifest trees, sorted by path and attribute name.
+// Attributes of added or removed elements are not listed, text of the elements is ignored.
+func DiffManifests(a, b *ManifestElement) []ManifestChange {
+	flatA, flatB := make(map[string]map[string]string), make(map[string]map[string]string)
+	if a != nil {
+		a.flattenForDiff("", a.Name.Local, flatA)
+	}
+	if b != nil {
+		b.flattenForDiff("", b.Name.Local, flatB)
+	}
+
+	paths := make([]string, 0, len(flatA)+len(flatB))
+	for p := range flatA {
+		paths = append(paths, p)
+	}
+	for p := range flatB {
+		if _, prs := flatA[p]; !prs {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var res []ManifestChange
+	for _, p := range paths {
+		attrsA, inA := flatA[p]
+		attrsB, inB := flatB[p]
+		switch {
+		case !inB:
+			res = append(res, ManifestChange{Kind: ElementRemoved, Path: p})
+		case !inA:
+			res = append(res, ManifestChange{Kind: ElementAdded, Path: p})
+		default:
+			res = append(res, diffAttrs(p, attrsA, attrsB)...)
+		}
+	}
+	return res
+}
+
+func diffAttrs(path string, a, b map[string]string) []ManifestChange {
+	var res []ManifestChange
+	for name, valA := range a {
+		if valB, prs := b[name]; !prs {
+			res = append(res, ManifestChange{Kind: AttrRemoved, Path: path, Attr: name, Old: valA})
+		} else if valA != valB {
+			res = append(res, ManifestChange{Kind: AttrChanged, Path: path, Attr: name, Old: valA, New: valB})
+		}
+	}
+	for name, valB := range b {
+		if _, prs := a[name]; !prs {
+			res = append(res, ManifestChange{Kind: AttrAdded, Path: path, Attr: name, New: valB})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Attr < res[j].Attr
+	})
+	return res
+}
+
+// Flattens the tree to path -> attributes, the element is stored under key in its parent's path.
+func (e *ManifestElement) flattenForDiff(prefix, key string, res map[string]map[string]string) {
+	path := prefix + "/" + key
+
+	attrs := make(map[string]string, len(e.Attr))
+	for _, a := range e.Attr {
+		attrs[diffAttrName(a.Name)] = a.Value
+	}
+	res[path] = attrs
+
+	unnamed := make(map[string]int)
+	for _, c := range e.Children {
+		var childKey string
+		if diffSingletonTags[c.Name.Local] {
+			childKey = c.Name.Local
+		} else if name := c.findAttr(androidNamespace, "name"); name != nil {
+			childKey = fmt.Sprintf("%s[%s]", c.Name.Local, name.Value)
+		} else {
+			childKey = fmt.Sprintf("%s#%d", c.Name.Local, unnamed[c.Name.Local])
+			unnamed[c.Name.Local]++
+		}
+		c.flattenForDiff(path, childKey, res)
+	}
+}
+
+func diffAttrName(name xml.Name) string {
+	switch name.Space {
+	case "":
+		return name.Local
+	case androidNamespace:
+		return "android:" + name.Local
+	default:
+		return name.Space + ":" + name.Local
+	}
+}
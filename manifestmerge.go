@@ -0,0 +1,80 @@
+package apkparser
+
+// Application components, which feature splits add to the installed app.
+var mergedComponents = map[string]bool{
+	"activity":       true,
+	"activity-alias": true,
+	"service":        true,
+	"receiver":       true,
+	"provider":       true,
+}
+
+// Returns the logical manifest of the app installed from base and the split APKs,
+// the inputs are not modified.
+//
+// The components of the splits' <application> are added unless the base already declares
+// a component with the same name, <uses-feature> and <uses-permission> elements are united.
+// A feature is required if any of the manifests requires it. The rest of the split manifests,
+// including the attributes of their root and <application> elements, is ignored.
+func MergeSplitManifests(base *ManifestElement, splits ...*ManifestElement) *ManifestElement {
+	res := base.clone()
+
+	app := res.Child("application")
+	for _, split := range splits {
+		for _, c := range split.Children {
+			switch c.Name.Local {
+			case "uses-feature":
+				mergeUsesFeature(res, c)
+			case "uses-permission", "uses-permission-sdk-23":
+				if findManifestChild(res, c.Name.Local, "name", c.AndroidAttr("name")) == nil {
+					res.Children = append(res.Children, c.clone())
+				}
+			case "application":
+				if app == nil {
+					app = &ManifestElement{Name: c.Name}
+					res.Children = append(res.Children, app)
+				}
+
+				for _, component := range c.Children {
+					if !mergedComponents[component.Name.Local] {
+						continue
+					}
+
+					if findManifestChild(app, component.Name.Local, "name", component.AndroidAttr("name")) == nil {
+						app.Children = append(app.Children, component.clone())
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+func mergeUsesFeature(manifest, feature *ManifestElement) {
+	var existing *ManifestElement
+	if name := feature.AndroidAttr("name"); name != "" {
+		existing = findManifestChild(manifest, "uses-feature", "name", name)
+	} else if version := feature.AndroidAttr("glEsVersion"); version != "" {
+		existing = findManifestChild(manifest, "uses-feature", "glEsVersion", version)
+	}
+
+	if existing == nil {
+		manifest.Children = append(manifest.Children, feature.clone())
+		return
+	}
+
+	if feature.AndroidAttr("required") != "false" {
+		if a := existing.findAttr(androidNamespace, "required"); a != nil {
+			a.Value = "true"
+		}
+	}
+}
+
+func findManifestChild(parent *ManifestElement, name, attr, value string) *ManifestElement {
+	for _, c := range parent.Children {
+		if c.Name.Local == name && c.AndroidAttr(attr) == value {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package apkparser
+
+import (
+	"strings"
+)
+
+// One <meta-data> element from the manifest.
+type MetaData struct {
+	Name string
+	// Resolved android:value, empty if not set.
+	Value string
+	// Resolved android:resource, for XML resources the path like "res/xml/file_paths.xml".
+	// Unresolved references are left in the "@7f0b0001" form.
+	Resource string
+
+	// The parent element, "application" or the component like "activity", "service" or "provider".
+	ComponentType string
+	// android:name of the parent component, empty for <application>.
+	Component string
+
+	// The XML file referenced by Resource, set only when decoding was requested and Resource
+	// is an .xml file in the APK, or any binary XML file with ParseOptions.SniffResourceFiles.
+	Xml *ManifestElement
+	// Error from decoding the Xml.
+	XmlErr error
+}
+
+// Returns all <meta-data> of the application and its components, in the manifest order. If decodeXml
+// is true, XML files referenced by android:resource (FileProvider paths, app shortcuts, configs
+// of various SDKs...) are decoded too.
+func (p *ApkParser) MetaData(decodeXml bool) ([]*MetaData, error) {
+	manifest, err := p.ParseManifestTree()
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*MetaData
+	for _, app := range manifest.ChildrenNamed("application") {
+		for _, c := range app.Children {
+			if c.Name.Local == "meta-data" {
+				res = append(res, p.newMetaData(c, app, decodeXml))
+				continue
+			}
+
+			for _, m := range c.ChildrenNamed("meta-data") {
+				res = append(res, p.newMetaData(m, c, decodeXml))
+			}
+		}
+	}
+	return res, nil
+}
+
+func (p *ApkParser) newMetaData(e, parent *ManifestElement, decodeXml bool) *MetaData {
+	res := &MetaData{
+		Name:          e.AndroidAttr("name"),
+		Value:         e.AndroidAttr("value"),
+		Resource:      e.AndroidAttr("resource"),
+		ComponentType: parent.Name.Local,
+	}
+
+	if parent.Name.Local != "application" {
+		res.Component = parent.AndroidAttr("name")
+	}
+
+	if f := p.zip.File[res.Resource]; decodeXml && f != nil {
+		if strings.HasSuffix(res.Resource, ".xml") || (p.opts.isSniffResourceFiles() && sniffFileFormat(f) == "xml") {
+			res.Xml, res.XmlErr = p.ParseXmlTree(res.Resource)
+		}
+	}
+	return res
+}
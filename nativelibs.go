@@ -0,0 +1,144 @@
+package apkparser
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Native library from lib/ of the APK, see ZipReader.NativeLibs.
+type NativeLib struct {
+	// Path in the APK, like "lib/arm64-v8a/libnative.so".
+	Path string
+	// ABI directory the library is in, like "arm64-v8a", empty if it is not in one.
+	Abi string
+
+	// Architecture from the ELF header in the form of the ABI names, "arm", "arm64", "x86", "x86_64",
+	// "mips", "mips64" or "riscv64". Other machines are named by debug/elf, like "EM_PPC".
+	Arch string
+	// 32 or 64.
+	Bits int
+	// True if Arch doesn't belong to the Abi directory, which the installer would reject or which hides
+	// code for a different device than it appears.
+	AbiMismatch bool
+
+	// DT_SONAME, empty if not set.
+	Soname string
+	// DT_NEEDED libraries in the order of the dynamic section.
+	Needed []string
+	// True if the library has no .symtab section.
+	Stripped bool
+
+	// Error reading or parsing the file.
+	Err error
+}
+
+// Architectures of the ABI directories Android knows.
+var abiArchs = map[string]string{
+	"armeabi":     "arm",
+	"armeabi-v7a": "arm",
+	"arm64-v8a":   "arm64",
+	"x86":         "x86",
+	"x86_64":      "x86_64",
+	"mips":        "mips",
+	"mips64":      "mips64",
+	"riscv64":     "riscv64",
+}
+
+// Inspects ELF headers of all files in lib/, sorted by path. Files larger than maxSize bytes
+// are not parsed and have ErrZipEntryTooLarge set, 0 or less means no limit. Nothing is written to disk.
+func (zr *ZipReader) NativeLibs(maxSize int64) []*NativeLib {
+	var res []*NativeLib
+	for name, f := range zr.File {
+		if f.IsDir || !strings.HasPrefix(name, "lib/") {
+			continue
+		}
+
+		lib := &NativeLib{Path: name}
+		if parts := strings.Split(name, "/"); len(parts) == 3 {
+			lib.Abi = parts[1]
+		}
+
+		lib.Err = lib.parse(f, maxSize)
+		res = append(res, lib)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Path < res[j].Path
+	})
+	return res
+}
+
+func (lib *NativeLib) parse(f *ZipReaderFile, maxSize int64) (err error) {
+	defer recoverPanic(&err)
+
+	limit := int64(math.MaxInt64)
+	if maxSize > 0 {
+		limit = maxSize + 1
+	}
+
+	data, err := f.ReadAll(limit)
+	if err != nil {
+		return err
+	} else if maxSize > 0 && int64(len(data)) > maxSize {
+		return ErrZipEntryTooLarge
+	}
+	return lib.parseElf(data)
+}
+
+func (lib *NativeLib) parseElf(data []byte) error {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lib.Bits = 32
+	if f.Class == elf.ELFCLASS64 {
+		lib.Bits = 64
+	}
+	lib.Arch = elfArch(f.Machine, lib.Bits)
+	if arch, prs := abiArchs[lib.Abi]; prs {
+		lib.AbiMismatch = arch != lib.Arch
+	}
+
+	if f.Section(".dynamic") != nil {
+		if sonames, err := f.DynString(elf.DT_SONAME); err != nil {
+			return fmt.Errorf("Failed to read DT_SONAME: %w", err)
+		} else if len(sonames) != 0 {
+			lib.Soname = sonames[0]
+		}
+
+		if lib.Needed, err = f.DynString(elf.DT_NEEDED); err != nil {
+			return fmt.Errorf("Failed to read DT_NEEDED: %w", err)
+		}
+	}
+
+	lib.Stripped = f.Section(".symtab") == nil
+	return nil
+}
+
+func elfArch(machine elf.Machine, bits int) string {
+	switch machine {
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_AARCH64:
+		return "arm64"
+	case elf.EM_386:
+		return "x86"
+	case elf.EM_X86_64:
+		return "x86_64"
+	case elf.EM_MIPS:
+		if bits == 64 {
+			return "mips64"
+		}
+		return "mips"
+	case elf.EM_RISCV:
+		return "riscv64"
+	default:
+		return machine.String()
+	}
+}
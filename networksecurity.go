@@ -0,0 +1,148 @@
+package apkparser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Parsed res/xml/network_security_config.xml, see
+// https://developer.android.com/training/articles/security-config
+type NetworkSecurityConfig struct {
+	BaseConfig     *NetworkSecurityBaseConfig     `xml:"base-config"`
+	DomainConfigs  []*NetworkSecurityDomainConfig `xml:"domain-config"`
+	DebugOverrides *NetworkSecurityBaseConfig     `xml:"debug-overrides"`
+}
+
+// The <base-config> and <debug-overrides> elements.
+type NetworkSecurityBaseConfig struct {
+	// nil if not specified
+	CleartextTrafficPermitted *bool `xml:"cleartextTrafficPermitted,attr"`
+
+	TrustAnchors []NetworkSecurityCertificates `xml:"trust-anchors>certificates"`
+}
+
+// The <domain-config> element, which can be nested.
+type NetworkSecurityDomainConfig struct {
+	NetworkSecurityBaseConfig
+
+	Domains       []NetworkSecurityDomain        `xml:"domain"`
+	PinSet        *NetworkSecurityPinSet         `xml:"pin-set"`
+	DomainConfigs []*NetworkSecurityDomainConfig `xml:"domain-config"`
+}
+
+type NetworkSecurityDomain struct {
+	Name              string `xml:",chardata"`
+	IncludeSubdomains bool   `xml:"includeSubdomains,attr"`
+}
+
+type NetworkSecurityCertificates struct {
+	// "system", "user" or the path of the certificate file in the APK
+	Src          string `xml:"src,attr"`
+	OverridePins bool   `xml:"overridePins,attr"`
+}
+
+type NetworkSecurityPinSet struct {
+	Expiration string               `xml:"expiration,attr"`
+	Pins       []NetworkSecurityPin `xml:"pin"`
+}
+
+type NetworkSecurityPin struct {
+	Digest string `xml:"digest,attr"`
+	// Base64 encoded digest of the SubjectPublicKeyInfo
+	Value string `xml:",chardata"`
+}
+
+// Parses binary network security config XML, resources are used to resolve
+// certificate references and can be nil.
+func ParseNetworkSecurityConfig(r io.Reader, resources *ResourceTable) (*NetworkSecurityConfig, error) {
+	var buf bytes.Buffer
+	if err := ParseXml(r, xml.NewEncoder(&buf), resources); err != nil {
+		return nil, err
+	}
+	return decodeNetworkSecurityConfig(buf.Bytes())
+}
+
+// Parses the network security config referenced from the manifest's android:networkSecurityConfig.
+// Returns nil and no error if the manifest doesn't reference any.
+func (p *ApkParser) ParseNetworkSecurityConfig() (*NetworkSecurityConfig, error) {
+	path, err := p.networkSecurityConfigPath()
+	if err != nil || path == "" {
+		return nil, err
+	}
+
+	if strings.HasPrefix(path, "@") {
+		return nil, fmt.Errorf("Failed to resolve network security config reference %s", path)
+	}
+
+	var buf bytes.Buffer
+	if err := p.parseXmlWith(path, xml.NewEncoder(&buf)); err != nil {
+		return nil, err
+	}
+	return decodeNetworkSecurityConfig(buf.Bytes())
+}
+
+// Returns the resolved value of android:networkSecurityConfig of <application>.
+func (p *ApkParser) networkSecurityConfigPath() (string, error) {
+	finder := &networkSecurityPathFinder{}
+	if err := p.parseXmlWith("AndroidManifest.xml", finder); err != nil {
+		return "", err
+	}
+	return finder.path, nil
+}
+
+// ManifestEncoder looking for android:networkSecurityConfig. The typed ManifestVisitor would give
+// just the resource id, the XML tokens have the resolved value.
+type networkSecurityPathFinder struct {
+	depth int
+	path  string
+}
+
+func (f *networkSecurityPathFinder) EncodeToken(t xml.Token) error {
+	switch tok := t.(type) {
+	case xml.StartElement:
+		f.depth++
+		if f.depth != 2 || tok.Name.Local != "application" {
+			break
+		}
+
+		for _, a := range tok.Attr {
+			if a.Name.Space == androidNamespace && a.Name.Local == "networkSecurityConfig" {
+				f.path = a.Value
+			}
+		}
+	case xml.EndElement:
+		f.depth--
+	}
+	return nil
+}
+
+func (f *networkSecurityPathFinder) Flush() error {
+	return nil
+}
+
+func decodeNetworkSecurityConfig(data []byte) (*NetworkSecurityConfig, error) {
+	var res NetworkSecurityConfig
+	if err := xml.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("Failed to decode network security config: %s", err.Error())
+	}
+
+	var trimDomains func(configs []*NetworkSecurityDomainConfig)
+	trimDomains = func(configs []*NetworkSecurityDomainConfig) {
+		for _, c := range configs {
+			for i := range c.Domains {
+				c.Domains[i].Name = strings.TrimSpace(c.Domains[i].Name)
+			}
+			if c.PinSet != nil {
+				for i := range c.PinSet.Pins {
+					c.PinSet.Pins[i].Value = strings.TrimSpace(c.PinSet.Pins[i].Value)
+				}
+			}
+			trimDomains(c.DomainConfigs)
+		}
+	}
+	trimDomains(res.DomainConfigs)
+	return &res, nil
+}
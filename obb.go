@@ -0,0 +1,179 @@
+package apkparser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// frameworks/base/libs/androidfw/ObbFile.cpp
+const (
+	obbSignature     = 0x01059983
+	obbSigVersion    = 1
+	obbFooterTagSize = 8 // footer size and signature at the very end
+	obbFooterMinSize = 33
+	obbMaxFooterSize = 32768
+)
+
+// Flags of ObbInfo.
+const (
+	ObbFlagOverlay = 1 << 0
+	ObbFlagSalted  = 1 << 1
+)
+
+// Footer appended to expansion files by obbtool.
+type ObbInfo struct {
+	PackageName string
+	Version     int32
+	Flags       uint32
+	Salt        [8]byte
+}
+
+// Name of an APK expansion file, like "main.12.com.example.app.obb".
+type ObbFileName struct {
+	// True for the main expansion file, false for the patch one.
+	Main bool
+	// versionCode of the APK the file was first published with, which can be older than the installed one.
+	VersionCode int
+	Package     string
+}
+
+// Opens an APK expansion file. These are ZIPs, often huge with uncompressed entries, possibly with
+// the obbtool footer appended, which is returned as info or nil if there's none. The ZipReader has
+// no files for OBBs which are not ZIPs, like the FAT images made by obbtool.
+func OpenObb(path string) (zr *ZipReader, info *ObbInfo, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	zr, info, err = OpenObbReader(f)
+	if err != nil {
+		f.Close()
+	} else {
+		zr.ownedZipFile = f
+	}
+	return
+}
+
+// Same as OpenObb, but reads from r. Might Seek the reader to arbitrary positions.
+func OpenObbReader(r io.ReadSeeker) (zr *ZipReader, info *ObbInfo, err error) {
+	defer recoverPanic(&err)
+
+	f := &readAtWrapper{r}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, end, err := readObbInfo(f, size)
+	if err != nil {
+		return nil, nil, err
+	} else if info == nil {
+		zr, err = OpenZipReader(r)
+		return zr, nil, err
+	}
+
+	// The footer is left out, so that the ZIP reader doesn't have to skip it.
+	if zr, err = OpenZipReader(io.NewSectionReader(f, 0, end)); err != nil {
+		return nil, nil, err
+	}
+	zr.outerReader = f
+	return zr, info, nil
+}
+
+// Returns the obbtool footer and the offset it starts at, or nil and size if there's none.
+func readObbInfo(f *readAtWrapper, size int64) (*ObbInfo, int64, error) {
+	if size < obbFooterMinSize {
+		return nil, size, nil
+	}
+
+	var tag [obbFooterTagSize]byte
+	if _, err := f.ReadAt(tag[:], size-obbFooterTagSize); err != nil {
+		return nil, size, err
+	}
+
+	if binary.LittleEndian.Uint32(tag[4:]) != obbSignature {
+		return nil, size, nil
+	}
+
+	footerSize := int64(binary.LittleEndian.Uint32(tag[:]))
+	if footerSize < obbFooterMinSize-obbFooterTagSize || footerSize > obbMaxFooterSize || footerSize > size-obbFooterTagSize {
+		return nil, size, fmt.Errorf("Invalid OBB footer size %d.", footerSize)
+	}
+
+	start := size - obbFooterTagSize - footerSize
+	buf := make([]byte, footerSize)
+	if _, err := f.ReadAt(buf, start); err != nil {
+		return nil, size, fmt.Errorf("Failed to read OBB footer: %s", err.Error())
+	}
+
+	if v := binary.LittleEndian.Uint32(buf); v != obbSigVersion {
+		return nil, size, fmt.Errorf("Unsupported OBB footer version %d.", v)
+	}
+
+	nameLen := int64(binary.LittleEndian.Uint32(buf[20:]))
+	if nameLen == 0 || 24+nameLen > footerSize {
+		return nil, size, fmt.Errorf("Invalid OBB package name length %d.", nameLen)
+	}
+
+	info := &ObbInfo{
+		PackageName: string(buf[24 : 24+nameLen]),
+		Version:     int32(binary.LittleEndian.Uint32(buf[4:])),
+		Flags:       binary.LittleEndian.Uint32(buf[8:]),
+	}
+	copy(info.Salt[:], buf[12:20])
+	return info, start, nil
+}
+
+// Parses expansion file name like "patch.12.com.example.app.obb", optionally with a directory.
+func ParseObbFileName(name string) (ObbFileName, bool) {
+	parts := strings.SplitN(strings.TrimSuffix(path.Base(name), ".obb"), ".", 3)
+	if len(parts) != 3 || !strings.HasSuffix(name, ".obb") || (parts[0] != "main" && parts[0] != "patch") || parts[2] == "" {
+		return ObbFileName{}, false
+	}
+
+	version, err := strconv.Atoi(parts[1])
+	if err != nil || version < 0 {
+		return ObbFileName{}, false
+	}
+	return ObbFileName{Main: parts[0] == "main", VersionCode: version, Package: parts[2]}, true
+}
+
+func (n ObbFileName) String() string {
+	kind := "patch"
+	if n.Main {
+		kind = "main"
+	}
+	return fmt.Sprintf("%s.%d.%s.obb", kind, n.VersionCode, n.Package)
+}
+
+// Returns the path of the file relative to the shared storage, e.g. "Android/obb/com.example.app/main.12.com.example.app.obb".
+func (n ObbFileName) Path() string {
+	return path.Join("Android/obb", n.Package, n.String())
+}
+
+// Picks the main and patch expansion files of the package with the given versionCode from names,
+// for example a listing of Android/obb/<package>/. Files for newer versions are skipped and the newest
+// of the rest wins, as the expansion files are kept when updates of the app don't change them.
+// Returns empty names if none match.
+func FindObbFiles(names []string, packageName string, versionCode int) (main, patch string) {
+	mainVersion, patchVersion := -1, -1
+	for _, name := range names {
+		n, ok := ParseObbFileName(name)
+		if !ok || n.Package != packageName || n.VersionCode > versionCode {
+			continue
+		}
+
+		if n.Main && n.VersionCode > mainVersion {
+			main, mainVersion = name, n.VersionCode
+		} else if !n.Main && n.VersionCode > patchVersion {
+			patch, patchVersion = name, n.VersionCode
+		}
+	}
+	return
+}
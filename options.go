@@ -0,0 +1,334 @@
+package apkparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const defaultMaxReferenceDepth = 5
+
+// Returned (wrapped) when the input is rejected because of ParseOptions.Strict.
+var ErrStrictMode = errors.New("strict mode violation")
+
+// Returned when allocations based on sizes declared in the parsed file exceed ParseOptions.MaxAllocBytes.
+type AllocLimitError struct {
+	Limit     int64
+	Requested int64
+}
+
+func (e *AllocLimitError) Error() string {
+	return fmt.Sprintf("allocation limit exceeded: %d bytes requested, limit is %d", e.Requested, e.Limit)
+}
+
+// Options for the parsing functions. Nil or the zero value gives the default behavior,
+// which tolerates everything Android tolerates.
+type ParseOptions struct {
+	// Reject files exploiting Android's leniency (string count mismatches, wrong chunk ids,
+	// unusual attribute sizes, truncated string pools...) instead of silently tolerating them.
+	// Meant for validating your own builds rather than analyzing malware.
+	Strict bool
+
+	// If not nil, warnings about recovered anomalies are appended to it.
+	Warnings *[]Warning
+
+	// If not nil, gets the recovered anomalies and skipped data as they are found, including
+	// the warnings of OpenZipReaderEx.
+	Logger Logger
+
+	// If not nil, statistics of the parsed data are added to it.
+	Stats *Stats
+
+	// If not nil, the parsing is aborted with Context.Err() once the context is done.
+	Context context.Context
+
+	// Maximum number of bytes allocated based on sizes declared in one parsed file (string pools,
+	// resource blocks...). Crafted files can declare huge sizes, exceeding the limit aborts
+	// the parsing with *AllocLimitError. 0 means no limit.
+	MaxAllocBytes int64
+
+	// Keep each string pool in a single buffer and decode the strings on every access
+	// instead of caching them, trading CPU for memory in high-volume batch processing.
+	LazyStrings bool
+
+	// Maximum number of decoded strings cached per string pool, the cache is dropped and filled
+	// again when it's full. 0 means no limit, negative disables the cache. Ignored with LazyStrings.
+	StringCacheSize int
+
+	// Decode all strings of each string pool right when it's parsed, which is faster and takes less
+	// memory than the cache when most of the strings are used anyway, e.g. when dumping whole
+	// resource tables. Overrides StringCacheSize, ignored with LazyStrings.
+	PredecodeStrings bool
+
+	// Parse plaintext XML, which Android refuses, with encoding/xml and pass it to the encoder
+	// instead of failing with ErrPlainTextManifest. The WarnPlainTextXml anomaly is reported.
+	PlainTextFallback bool
+
+	// Names and values of resources known from elsewhere, consulted by ResourceTable when resolving
+	// ids its own entries are missing for or were renamed by an obfuscator. Mapped names take
+	// precedence over the names in the table.
+	ResourceMapping ResourceMapping
+
+	// Maximum number of references followed when resolving a value, e.g. an XML attribute pointing
+	// to @mipmap/icon which points to @drawable/icon. Loops found within the depth are reported
+	// as WarnReferenceLoop and left unresolved. 0 means the default of 5.
+	MaxReferenceDepth int
+
+	// Preferred locale like "de", "en-US" or "en-rUS" for values of references in XML attributes.
+	// Values for other locales are skipped and the default one is used if there's none for this
+	// locale. Empty means the first value is used regardless of its configuration.
+	Locale string
+
+	// Preferred screen density like DensityXHigh for values of references in XML attributes, including
+	// icons, which are otherwise resolved to the biggest png. The closest higher density wins, lower
+	// ones are used only when there's no higher one. 0 means no preference.
+	Density uint16
+
+	// Preferred ui mode type and night mode like UiModeTypeTelevision|UiModeNightYes for values
+	// of references in XML attributes. Values for other types or night modes are skipped. 0 means
+	// no preference, either part can be 0 too.
+	UiMode uint8
+
+	// Parse the chunks with resource entries of a type only when the type is first looked up, cutting
+	// the parsing time when just a few resources like the app label and icon are resolved. Broken
+	// configurations are then reported as warnings even in strict mode.
+	LazyResourceTypes bool
+
+	// Skip chunks of unknown types in binary XML with WarnUnknownChunk like Android does, instead of
+	// failing with *UnknownChunkError. OEM tools and packers leave such chunks in some files.
+	SkipUnknownChunks bool
+
+	// When parsing of a binary XML fails halfway, e.g. on a truncated chunk or a bad string index, end
+	// the elements still open, so that the encoder gets well-formed output of everything decoded until
+	// then. The error is returned as usual, the trees from ParseXmlTreeEx and ApkParser.ParseXmlTree
+	// are returned along with it.
+	PartialResults bool
+
+	// Locate the ZIP within the file by scanning for its end of central directory record and open just
+	// that span, tolerating loaders prepended and payloads appended to it, even past the 64 KiB
+	// the standard ZIP readers look for the record in. The data outside is reported
+	// in ZipReader.ExtraRanges and as WarnZipExtraData.
+	LocateZipSpan bool
+
+	// Recognize the files referenced by resource values by their contents instead of their extensions,
+	// for APKs whose resource paths were shortened by obfuscators like AndResGuard, e.g. to r/a/b.
+	// Affects the icon picked by ResourceTable.GetIconPng, ResourceFile.Format and the meta-data
+	// resources decoded as XML. Only applies to resources loaded by ApkParser, the files are read
+	// on the first lookup.
+	SniffResourceFiles bool
+
+	// If not nil, called after each ZIP entry found by OpenZipReaderEx and each chunk parsed from binary XML
+	// and resource tables, to show progress of long parsing or detect stalls on pathological inputs.
+	// It is called from the parsing goroutine and should return quickly.
+	Progress func(p Progress)
+
+	// per-parse state, set up by withState
+	allocated *int64
+	progress  *Progress
+
+	// element and attribute names shared by the parses of ParseBatch
+	names *nameTable
+}
+
+// What a Progress report is about.
+type ProgressStage int
+
+const (
+	ProgressZip ProgressStage = iota
+	ProgressXml
+	ProgressResources
+)
+
+func (s ProgressStage) String() string {
+	switch s {
+	case ProgressZip:
+		return "zip"
+	case ProgressXml:
+		return "xml"
+	case ProgressResources:
+		return "resources"
+	default:
+		return fmt.Sprintf("ProgressStage(%d)", int(s))
+	}
+}
+
+// Progress of one parsing function call, see ParseOptions.Progress.
+type Progress struct {
+	Stage ProgressStage
+	// ZIP entries found so far, for ProgressZip.
+	Entries int
+	// Chunks parsed so far, including the nested ones like resource types in packages.
+	Chunks int
+	// Offset in the parsed file reached so far. Not set for ProgressZip, for ProgressResources it advances
+	// by whole packages.
+	Bytes int64
+}
+
+// Returns a copy of the options with fresh per-parse state. Returns nil for nil options.
+func (o *ParseOptions) withState() *ParseOptions {
+	if o == nil {
+		return nil
+	}
+	res := *o
+	res.allocated = new(int64)
+	if o.Progress != nil {
+		res.progress = &Progress{}
+	}
+	return &res
+}
+
+// Reports a parsed chunk to Progress, offset is negative if it is not known.
+func (o *ParseOptions) progressChunk(stage ProgressStage, offset int64) {
+	if o == nil || o.progress == nil {
+		return
+	}
+
+	o.progress.Stage = stage
+	o.progress.Chunks++
+	if offset >= 0 {
+		o.progress.Bytes = offset
+	}
+	o.Progress(*o.progress)
+}
+
+// Reports a found ZIP entry to Progress.
+func (o *ParseOptions) progressEntry() {
+	if o == nil || o.progress == nil {
+		return
+	}
+
+	o.progress.Stage = ProgressZip
+	o.progress.Entries++
+	o.Progress(*o.progress)
+}
+
+// Accounts n bytes allocated based on sizes declared in the file.
+func (o *ParseOptions) alloc(n int64) error {
+	if o == nil || o.MaxAllocBytes <= 0 {
+		return nil
+	}
+
+	if o.allocated == nil {
+		return o.allocTemporary(n)
+	}
+
+	*o.allocated += n
+	if n < 0 || *o.allocated > o.MaxAllocBytes {
+		return &AllocLimitError{Limit: o.MaxAllocBytes, Requested: *o.allocated}
+	}
+	return nil
+}
+
+// Checks a short-lived allocation of n bytes, which is not accounted.
+func (o *ParseOptions) allocTemporary(n int64) error {
+	if o == nil || o.MaxAllocBytes <= 0 || n <= o.MaxAllocBytes {
+		return nil
+	}
+	return &AllocLimitError{Limit: o.MaxAllocBytes, Requested: n}
+}
+
+func (o *ParseOptions) isStrict() bool {
+	return o != nil && o.Strict
+}
+
+// Reports an anomaly Android tolerates. Returns error wrapping ErrStrictMode in strict mode,
+// otherwise records the warning and returns nil.
+func (o *ParseOptions) anomaly(kind WarningKind, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if o.isStrict() {
+		return fmt.Errorf("%w: %s", ErrStrictMode, msg)
+	}
+	o.warn(Warning{Kind: kind, Message: msg})
+	return nil
+}
+
+func (o *ParseOptions) isLazyStrings() bool {
+	return o != nil && o.LazyStrings
+}
+
+func (o *ParseOptions) stringCacheSize() int {
+	if o == nil {
+		return 0
+	}
+	return o.StringCacheSize
+}
+
+func (o *ParseOptions) isPredecodeStrings() bool {
+	return o != nil && o.PredecodeStrings
+}
+
+func (o *ParseOptions) isSkipUnknownChunks() bool {
+	return o != nil && o.SkipUnknownChunks
+}
+
+func (o *ParseOptions) isPartialResults() bool {
+	return o != nil && o.PartialResults
+}
+
+func (o *ParseOptions) isLocateZipSpan() bool {
+	return o != nil && o.LocateZipSpan
+}
+
+func (o *ParseOptions) isLazyResourceTypes() bool {
+	return o != nil && o.LazyResourceTypes
+}
+
+func (o *ParseOptions) isSniffResourceFiles() bool {
+	return o != nil && o.SniffResourceFiles
+}
+
+func (o *ParseOptions) isPlainTextFallback() bool {
+	return o != nil && o.PlainTextFallback
+}
+
+func (o *ParseOptions) resourceMapping() ResourceMapping {
+	if o == nil {
+		return nil
+	}
+	return o.ResourceMapping
+}
+
+// Returns the shared copy of an element or attribute name, if there is a shared table.
+func (o *ParseOptions) intern(s string) string {
+	if o == nil {
+		return s
+	}
+	return o.names.intern(s)
+}
+
+func (o *ParseOptions) maxAllocBytes() int64 {
+	if o == nil {
+		return 0
+	}
+	return o.MaxAllocBytes
+}
+
+func (o *ParseOptions) maxReferenceDepth() int {
+	if o == nil || o.MaxReferenceDepth <= 0 {
+		return defaultMaxReferenceDepth
+	}
+	return o.MaxReferenceDepth
+}
+
+func (o *ParseOptions) context() context.Context {
+	if o == nil || o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+func (o *ParseOptions) checkContext() error {
+	if o == nil || o.Context == nil {
+		return nil
+	}
+	return o.Context.Err()
+}
+
+func (o *ParseOptions) warn(w Warning) {
+	if o != nil && o.Warnings != nil {
+		*o.Warnings = append(*o.Warnings, w)
+	}
+	if l := o.logger(); l != nil {
+		l.Warning(w)
+	}
+}
@@ -0,0 +1,175 @@
+package apkparser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Policy flags of an overlayable group, which overlays are allowed to overlay its resources.
+//
+// frameworks/base/libs/androidfw/include/androidfw/ResourceTypes.h, ResTable_overlayable_policy_header
+type OverlayablePolicy uint32
+
+const (
+	OverlayablePolicyNone             OverlayablePolicy = 0
+	OverlayablePolicyPublic           OverlayablePolicy = 0x00000001
+	OverlayablePolicySystemPartition  OverlayablePolicy = 0x00000002
+	OverlayablePolicyVendorPartition  OverlayablePolicy = 0x00000004
+	OverlayablePolicyProductPartition OverlayablePolicy = 0x00000008
+	OverlayablePolicySignature        OverlayablePolicy = 0x00000010
+	OverlayablePolicyOdmPartition     OverlayablePolicy = 0x00000020
+	OverlayablePolicyOemPartition     OverlayablePolicy = 0x00000040
+	OverlayablePolicyActorSignature   OverlayablePolicy = 0x00000080
+	OverlayablePolicyConfigSignature  OverlayablePolicy = 0x00000100
+)
+
+var overlayablePolicyNames = []struct {
+	flag OverlayablePolicy
+	name string
+}{
+	{OverlayablePolicyPublic, "public"},
+	{OverlayablePolicySystemPartition, "system"},
+	{OverlayablePolicyVendorPartition, "vendor"},
+	{OverlayablePolicyProductPartition, "product"},
+	{OverlayablePolicySignature, "signature"},
+	{OverlayablePolicyOdmPartition, "odm"},
+	{OverlayablePolicyOemPartition, "oem"},
+	{OverlayablePolicyActorSignature, "actor"},
+	{OverlayablePolicyConfigSignature, "config_signature"},
+}
+
+// Returns the policies like in the <policy type="..."> attribute, e.g. "system|vendor".
+func (p OverlayablePolicy) String() string {
+	if p == OverlayablePolicyNone {
+		return "none"
+	}
+
+	var parts []string
+	for _, n := range overlayablePolicyNames {
+		if p&n.flag != 0 {
+			parts = append(parts, n.name)
+			p &^= n.flag
+		}
+	}
+
+	if p != 0 {
+		parts = append(parts, fmt.Sprintf("0x%x", uint32(p)))
+	}
+	return strings.Join(parts, "|")
+}
+
+// The <overlayable> group of resources which can be overlaid by runtime resource overlays.
+type Overlayable struct {
+	Name    string
+	Actor   string
+	Package string
+
+	Policies []OverlayablePolicyEntry
+}
+
+// Resources overlayable by overlays fulfilling the Flags.
+type OverlayablePolicyEntry struct {
+	Flags       OverlayablePolicy
+	ResourceIds []uint32
+}
+
+// Returns the overlayable groups of all packages in the table.
+func (x *ResourceTable) Overlayables() []*Overlayable {
+	return x.overlayables
+}
+
+// Returns the overlayable group and the policies for resId, or nil and OverlayablePolicyNone
+// if the resource is not overlayable.
+func (x *ResourceTable) GetOverlayable(resId uint32) (*Overlayable, OverlayablePolicy) {
+	for _, o := range x.overlayables {
+		for _, p := range o.Policies {
+			for _, id := range p.ResourceIds {
+				if id == resId {
+					return o, p.Flags
+				}
+			}
+		}
+	}
+	return nil, OverlayablePolicyNone
+}
+
+// Parses RES_TABLE_OVERLAYABLE_TYPE chunk including its header, which contains
+// RES_TABLE_OVERLAYABLE_POLICY_TYPE chunks.
+func (x *ResourceTable) parseOverlayable(chunk []byte, hdrLen uint16, pkg *resourcePackage) error {
+	const nameLen = 256
+	const minHdrLen = chunkHeaderSize + 2*nameLen*2
+
+	if int(hdrLen) < minHdrLen || int(hdrLen) > len(chunk) {
+		return fmt.Errorf("Invalid overlayable header length: %d", hdrLen)
+	}
+
+	o := &Overlayable{
+		Name:    decodeUtf16Name(chunk[chunkHeaderSize : chunkHeaderSize+nameLen*2]),
+		Actor:   decodeUtf16Name(chunk[chunkHeaderSize+nameLen*2 : minHdrLen]),
+		Package: pkg.Name,
+	}
+
+	for offset := int(hdrLen); offset < len(chunk); {
+		if len(chunk)-offset < chunkHeaderSize {
+			return fmt.Errorf("Truncated overlayable policy chunk at %d", offset)
+		}
+
+		id := binary.LittleEndian.Uint16(chunk[offset:])
+		policyHdrLen := int(binary.LittleEndian.Uint16(chunk[offset+2:]))
+		size := int(binary.LittleEndian.Uint32(chunk[offset+4:]))
+		if size < chunkHeaderSize || size > len(chunk)-offset {
+			return fmt.Errorf("Invalid overlayable policy chunk size %d at %d", size, offset)
+		}
+
+		policy := chunk[offset : offset+size]
+		offset += size
+
+		if id != chunkTableOverlayablePolicy {
+			if err := x.opts.anomaly(WarnUnknownChunk, "Unknown chunk id 0x%x in overlayable %s", id, o.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if policyHdrLen < chunkHeaderSize+8 || policyHdrLen > size {
+			return fmt.Errorf("Invalid overlayable policy header length: %d", policyHdrLen)
+		}
+
+		entry := OverlayablePolicyEntry{
+			Flags: OverlayablePolicy(binary.LittleEndian.Uint32(policy[chunkHeaderSize:])),
+		}
+
+		count := binary.LittleEndian.Uint32(policy[chunkHeaderSize+4:])
+		if uint64(count)*4 > uint64(size-policyHdrLen) {
+			return fmt.Errorf("Overlayable policy entry count %d overflows the chunk", count)
+		}
+
+		if err := x.opts.alloc(4 * int64(count)); err != nil {
+			return err
+		}
+
+		entry.ResourceIds = make([]uint32, count)
+		for i := range entry.ResourceIds {
+			entry.ResourceIds[i] = binary.LittleEndian.Uint32(policy[policyHdrLen+4*i:])
+		}
+		o.Policies = append(o.Policies, entry)
+	}
+
+	x.overlayables = append(x.overlayables, o)
+	return nil
+}
+
+// Decodes NUL-terminated UTF-16LE string from fixed-size buffer.
+func decodeUtf16Name(data []byte) string {
+	buf := make([]uint16, len(data)/2)
+	for i := range buf {
+		buf[i] = binary.LittleEndian.Uint16(data[2*i:])
+		if buf[i] == 0 {
+			buf = buf[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(buf))
+}
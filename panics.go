@@ -0,0 +1,95 @@
+package apkparser
+
+import (
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"runtime/debug"
+)
+
+// Returned by the parsing functions instead of panicking when a crafted input hits a bug,
+// so that the library never takes down the whole process.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("Panic: %v\n%s", e.Value, string(e.Stack))
+}
+
+// Panic of a callback of the caller, see callback.
+type callbackPanic struct {
+	value interface{}
+}
+
+// Converts a panic to *PanicError stored into err, panics of the caller's callbacks are propagated.
+// Must be deferred directly, and not around another function recovering a callback's panic, which
+// is propagated as a plain panic.
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		if p, ok := r.(callbackPanic); ok {
+			panic(p.value)
+		}
+		*err = &PanicError{Value: r, Stack: debug.Stack()}
+	}
+}
+
+// Calls a callback of the caller, whose panic is propagated by recoverPanic.
+func callback(cb func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(callbackPanic{value: r})
+		}
+	}()
+	cb()
+}
+
+// Wraps the caller's encoder so that its panics are propagated by recoverPanic.
+func callerEncoder(enc ManifestEncoder) ManifestEncoder {
+	if enc == nil {
+		return nil
+	} else if typed, ok := enc.(TypedManifestEncoder); ok {
+		return callbackTypedEncoder{callbackEncoder{typed}, typed}
+	}
+	return callbackEncoder{enc}
+}
+
+// Hash of HashOptions.Extra, whose panics are propagated by recoverPanic.
+type callbackHash struct {
+	hash.Hash
+}
+
+func (h callbackHash) Write(p []byte) (n int, err error) {
+	callback(func() { n, err = h.Hash.Write(p) })
+	return
+}
+
+func (h callbackHash) Sum(b []byte) (res []byte) {
+	callback(func() { res = h.Hash.Sum(b) })
+	return
+}
+
+type callbackEncoder struct {
+	enc ManifestEncoder
+}
+
+func (e callbackEncoder) EncodeToken(t xml.Token) (err error) {
+	callback(func() { err = e.enc.EncodeToken(t) })
+	return
+}
+
+func (e callbackEncoder) Flush() (err error) {
+	callback(func() { err = e.enc.Flush() })
+	return
+}
+
+type callbackTypedEncoder struct {
+	callbackEncoder
+	typed TypedManifestEncoder
+}
+
+func (e callbackTypedEncoder) EncodeTypedStart(el *TypedStartElement) (err error) {
+	callback(func() { err = e.typed.EncodeTypedStart(el) })
+	return
+}
@@ -0,0 +1,125 @@
+package apkparser
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kind of an embedded payload, see ZipReader.ScanPayloads.
+type PayloadKind int
+
+const (
+	PayloadDex PayloadKind = iota
+	PayloadElf
+	PayloadZip
+	PayloadApk // ZIP with AndroidManifest.xml
+)
+
+func (k PayloadKind) String() string {
+	switch k {
+	case PayloadDex:
+		return "dex"
+	case PayloadElf:
+		return "elf"
+	case PayloadZip:
+		return "zip"
+	case PayloadApk:
+		return "apk"
+	default:
+		return fmt.Sprintf("PayloadKind(%d)", int(k))
+	}
+}
+
+// Executable or archive found inside a file of the APK.
+type PayloadHit struct {
+	// Path of the file in the APK, like "assets/data.bin".
+	Path string
+	// Offset of the payload in the uncompressed file.
+	Offset int64
+	Kind   PayloadKind
+}
+
+// Directories of the APK the payloads are looked for in.
+var payloadDirs = []string{"assets/", "res/raw/"}
+
+var (
+	dexMagicRe = regexp.MustCompile(`dex\n\d{3}\x00`)
+	elfMagicRe = regexp.MustCompile(`\x7fELF[\x01\x02][\x01\x02]`)
+)
+
+// Looks for DEX, ELF and ZIP files in assets/ and res/raw/, anywhere in the files, not just at their start,
+// as droppers hide second stages appended to or embedded in innocent looking data. At most maxSize bytes
+// of each file are scanned. ZIPs are found by their end of central directory records and reported as
+// PayloadApk if they have AndroidManifest.xml. Hits are sorted by path and offset.
+func (zr *ZipReader) ScanPayloads(maxSize int64) []PayloadHit {
+	var res []PayloadHit
+	for name, f := range zr.File {
+		if f.IsDir || !hasAnyPrefix(name, payloadDirs) {
+			continue
+		}
+
+		if hits, err := scanPayloadFile(name, f, maxSize); err == nil {
+			res = append(res, hits...)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Path != res[j].Path {
+			return res[i].Path < res[j].Path
+		}
+		return res[i].Offset < res[j].Offset
+	})
+	return res
+}
+
+func scanPayloadFile(name string, f *ZipReaderFile, maxSize int64) (hits []PayloadHit, err error) {
+	defer recoverPanic(&err)
+
+	data, err := f.ReadAll(maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return scanPayloads(name, data), nil
+}
+
+func scanPayloads(name string, data []byte) []PayloadHit {
+	var res []PayloadHit
+	for _, m := range dexMagicRe.FindAllIndex(data, -1) {
+		res = append(res, PayloadHit{Path: name, Offset: int64(m[0]), Kind: PayloadDex})
+	}
+
+	for _, m := range elfMagicRe.FindAllIndex(data, -1) {
+		res = append(res, PayloadHit{Path: name, Offset: int64(m[0]), Kind: PayloadElf})
+	}
+
+	// Quick check, FindEmbeddedZipOffsets goes through the whole data byte by byte.
+	if !bytes.Contains(data, []byte("PK\x05\x06")) {
+		return res
+	}
+
+	r := bytes.NewReader(data)
+	offsets, _ := FindEmbeddedZipOffsets(r)
+	for _, off := range offsets {
+		hit := PayloadHit{Path: name, Offset: off, Kind: PayloadZip}
+		if zip, err := OpenZipReaderAtOffset(r, off); err == nil {
+			if zip.File["AndroidManifest.xml"] != nil {
+				hit.Kind = PayloadApk
+			}
+			zip.Close()
+		}
+		res = append(res, hit)
+	}
+	return res
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
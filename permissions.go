@@ -0,0 +1,300 @@
+package apkparser
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Protection level of a permission, which decides how it is granted.
+type PermissionProtection int
+
+const (
+	ProtectionUnknown   PermissionProtection = iota // not a known permission, e.g. of another app
+	ProtectionNormal                                // granted at install time
+	ProtectionDangerous                             // granted by the user, at runtime since API 23
+	ProtectionSignature                             // granted only to apps signed by the same key, privileged apps or by an app op
+)
+
+func (p PermissionProtection) String() string {
+	switch p {
+	case ProtectionNormal:
+		return "normal"
+	case ProtectionDangerous:
+		return "dangerous"
+	case ProtectionSignature:
+		return "signature"
+	default:
+		return "unknown"
+	}
+}
+
+type permissionLevel struct {
+	api        int
+	protection PermissionProtection
+}
+
+// Permission name -> protection levels ordered by the API level they apply since. The first level
+// is the API level the permission was added in. Signature protection includes signature|privileged
+// and signature|appop.
+//
+// frameworks/base/core/res/AndroidManifest.xml
+var knownPermissions = map[string][]permissionLevel{
+	// dangerous
+	"android.permission.ACCEPT_HANDOVER":                 {{28, ProtectionDangerous}},
+	"android.permission.ACCESS_BACKGROUND_LOCATION":      {{29, ProtectionDangerous}},
+	"android.permission.ACCESS_COARSE_LOCATION":          {{1, ProtectionDangerous}},
+	"android.permission.ACCESS_FINE_LOCATION":            {{1, ProtectionDangerous}},
+	"android.permission.ACCESS_MEDIA_LOCATION":           {{29, ProtectionDangerous}},
+	"android.permission.ACTIVITY_RECOGNITION":            {{29, ProtectionDangerous}},
+	"android.permission.ANSWER_PHONE_CALLS":              {{26, ProtectionDangerous}},
+	"android.permission.BLUETOOTH_ADVERTISE":             {{31, ProtectionDangerous}},
+	"android.permission.BLUETOOTH_CONNECT":               {{31, ProtectionDangerous}},
+	"android.permission.BLUETOOTH_SCAN":                  {{31, ProtectionDangerous}},
+	"android.permission.BODY_SENSORS":                    {{20, ProtectionDangerous}},
+	"android.permission.BODY_SENSORS_BACKGROUND":         {{33, ProtectionDangerous}},
+	"android.permission.CALL_PHONE":                      {{1, ProtectionDangerous}},
+	"android.permission.CAMERA":                          {{1, ProtectionDangerous}},
+	"android.permission.GET_ACCOUNTS":                    {{1, ProtectionDangerous}},
+	"android.permission.NEARBY_WIFI_DEVICES":             {{33, ProtectionDangerous}},
+	"android.permission.POST_NOTIFICATIONS":              {{33, ProtectionDangerous}},
+	"android.permission.PROCESS_OUTGOING_CALLS":          {{1, ProtectionDangerous}},
+	"android.permission.READ_CALENDAR":                   {{1, ProtectionDangerous}},
+	"android.permission.READ_CALL_LOG":                   {{16, ProtectionDangerous}},
+	"android.permission.READ_CONTACTS":                   {{1, ProtectionDangerous}},
+	"android.permission.READ_EXTERNAL_STORAGE":           {{16, ProtectionDangerous}},
+	"android.permission.READ_MEDIA_AUDIO":                {{33, ProtectionDangerous}},
+	"android.permission.READ_MEDIA_IMAGES":               {{33, ProtectionDangerous}},
+	"android.permission.READ_MEDIA_VIDEO":                {{33, ProtectionDangerous}},
+	"android.permission.READ_MEDIA_VISUAL_USER_SELECTED": {{34, ProtectionDangerous}},
+	"android.permission.READ_PHONE_NUMBERS":              {{26, ProtectionDangerous}},
+	"android.permission.READ_PHONE_STATE":                {{1, ProtectionDangerous}},
+	"android.permission.READ_SMS":                        {{1, ProtectionDangerous}},
+	"android.permission.RECEIVE_MMS":                     {{1, ProtectionDangerous}},
+	"android.permission.RECEIVE_SMS":                     {{1, ProtectionDangerous}},
+	"android.permission.RECEIVE_WAP_PUSH":                {{1, ProtectionDangerous}},
+	"android.permission.RECORD_AUDIO":                    {{1, ProtectionDangerous}},
+	"android.permission.SEND_SMS":                        {{1, ProtectionDangerous}},
+	"android.permission.USE_SIP":                         {{9, ProtectionDangerous}},
+	"android.permission.UWB_RANGING":                     {{31, ProtectionDangerous}},
+	"android.permission.WRITE_CALENDAR":                  {{1, ProtectionDangerous}},
+	"android.permission.WRITE_CALL_LOG":                  {{16, ProtectionDangerous}},
+	"android.permission.WRITE_CONTACTS":                  {{1, ProtectionDangerous}},
+	"android.permission.WRITE_EXTERNAL_STORAGE":          {{4, ProtectionDangerous}},
+	"com.android.voicemail.permission.ADD_VOICEMAIL":     {{14, ProtectionDangerous}},
+
+	// protection changed over time
+	"android.permission.GET_TASKS":                {{1, ProtectionDangerous}, {21, ProtectionNormal}},
+	"android.permission.SYSTEM_ALERT_WINDOW":      {{1, ProtectionDangerous}, {23, ProtectionSignature}},
+	"android.permission.READ_LOGS":                {{1, ProtectionDangerous}, {16, ProtectionSignature}},
+	"android.permission.WRITE_SETTINGS":           {{1, ProtectionNormal}, {23, ProtectionSignature}},
+	"android.permission.REQUEST_INSTALL_PACKAGES": {{23, ProtectionNormal}, {26, ProtectionSignature}},
+
+	// signature
+	"android.permission.ACCESS_CHECKIN_PROPERTIES":          {{1, ProtectionSignature}},
+	"android.permission.BIND_ACCESSIBILITY_SERVICE":         {{16, ProtectionSignature}},
+	"android.permission.BIND_APPWIDGET":                     {{3, ProtectionSignature}},
+	"android.permission.BIND_AUTOFILL_SERVICE":              {{26, ProtectionSignature}},
+	"android.permission.BIND_CARRIER_SERVICES":              {{23, ProtectionSignature}},
+	"android.permission.BIND_CONDITION_PROVIDER_SERVICE":    {{24, ProtectionSignature}},
+	"android.permission.BIND_DEVICE_ADMIN":                  {{8, ProtectionSignature}},
+	"android.permission.BIND_INCALL_SERVICE":                {{23, ProtectionSignature}},
+	"android.permission.BIND_INPUT_METHOD":                  {{3, ProtectionSignature}},
+	"android.permission.BIND_JOB_SERVICE":                   {{21, ProtectionSignature}},
+	"android.permission.BIND_NFC_SERVICE":                   {{19, ProtectionSignature}},
+	"android.permission.BIND_NOTIFICATION_LISTENER_SERVICE": {{18, ProtectionSignature}},
+	"android.permission.BIND_PRINT_SERVICE":                 {{19, ProtectionSignature}},
+	"android.permission.BIND_REMOTEVIEWS":                   {{11, ProtectionSignature}},
+	"android.permission.BIND_SCREENING_SERVICE":             {{24, ProtectionSignature}},
+	"android.permission.BIND_TEXT_SERVICE":                  {{14, ProtectionSignature}},
+	"android.permission.BIND_TV_INPUT":                      {{21, ProtectionSignature}},
+	"android.permission.BIND_VOICE_INTERACTION":             {{21, ProtectionSignature}},
+	"android.permission.BIND_VPN_SERVICE":                   {{14, ProtectionSignature}},
+	"android.permission.BIND_WALLPAPER":                     {{8, ProtectionSignature}},
+	"android.permission.CALL_PRIVILEGED":                    {{1, ProtectionSignature}},
+	"android.permission.CAPTURE_AUDIO_OUTPUT":               {{19, ProtectionSignature}},
+	"android.permission.CHANGE_COMPONENT_ENABLED_STATE":     {{1, ProtectionSignature}},
+	"android.permission.DELETE_PACKAGES":                    {{1, ProtectionSignature}},
+	"android.permission.DEVICE_POWER":                       {{1, ProtectionSignature}},
+	"android.permission.INJECT_EVENTS":                      {{1, ProtectionSignature}},
+	"android.permission.INSTALL_PACKAGES":                   {{1, ProtectionSignature}},
+	"android.permission.MANAGE_EXTERNAL_STORAGE":            {{30, ProtectionSignature}},
+	"android.permission.MASTER_CLEAR":                       {{1, ProtectionSignature}},
+	"android.permission.MODIFY_PHONE_STATE":                 {{1, ProtectionSignature}},
+	"android.permission.MOUNT_UNMOUNT_FILESYSTEMS":          {{1, ProtectionSignature}},
+	"android.permission.PACKAGE_USAGE_STATS":                {{21, ProtectionSignature}},
+	"android.permission.READ_FRAME_BUFFER":                  {{1, ProtectionSignature}},
+	"android.permission.READ_PRIVILEGED_PHONE_STATE":        {{21, ProtectionSignature}},
+	"android.permission.REBOOT":                             {{1, ProtectionSignature}},
+	"android.permission.SCHEDULE_EXACT_ALARM":               {{31, ProtectionSignature}},
+	"android.permission.SET_TIME":                           {{8, ProtectionSignature}},
+	"android.permission.STATUS_BAR":                         {{1, ProtectionSignature}},
+	"android.permission.UPDATE_DEVICE_STATS":                {{3, ProtectionSignature}},
+	"android.permission.WRITE_APN_SETTINGS":                 {{1, ProtectionSignature}},
+	"android.permission.WRITE_SECURE_SETTINGS":              {{3, ProtectionSignature}},
+
+	// normal
+	"android.permission.ACCESS_LOCATION_EXTRA_COMMANDS":       {{1, ProtectionNormal}},
+	"android.permission.ACCESS_NETWORK_STATE":                 {{1, ProtectionNormal}},
+	"android.permission.ACCESS_NOTIFICATION_POLICY":           {{23, ProtectionNormal}},
+	"android.permission.ACCESS_WIFI_STATE":                    {{1, ProtectionNormal}},
+	"android.permission.BLUETOOTH":                            {{1, ProtectionNormal}},
+	"android.permission.BLUETOOTH_ADMIN":                      {{1, ProtectionNormal}},
+	"android.permission.BROADCAST_STICKY":                     {{1, ProtectionNormal}},
+	"android.permission.CALL_COMPANION_APP":                   {{29, ProtectionNormal}},
+	"android.permission.CHANGE_NETWORK_STATE":                 {{1, ProtectionNormal}},
+	"android.permission.CHANGE_WIFI_MULTICAST_STATE":          {{4, ProtectionNormal}},
+	"android.permission.CHANGE_WIFI_STATE":                    {{1, ProtectionNormal}},
+	"android.permission.DETECT_SCREEN_CAPTURE":                {{34, ProtectionNormal}},
+	"android.permission.DISABLE_KEYGUARD":                     {{1, ProtectionNormal}},
+	"android.permission.EXPAND_STATUS_BAR":                    {{1, ProtectionNormal}},
+	"android.permission.FOREGROUND_SERVICE":                   {{28, ProtectionNormal}},
+	"android.permission.GET_PACKAGE_SIZE":                     {{1, ProtectionNormal}},
+	"android.permission.HIGH_SAMPLING_RATE_SENSORS":           {{31, ProtectionNormal}},
+	"android.permission.INTERNET":                             {{1, ProtectionNormal}},
+	"android.permission.KILL_BACKGROUND_PROCESSES":            {{8, ProtectionNormal}},
+	"android.permission.MANAGE_OWN_CALLS":                     {{26, ProtectionNormal}},
+	"android.permission.MODIFY_AUDIO_SETTINGS":                {{1, ProtectionNormal}},
+	"android.permission.NFC":                                  {{9, ProtectionNormal}},
+	"android.permission.PERSISTENT_ACTIVITY":                  {{1, ProtectionNormal}},
+	"android.permission.QUERY_ALL_PACKAGES":                   {{30, ProtectionNormal}},
+	"android.permission.READ_BASIC_PHONE_STATE":               {{33, ProtectionNormal}},
+	"android.permission.READ_SYNC_SETTINGS":                   {{1, ProtectionNormal}},
+	"android.permission.READ_SYNC_STATS":                      {{1, ProtectionNormal}},
+	"android.permission.RECEIVE_BOOT_COMPLETED":               {{1, ProtectionNormal}},
+	"android.permission.REORDER_TASKS":                        {{1, ProtectionNormal}},
+	"android.permission.REQUEST_COMPANION_RUN_IN_BACKGROUND":  {{26, ProtectionNormal}},
+	"android.permission.REQUEST_DELETE_PACKAGES":              {{26, ProtectionNormal}},
+	"android.permission.REQUEST_IGNORE_BATTERY_OPTIMIZATIONS": {{23, ProtectionNormal}},
+	"android.permission.RESTART_PACKAGES":                     {{1, ProtectionNormal}},
+	"android.permission.SET_WALLPAPER":                        {{1, ProtectionNormal}},
+	"android.permission.SET_WALLPAPER_HINTS":                  {{1, ProtectionNormal}},
+	"android.permission.TRANSMIT_IR":                          {{19, ProtectionNormal}},
+	"android.permission.USE_BIOMETRIC":                        {{28, ProtectionNormal}},
+	"android.permission.USE_EXACT_ALARM":                      {{33, ProtectionNormal}},
+	"android.permission.USE_FINGERPRINT":                      {{23, ProtectionNormal}},
+	"android.permission.USE_FULL_SCREEN_INTENT":               {{29, ProtectionNormal}},
+	"android.permission.VIBRATE":                              {{1, ProtectionNormal}},
+	"android.permission.WAKE_LOCK":                            {{1, ProtectionNormal}},
+	"android.permission.WRITE_SYNC_SETTINGS":                  {{1, ProtectionNormal}},
+	"com.android.alarm.permission.SET_ALARM":                  {{9, ProtectionNormal}},
+	"com.android.launcher.permission.INSTALL_SHORTCUT":        {{19, ProtectionNormal}},
+}
+
+// Returns the protection level of the platform permission at the API level, 0 means the latest one.
+// Returns ProtectionUnknown for permissions not known or not yet added at the API level.
+func GetPermissionProtection(name string, apiLevel int) PermissionProtection {
+	levels := knownPermissions[name]
+	if len(levels) == 0 && strings.HasPrefix(name, "android.permission.FOREGROUND_SERVICE_") {
+		// the per-type foreground service permissions
+		levels = []permissionLevel{{34, ProtectionNormal}}
+	}
+
+	res := ProtectionUnknown
+	for _, l := range levels {
+		if apiLevel > 0 && l.api > apiLevel {
+			break
+		}
+		res = l.protection
+	}
+	return res
+}
+
+// Requested permissions split by their protection level, each list is sorted.
+type ClassifiedPermissions struct {
+	Normal    []string
+	Dangerous []string
+	Signature []string
+	// Permissions not known to this library and not declared by the app itself.
+	Unknown []string
+}
+
+// Splits the permissions by their protection level at the API level, 0 means the latest one.
+// declared maps custom permissions to their protection levels and takes precedence, it can be nil.
+func ClassifyPermissions(names []string, apiLevel int, declared map[string]PermissionProtection) *ClassifiedPermissions {
+	res := &ClassifiedPermissions{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		protection, prs := declared[name]
+		if !prs {
+			protection = GetPermissionProtection(name, apiLevel)
+		}
+
+		switch protection {
+		case ProtectionNormal:
+			res.Normal = append(res.Normal, name)
+		case ProtectionDangerous:
+			res.Dangerous = append(res.Dangerous, name)
+		case ProtectionSignature:
+			res.Signature = append(res.Signature, name)
+		default:
+			res.Unknown = append(res.Unknown, name)
+		}
+	}
+
+	for _, list := range [][]string{res.Normal, res.Dangerous, res.Signature, res.Unknown} {
+		sort.Strings(list)
+	}
+	return res
+}
+
+// Classifies permissions requested by the manifest's <uses-permission> and <uses-permission-sdk-23>
+// at the API level, 0 means the latest one. Permissions declared by the app itself with <permission>
+// are classified by their android:protectionLevel.
+func (p *ApkParser) ClassifyPermissions(apiLevel int) (*ClassifiedPermissions, error) {
+	manifest, err := p.ParseManifestTree()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	declared := make(map[string]PermissionProtection)
+	for _, c := range manifest.Children {
+		switch c.Name.Local {
+		case "uses-permission", "uses-permission-sdk-23":
+			if name := c.AndroidAttr("name"); name != "" {
+				names = append(names, name)
+			}
+		case "permission":
+			declared[c.AndroidAttr("name")] = parseProtectionLevel(c.AndroidAttr("protectionLevel"))
+		}
+	}
+	return ClassifyPermissions(names, apiLevel, declared), nil
+}
+
+// Parses the android:protectionLevel value, which is an integer in binary XML and flags
+// like "signature|privileged" in plaintext ones. The base level is in the lowest 4 bits.
+func parseProtectionLevel(value string) PermissionProtection {
+	if value == "" {
+		return ProtectionNormal
+	}
+
+	if v, err := strconv.ParseUint(value, 0, 32); err == nil {
+		switch v & 0xF {
+		case 0:
+			return ProtectionNormal
+		case 1:
+			return ProtectionDangerous
+		case 2, 3:
+			return ProtectionSignature
+		default:
+			return ProtectionUnknown
+		}
+	}
+
+	switch strings.SplitN(value, "|", 2)[0] {
+	case "normal":
+		return ProtectionNormal
+	case "dangerous":
+		return ProtectionDangerous
+	case "signature", "signatureOrSystem":
+		return ProtectionSignature
+	default:
+		return ProtectionUnknown
+	}
+}
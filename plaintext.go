@@ -0,0 +1,86 @@
+package apkparser
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Parses plaintext XML with encoding/xml and passes it to the encoder the same way as the binary XML:
+// without namespace declarations, comments, processing instructions and whitespace-only text.
+func (x *binxmlParseInfo) parsePlainText(r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+
+	for {
+		if err := x.opts.checkContext(); err != nil {
+			return err
+		}
+
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Failed to parse plaintext XML: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			err = x.plainTextStart(t)
+		case xml.EndElement:
+			err = x.encoder.EncodeToken(t)
+		case xml.CharData:
+			if strings.TrimSpace(string(t)) != "" {
+				err = x.encoder.EncodeToken(t.Copy())
+			}
+		}
+
+		if err == ErrEndParsing {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return x.encoder.Flush()
+}
+
+func (x *binxmlParseInfo) plainTextStart(t xml.StartElement) error {
+	tok := xml.StartElement{Name: t.Name}
+	for _, a := range t.Attr {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		tok.Attr = append(tok.Attr, a)
+	}
+
+	if x.typedEncoder == nil {
+		return x.encoder.EncodeToken(tok)
+	}
+
+	// The values are strings in a string table made up for this element.
+	table := stringTable{
+		stringOffsets: make([]byte, 4*len(tok.Attr)),
+		cache:         make(map[uint32]string, len(tok.Attr)),
+	}
+
+	typedTok := &TypedStartElement{
+		Name:    tok.Name,
+		strings: &table,
+	}
+
+	for i, a := range tok.Attr {
+		binary.LittleEndian.PutUint32(table.stringOffsets[4*i:], uint32(i))
+		table.cache[uint32(i)] = a.Value
+
+		typedTok.Attr = append(typedTok.Attr, TypedAttr{
+			Name:     a.Name,
+			Type:     AttrTypeString,
+			RawData:  uint32(i),
+			RawValue: StringIndex(i),
+			Value:    StringIndex(i),
+		})
+	}
+	return x.typedEncoder.EncodeTypedStart(typedTok)
+}
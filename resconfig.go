@@ -0,0 +1,256 @@
+package apkparser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Density values of ResourceConfig.Density
+const (
+	DensityDefault = 0
+	DensityLow     = 120
+	DensityMedium  = 160
+	DensityTv      = 213
+	DensityHigh    = 240
+	DensityXHigh   = 320
+	DensityXXHigh  = 480
+	DensityXXXHigh = 640
+	DensityAny     = 0xFFFE
+	DensityNone    = 0xFFFF
+)
+
+// Masks and values of ResourceConfig.UiMode
+const (
+	UiModeTypeMask       = 0x0F
+	UiModeNightMask      = 0x30
+	UiModeNightNo        = 0x10
+	UiModeNightYes       = 0x20
+	UiModeTypeNormal     = 0x01
+	UiModeTypeDesk       = 0x02
+	UiModeTypeCar        = 0x03
+	UiModeTypeTelevision = 0x04
+	UiModeTypeAppliance  = 0x05
+	UiModeTypeWatch      = 0x06
+	UiModeTypeVrHeadset  = 0x07
+)
+
+// ResTable_config, the configuration a resource value applies to (locale, density, sdk version...).
+// Zero values mean "any".
+//
+// frameworks/base/libs/androidfw/include/androidfw/ResourceTypes.h
+type ResourceConfig struct {
+	Mcc uint16
+	Mnc uint16
+
+	Language [2]byte
+	Country  [2]byte
+
+	Orientation uint8
+	Touchscreen uint8
+	Density     uint16
+
+	Keyboard   uint8
+	Navigation uint8
+	InputFlags uint8
+
+	ScreenWidth  uint16
+	ScreenHeight uint16
+
+	SdkVersion   uint16
+	MinorVersion uint16
+
+	ScreenLayout          uint8
+	UiMode                uint8
+	SmallestScreenWidthDp uint16
+
+	ScreenWidthDp  uint16
+	ScreenHeightDp uint16
+
+	LocaleScript  [4]byte
+	LocaleVariant [8]byte
+
+	ScreenLayout2 uint8
+	ColorMode     uint8
+}
+
+const resourceConfigMaxSize = 64
+
+// Parses ResTable_config, which starts with its own size. Missing fields are zero.
+func parseResourceConfig(data []byte) (ResourceConfig, error) {
+	var c ResourceConfig
+	if len(data) < 4 {
+		return c, fmt.Errorf("ResTable_config is truncated")
+	}
+
+	size := binary.LittleEndian.Uint32(data)
+	if size < 4 || uint64(size) > uint64(len(data)) {
+		return c, fmt.Errorf("Invalid ResTable_config size %d", size)
+	}
+
+	var buf [resourceConfigMaxSize]byte
+	copy(buf[:], data[:size])
+
+	le := binary.LittleEndian
+	c.Mcc = le.Uint16(buf[4:])
+	c.Mnc = le.Uint16(buf[6:])
+	copy(c.Language[:], buf[8:10])
+	copy(c.Country[:], buf[10:12])
+	c.Orientation = buf[12]
+	c.Touchscreen = buf[13]
+	c.Density = le.Uint16(buf[14:])
+	c.Keyboard = buf[16]
+	c.Navigation = buf[17]
+	c.InputFlags = buf[18]
+	c.ScreenWidth = le.Uint16(buf[20:])
+	c.ScreenHeight = le.Uint16(buf[22:])
+	c.SdkVersion = le.Uint16(buf[24:])
+	c.MinorVersion = le.Uint16(buf[26:])
+	c.ScreenLayout = buf[28]
+	c.UiMode = buf[29]
+	c.SmallestScreenWidthDp = le.Uint16(buf[30:])
+	c.ScreenWidthDp = le.Uint16(buf[32:])
+	c.ScreenHeightDp = le.Uint16(buf[34:])
+	copy(c.LocaleScript[:], buf[36:40])
+	copy(c.LocaleVariant[:], buf[40:48])
+	c.ScreenLayout2 = buf[48]
+	c.ColorMode = buf[49]
+	return c, nil
+}
+
+// Returns true if this is the default configuration, without any qualifiers.
+func (c *ResourceConfig) IsDefault() bool {
+	return *c == ResourceConfig{}
+}
+
+// Returns the language of the locale, like "en", or empty string.
+func (c *ResourceConfig) LanguageString() string {
+	return unpackLocalePart(c.Language, 'a')
+}
+
+// Returns the country of the locale, like "US", or empty string.
+func (c *ResourceConfig) CountryString() string {
+	return unpackLocalePart(c.Country, '0')
+}
+
+// Two-letter codes are stored as-is, three-letter ones are packed into 15 bits.
+func unpackLocalePart(in [2]byte, base byte) string {
+	if in[0] == 0 {
+		return ""
+	}
+
+	if in[0]&0x80 != 0 {
+		first := in[1] & 0x1f
+		second := ((in[1] & 0xe0) >> 5) + ((in[0] & 0x03) << 3)
+		third := (in[0] & 0x7c) >> 2
+		return string([]byte{first + base, second + base, third + base})
+	}
+	return string(in[:])
+}
+
+// Returns the qualifiers as used in resource directory names, like "de-rAT-hdpi-v21".
+// Returns empty string for the default configuration.
+func (c *ResourceConfig) String() string {
+	var parts []string
+	add := func(format string, args ...interface{}) {
+		parts = append(parts, fmt.Sprintf(format, args...))
+	}
+
+	if c.Mcc != 0 {
+		add("mcc%d", c.Mcc)
+		if c.Mnc != 0 {
+			add("mnc%d", c.Mnc)
+		}
+	}
+
+	if lang := c.LanguageString(); lang != "" {
+		script := strings.TrimRight(string(c.LocaleScript[:]), "\x00")
+		variant := strings.TrimRight(string(c.LocaleVariant[:]), "\x00")
+		country := c.CountryString()
+		if script != "" || variant != "" || len(lang) == 3 || len(country) == 3 {
+			locale := "b+" + lang
+			for _, p := range []string{script, country, variant} {
+				if p != "" {
+					locale += "+" + p
+				}
+			}
+			add("%s", locale)
+		} else if country != "" {
+			add("%s-r%s", lang, country)
+		} else {
+			add("%s", lang)
+		}
+	}
+
+	switch c.ScreenLayout & 0xC0 {
+	case 0x40:
+		add("ldltr")
+	case 0x80:
+		add("ldrtl")
+	}
+
+	if c.SmallestScreenWidthDp != 0 {
+		add("sw%ddp", c.SmallestScreenWidthDp)
+	}
+	if c.ScreenWidthDp != 0 {
+		add("w%ddp", c.ScreenWidthDp)
+	}
+	if c.ScreenHeightDp != 0 {
+		add("h%ddp", c.ScreenHeightDp)
+	}
+
+	addEnum := func(val uint8, names ...string) {
+		if int(val) > 0 && int(val) < len(names) && names[val] != "" {
+			add("%s", names[val])
+		}
+	}
+
+	addEnum(c.ScreenLayout&0x0F, "", "small", "normal", "large", "xlarge")
+	addEnum((c.ScreenLayout&0x30)>>4, "", "notlong", "long")
+	addEnum(c.ScreenLayout2&0x03, "", "notround", "round")
+	addEnum(c.ColorMode&0x03, "", "nowidecg", "widecg")
+	addEnum((c.ColorMode&0x0C)>>2, "", "lowdr", "highdr")
+	addEnum(c.Orientation, "", "port", "land", "square")
+	addEnum(c.UiMode&UiModeTypeMask, "", "", "desk", "car", "television", "appliance", "watch", "vrheadset")
+	addEnum((c.UiMode&UiModeNightMask)>>4, "", "notnight", "night")
+
+	switch c.Density {
+	case DensityDefault:
+	case DensityLow:
+		add("ldpi")
+	case DensityMedium:
+		add("mdpi")
+	case DensityTv:
+		add("tvdpi")
+	case DensityHigh:
+		add("hdpi")
+	case DensityXHigh:
+		add("xhdpi")
+	case DensityXXHigh:
+		add("xxhdpi")
+	case DensityXXXHigh:
+		add("xxxhdpi")
+	case DensityAny:
+		add("anydpi")
+	case DensityNone:
+		add("nodpi")
+	default:
+		add("%ddpi", c.Density)
+	}
+
+	addEnum(c.Touchscreen, "", "notouch", "stylus", "finger")
+	addEnum(c.InputFlags&0x03, "", "keysexposed", "keyshidden", "keyssoft")
+	addEnum(c.Keyboard, "", "nokeys", "qwerty", "12key")
+	addEnum((c.InputFlags&0x0C)>>2, "", "navexposed", "navhidden")
+	addEnum(c.Navigation, "", "nonav", "dpad", "trackball", "wheel")
+
+	if c.ScreenWidth != 0 && c.ScreenHeight != 0 {
+		add("%dx%d", c.ScreenWidth, c.ScreenHeight)
+	}
+
+	if c.SdkVersion != 0 {
+		add("v%d", c.SdkVersion)
+	}
+
+	return strings.Join(parts, "-")
+}
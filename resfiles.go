@@ -0,0 +1,159 @@
+package apkparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Resource types whose values are usually files in the APK.
+var fileResourceTypes = map[string]bool{
+	"anim":         true,
+	"animator":     true,
+	"color":        true,
+	"drawable":     true,
+	"font":         true,
+	"interpolator": true,
+	"layout":       true,
+	"menu":         true,
+	"mipmap":       true,
+	"navigation":   true,
+	"raw":          true,
+	"transition":   true,
+	"xml":          true,
+}
+
+// File backing a resource value, for example a png drawable or a binary XML.
+type ResourceFile struct {
+	// Path of the file in the APK, like "res/drawable-hdpi/icon.png", usable with ZipReader.File.
+	Path string
+	// Resource type like "drawable", "xml" or "raw".
+	Type string
+	// The configuration this file applies to.
+	Config ResourceConfig
+	// "png", "webp", "jpg", "xml" for binary XML or empty if not known. Recognized by the extension,
+	// or by the contents with ParseOptions.SniffResourceFiles for tables loaded by ApkParser.
+	Format string
+}
+
+// Returns the file backing the value of this entry. Returns false for values which are not files,
+// e.g. colors defined in place or strings.
+func (e *ResourceEntry) File() (ResourceFile, bool) {
+	if e.IsComplex() || e.value.dataType != AttrTypeString {
+		return ResourceFile{}, false
+	}
+
+	val, err := e.value.String()
+	if err != nil || !isResourceFilePath(e.ResourceType, val) {
+		return ResourceFile{}, false
+	}
+
+	return ResourceFile{
+		Path:   val,
+		Type:   e.ResourceType,
+		Config: e.Config,
+		Format: extensionFileFormat(val),
+	}, true
+}
+
+// Returns the files of resId in all configurations which have one. For tables loaded by ApkParser,
+// any value naming a file in the APK counts, even if the type was renamed by an obfuscator.
+func (x *ResourceTable) GetResourceFiles(resId uint32) ([]ResourceFile, error) {
+	entries, err := x.GetResourceEntries(resId)
+	if len(entries) == 0 {
+		return nil, err
+	}
+
+	var res []ResourceFile
+	for _, e := range entries {
+		f, ok := e.File()
+		if !ok && x.files != nil && !e.IsComplex() && e.value.dataType == AttrTypeString {
+			if val, err := e.value.String(); err == nil && x.files.File[val] != nil {
+				f, ok = ResourceFile{Path: val, Type: e.ResourceType, Config: e.Config}, true
+			}
+		}
+
+		if ok {
+			f.Format = x.fileFormat(f.Path)
+			res = append(res, f)
+		}
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("Resource 0x%08x is not backed by a file.", resId)
+	}
+	return res, nil
+}
+
+// Files are normally in res/, but obfuscators and resource shrinking move them elsewhere,
+// e.g. to r/a/b.png, so any path is accepted for the file-backed types.
+func isResourceFilePath(typ, val string) bool {
+	if strings.ContainsAny(val, " \t\n") || strings.HasSuffix(val, "/") {
+		return false
+	}
+	return strings.HasPrefix(val, "res/") || (fileResourceTypes[typ] && strings.Contains(val, "/"))
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Returns the format of the named file like ResourceFile.Format. The contents are sniffed with
+// ParseOptions.SniffResourceFiles if the table was loaded from an APK, as obfuscators like AndResGuard
+// shorten paths to r/a/b.png or drop the extension altogether.
+func (x *ResourceTable) fileFormat(name string) string {
+	if x.files == nil || !x.opts.isSniffResourceFiles() || x.files.File[name] == nil {
+		return extensionFileFormat(name)
+	}
+
+	// ZipReaderFile can't be read concurrently and the icons are looked up repeatedly.
+	x.filesMu.Lock()
+	defer x.filesMu.Unlock()
+
+	format, prs := x.fileFormats[name]
+	if !prs {
+		if format = sniffFileFormat(x.files.File[name]); format == "" {
+			format = extensionFileFormat(name)
+		}
+
+		if x.fileFormats == nil {
+			x.fileFormats = make(map[string]string)
+		}
+		x.fileFormats[name] = format
+	}
+	return format
+}
+
+// Recognizes the file by its first bytes, returns "" if the format is unknown.
+func sniffFileFormat(f *ZipReaderFile) string {
+	head, err := f.ReadAll(12)
+	if err != nil {
+		return ""
+	}
+
+	switch {
+	case bytes.HasPrefix(head, pngSignature):
+		return "png"
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return "webp"
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpg"
+	case len(head) >= 8 && binary.LittleEndian.Uint16(head) == chunkAxmlFile && binary.LittleEndian.Uint16(head[2:]) == chunkHeaderSize:
+		return "xml"
+	}
+	return ""
+}
+
+func extensionFileFormat(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png":
+		return "png"
+	case ".webp":
+		return "webp"
+	case ".jpg", ".jpeg":
+		return "jpg"
+	case ".xml":
+		return "xml"
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+package apkparser
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Externally known name and value of one resource, see ResourceMapping.
+type MappedResource struct {
+	Package string // for example "com.example.app", empty keeps the name from the table
+	Type    string // for example "string"
+	Key     string // for example "app_name"
+
+	// Value used when the table doesn't have the entry, in the format of ResourceValue.String.
+	// Empty means the value is not known.
+	Value string
+}
+
+// Resource id -> externally known resource, for example recovered from a previous version of the app
+// with ResourceTable.Mapping or from mapping files of AndResGuard-like tools. Set it in
+// ParseOptions.ResourceMapping to restore names of obfuscated resources and values of stripped ones.
+type ResourceMapping map[uint32]MappedResource
+
+// Returns a table without any resources of its own, which resolves ids only from the mapping.
+// Meant for APKs with resources.arsc missing or broken beyond parsing.
+func NewResourceTableFromMapping(mapping ResourceMapping) *ResourceTable {
+	return &ResourceTable{
+		nextPackageId: 2,
+		packages:      make(map[uint32]*packageGroup),
+		mapping:       mapping,
+	}
+}
+
+// Returns names and values of all simple resources in the table, in the first configuration
+// they are defined for. The result can be used to deobfuscate another version of the same app.
+func (x *ResourceTable) Mapping() ResourceMapping {
+	res := make(ResourceMapping)
+	for _, pkgId := range x.packageIds() {
+		group := x.packages[pkgId]
+		for typeId := 1; typeId <= int(group.largestTypeId); typeId++ {
+			var count int
+			for _, spec := range group.typeSpecs(uint8(typeId)) {
+				if len(spec.Entries) > count {
+					count = len(spec.Entries)
+				}
+			}
+
+			for entryId := 0; entryId < count; entryId++ {
+				e, err := x.getEntry(group, uint32(typeId)-1, uint32(entryId), ConfigFirst)
+				if err != nil || e == nil {
+					continue
+				}
+
+				m := MappedResource{
+					Package: group.Name,
+					Type:    e.ResourceType,
+					Key:     e.Key,
+				}
+				if !e.IsComplex() {
+					m.Value, _ = e.value.String()
+				}
+				res[pkgId<<24|uint32(typeId)<<16|uint32(entryId)] = m
+			}
+		}
+	}
+	return res
+}
+
+// Returns the name of the resource from the mapping, in the format of GetResourceName.
+func (x *ResourceTable) mappedName(resId uint32) (string, bool) {
+	m, prs := x.mapping[resId]
+	if !prs || m.Type == "" || m.Key == "" {
+		return "", false
+	}
+
+	pkg := m.Package
+	if group := x.packages[resId>>24]; pkg == "" && group != nil {
+		pkg = group.Name
+	}
+	return fmt.Sprintf("@%s:%s.%s", m.Type, pkg, m.Key), true
+}
+
+// Looks up the id of a resource name in the mapping, the lowest id wins if more resources match.
+func (x *ResourceTable) mappedId(pkgName, typeName, key string) (uint32, bool) {
+	var ids []uint32
+	for id, m := range x.mapping {
+		if m.Type != typeName || m.Key != key {
+			continue
+		}
+
+		pkg := m.Package
+		if group := x.packages[id>>24]; pkg == "" && group != nil {
+			pkg = group.Name
+		}
+		if pkgName == "" || pkg == pkgName {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return 0, false
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids[0], true
+}
+
+// Renames entries found in the table according to the mapping, or makes up an entry with the mapped
+// value if the table has none.
+func (x *ResourceTable) mapEntries(resId uint32, entries []*ResourceEntry, err error) ([]*ResourceEntry, error) {
+	m, prs := x.mapping[resId]
+	if !prs {
+		return entries, err
+	}
+
+	if len(entries) == 0 {
+		if m.Value == "" {
+			return entries, err
+		}
+
+		e := &ResourceEntry{
+			ResourceType: m.Type,
+			Key:          m.Key,
+			Package:      m.Package,
+		}
+		e.value.dataType = AttrTypeString
+		e.value.convertedData = m.Value
+		return []*ResourceEntry{e}, nil
+	}
+
+	for _, e := range entries {
+		if m.Package != "" {
+			e.Package = m.Package
+		}
+		if m.Type != "" {
+			e.ResourceType = m.Type
+		}
+		if m.Key != "" {
+			e.Key = m.Key
+		}
+	}
+	return entries, err
+}
+
+func (x *ResourceTable) mapEntry(resId uint32, entry *ResourceEntry, err error) (*ResourceEntry, error) {
+	var entries []*ResourceEntry
+	if entry != nil {
+		entries = []*ResourceEntry{entry}
+	}
+
+	entries, err = x.mapEntries(resId, entries, err)
+	if len(entries) == 0 {
+		return nil, err
+	}
+	return entries[0], err
+}
@@ -2,13 +2,16 @@ package apkparser
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
+	"sort"
 	"strings"
+	"sync"
 	"unicode/utf16"
 )
 
@@ -19,6 +22,31 @@ type ResourceTable struct {
 	mainStrings   stringTable
 	nextPackageId uint32
 	packages      map[uint32]*packageGroup
+
+	overlayables []*Overlayable
+
+	// staged resource id -> finalized resource id
+	stagedAliases map[uint32]uint32
+
+	// build-time package id -> package name, from the library chunks
+	libraries []SharedLibrary
+
+	// id of the first package, which dynamic references with package id 0 point to
+	ownPackageId uint32
+
+	// from ParseOptions.ResourceMapping
+	mapping ResourceMapping
+
+	// set for tables parsed by ParseResourceTableReaderAt
+	source *resourceSource
+
+	// APK the table was loaded from, used to recognize files referenced by values by their contents
+	files       *ZipReader
+	fileFormats map[string]string
+	filesMu     sync.Mutex
+
+	opts     *ParseOptions
+	warnings []Warning
 }
 
 type packageGroup struct {
@@ -29,6 +57,10 @@ type packageGroup struct {
 	table         *ResourceTable
 	largestTypeId uint8
 	types         map[uint8][]resourceTypeSpec
+
+	// type chunks not parsed yet by type id, nil unless ParseOptions.LazyResourceTypes is set
+	pendingTypes map[uint8][]pendingType
+	pendingMu    sync.Mutex
 }
 
 type resourcePackage struct {
@@ -49,18 +81,42 @@ type resourceTypeSpec struct {
 }
 
 type resourceType struct {
-	chunkData    []byte
+	typeChunk
+	flags        uint8
 	entryCount   uint32
 	entriesStart uint32
 	indexesStart uint32
 
-	// ResTable_config config;
+	config ResourceConfig
+}
+
+// Type chunk with resource entries. block holds the whole chunk, it's nil in streamed tables,
+// which read the chunk from source.
+type typeChunk struct {
+	block        []byte
+	source       io.ReaderAt
+	sourceOffset int64
+	size         int
+	hdrLen       uint16
+}
+
+// Type chunk deferred by ParseOptions.LazyResourceTypes.
+type pendingType struct {
+	typeChunk
+	spec int // index of the spec in packageGroup.types the chunk belongs to
 }
 
 const (
 	tableEntryComplex = 0x0001
 	tableEntryPublic  = 0x0002
 	tableEntryWeak    = 0x0004
+	tableEntryCompact = 0x0008 // key index in place of size, value type in the high byte of flags and data in place of key
+)
+
+// ResTable_type flags
+const (
+	tableTypeSparse   = 0x01 // the indexes are (entry index, offset/4) pairs sorted by entry index
+	tableTypeOffset16 = 0x02 // the indexes are uint16 offset/4
 )
 
 // Describes one resource entry, for example @drawable/icon in the original XML, in one particular config option.
@@ -72,7 +128,14 @@ type ResourceEntry struct {
 	Key          string
 	Package      string
 
+	// The configuration this value applies to
+	Config ResourceConfig
+
 	value ResourceValue
+
+	// complex entries only
+	parent uint32
+	bag    []ResourceBagItem
 }
 
 // Handle to the resource's actual value.
@@ -102,20 +165,98 @@ const (
 
 // Parses the resources.arsc file
 func ParseResourceTable(r io.Reader) (*ResourceTable, error) {
+	return ParseResourceTableEx(r, nil)
+}
+
+// Same as ParseResourceTable, but aborts with ctx.Err() once the ctx is done.
+func ParseResourceTableCtx(ctx context.Context, r io.Reader) (*ResourceTable, error) {
+	return ParseResourceTableEx(r, &ParseOptions{Context: ctx})
+}
+
+// Same as ParseResourceTable, but parses the data in memory.
+func ParseResourceTableBytes(data []byte) (*ResourceTable, error) {
+	return ParseResourceTable(bytes.NewReader(data))
+}
+
+// Parses the resources.arsc file with options, opts can be nil.
+func ParseResourceTableEx(r io.Reader, opts *ParseOptions) (table *ResourceTable, err error) {
+	defer recoverPanic(&err)
+	return parseResourceTable(r, opts, nil)
+}
+
+// Same as ParseResourceTableEx, but only the headers of the type chunks with resource entries are read
+// while parsing, and the entries are read from r on demand. Uses a fraction of the memory when only
+// a few resources are resolved, at the cost of reading the chunk on every lookup.
+// String pools are kept as with ParseOptions.LazyStrings. r must stay readable while the table is used.
+func ParseResourceTableReaderAt(r io.ReaderAt, size int64, opts *ParseOptions) (table *ResourceTable, err error) {
+	defer recoverPanic(&err)
+
+	lazyOpts := ParseOptions{}
+	if opts != nil {
+		lazyOpts = *opts
+	}
+	lazyOpts.LazyStrings = true
+
+	source := &resourceSource{r: r, section: io.NewSectionReader(r, 0, size)}
+	return parseResourceTable(source, &lazyOpts, source)
+}
+
+// Reader of streamed tables, which tracks the position to find the chunk offsets.
+type resourceSource struct {
+	r       io.ReaderAt
+	section *io.SectionReader
+	pos     int64
+}
+
+func (s *resourceSource) Read(p []byte) (int, error) {
+	n, err := s.section.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+// Moves past n bytes of r, which reads from the source, without reading them.
+func (s *resourceSource) skip(r *io.LimitedReader, n int64) error {
+	if _, err := s.section.Seek(n, io.SeekCurrent); err != nil {
+		return err
+	}
+	s.pos += n
+	r.N -= n
+	return nil
+}
+
+func parseResourceTable(r io.Reader, opts *ParseOptions, source *resourceSource) (*ResourceTable, error) {
 	res := ResourceTable{
 		nextPackageId: 2,
 		packages:      make(map[uint32]*packageGroup),
+		source:        source,
 	}
 
+	// Collect the warnings in the table, and pass them to the caller's slice too.
+	localOpts := ParseOptions{}
+	if opts != nil {
+		localOpts = *opts
+		if opts.Warnings != nil {
+			defer func() {
+				*opts.Warnings = append(*opts.Warnings, res.warnings...)
+			}()
+		}
+	}
+	localOpts.Warnings = &res.warnings
+	opts = localOpts.withState()
+	res.opts = opts
+	res.mapping = localOpts.ResourceMapping
+
 	id, hdrLen, totalLen, err := parseChunkHeader(r)
 	if err != nil {
 		return nil, err
 	}
 
 	// Android doesn't care.
-	/*if id != chunkTable {
-		return fmt.Errorf("Invalid top chunk id: 0x%08x", id)
-	}*/
+	if id != chunkTable {
+		if err := opts.anomaly(WarnInvalidChunkId, "Invalid top chunk id: 0x%08x", id); err != nil {
+			return nil, err
+		}
+	}
 
 	var packageCurrent, packagesCnt uint32
 	if err = binary.Read(r, binary.LittleEndian, &packagesCnt); err != nil {
@@ -126,29 +267,38 @@ func ParseResourceTable(r io.Reader) (*ResourceTable, error) {
 		return nil, fmt.Errorf("Invalid header length: %d", hdrLen)
 	}
 
+	headerEnd := int64(hdrLen)
 	totalLen -= uint32(hdrLen)
 	hdrLen -= chunkHeaderSize + 4
 
 	if _, err = io.CopyN(ioutil.Discard, r, int64(hdrLen)); err != nil {
-		return nil, fmt.Errorf("Failed to read header padding: %s", err.Error())
+		return nil, fmt.Errorf("Failed to read header padding: %w", truncated(err))
 	}
 
 	var len uint32
 	var lastId uint16
 	for i := uint32(0); i < totalLen; i += len {
+		if err := opts.checkContext(); err != nil {
+			return nil, err
+		}
+
 		id, hdrLen, len, err = parseChunkHeader(r)
 		if err != nil {
-			return nil, fmt.Errorf("Error parsing header at 0x%08x of 0x%08x %08x: %s", i, totalLen, lastId, err.Error())
+			return nil, fmt.Errorf("Error parsing header at 0x%08x of 0x%08x %08x: %w", i, totalLen, lastId, truncated(err))
 		}
 
 		lastId = id
+		opts.progressChunk(ProgressResources, headerEnd+int64(i))
+		opts.countChunk(id)
 
 		lm := &io.LimitedReader{R: r, N: int64(len) - chunkHeaderSize}
 
 		switch id {
 		case chunkStringTable:
 			if res.mainStrings.isEmpty() {
-				res.mainStrings, err = parseStringTable(lm)
+				res.mainStrings, err = parseStringTable(lm, hdrLen, opts)
+			} else {
+				err = opts.anomaly(WarnDuplicateChunk, "Duplicate global string table")
 			}
 		case chunkTablePackage:
 			if packageCurrent >= packagesCnt {
@@ -158,26 +308,54 @@ func ParseResourceTable(r io.Reader) (*ResourceTable, error) {
 			err = res.parsePackage(lm, hdrLen)
 			packageCurrent++
 		default:
+			if err = opts.anomaly(WarnUnknownChunk, "Unknown chunk id 0x%x", id); err != nil {
+				break
+			}
+
 			// Ignore unknown chunks, 075909870a3d16a194e084fbe7a98d2da07c8317fcbfe1f25e5478e585be1954
-			_, err = io.CopyN(ioutil.Discard, lm, lm.N)
+			err = opts.skip(lm, "unknown chunk 0x%04x", id)
 		}
 
 		if err != nil {
-			return nil, fmt.Errorf("Chunk: 0x%08x: %s", id, err.Error())
+			return nil, fmt.Errorf("Chunk: 0x%08x: %w", id, truncated(err))
 		} else if lm.N != 0 {
 			return nil, fmt.Errorf("Chunk: 0x%08x: was not fully read", id)
 		}
 	}
+
+	if packageCurrent != packagesCnt {
+		if err := opts.anomaly(WarnPackageCountMismatch, "Expected %d packages, found %d", packagesCnt, packageCurrent); err != nil {
+			return nil, err
+		}
+	}
 	return &res, nil
 }
 
 func (x *ResourceTable) parsePackage(r *io.LimitedReader, hdrLen uint16) error {
-	pkgBlock, err := ioutil.ReadAll(r)
-	if err != nil {
-		return fmt.Errorf("error reading package block: %s", err.Error())
-	}
+	// Streamed tables read the chunks from the source as they're needed, without the entries
+	// of the type chunks. Otherwise the whole package is read into memory.
+	var pkgBlock []byte
+	var pkgReader io.ReadSeeker
+	var pkgOffset, pkgLen int64
+	if x.source != nil {
+		pkgOffset = x.source.pos
+		pkgLen = r.N
+		if rest := x.source.section.Size() - pkgOffset; pkgLen > rest {
+			pkgLen = rest
+		}
+		pkgReader = io.NewSectionReader(x.source.r, pkgOffset, pkgLen)
+	} else {
+		if err := x.opts.alloc(r.N); err != nil {
+			return err
+		}
 
-	pkgReader := bytes.NewReader(pkgBlock)
+		var err error
+		if pkgBlock, err = ioutil.ReadAll(r); err != nil {
+			return fmt.Errorf("error reading package block: %w", truncated(err))
+		}
+		pkgReader = bytes.NewReader(pkgBlock)
+		pkgLen = int64(len(pkgBlock))
+	}
 
 	const valsSize = chunkHeaderSize + 4 + 2*128 + 4*5
 	vals := struct {
@@ -191,7 +369,7 @@ func (x *ResourceTable) parsePackage(r *io.LimitedReader, hdrLen uint16) error {
 	}{}
 
 	if err := binary.Read(pkgReader, binary.LittleEndian, &vals); err != nil {
-		return fmt.Errorf("error reading values: %s", err.Error())
+		return fmt.Errorf("error reading values: %w", truncated(err))
 	}
 
 	if vals.Id >= 256 {
@@ -203,6 +381,10 @@ func (x *ResourceTable) parsePackage(r *io.LimitedReader, hdrLen uint16) error {
 		x.nextPackageId++
 	}
 
+	if x.ownPackageId == 0 {
+		x.ownPackageId = vals.Id
+	}
+
 	pkg := &resourcePackage{
 		Id: vals.Id,
 	}
@@ -228,7 +410,8 @@ func (x *ResourceTable) parsePackage(r *io.LimitedReader, hdrLen uint16) error {
 		return err
 	}
 
-	if pkg.typeStrings, err = parseStringTableWithChunk(pkgReader); err != nil {
+	var err error
+	if pkg.typeStrings, err = parseStringTableWithChunk(pkgReader, x.opts); err != nil {
 		return err
 	}
 
@@ -236,7 +419,7 @@ func (x *ResourceTable) parsePackage(r *io.LimitedReader, hdrLen uint16) error {
 		return err
 	}
 
-	if pkg.keyStrings, err = parseStringTableWithChunk(pkgReader); err != nil {
+	if pkg.keyStrings, err = parseStringTableWithChunk(pkgReader, x.opts); err != nil {
 		return err
 	}
 
@@ -248,6 +431,9 @@ func (x *ResourceTable) parsePackage(r *io.LimitedReader, hdrLen uint16) error {
 			table: x,
 			types: make(map[uint8][]resourceTypeSpec),
 		}
+		if x.opts.isLazyResourceTypes() {
+			group.pendingTypes = make(map[uint8][]pendingType)
+		}
 		x.packages[pkg.Id] = group
 
 		/*
@@ -267,49 +453,103 @@ func (x *ResourceTable) parsePackage(r *io.LimitedReader, hdrLen uint16) error {
 	}
 
 	for {
+		if err := x.opts.checkContext(); err != nil {
+			return err
+		}
+
 		chunkStartOffset, _ := pkgReader.Seek(0, io.SeekCurrent)
 
 		id, hdrLen, totalLen, err := parseChunkHeader(pkgReader)
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return fmt.Errorf("Error parsing package internal header: %s", err.Error())
+			return fmt.Errorf("Error parsing package internal header: %w", truncated(err))
 		}
 
 		// Sample: 7e97541191621e72bd794b5b2d60eb2f68669ea8782421e54ec719ccda06c8a4
-		if chunkStartOffset+int64(totalLen) >= int64(len(pkgBlock)) {
-			totalLen = uint32(int64(len(pkgBlock)) - chunkStartOffset)
+		if chunkStartOffset+int64(totalLen) >= pkgLen {
+			if chunkStartOffset+int64(totalLen) > pkgLen {
+				if err := x.opts.anomaly(WarnChunkOverflow, "Chunk 0x%08x overflows the package", id); err != nil {
+					return err
+				}
+			}
+			totalLen = uint32(pkgLen - chunkStartOffset)
 		}
 
+		x.opts.progressChunk(ProgressResources, -1)
+		x.opts.countChunk(id)
+
 		lm := &io.LimitedReader{R: pkgReader, N: int64(totalLen) - chunkHeaderSize}
 
 		switch id {
 		case chunkTableTypeSpec:
-			err = x.parseTypeSpec(lm, pkg, group)
+			err = x.parseTypeSpec(lm, pkg, group, hdrLen)
+		case chunkTableStagedAlias:
+			err = x.parseStagedAlias(lm, hdrLen)
+		case chunkTableLibrary:
+			err = x.parseLibrary(lm, hdrLen)
 		case chunkTableType:
-			block := pkgBlock[chunkStartOffset : chunkStartOffset+int64(totalLen)]
-			if err = x.parseType(lm, pkg, group, block, hdrLen); err != nil {
-				break
+			c := typeChunk{size: int(totalLen), hdrLen: hdrLen}
+			if x.source != nil {
+				c.source = x.source.r
+				c.sourceOffset = pkgOffset + chunkStartOffset
+			} else {
+				c.block = pkgBlock[chunkStartOffset : chunkStartOffset+int64(totalLen)]
+			}
+
+			if err = x.parseType(group, c); err == nil {
+				err = skipChunk(pkgReader, lm, chunkStartOffset+int64(totalLen))
+			}
+		case chunkTableOverlayable:
+			var block []byte
+			if x.source != nil {
+				if err = x.opts.alloc(int64(totalLen)); err != nil {
+					break
+				}
+				block = make([]byte, totalLen)
+				if _, err = io.ReadFull(io.NewSectionReader(x.source.r, pkgOffset+chunkStartOffset, int64(totalLen)), block); err != nil {
+					break
+				}
+			} else {
+				block = pkgBlock[chunkStartOffset : chunkStartOffset+int64(totalLen)]
+			}
+
+			if err = x.parseOverlayable(block, hdrLen, pkg); err == nil {
+				err = skipChunk(pkgReader, lm, chunkStartOffset+int64(totalLen))
 			}
-			fallthrough
 		default:
-			_, err = io.CopyN(ioutil.Discard, lm, lm.N)
+			err = x.opts.skip(lm, "unknown package chunk 0x%04x", id)
 		}
 
 		if err != nil {
-			return fmt.Errorf("Chunk: 0x%08x: %s", id, err.Error())
+			return fmt.Errorf("Chunk: 0x%08x: %w", id, truncated(err))
 		} else if lm.N != 0 {
 			return fmt.Errorf("Chunk: 0x%08x: was not fully read", id)
 		}
 	}
 
+	// The package was read through the section, the stream just moves past it.
+	if x.source != nil {
+		return x.source.skip(r, pkgLen)
+	}
+	return nil
+}
+
+// Moves r, which reads the chunk at end, past the chunk parsed from its block.
+func skipChunk(r io.Seeker, lm *io.LimitedReader, end int64) error {
+	if _, err := r.Seek(end, io.SeekStart); err != nil {
+		return err
+	}
+	lm.N = 0
 	return nil
 }
 
-func (x *ResourceTable) parseTypeSpec(r io.Reader, pkg *resourcePackage, group *packageGroup) error {
+func (x *ResourceTable) parseTypeSpec(r io.Reader, pkg *resourcePackage, group *packageGroup, hdrLen uint16) error {
+	const typeSpecHeaderSize = chunkHeaderSize + 8
+
 	var id uint8
 	if err := binary.Read(r, binary.LittleEndian, &id); err != nil {
-		return fmt.Errorf("Failed to read type spec id: %s", err.Error())
+		return fmt.Errorf("Failed to read type spec id: %w", truncated(err))
 	}
 
 	if id == 0 {
@@ -317,20 +557,31 @@ func (x *ResourceTable) parseTypeSpec(r io.Reader, pkg *resourcePackage, group *
 	}
 
 	if _, err := io.CopyN(ioutil.Discard, r, 1+2); err != nil {
-		return fmt.Errorf("Failed to skip padding: %s", err.Error())
+		return fmt.Errorf("Failed to skip padding: %w", truncated(err))
 	}
 
 	var entryCount uint32
 	if err := binary.Read(r, binary.LittleEndian, &entryCount); err != nil {
-		return fmt.Errorf("Failed to read entryCount: %s", err.Error())
+		return fmt.Errorf("Failed to read entryCount: %w", truncated(err))
+	}
+
+	// The flags start right after the header, which may have fields added in newer versions.
+	if hdrLen > typeSpecHeaderSize {
+		if _, err := io.CopyN(ioutil.Discard, r, int64(hdrLen-typeSpecHeaderSize)); err != nil {
+			return fmt.Errorf("Failed to skip type spec header: %w", truncated(err))
+		}
 	}
 
 	if entryCount > 0 {
+		if err := x.opts.alloc(4 * int64(entryCount)); err != nil {
+			return err
+		}
+
 		var entries []uint32
 		for i := uint32(0); i < entryCount; i++ {
 			var e uint32
 			if err := binary.Read(r, binary.LittleEndian, &e); err != nil {
-				return fmt.Errorf("Failed to read type spec entry: %s", err.Error())
+				return fmt.Errorf("Failed to read type spec entry: %w", truncated(err))
 			}
 			entries = append(entries, e)
 		}
@@ -348,45 +599,260 @@ func (x *ResourceTable) parseTypeSpec(r io.Reader, pkg *resourcePackage, group *
 	return nil
 }
 
-func (x *ResourceTable) parseType(r io.Reader, pkg *resourcePackage, group *packageGroup, chunkData []byte, hdrLen uint16) error {
-	vals := struct {
-		Id   uint8
-		Res0 uint8
-		Res1 uint16
+// Size of ResTable_type without the config.
+const typeHeaderSize = chunkHeaderSize + 12
 
-		EntryCount   uint32
-		EntriesStart uint32
+// Checks the type chunk and adds it to the last spec of its type, parsed right away unless
+// ParseOptions.LazyResourceTypes is set.
+func (x *ResourceTable) parseType(group *packageGroup, c typeChunk) error {
+	lazy := x.opts.isLazyResourceTypes()
 
-		//ResTable_config config;
-	}{}
+	header, err := c.header(!lazy)
+	if err != nil {
+		return err
+	} else if len(header) < typeHeaderSize {
+		return fmt.Errorf("error reading values: %w", truncated(io.ErrUnexpectedEOF))
+	}
 
-	if err := binary.Read(r, binary.LittleEndian, &vals); err != nil {
-		return fmt.Errorf("error reading values: %s", err.Error())
+	id := header[chunkHeaderSize]
+	if id == 0 {
+		return fmt.Errorf("Invalid type id: %d", id)
 	}
 
-	if vals.Id == 0 {
-		return fmt.Errorf("Invalid type id: %d", vals.Id)
+	if binary.LittleEndian.Uint32(header[chunkHeaderSize+4:]) == 0 {
+		return nil
 	}
 
-	if vals.EntryCount > 0 {
-		typeList := group.types[vals.Id]
-		if len(typeList) == 0 {
-			return fmt.Errorf("No spec entry for type %d", vals.Id)
+	spec := len(group.types[id]) - 1
+	if spec < 0 {
+		return fmt.Errorf("No spec entry for type %d", id)
+	}
+
+	if lazy {
+		group.pendingTypes[id] = append(group.pendingTypes[id], pendingType{typeChunk: c, spec: spec})
+		return nil
+	}
+	return x.addType(group, id, spec, c, header)
+}
+
+// Returns the header of the chunk, with the config if withConfig is set.
+func (c *typeChunk) header(withConfig bool) ([]byte, error) {
+	n := typeHeaderSize
+	if withConfig && int(c.hdrLen) > n {
+		n = int(c.hdrLen)
+	}
+	return c.head(n)
+}
+
+// Adds the type chunk with the header to the spec at index spec of the type.
+func (x *ResourceTable) addType(group *packageGroup, id uint8, spec int, c typeChunk, header []byte) error {
+	t := &resourceType{
+		typeChunk:    c,
+		flags:        header[chunkHeaderSize+1],
+		entryCount:   binary.LittleEndian.Uint32(header[chunkHeaderSize+4:]),
+		entriesStart: binary.LittleEndian.Uint32(header[chunkHeaderSize+8:]),
+		indexesStart: uint32(c.hdrLen),
+	}
+
+	if err := t.decodeConfig(header); err != nil {
+		if err := x.opts.anomaly(WarnUnusualLayout, "type %d: %s", id, err.Error()); err != nil {
+			return err
+		}
+	}
+
+	specs := group.types[id]
+	specs[spec].Configs = append(specs[spec].Configs, t)
+	return nil
+}
+
+// Decodes ResTable_config from the type chunk header.
+func (t *resourceType) decodeConfig(chunkData []byte) (err error) {
+	const configStart = chunkHeaderSize + 12
+	if int(t.indexesStart) > configStart && int(t.indexesStart) <= len(chunkData) {
+		t.config, err = parseResourceConfig(chunkData[configStart:t.indexesStart])
+	}
+	return
+}
+
+// Returns the specs of the type, with the type chunks deferred by ParseOptions.LazyResourceTypes parsed.
+func (g *packageGroup) typeSpecs(id uint8) []resourceTypeSpec {
+	if g.pendingTypes == nil {
+		return g.types[id]
+	}
+
+	g.pendingMu.Lock()
+	defer g.pendingMu.Unlock()
+
+	for _, p := range g.pendingTypes[id] {
+		header, err := p.header(true)
+		if err == nil {
+			err = g.table.addType(g, id, p.spec, p.typeChunk, header)
+		}
+		if err != nil {
+			g.table.opts.warn(Warning{Kind: WarnUnusualLayout, Message: fmt.Sprintf("type %d: %s", id, err.Error())})
+		}
+	}
+	delete(g.pendingTypes, id)
+	return g.types[id]
+}
+
+// Returns anomalies which were recovered from while parsing the table.
+func (x *ResourceTable) Warnings() []Warning {
+	return x.warnings
+}
+
+// Parses RES_TABLE_STAGED_ALIAS_TYPE, which maps resource ids used during platform development
+// to their finalized ids.
+func (x *ResourceTable) parseStagedAlias(r *io.LimitedReader, hdrLen uint16) error {
+	if hdrLen < chunkHeaderSize+4 {
+		return fmt.Errorf("Invalid staged alias header length: %d", hdrLen)
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return fmt.Errorf("Failed to read staged alias count: %w", truncated(err))
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, r, int64(hdrLen-chunkHeaderSize-4)); err != nil {
+		return fmt.Errorf("Failed to skip staged alias header: %w", truncated(err))
+	}
+
+	if uint64(count)*8 > uint64(r.N) {
+		return fmt.Errorf("Staged alias count %d overflows the chunk", count)
+	}
+
+	if x.stagedAliases == nil {
+		x.stagedAliases = make(map[uint32]uint32)
+	}
+
+	for i := uint32(0); i < count; i++ {
+		var entry struct {
+			StagedResId    uint32
+			FinalizedResId uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+			return fmt.Errorf("Failed to read staged alias entry: %w", truncated(err))
+		}
+		x.stagedAliases[entry.StagedResId] = entry.FinalizedResId
+	}
+
+	// Trailing padding, if any.
+	_, err := io.CopyN(ioutil.Discard, r, r.N)
+	return err
+}
+
+// Returns the mapping of staged resource ids to their finalized ids.
+func (x *ResourceTable) StagedAliases() map[uint32]uint32 {
+	return x.stagedAliases
+}
+
+// Shared library a resources package references, from the RES_TABLE_LIBRARY_TYPE chunk.
+type SharedLibrary struct {
+	// Package id used in references to resources of the library when the APK was built
+	PackageId   uint32
+	PackageName string
+}
+
+// Parses RES_TABLE_LIBRARY_TYPE, which lists the shared libraries by the package ids
+// used for them at build time.
+func (x *ResourceTable) parseLibrary(r *io.LimitedReader, hdrLen uint16) error {
+	if hdrLen < chunkHeaderSize+4 {
+		return fmt.Errorf("Invalid library header length: %d", hdrLen)
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return fmt.Errorf("Failed to read library count: %w", truncated(err))
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, r, int64(hdrLen-chunkHeaderSize-4)); err != nil {
+		return fmt.Errorf("Failed to skip library header: %w", truncated(err))
+	}
+
+	const entrySize = 4 + 128*2
+	if uint64(count)*entrySize > uint64(r.N) {
+		return fmt.Errorf("Library count %d overflows the chunk", count)
+	}
+
+	for i := uint32(0); i < count; i++ {
+		var entry struct {
+			PackageId   uint32
+			PackageName [128]uint16
+		}
+		if err := binary.Read(r, binary.LittleEndian, &entry); err != nil {
+			return fmt.Errorf("Failed to read library entry: %w", truncated(err))
+		}
+
+		name := string(utf16.Decode(entry.PackageName[:]))
+		if idx := strings.IndexRune(name, 0); idx != -1 {
+			name = name[:idx]
 		}
 
-		i := len(typeList) - 1
-		typeList[i].Configs = append(typeList[i].Configs, &resourceType{
-			chunkData:    chunkData,
-			entryCount:   vals.EntryCount,
-			entriesStart: vals.EntriesStart,
-			indexesStart: uint32(hdrLen),
+		x.libraries = append(x.libraries, SharedLibrary{
+			PackageId:   entry.PackageId,
+			PackageName: name,
 		})
 	}
-	return nil
+
+	_, err := io.CopyN(ioutil.Discard, r, r.N)
+	return err
+}
+
+// Returns the shared libraries referenced by the packages of this table.
+func (x *ResourceTable) SharedLibraries() []SharedLibrary {
+	return x.libraries
+}
+
+// Translates dynamic and staged resource ids to the ids the resources have in this table.
+func (x *ResourceTable) resolveId(resId uint32) uint32 {
+	resId = x.resolveDynamicId(resId)
+	if finalized, prs := x.stagedAliases[resId]; prs {
+		return finalized
+	}
+	return resId
+}
+
+// Resources of shared libraries are referenced with the package id the library had at build time
+// (0 for the library's own resources), which is mapped to the runtime id by the package name.
+//
+// frameworks/base/libs/androidfw/ResourceTypes.cpp, DynamicRefTable::lookupResourceId
+func (x *ResourceTable) resolveDynamicId(resId uint32) uint32 {
+	pkgId := resId >> 24
+	if resId == 0 || pkgId == 0x01 {
+		return resId
+	}
+
+	if pkgId == 0 {
+		if x.ownPackageId == 0 {
+			return resId
+		}
+		return x.ownPackageId<<24 | (resId & 0xFFFFFF)
+	}
+
+	for _, lib := range x.libraries {
+		if lib.PackageId != pkgId {
+			continue
+		}
+
+		for id, group := range x.packages {
+			if group.Name == lib.PackageName {
+				return id<<24 | (resId & 0xFFFFFF)
+			}
+		}
+	}
+	return resId
 }
 
 // Converts the resource id to readable name including the package name like "@drawable:com.example.app.icon".
-func (x *ResourceTable) GetResourceName(resId uint32) (string, error) {
+func (x *ResourceTable) GetResourceName(resId uint32) (name string, err error) {
+	defer recoverPanic(&err)
+
+	resId = x.resolveId(resId)
+
+	if name, ok := x.mappedName(resId); ok {
+		return name, nil
+	}
+
 	pkgId := (resId >> 24)
 	typ := ((resId >> 16) & 0xFF) - 1
 	entryId := (resId & 0xFFFF)
@@ -410,59 +876,370 @@ func (x *ResourceTable) GetResourceEntry(resId uint32) (*ResourceEntry, error) {
 }
 
 // Returns the resource entry for resId and config configuration option.
-func (x *ResourceTable) GetResourceEntryEx(resId uint32, config ResourceConfigOption) (*ResourceEntry, error) {
+func (x *ResourceTable) GetResourceEntryEx(resId uint32, config ResourceConfigOption) (res *ResourceEntry, err error) {
+	defer recoverPanic(&err)
+	return x.getResourceEntry(x.resolveId(resId), config)
+}
+
+// Same as GetResourceEntryEx, resId is already resolved by resolveId.
+func (x *ResourceTable) getResourceEntry(resId uint32, config ResourceConfigOption) (*ResourceEntry, error) {
 	if config == ConfigPngIcon {
-		return x.GetIconPng(resId)
+		return x.getIconPng(resId)
+	}
+
+	pkgId := (resId >> 24)
+	typ := ((resId >> 16) & 0xFF) - 1
+	entryId := (resId & 0xFFFF)
+
+	group := x.packages[pkgId]
+	if group == nil {
+		return x.mapEntry(resId, nil, fmt.Errorf("Invalid package identifier."))
 	}
 
+	entry, err := x.getEntry(group, typ, entryId, config)
+	return x.mapEntry(resId, entry, err)
+}
+
+// Returns the resource entry for resId in all configurations it is defined for.
+func (x *ResourceTable) GetResourceEntries(resId uint32) (res []*ResourceEntry, err error) {
+	defer recoverPanic(&err)
+
+	resId = x.resolveId(resId)
+
 	pkgId := (resId >> 24)
 	typ := ((resId >> 16) & 0xFF) - 1
 	entryId := (resId & 0xFFFF)
 
 	group := x.packages[pkgId]
 	if group == nil {
-		return nil, fmt.Errorf("Invalid package identifier.")
+		return x.mapEntries(resId, nil, fmt.Errorf("Invalid package identifier."))
+	}
+
+	entries, err := x.getEntryConfigs(group, typ, entryId, math.MaxInt32)
+	return x.mapEntries(resId, entries, err)
+}
+
+// Id and name of a package in the resource table.
+type ResourcePackageInfo struct {
+	Id   uint32
+	Name string
+}
+
+// Returns all packages in this table ordered by their id. Some APKs contain more than one,
+// for example shared libraries or feature packages.
+func (x *ResourceTable) Packages() []ResourcePackageInfo {
+	var res []ResourcePackageInfo
+	for _, pkgId := range x.packageIds() {
+		res = append(res, ResourcePackageInfo{
+			Id:   pkgId,
+			Name: x.packages[pkgId].Name,
+		})
+	}
+	return res
+}
+
+// Returns the id of the package with this name.
+func (x *ResourceTable) GetPackageId(name string) (uint32, error) {
+	for _, pkgId := range x.packageIds() {
+		if x.packages[pkgId].Name == name {
+			return pkgId, nil
+		}
+	}
+	return 0, fmt.Errorf("Package %s not found.", name)
+}
+
+// Returns sorted ids of the packages.
+func (x *ResourceTable) packageIds() []uint32 {
+	pkgIds := make([]uint32, 0, len(x.packages))
+	for id := range x.packages {
+		pkgIds = append(pkgIds, id)
+	}
+	sort.Slice(pkgIds, func(i, j int) bool { return pkgIds[i] < pkgIds[j] })
+	return pkgIds
+}
+
+// Returns all distinct configurations used by resources in this table, in the order they first appear.
+func (x *ResourceTable) Configs() []ResourceConfig {
+	var res []ResourceConfig
+	seen := make(map[ResourceConfig]bool)
+	for _, pkgId := range x.packageIds() {
+		group := x.packages[pkgId]
+		for typeId := 1; typeId <= int(group.largestTypeId); typeId++ {
+			for _, spec := range group.typeSpecs(uint8(typeId)) {
+				for _, t := range spec.Configs {
+					if !seen[t.config] {
+						seen[t.config] = true
+						res = append(res, t.config)
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+// Returns the id of a resource by its name, like "@string/app_name", "string/app_name"
+// or "@com.example:string/app_name". Names without package are looked up in the first package
+// of the table first, then in the others. Names from ParseOptions.ResourceMapping take precedence.
+func (x *ResourceTable) GetResourceId(name string) (id uint32, err error) {
+	defer recoverPanic(&err)
+
+	name = strings.TrimPrefix(name, "@")
+
+	var pkgName string
+	if idx := strings.IndexByte(name, ':'); idx != -1 {
+		pkgName, name = name[:idx], name[idx+1:]
+	}
+
+	slash := strings.IndexByte(name, '/')
+	if slash == -1 {
+		return 0, fmt.Errorf("Invalid resource name %s, expected type/name.", name)
+	}
+	typeName, key := name[:slash], name[slash+1:]
+
+	if id, ok := x.mappedId(pkgName, typeName, key); ok {
+		return id, nil
+	}
+
+	var pkgIds []uint32
+	if pkgName != "" {
+		pkgId, err := x.GetPackageId(pkgName)
+		if err != nil {
+			return 0, err
+		}
+		pkgIds = []uint32{pkgId}
+	} else {
+		pkgIds = []uint32{x.ownPackageId}
+		for _, pkgId := range x.packageIds() {
+			if pkgId != x.ownPackageId {
+				pkgIds = append(pkgIds, pkgId)
+			}
+		}
+	}
+
+	for _, pkgId := range pkgIds {
+		group := x.packages[pkgId]
+		if group == nil {
+			continue
+		}
+
+		for typeId := 1; typeId <= int(group.largestTypeId); typeId++ {
+			for _, spec := range group.typeSpecs(uint8(typeId)) {
+				pkg := spec.Package
+				if t, err := pkg.typeStrings.get(uint32(typeId) - 1 - pkg.typeIdOffset); err != nil || t != typeName {
+					continue
+				}
+
+				for _, config := range spec.Configs {
+					data, err := config.data()
+					if err != nil {
+						continue
+					}
+
+					for entry := uint32(0); entry < uint32(len(spec.Entries)); entry++ {
+						if k, err := config.entryKey(pkg, data, entry); err == nil && k == key {
+							return pkgId<<24 | uint32(typeId)<<16 | entry, nil
+						}
+					}
+				}
+			}
+		}
 	}
+	return 0, fmt.Errorf("Resource %s not found.", name)
+}
 
-	return x.getEntry(group, typ, entryId, config)
+// Returns the chunk data, streamed tables read it from the source on every call.
+func (c *typeChunk) data() ([]byte, error) {
+	return c.head(c.size)
 }
 
-// Return the biggest last config ending with .png. Falls back to GetResourceEntry() if none found.
-func (x *ResourceTable) GetIconPng(resId uint32) (*ResourceEntry, error) {
+// Returns the first n bytes of the chunk, or the whole chunk if it's shorter.
+func (c *typeChunk) head(n int) ([]byte, error) {
+	if n > c.size {
+		n = c.size
+	}
+
+	if c.source == nil {
+		return c.block[:n], nil
+	}
+
+	buf := make([]byte, n)
+	if read, err := c.source.ReadAt(buf, c.sourceOffset); read != n {
+		return nil, fmt.Errorf("Failed to read type chunk at 0x%x: %v", c.sourceOffset, err)
+	}
+	return buf, nil
+}
+
+// Returns the key of entry in this type config, without parsing the whole entry.
+func (t *resourceType) entryKey(pkg *resourcePackage, data []byte, entry uint32) (string, error) {
+	offset, prs, err := t.entryOffset(data, entry)
+	if err != nil {
+		return "", err
+	} else if !prs {
+		return "", fmt.Errorf("No entry.")
+	}
+
+	start := uint64(t.entriesStart) + uint64(offset)
+	if start+8 > uint64(len(data)) {
+		return "", fmt.Errorf("Entry out of bounds.")
+	}
+
+	flags := binary.LittleEndian.Uint16(data[start+2:])
+	if (flags & tableEntryCompact) != 0 {
+		return pkg.keyStrings.get(uint32(binary.LittleEndian.Uint16(data[start:])))
+	}
+	return pkg.keyStrings.get(binary.LittleEndian.Uint32(data[start+4:]))
+}
+
+// Returns the offset of entry relative to entriesStart in the chunk data, prs is false if the entry
+// is not defined in this config.
+func (t *resourceType) entryOffset(data []byte, entry uint32) (offset uint32, prs bool, err error) {
+	idx := uint64(t.indexesStart)
+
+	switch {
+	case (t.flags & tableTypeSparse) != 0:
+		if idx+4*uint64(t.entryCount) > uint64(len(data)) {
+			return 0, false, fmt.Errorf("Sparse entry indexes out of bounds.")
+		}
+
+		i := sort.Search(int(t.entryCount), func(i int) bool {
+			return uint32(binary.LittleEndian.Uint16(data[idx+4*uint64(i):])) >= entry
+		})
+		if i == int(t.entryCount) {
+			return 0, false, nil
+		}
+
+		pos := idx + 4*uint64(i)
+		if uint32(binary.LittleEndian.Uint16(data[pos:])) != entry {
+			return 0, false, nil
+		}
+		return uint32(binary.LittleEndian.Uint16(data[pos+2:])) * 4, true, nil
+	case (t.flags & tableTypeOffset16) != 0:
+		if entry >= t.entryCount {
+			return 0, false, nil
+		}
+
+		pos := idx + 2*uint64(entry)
+		if pos+2 > uint64(len(data)) {
+			return 0, false, fmt.Errorf("Entry index out of bounds.")
+		}
+
+		offset16 := binary.LittleEndian.Uint16(data[pos:])
+		if offset16 == math.MaxUint16 {
+			return 0, false, nil
+		}
+		return uint32(offset16) * 4, true, nil
+	default:
+		if entry >= t.entryCount {
+			return 0, false, nil
+		}
+
+		pos := idx + 4*uint64(entry)
+		if pos+4 > uint64(len(data)) {
+			return 0, false, fmt.Errorf("Entry index out of bounds.")
+		}
+
+		offset = binary.LittleEndian.Uint32(data[pos:])
+		if offset == math.MaxUint32 {
+			return 0, false, nil
+		}
+		return offset, true, nil
+	}
+}
+
+// Return the biggest last config ending with .png, or with a png file recognized by its contents
+// with ParseOptions.SniffResourceFiles. Falls back to GetResourceEntry() if none found.
+func (x *ResourceTable) GetIconPng(resId uint32) (icon *ResourceEntry, err error) {
+	defer recoverPanic(&err)
+	return x.getIconPng(x.resolveId(resId))
+}
+
+// Same as GetIconPng, resId is already resolved by resolveId.
+func (x *ResourceTable) getIconPng(resId uint32) (*ResourceEntry, error) {
 	pkgId := (resId >> 24)
 	typ := ((resId >> 16) & 0xFF) - 1
 	entryId := (resId & 0xFFFF)
 
 	group := x.packages[pkgId]
 	if group == nil {
-		return nil, fmt.Errorf("Invalid package identifier.")
+		return x.mapEntry(resId, nil, fmt.Errorf("Invalid package identifier."))
 	}
 
 	entries, err := x.getEntryConfigs(group, typ, entryId, 256)
 	if len(entries) == 0 {
-		return nil, err
+		return x.mapEntry(resId, nil, err)
 	}
 
+	// References of each config are followed separately, chains keeps the ids on the way to each entry.
+	chains := make([][]uint32, len(entries))
+	for i := range chains {
+		chains[i] = []uint32{resId}
+	}
+
+	maxDepth := x.opts.maxReferenceDepth()
 	var res *ResourceEntry
 	for i := 0; i < len(entries) && i < 1024; i++ {
 		e := entries[i]
 		if e.value.dataType == AttrTypeReference {
+			if len(chains[i]) > maxDepth {
+				continue
+			} else if containsId(chains[i], e.value.data) {
+				x.opts.warn(Warning{Kind: WarnReferenceLoop, Message: fmt.Sprintf("Reference loop at 0x%08x", e.value.data)})
+				continue
+			}
+
 			pkgId = (e.value.data >> 24)
 			typ = ((e.value.data >> 16) & 0xFF) - 1
 			entryId = (e.value.data & 0xFFFF)
 
-			if more, _ := x.getEntryConfigs(group, typ, entryId, 256); len(more) != 0 {
-				entries = append(entries, more...)
+			more, _ := x.getEntryConfigs(group, typ, entryId, 256)
+			for _, m := range more {
+				entries = append(entries, m)
+				chains = append(chains, append(chains[i][:len(chains[i]):len(chains[i])], e.value.data))
 			}
-		} else if val, _ := e.value.String(); strings.HasSuffix(val, ".png") {
+		} else if val, _ := e.value.String(); x.fileFormat(val) == "png" {
 			res = e
 		}
 	}
 
 	if res == nil {
-		return x.GetResourceEntry(resId)
+		return x.getResourceEntry(resId, ConfigFirst)
+	}
+	return x.mapEntry(resId, res, nil)
+}
+
+// Follows references starting at the value of entry e of resId at most maxDepth times, with preferences
+// of opts. Returns the entry at the end of the chain, whose value is still a reference if the chain
+// was longer, and the id found twice in the chain or 0 if there's no loop.
+func (x *ResourceTable) followReferences(resId uint32, e *ResourceEntry, maxDepth int, opts *ParseOptions) (*ResourceEntry, uint32) {
+	chain := []uint32{x.resolveId(resId)}
+	for depth := 0; depth < maxDepth; depth++ {
+		if e.value.dataType != AttrTypeReference && e.value.dataType != AttrTypeDynamicReference {
+			break
+		}
+
+		next := x.resolveId(e.value.data)
+		if containsId(chain, next) {
+			return e, next
+		}
+
+		nextEntry, err := x.getPreferredEntry(next, opts)
+		if err != nil {
+			break
+		}
+		chain = append(chain, next)
+		e = nextEntry
+	}
+	return e, 0
+}
+
+func containsId(ids []uint32, id uint32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
 	}
-	return res, nil
+	return false
 }
 
 func (x *ResourceTable) getEntry(group *packageGroup, typeId, entry uint32, config ResourceConfigOption) (*ResourceEntry, error) {
@@ -480,7 +1257,7 @@ func (x *ResourceTable) getEntry(group *packageGroup, typeId, entry uint32, conf
 }
 
 func (x *ResourceTable) getEntryConfigs(group *packageGroup, typeId, entry uint32, limit int) ([]*ResourceEntry, error) {
-	typeList := group.types[uint8(typeId+1)]
+	typeList := group.typeSpecs(uint8(typeId + 1))
 	if len(typeList) == 0 {
 		return nil, fmt.Errorf("Invalid type: %d", typeId)
 	}
@@ -489,30 +1266,25 @@ func (x *ResourceTable) getEntryConfigs(group *packageGroup, typeId, entry uint3
 	var entries []*ResourceEntry
 	for _, typ := range typeList {
 		for _, thisType := range typ.Configs {
-			if entry >= thisType.entryCount {
-				continue
-			}
-
-			r := bytes.NewReader(thisType.chunkData)
-			if _, err := r.Seek(int64(thisType.indexesStart+entry*4), io.SeekStart); err != nil {
+			data, err := thisType.data()
+			if err != nil {
 				return nil, err
 			}
 
-			var thisOffset uint32
-			if err := binary.Read(r, binary.LittleEndian, &thisOffset); err != nil {
-				return nil, fmt.Errorf("Failed to read this type offset: %s", err.Error())
-			}
-
-			if thisOffset == math.MaxUint32 {
+			thisOffset, prs, err := thisType.entryOffset(data, entry)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read this type offset: %w", truncated(err))
+			} else if !prs {
 				continue
 			}
 
 			offset := thisType.entriesStart + thisOffset
 
-			if int(offset) >= len(thisType.chunkData) || ((offset & 0x03) != 0) {
+			if int(offset) >= len(data) || ((offset & 0x03) != 0) {
 				return nil, fmt.Errorf("Invalid entry 0x%04x offset: %d!", entry, offset)
 			}
 
+			r := bytes.NewReader(data)
 			if _, err := r.Seek(int64(offset), io.SeekStart); err != nil {
 				return nil, err
 			}
@@ -521,6 +1293,7 @@ func (x *ResourceTable) getEntryConfigs(group *packageGroup, typeId, entry uint3
 			if err != nil {
 				lastErr = err
 			} else {
+				res.Config = thisType.config
 				entries = append(entries, res)
 			}
 
@@ -543,33 +1316,53 @@ func (x *ResourceTable) parseEntry(r io.Reader, pkg *resourcePackage, typeId uin
 	var keyIndex uint32
 
 	if err := binary.Read(r, binary.LittleEndian, &res.size); err != nil {
-		return nil, fmt.Errorf("Failed to read entry size: %s", err.Error())
+		return nil, fmt.Errorf("Failed to read entry size: %w", truncated(err))
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &res.flags); err != nil {
-		return nil, fmt.Errorf("Failed to read entry flags: %s", err.Error())
+		return nil, fmt.Errorf("Failed to read entry flags: %w", truncated(err))
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &keyIndex); err != nil {
-		return nil, fmt.Errorf("Failed to read entry key index: %s", err.Error())
+		return nil, fmt.Errorf("Failed to read entry key index: %w", truncated(err))
 	}
 
 	res.Package = pkg.Name
 
 	res.ResourceType, err = pkg.typeStrings.get(typeId - pkg.typeIdOffset)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid typeString: %s", err.Error())
+		return nil, fmt.Errorf("Invalid typeString: %w", truncated(err))
+	}
+
+	// Compact entries are just 8 bytes, the fields are reused for the key index and value.
+	var compactData uint32
+	if (res.flags & tableEntryCompact) != 0 {
+		compactData = keyIndex
+		keyIndex = uint32(res.size)
+		res.size = 8
 	}
 
 	res.Key, err = pkg.keyStrings.get(keyIndex)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid keyString: %s", err.Error())
+		return nil, fmt.Errorf("Invalid keyString: %w", truncated(err))
 	}
 
-	if !res.IsComplex() {
+	if (res.flags & tableEntryCompact) != 0 {
+		res.value.dataType = AttrType(res.flags >> 8)
+		res.value.data = compactData
+		res.value.globalStringTable = &x.mainStrings
+		res.flags &= 0x00FF
+	} else if !res.IsComplex() {
+		// Res_value follows the entry, whose size can grow with new fields.
+		if res.size > 8 {
+			if _, err := io.CopyN(ioutil.Discard, r, int64(res.size-8)); err != nil {
+				return nil, fmt.Errorf("Failed to skip entry padding: %w", truncated(err))
+			}
+		}
+
 		var size uint16
 		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
-			return nil, fmt.Errorf("Failed to read entry value size: %s", err.Error())
+			return nil, fmt.Errorf("Failed to read entry value size: %w", truncated(err))
 		}
 
 		if size < 8 {
@@ -577,21 +1370,21 @@ func (x *ResourceTable) parseEntry(r io.Reader, pkg *resourcePackage, typeId uin
 		}
 
 		if _, err := io.CopyN(ioutil.Discard, r, 1); err != nil {
-			return nil, fmt.Errorf("Failed to read entry value res0: %s", err.Error())
+			return nil, fmt.Errorf("Failed to read entry value res0: %w", truncated(err))
 		}
 
 		if err := binary.Read(r, binary.LittleEndian, &res.value.dataType); err != nil {
-			return nil, fmt.Errorf("Failed to read entry value data type: %s", err.Error())
+			return nil, fmt.Errorf("Failed to read entry value data type: %w", truncated(err))
 		}
 
 		if err := binary.Read(r, binary.LittleEndian, &res.value.data); err != nil {
-			return nil, fmt.Errorf("Failed to read entry value data: %s", err.Error())
+			return nil, fmt.Errorf("Failed to read entry value data: %w", truncated(err))
 		}
 
 		res.value.globalStringTable = &x.mainStrings
 
-	} else {
-		// NYI
+	} else if err := x.parseBag(r, &res); err != nil {
+		return nil, err
 	}
 
 	return &res, nil
@@ -599,7 +1392,7 @@ func (x *ResourceTable) parseEntry(r io.Reader, pkg *resourcePackage, typeId uin
 
 // Returns true if the resource entry is complex (for example arrays, string plural arrays...).
 //
-// Complex ResourceEntries are not yet supported.
+// Values of complex entries are available from GetBag().
 func (e *ResourceEntry) IsComplex() bool {
 	return (e.flags & tableEntryComplex) != 0
 }
@@ -640,7 +1433,7 @@ func (v *ResourceValue) Data() (interface{}, error) {
 	case AttrTypeIntDec, AttrTypeIntHex, AttrTypeIntBool,
 		AttrTypeIntColorArgb8, AttrTypeIntColorRgb8,
 		AttrTypeIntColorArgb4, AttrTypeIntColorRgb4,
-		AttrTypeReference:
+		AttrTypeReference, AttrTypeDynamicReference:
 		val = v.data
 	default:
 		return nil, ErrUnknownResourceDataType
@@ -665,16 +1458,18 @@ func (v *ResourceValue) String() (res string, err error) {
 		} else {
 			res = "false"
 		}
-	case AttrTypeIntColorArgb8:
-		res = fmt.Sprintf("#%08x", v.data)
-	case AttrTypeIntColorRgb8:
-		res = fmt.Sprintf("#%06x", v.data)
-	case AttrTypeIntColorArgb4:
-		res = fmt.Sprintf("#%04x", v.data)
-	case AttrTypeIntColorRgb4:
-		res = fmt.Sprintf("#%03x", v.data)
-	case AttrTypeReference:
+	case AttrTypeIntColorArgb8, AttrTypeIntColorRgb8, AttrTypeIntColorArgb4, AttrTypeIntColorRgb4:
+		res = formatColor(v.dataType, v.data)
+	case AttrTypeReference, AttrTypeDynamicReference:
 		res = fmt.Sprintf("@%x", v.data)
+	case AttrTypeAttribute, AttrTypeDynamicAttribute:
+		res = fmt.Sprintf("?%x", v.data)
+	case AttrTypeDimension, AttrTypeFraction:
+		var ok bool
+		if res, ok = formatComplex(v.dataType, v.data); ok {
+			break
+		}
+		fallthrough
 	default:
 		var val interface{}
 		val, err = v.Data()
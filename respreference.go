@@ -0,0 +1,155 @@
+package apkparser
+
+import (
+	"strings"
+)
+
+// Returns the entry of resId best matching the preferences in opts, see ParseOptions.Locale,
+// ParseOptions.Density and ParseOptions.UiMode.
+func (x *ResourceTable) getPreferredEntry(resId uint32, opts *ParseOptions) (*ResourceEntry, error) {
+	if !opts.hasResourcePreference() {
+		return x.GetResourceEntry(resId)
+	}
+
+	entries, err := x.GetResourceEntries(resId)
+	if len(entries) == 0 {
+		return nil, err
+	}
+	return selectPreferredEntry(entries, opts), nil
+}
+
+func (o *ParseOptions) hasResourcePreference() bool {
+	return o != nil && (o.Locale != "" || o.hasDisplayPreference())
+}
+
+// Icons are resolved by the preferences instead of GetIconPng if true.
+func (o *ParseOptions) hasDisplayPreference() bool {
+	return o != nil && (o.Density != 0 || o.UiMode != 0)
+}
+
+type resourcePreference struct {
+	lang, country string
+	density       uint16
+	uiMode        uint8
+}
+
+// Picks the best matching entry, the first one wins ties. If no entry matches, the first one is returned.
+func selectPreferredEntry(entries []*ResourceEntry, opts *ParseOptions) *ResourceEntry {
+	pref := resourcePreference{
+		density: opts.Density,
+		uiMode:  opts.UiMode,
+	}
+	pref.lang, pref.country = splitLocale(opts.Locale)
+
+	var best *ResourceEntry
+	for _, e := range entries {
+		if pref.matches(&e.Config) && (best == nil || pref.isBetter(&e.Config, &best.Config)) {
+			best = e
+		}
+	}
+
+	if best == nil {
+		return entries[0]
+	}
+	return best
+}
+
+// Returns false for configs of other locales, ui mode types and night modes.
+func (p *resourcePreference) matches(c *ResourceConfig) bool {
+	if localeScore(c, p.lang, p.country) < 0 {
+		return false
+	}
+
+	for _, mask := range []uint8{UiModeTypeMask, UiModeNightMask} {
+		if want, have := p.uiMode&mask, c.UiMode&mask; want != 0 && have != 0 && want != have {
+			return false
+		}
+	}
+	return true
+}
+
+// Compares two matching configs in the order Android does, the more specific locale and ui mode win,
+// then the closest density.
+//
+// frameworks/base/libs/androidfw/ResourceTypes.cpp, ResTable_config::isBetterThan
+func (p *resourcePreference) isBetter(a, b *ResourceConfig) bool {
+	if sa, sb := localeScore(a, p.lang, p.country), localeScore(b, p.lang, p.country); sa != sb {
+		return sa > sb
+	}
+
+	for _, mask := range []uint8{UiModeTypeMask, UiModeNightMask} {
+		if p.uiMode&mask == 0 {
+			continue
+		}
+		if ha, hb := a.UiMode&mask != 0, b.UiMode&mask != 0; ha != hb {
+			return ha
+		}
+	}
+
+	if p.density != 0 {
+		return isBetterDensity(a.Density, b.Density, p.density)
+	}
+	return false
+}
+
+// anydpi wins, then the closest higher density, then the closest lower one. The default density is mdpi.
+func isBetterDensity(a, b, pref uint16) bool {
+	if a == b {
+		return false
+	} else if a == DensityAny || b == DensityAny {
+		return a == DensityAny
+	}
+
+	if a == DensityDefault {
+		a = DensityMedium
+	}
+	if b == DensityDefault {
+		b = DensityMedium
+	}
+
+	if (a >= pref) != (b >= pref) {
+		return a >= pref
+	} else if a >= pref {
+		return a < b
+	}
+	return a > b
+}
+
+// Returns -1 for configs of other locales, 0 for the default locale, 1 for the language and 2 for
+// the language and country.
+func localeScore(c *ResourceConfig, lang, country string) int {
+	configLang := c.LanguageString()
+	switch {
+	case lang == "" || configLang == "":
+		return 0
+	case configLang != lang:
+		return -1
+	}
+
+	switch configCountry := c.CountryString(); {
+	case configCountry == "":
+		return 1
+	case configCountry == country:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// Splits locale like "en-US", "en-rUS" or "en_US" into the lowercase language and uppercase country.
+func splitLocale(locale string) (lang, country string) {
+	parts := strings.FieldsFunc(locale, func(r rune) bool { return r == '-' || r == '_' })
+	if len(parts) == 0 {
+		return "", ""
+	}
+
+	lang = strings.ToLower(parts[0])
+	if len(parts) > 1 {
+		country = parts[1]
+		if len(country) == 3 && (country[0] == 'r' || country[0] == 'R') {
+			country = country[1:]
+		}
+		country = strings.ToUpper(country)
+	}
+	return
+}
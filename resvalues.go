@@ -0,0 +1,55 @@
+package apkparser
+
+import (
+	"math"
+)
+
+// Returns the value of AttrTypeIntDec or AttrTypeIntHex entries, *ValueTypeError for other types.
+func (e *ResourceEntry) Int() (int32, error) {
+	data, err := e.dataOfType("int", AttrTypeIntDec, AttrTypeIntHex)
+	return int32(data), err
+}
+
+// Returns the value of AttrTypeIntBool entries, *ValueTypeError for other types.
+func (e *ResourceEntry) Bool() (bool, error) {
+	data, err := e.dataOfType("bool", AttrTypeIntBool)
+	return data != 0, err
+}
+
+// Returns the value of AttrTypeIntColor* entries as 0xAARRGGBB, *ValueTypeError for other types.
+func (e *ResourceEntry) Color() (uint32, error) {
+	return e.dataOfType("color", AttrTypeIntColorArgb8, AttrTypeIntColorRgb8, AttrTypeIntColorArgb4, AttrTypeIntColorRgb4)
+}
+
+// Returns the value of AttrTypeFloat entries, *ValueTypeError for other types.
+func (e *ResourceEntry) Float() (float32, error) {
+	data, err := e.dataOfType("float", AttrTypeFloat)
+	return math.Float32frombits(data), err
+}
+
+// Returns the referenced resource id of AttrTypeReference and AttrTypeDynamicReference entries,
+// *ValueTypeError for other types.
+func (e *ResourceEntry) Reference() (uint32, error) {
+	return e.dataOfType("reference", AttrTypeReference, AttrTypeDynamicReference)
+}
+
+// Returns the path of the file in the APK backing the value, see File. Returns *ValueTypeError
+// for values which are not files.
+func (e *ResourceEntry) FilePath() (string, error) {
+	f, ok := e.File()
+	if !ok {
+		return "", &ValueTypeError{Expected: "file", Type: e.value.dataType, Complex: e.IsComplex()}
+	}
+	return f.Path, nil
+}
+
+func (e *ResourceEntry) dataOfType(expected string, types ...AttrType) (uint32, error) {
+	if !e.IsComplex() {
+		for _, t := range types {
+			if e.value.dataType == t {
+				return e.value.data, nil
+			}
+		}
+	}
+	return 0, &ValueTypeError{Expected: expected, Type: e.value.dataType, Complex: e.IsComplex()}
+}
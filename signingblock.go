@@ -0,0 +1,127 @@
+package apkparser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// IDs of the well-known pairs of the APK Signing Block.
+const (
+	SigningBlockV2            uint32 = 0x7109871a
+	SigningBlockV3            uint32 = 0xf05368c0
+	SigningBlockV31           uint32 = 0x1b93ad61
+	SigningBlockVerityPadding uint32 = 0x42726577
+	SigningBlockSourceStamp   uint32 = 0x6dff800d
+	SigningBlockDependencies  uint32 = 0x504b4453 // dependency metadata added by the Android Gradle plugin
+	SigningBlockFrosting      uint32 = 0x2146444e // Google Play metadata
+)
+
+var signingBlockIdNames = map[uint32]string{
+	SigningBlockV2:            "v2",
+	SigningBlockV3:            "v3",
+	SigningBlockV31:           "v3.1",
+	SigningBlockVerityPadding: "verity padding",
+	SigningBlockSourceStamp:   "source stamp",
+	SigningBlockDependencies:  "dependency info",
+	SigningBlockFrosting:      "frosting",
+}
+
+// Returns a name of the well-known pair ID like "v2", or its hex value.
+func SigningBlockIdName(id uint32) string {
+	if name, prs := signingBlockIdNames[id]; prs {
+		return name
+	}
+	return fmt.Sprintf("0x%08x", id)
+}
+
+// Location and contents of the APK Signing Block, which holds v2+ signatures and other metadata
+// between the ZIP entries and the central directory. Nothing is verified.
+type SigningBlock struct {
+	// Offset of the block in the ZIP and its size, including both size fields and the magic.
+	Offset int64
+	Size   int64
+	// IDs of the ID-value pairs in the order of the block.
+	Ids []uint32
+}
+
+// Returns true if the block has a pair with the ID.
+func (b *SigningBlock) Has(id uint32) bool {
+	for _, i := range b.Ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+const signingBlockMagic = "APK Sig Block 42"
+
+// Looks for the APK Signing Block, returns nil if the ZIP doesn't have one.
+func FindSigningBlock(r io.ReadSeeker) (res *SigningBlock, err error) {
+	defer recoverPanic(&err)
+	return findSigningBlock(&readAtWrapper{r})
+}
+
+// Same as FindSigningBlock for the reader the ZIP was opened from. Must not be used after the ZipReader is closed.
+func (zr *ZipReader) SigningBlock() (*SigningBlock, error) {
+	return FindSigningBlock(zr.zipFileReader)
+}
+
+func findSigningBlock(f *readAtWrapper) (*SigningBlock, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	cdOffset, _, ok := findCentralDirectory(f, size)
+	if !ok {
+		return nil, fmt.Errorf("End of central directory not found.")
+	}
+
+	// size of the block without this field, then the magic
+	var footer [24]byte
+	if cdOffset < int64(len(footer)) {
+		return nil, nil
+	} else if _, err := f.ReadAt(footer[:], cdOffset-int64(len(footer))); err != nil {
+		return nil, err
+	} else if string(footer[8:]) != signingBlockMagic {
+		return nil, nil
+	}
+
+	blockSize := binary.LittleEndian.Uint64(footer[:])
+	if blockSize < uint64(len(footer)) || blockSize > uint64(cdOffset-8) {
+		return nil, fmt.Errorf("Invalid APK Signing Block size %d.", blockSize)
+	}
+
+	res := &SigningBlock{
+		Offset: cdOffset - int64(blockSize) - 8,
+		Size:   int64(blockSize) + 8,
+	}
+
+	var leadingSize [8]byte
+	if _, err := f.ReadAt(leadingSize[:], res.Offset); err != nil {
+		return nil, err
+	} else if binary.LittleEndian.Uint64(leadingSize[:]) != blockSize {
+		return nil, fmt.Errorf("APK Signing Block sizes don't match.")
+	}
+
+	off, end := res.Offset+8, cdOffset-int64(len(footer))
+	for off < end {
+		var pair [12]byte
+		if end-off < int64(len(pair)) {
+			return nil, fmt.Errorf("Truncated APK Signing Block pair at %d.", off)
+		} else if _, err := f.ReadAt(pair[:], off); err != nil {
+			return nil, err
+		}
+
+		pairLen := binary.LittleEndian.Uint64(pair[:])
+		if pairLen < 4 || pairLen > uint64(end-off-8) {
+			return nil, fmt.Errorf("Invalid APK Signing Block pair size %d at %d.", pairLen, off)
+		}
+
+		res.Ids = append(res.Ids, binary.LittleEndian.Uint32(pair[8:]))
+		off += 8 + int64(pairLen)
+	}
+	return res, nil
+}
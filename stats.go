@@ -0,0 +1,75 @@
+package apkparser
+
+// Statistics of parsing, see ParseOptions.Stats. The counts are added to, so one Stats can sum up
+// any number of parsed files.
+type Stats struct {
+	// Chunks parsed or skipped by their type id, like 0x0001 for string pools. Chunks nested in other
+	// ones, like resource types in packages, are counted too.
+	Chunks map[uint16]int
+
+	// String pools, strings in them and size of their offsets and data in bytes.
+	StringPools     int
+	Strings         int
+	StringPoolBytes int64
+
+	// Elements of binary XML and their attributes.
+	Elements int
+	Attrs    int
+
+	// Bytes skipped without being parsed, e.g. unknown chunks, see Logger.Skipped.
+	BytesSkipped int64
+
+	// Lookups of strings in string pools hit and missed the cache of decoded strings, see
+	// ParseOptions.StringCacheSize. Lookups in a ResourceTable after it was parsed are counted as well.
+	CacheHits   int64
+	CacheMisses int64
+}
+
+// Returns the share of string lookups found in the cache, 0 when there were none.
+func (s *Stats) CacheHitRate() float64 {
+	if s.CacheHits+s.CacheMisses == 0 {
+		return 0
+	}
+	return float64(s.CacheHits) / float64(s.CacheHits+s.CacheMisses)
+}
+
+func (o *ParseOptions) stats() *Stats {
+	if o == nil {
+		return nil
+	}
+	return o.Stats
+}
+
+func (o *ParseOptions) countChunk(id uint16) {
+	if s := o.stats(); s != nil {
+		if s.Chunks == nil {
+			s.Chunks = make(map[uint16]int)
+		}
+		s.Chunks[id]++
+	}
+}
+
+func (o *ParseOptions) countStringPool(t *stringTable) {
+	if s := o.stats(); s != nil {
+		s.StringPools++
+		s.Strings += len(t.stringOffsets) / 4
+		s.StringPoolBytes += int64(len(t.stringOffsets) + len(t.data))
+	}
+}
+
+func (o *ParseOptions) countElement(attrs int) {
+	if s := o.stats(); s != nil {
+		s.Elements++
+		s.Attrs += attrs
+	}
+}
+
+func (o *ParseOptions) countCacheLookup(hit bool) {
+	if s := o.stats(); s != nil {
+		if hit {
+			s.CacheHits++
+		} else {
+			s.CacheMisses++
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package apkparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf16"
+)
+
+// String pool of a binary XML or resource table as stored in the file, see ReadStringPools.
+type StringPool struct {
+	// "xml" for the pool of a binary XML, "resources" for the global pool of a resource table,
+	// package name followed by " types" or " keys" for the pools of the packages.
+	Name string
+	// Offset of the pool chunk in the file.
+	Offset int64
+	Utf8   bool
+	// Number of style spans, they are not decoded.
+	Styles  int
+	Strings []PoolString
+}
+
+// One string of a StringPool.
+type PoolString struct {
+	Index int
+	// Offset of the string data, including its length prefix, in the file.
+	Offset int64
+	// Decoded value, with invalid characters replaced like in the parsed output.
+	Value string
+	// Error decoding the string, e.g. offset out of bounds.
+	Err error
+}
+
+// Reads the string pools of a binary XML or resource table without parsing anything else,
+// which is useful for looking at obfuscated pools or writing signatures of the raw strings.
+func ReadStringPools(r io.Reader) (res []*StringPool, err error) {
+	defer recoverPanic(&err)
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	} else if len(data) < chunkHeaderSize {
+		return nil, fmt.Errorf("File is too short.")
+	}
+
+	// Android doesn't check the id of binary XML files.
+	name := "xml"
+	if binary.LittleEndian.Uint16(data) == chunkTable {
+		name = "resources"
+	}
+
+	hdrLen := int64(binary.LittleEndian.Uint16(data[2:]))
+	if hdrLen < chunkHeaderSize || hdrLen > int64(len(data)) {
+		return nil, fmt.Errorf("Invalid header length %d.", hdrLen)
+	}
+
+	chunks, err := stringPoolChunks(data, hdrLen)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, off := range chunks {
+		switch binary.LittleEndian.Uint16(data[off:]) {
+		case chunkStringTable:
+			pool, err := readStringPool(data, off, name)
+			if err != nil {
+				return res, err
+			}
+			res = append(res, pool)
+		case chunkTablePackage:
+			pools, err := readPackageStringPools(data, off)
+			res = append(res, pools...)
+			if err != nil {
+				return res, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// Returns offsets of the chunks following the header of the top chunk.
+func stringPoolChunks(data []byte, start int64) ([]int64, error) {
+	var res []int64
+	for off := start; off+chunkHeaderSize <= int64(len(data)); {
+		size := int64(binary.LittleEndian.Uint32(data[off+4:]))
+		if size < chunkHeaderSize {
+			return nil, fmt.Errorf("Invalid chunk size %d at 0x%x.", size, off)
+		}
+		res = append(res, off)
+		off += size
+	}
+	return res, nil
+}
+
+func readStringPool(data []byte, off int64, name string) (*StringPool, error) {
+	const poolHeaderSize = chunkHeaderSize + 5*4
+	if off+poolHeaderSize > int64(len(data)) {
+		return nil, fmt.Errorf("Truncated string pool at 0x%x.", off)
+	}
+
+	table, err := parseStringTableWithChunk(bytes.NewReader(data[off:]), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse string pool at 0x%x: %w", off, err)
+	}
+
+	res := &StringPool{
+		Name:   name,
+		Offset: off,
+		Utf8:   table.isUtf8,
+		Styles: int(binary.LittleEndian.Uint32(data[off+12:])),
+	}
+
+	stringsStart := off + int64(binary.LittleEndian.Uint32(data[off+20:]))
+	for i := 0; i < len(table.stringOffsets)/4; i++ {
+		str := PoolString{
+			Index:  i,
+			Offset: stringsStart + int64(binary.LittleEndian.Uint32(table.stringOffsets[4*i:])),
+		}
+		str.Value, str.Err = table.get(uint32(i))
+		res.Strings = append(res.Strings, str)
+	}
+	return res, nil
+}
+
+func readPackageStringPools(data []byte, off int64) ([]*StringPool, error) {
+	const (
+		nameOffset        = chunkHeaderSize + 4
+		typeStringsOffset = nameOffset + 256
+		keyStringsOffset  = typeStringsOffset + 8
+	)
+
+	if off+keyStringsOffset+4 > int64(len(data)) {
+		return nil, fmt.Errorf("Truncated package at 0x%x.", off)
+	}
+
+	nameChars := make([]uint16, 128)
+	for i := range nameChars {
+		nameChars[i] = binary.LittleEndian.Uint16(data[off+nameOffset+int64(2*i):])
+	}
+	name := string(utf16.Decode(nameChars))
+	if idx := strings.IndexRune(name, 0); idx != -1 {
+		name = name[:idx]
+	}
+
+	var res []*StringPool
+	for _, p := range []struct {
+		field int64
+		kind  string
+	}{{typeStringsOffset, "types"}, {keyStringsOffset, "keys"}} {
+		poolOff := off + int64(binary.LittleEndian.Uint32(data[off+p.field:]))
+		if poolOff >= int64(len(data)) {
+			return res, fmt.Errorf("Package %s %s pool offset out of bounds.", name, p.kind)
+		}
+
+		pool, err := readStringPool(data, poolOff, name+" "+p.kind)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, pool)
+	}
+	return res, nil
+}
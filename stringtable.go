@@ -22,10 +22,17 @@ type stringTable struct {
 	stringOffsets []byte
 	data          []byte
 	cache         map[uint32]string
+	cacheLimit    int
+
+	// all strings when decoded up-front, errors of the ones which failed are in decodeErrs
+	decoded    []string
+	decodeErrs map[uint32]error
+
+	opts *ParseOptions
 }
 
-func parseStringTableWithChunk(r io.Reader) (res stringTable, err error) {
-	id, _, totalLen, err := parseChunkHeader(r)
+func parseStringTableWithChunk(r io.Reader, opts *ParseOptions) (res stringTable, err error) {
+	id, hdrLen, totalLen, err := parseChunkHeader(r)
 	if err != nil {
 		return
 	}
@@ -34,26 +41,29 @@ func parseStringTableWithChunk(r io.Reader) (res stringTable, err error) {
 		err = fmt.Errorf("Invalid chunk id 0x%08x, expected 0x%08x", id, chunkStringTable)
 		return
 	}
+	opts.countChunk(id)
 
-	return parseStringTable(&io.LimitedReader{R: r, N: int64(totalLen - chunkHeaderSize)})
+	return parseStringTable(&io.LimitedReader{R: r, N: int64(totalLen - chunkHeaderSize)}, hdrLen, opts)
 }
 
-func parseStringTable(r *io.LimitedReader) (stringTable, error) {
+// hdrLen is the size of ResStringPool_header, the rest of it is skipped if it's bigger than known.
+func parseStringTable(r *io.LimitedReader, hdrLen uint16, opts *ParseOptions) (stringTable, error) {
+	const poolHeaderSize = 7 * 4
+
 	var err error
-	var stringCnt, stringOffset, flags uint32
+	var stringCnt, styleCnt, stringOffset, flags uint32
 	var res stringTable
 
 	if err := binary.Read(r, binary.LittleEndian, &stringCnt); err != nil {
-		return res, fmt.Errorf("error reading stringCnt: %s", err.Error())
+		return res, fmt.Errorf("error reading stringCnt: %w", truncated(err))
 	}
 
-	// skip styles count
-	if _, err = io.CopyN(ioutil.Discard, r, 4); err != nil {
-		return res, fmt.Errorf("error reading styleCnt: %s", err.Error())
+	if err := binary.Read(r, binary.LittleEndian, &styleCnt); err != nil {
+		return res, fmt.Errorf("error reading styleCnt: %w", truncated(err))
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &flags); err != nil {
-		return res, fmt.Errorf("error reading flags: %s", err.Error())
+		return res, fmt.Errorf("error reading flags: %w", truncated(err))
 	}
 
 	res.isUtf8 = (flags & stringFlagUtf8) != 0
@@ -67,12 +77,20 @@ func parseStringTable(r *io.LimitedReader) (stringTable, error) {
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &stringOffset); err != nil {
-		return res, fmt.Errorf("error reading stringOffset: %s", err.Error())
+		return res, fmt.Errorf("error reading stringOffset: %w", truncated(err))
 	}
 
 	// skip styles offset
 	if _, err = io.CopyN(ioutil.Discard, r, 4); err != nil {
-		return res, fmt.Errorf("error reading styleOffset: %s", err.Error())
+		return res, fmt.Errorf("error reading styleOffset: %w", truncated(err))
+	}
+
+	if hdrLen > poolHeaderSize {
+		if _, err = io.CopyN(ioutil.Discard, r, int64(hdrLen-poolHeaderSize)); err != nil {
+			return res, fmt.Errorf("error skipping string pool header: %w", truncated(err))
+		}
+	} else {
+		hdrLen = poolHeaderSize
 	}
 
 	// Read lengths
@@ -80,8 +98,12 @@ func parseStringTable(r *io.LimitedReader) (stringTable, error) {
 		return res, fmt.Errorf("Too many strings in this file (%d).", stringCnt)
 	}
 
-	remainder := int64(stringOffset) - 7*4 - 4*int64(stringCnt)
+	remainder := int64(stringOffset) - int64(hdrLen) - 4*int64(stringCnt)
 	if remainder < 0 {
+		if err := opts.anomaly(WarnStringCountMismatch, "Wrong string offset (got remainder %d)", remainder); err != nil {
+			return res, err
+		}
+
 		// eb9b8603b58f1829cad3efba7c81eb8fe7bf6a97fc4007d02533b5c2c3cd69b4
 		if remainder%4 == 0 && uint32((-1*remainder)/4) < stringCnt {
 			stringCnt -= uint32(-1*remainder/4)
@@ -90,37 +112,118 @@ func parseStringTable(r *io.LimitedReader) (stringTable, error) {
 		}
 	}
 
-	res.stringOffsets = make([]byte, 4*stringCnt)
-	if _, err := io.ReadFull(r, res.stringOffsets); err != nil {
-		return res, fmt.Errorf("Failed to read string offsets data: %s", err.Error())
+	if remainder > 0 && uint64(remainder) != 4*uint64(styleCnt) {
+		if err := opts.anomaly(WarnStringPoolPadding, "%d bytes between string offsets and data, %d styles", remainder, styleCnt); err != nil {
+			return res, err
+		}
+	}
+
+	if opts.isLazyStrings() {
+		if err := res.readSingleBuffer(r, stringCnt, remainder, opts); err != nil {
+			return res, err
+		}
+	} else {
+		if err := opts.alloc(4 * int64(stringCnt)); err != nil {
+			return res, err
+		}
+
+		res.stringOffsets = make([]byte, 4*stringCnt)
+		if _, err := io.ReadFull(r, res.stringOffsets); err != nil {
+			return res, fmt.Errorf("Failed to read string offsets data: %w", truncated(err))
+		}
+
+		if remainder > 0 {
+			if _, err = io.CopyN(ioutil.Discard, r, remainder); err != nil {
+				return res, fmt.Errorf("error reading styleArray: %w", truncated(err))
+			}
+		}
+
+		if err := opts.alloc(r.N); err != nil {
+			return res, err
+		}
+
+		res.data = make([]byte, r.N)
+		if _, err := io.ReadFull(r, res.data); err != nil {
+			return res, fmt.Errorf("Failed to read string table data: %w", truncated(err))
+		}
 	}
 
-	if remainder > 0 {
-		if _, err = io.CopyN(ioutil.Discard, r, remainder); err != nil {
-			return res, fmt.Errorf("error reading styleArray: %s", err.Error())
+	for i := 0; i < len(res.stringOffsets); i += 4 {
+		if offset := binary.LittleEndian.Uint32(res.stringOffsets[i:]); offset >= uint32(len(res.data)) {
+			if err := opts.anomaly(WarnBadStringIndex, "String offset for idx %d is out of bounds (%d >= %d).", i/4, offset, len(res.data)); err != nil {
+				return res, err
+			}
+			break
 		}
 	}
 
-	res.data = make([]byte, r.N)
-	if _, err := io.ReadFull(r, res.data); err != nil {
-		return res, fmt.Errorf("Failed to read string table data: %s", err.Error())
+	res.opts = opts
+	opts.countStringPool(&res)
+	if opts.isLazyStrings() {
+		return res, nil
 	}
 
-	res.cache = make(map[uint32]string)
+	if opts.isPredecodeStrings() {
+		res.predecode()
+	} else if res.cacheLimit = opts.stringCacheSize(); res.cacheLimit >= 0 {
+		res.cache = make(map[uint32]string)
+	}
 	return res, nil
 }
 
+// Decodes all strings and drops the raw data.
+func (t *stringTable) predecode() {
+	cnt := uint32(len(t.stringOffsets) / 4)
+	t.decoded = make([]string, cnt)
+	for i := uint32(0); i < cnt; i++ {
+		str, err := t.decode(i)
+		if err != nil {
+			if t.decodeErrs == nil {
+				t.decodeErrs = make(map[uint32]error)
+			}
+			t.decodeErrs[i] = err
+		}
+		t.decoded[i] = str
+	}
+	t.data = nil
+}
+
+// Reads the rest of the pool into one buffer, stringOffsets and data are slices of it.
+func (t *stringTable) readSingleBuffer(r *io.LimitedReader, stringCnt uint32, remainder int64, opts *ParseOptions) error {
+	if remainder < 0 {
+		remainder = 0
+	}
+
+	if r.N < 4*int64(stringCnt)+remainder {
+		return fmt.Errorf("Failed to read string offsets data: %w", truncated(io.ErrUnexpectedEOF))
+	}
+
+	if err := opts.alloc(r.N); err != nil {
+		return err
+	}
+
+	buf := make([]byte, r.N)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return fmt.Errorf("Failed to read string table data: %w", truncated(err))
+	}
+
+	offsetsEnd := 4 * int64(stringCnt)
+	t.stringOffsets = buf[:offsetsEnd:offsetsEnd]
+	t.data = buf[offsetsEnd+remainder:]
+	return nil
+}
+
 func (t *stringTable) parseString16(r io.Reader) (string, error) {
 	var strCharacters uint32
 	var strCharactersLow, strCharactersHigh uint16
 
 	if err := binary.Read(r, binary.LittleEndian, &strCharactersHigh); err != nil {
-		return "", fmt.Errorf("error reading string char count: %s", err.Error())
+		return "", fmt.Errorf("error reading string char count: %w", truncated(err))
 	}
 
 	if (strCharactersHigh & 0x8000) != 0 {
 		if err := binary.Read(r, binary.LittleEndian, &strCharactersLow); err != nil {
-			return "", fmt.Errorf("error reading string char count: %s", err.Error())
+			return "", fmt.Errorf("error reading string char count: %w", truncated(err))
 		}
 
 		strCharacters = (uint32(strCharactersHigh&0x7FFF) << 16) | uint32(strCharactersLow)
@@ -128,9 +231,13 @@ func (t *stringTable) parseString16(r io.Reader) (string, error) {
 		strCharacters = uint32(strCharactersHigh)
 	}
 
+	if err := t.opts.allocTemporary(2 * int64(strCharacters)); err != nil {
+		return "", err
+	}
+
 	buf := make([]uint16, int64(strCharacters))
 	if err := binary.Read(r, binary.LittleEndian, &buf); err != nil {
-		return "", fmt.Errorf("error reading string : %s", err.Error())
+		return "", fmt.Errorf("error reading string : %w", truncated(err))
 	}
 
 	decoded := utf16.Decode(buf)
@@ -146,12 +253,12 @@ func (t *stringTable) parseString8Len(r io.Reader) (int64, error) {
 	var strCharactersLow, strCharactersHigh uint8
 
 	if err := binary.Read(r, binary.LittleEndian, &strCharactersHigh); err != nil {
-		return 0, fmt.Errorf("error reading string char count: %s", err.Error())
+		return 0, fmt.Errorf("error reading string char count: %w", truncated(err))
 	}
 
 	if (strCharactersHigh & 0x80) != 0 {
 		if err := binary.Read(r, binary.LittleEndian, &strCharactersLow); err != nil {
-			return 0, fmt.Errorf("error reading string char count: %s", err.Error())
+			return 0, fmt.Errorf("error reading string char count: %w", truncated(err))
 		}
 		strCharacters = (int64(strCharactersHigh&0x7F) << 8) | int64(strCharactersLow)
 	} else {
@@ -174,30 +281,102 @@ func (t *stringTable) parseString8(r io.Reader) (string, error) {
 
 	buf := make([]uint8, len8)
 	if err := binary.Read(r, binary.LittleEndian, &buf); err != nil {
-		return "", fmt.Errorf("error reading string : %s", err.Error())
+		return "", fmt.Errorf("error reading string : %w", truncated(err))
 	}
 
 	for len(buf) != 0 && buf[len(buf)-1] == 0 {
 		buf = buf[:len(buf)-1]
 	}
 
-	return string(buf), nil
+	return decodeModifiedUtf8(buf), nil
+}
+
+// Decodes the modified UTF-8 Java and older aapt versions produce: supplementary characters
+// are encoded as CESU-8 surrogate pairs and NUL as the overlong 0xC0 0x80.
+func decodeModifiedUtf8(buf []byte) string {
+	if utf8.Valid(buf) {
+		return string(buf)
+	}
+
+	// Invalid bytes are kept, they are replaced later like in the other strings.
+	res := make([]byte, 0, len(buf))
+	var tmp [utf8.UTFMax]byte
+	for i := 0; i < len(buf); {
+		if buf[i] == 0xC0 && i+1 < len(buf) && buf[i+1] == 0x80 {
+			res = append(res, 0)
+			i += 2
+			continue
+		}
+
+		if hi, ok := decodeCesuSurrogate(buf[i:], 0xD800); ok {
+			if lo, ok := decodeCesuSurrogate(buf[i+3:], 0xDC00); ok {
+				n := utf8.EncodeRune(tmp[:], utf16.DecodeRune(hi, lo))
+				res = append(res, tmp[:n]...)
+				i += 6
+				continue
+			}
+		}
+
+		res = append(res, buf[i])
+		i++
+	}
+	return string(res)
+}
+
+// Decodes 3-byte encoded UTF-16 surrogate from the range starting at base.
+func decodeCesuSurrogate(buf []byte, base rune) (rune, bool) {
+	if len(buf) < 3 || buf[0] != 0xED || buf[2]&0xC0 != 0x80 {
+		return 0, false
+	}
+
+	r := rune(0xD000) | rune(buf[1]&0x3F)<<6 | rune(buf[2]&0x3F)
+	if buf[1]&0xC0 != 0x80 || r < base || r >= base+0x400 {
+		return 0, false
+	}
+	return r, true
 }
 
 func (t *stringTable) get(idx uint32) (string, error) {
 	if idx == math.MaxUint32 {
 		return "", nil
 	} else if idx >= uint32(len(t.stringOffsets)/4) {
-		return "", fmt.Errorf("String with idx %d not found!", idx)
+		return "", &classifiedError{class: ErrBadStringIndex, err: fmt.Errorf("String with idx %d not found!", idx)}
+	}
+
+	if t.decoded != nil {
+		if err := t.decodeErrs[idx]; err != nil {
+			return "", err
+		}
+		return t.decoded[idx], nil
+	}
+
+	if t.cache != nil {
+		str, prs := t.cache[idx]
+		t.opts.countCacheLookup(prs)
+		if prs {
+			return str, nil
+		}
 	}
 
-	if str, prs := t.cache[idx]; prs {
-		return str, nil
+	res, err := t.decode(idx)
+	if err != nil {
+		return "", err
 	}
 
+	if t.cache != nil {
+		// Dropping the whole cache is cheap and keeps the strings used since then.
+		if t.cacheLimit > 0 && len(t.cache) >= t.cacheLimit {
+			t.cache = make(map[uint32]string, t.cacheLimit)
+		}
+		t.cache[idx] = res
+	}
+	return res, nil
+}
+
+func (t *stringTable) decode(idx uint32) (string, error) {
 	offset := binary.LittleEndian.Uint32(t.stringOffsets[4*idx : 4*idx+4])
 	if offset >= uint32(len(t.data)) {
-		return "", fmt.Errorf("String offset for idx %d is out of bounds (%d >= %d).", idx, offset, len(t.data))
+		return "", &classifiedError{class: ErrBadStringIndex, err: fmt.Errorf("String offset for idx %d is out of bounds (%d >= %d).", idx, offset, len(t.data))}
 	}
 
 	r := bytes.NewReader(t.data[offset:])
@@ -224,11 +403,9 @@ func (t *stringTable) get(idx uint32) (string, error) {
 			}
 		}, res)
 	}
-
-	t.cache[idx] = res
 	return res, nil
 }
 
 func (t *stringTable) isEmpty() bool {
-	return t.cache == nil
+	return t.stringOffsets == nil
 }
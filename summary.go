@@ -0,0 +1,183 @@
+package apkparser
+
+import (
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Maximum size of the icon read by Summarize.
+const summaryMaxIconSize = 16 * 1024 * 1024
+
+var dexNameRe = regexp.MustCompile(`^classes[0-9]*\.dex$`)
+
+// Basic information about an APK, see Summarize.
+type Summary struct {
+	Package string
+	// android:versionCode combined with android:versionCodeMajor in the upper 32 bits.
+	VersionCode int64
+	VersionName string
+
+	// SDK levels from <uses-sdk>, 0 if not declared or a codename of a preview.
+	MinSdk    int
+	TargetSdk int
+	MaxSdk    int
+
+	// The application label in the default configuration, or the one for ParseOptions.Locale.
+	Label string
+	// Path and contents of the application icon, nil if it's not an image, e.g. an adaptive icon
+	// without a png fallback.
+	IconPath string
+	Icon     []byte
+
+	// Permissions from <uses-permission> and <uses-permission-sdk-23> in the manifest order.
+	Permissions []string
+
+	// Numbers of components declared in the manifest, activities include activity aliases.
+	Activities int
+	Services   int
+	Receivers  int
+	Providers  int
+
+	// ABIs with native libraries in lib/, sorted.
+	NativeAbis []string
+	// Number of classes*.dex files in the root.
+	DexCount int
+
+	FileCount        int
+	CompressedSize   int64
+	UncompressedSize int64
+
+	// The resources failed to parse, references in the manifest values are not resolved.
+	ResourcesErr error
+}
+
+// Reads the basic information about the APK at path in one go: the package, versions, label, icon,
+// permissions, component counts and file statistics.
+func Summarize(path string) (*Summary, error) {
+	return SummarizeEx(path, nil)
+}
+
+// Same as Summarize, but parses the APK with opts, which can be nil.
+func SummarizeEx(path string, opts *ParseOptions) (*Summary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return SummarizeReader(f, opts)
+}
+
+// Same as SummarizeEx, but reads the APK from r, e.g. a bytes.Reader of an APK received over the network.
+func SummarizeReader(r io.ReadSeeker, opts *ParseOptions) (*Summary, error) {
+	zip, err := OpenZipReaderEx(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer zip.Close()
+
+	parser, resourcesErr := NewParserEx(zip, nil, opts)
+	manifest, err := parser.ParseManifestTree()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Summary{
+		Package:      manifest.AttrValue("", "package"),
+		VersionName:  manifest.AndroidAttr("versionName"),
+		ResourcesErr: resourcesErr,
+	}
+
+	versionCode, _ := strconv.ParseInt(manifest.AndroidAttr("versionCode"), 0, 64)
+	versionCodeMajor, _ := strconv.ParseInt(manifest.AndroidAttr("versionCodeMajor"), 0, 64)
+	res.VersionCode = versionCodeMajor<<32 | (versionCode & 0xFFFFFFFF)
+
+	if sdk := manifest.Child("uses-sdk"); sdk != nil {
+		res.MinSdk, _ = strconv.Atoi(sdk.AndroidAttr("minSdkVersion"))
+		res.TargetSdk, _ = strconv.Atoi(sdk.AndroidAttr("targetSdkVersion"))
+		res.MaxSdk, _ = strconv.Atoi(sdk.AndroidAttr("maxSdkVersion"))
+	}
+
+	for _, c := range manifest.Children {
+		if c.Name.Local == "uses-permission" || c.Name.Local == "uses-permission-sdk-23" {
+			if name := c.AndroidAttr("name"); name != "" {
+				res.Permissions = append(res.Permissions, name)
+			}
+		}
+	}
+
+	if app := manifest.Child("application"); app != nil {
+		res.Label = app.AndroidAttr("label")
+		res.summarizeComponents(app)
+		res.readIcon(parser, app.AndroidAttr("icon"))
+	}
+
+	res.summarizeFiles(zip)
+	return res, nil
+}
+
+func (s *Summary) summarizeComponents(app *ManifestElement) {
+	for _, c := range app.Children {
+		switch c.Name.Local {
+		case "activity", "activity-alias":
+			s.Activities++
+		case "service":
+			s.Services++
+		case "receiver":
+			s.Receivers++
+		case "provider":
+			s.Providers++
+		}
+	}
+}
+
+func (s *Summary) readIcon(parser *ApkParser, iconPath string) {
+	f := parser.zip.File[iconPath]
+	if f == nil {
+		return
+	}
+
+	format := sniffFileFormat(f)
+	if format == "" {
+		format = extensionFileFormat(iconPath)
+	}
+	if format != "png" && format != "webp" && format != "jpg" {
+		return
+	}
+
+	if data, err := f.ReadAll(summaryMaxIconSize); err == nil {
+		s.IconPath, s.Icon = iconPath, data
+	}
+}
+
+func (s *Summary) summarizeFiles(zip *ZipReader) {
+	abis := make(map[string]bool)
+	seen := make(map[*ZipReaderFile]bool)
+	for _, f := range zip.FilesOrdered {
+		if seen[f] || f.IsDir {
+			continue
+		}
+		seen[f] = true
+
+		s.FileCount++
+		if hdr := f.ZipHeader(); hdr != nil {
+			s.CompressedSize += int64(hdr.CompressedSize64)
+			s.UncompressedSize += int64(hdr.UncompressedSize64)
+		}
+
+		if dexNameRe.MatchString(f.Name) {
+			s.DexCount++
+		} else if parts := strings.Split(f.Name, "/"); len(parts) == 3 && parts[0] == "lib" && path.Ext(parts[2]) == ".so" {
+			abis[parts[1]] = true
+		}
+	}
+
+	for abi := range abis {
+		s.NativeAbis = append(s.NativeAbis, abi)
+	}
+	sort.Strings(s.NativeAbis)
+}
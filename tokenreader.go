@@ -0,0 +1,62 @@
+package apkparser
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// Returns xml.TokenReader over the binary XML, which can be wrapped with xml.NewTokenDecoder
+// to unmarshal the XML into structs with Decode. The resources are optional and can be nil.
+// The whole XML is parsed on the first call of Token, parsing errors are returned by Token.
+func NewXmlTokenReader(r io.Reader, resources *ResourceTable) xml.TokenReader {
+	return &xmlTokenReader{
+		parse: func(enc ManifestEncoder) error {
+			return ParseXml(r, enc, resources)
+		},
+	}
+}
+
+// Same as NewXmlTokenReader, but for the XML file from the APK, with resources of the APK.
+func (p *ApkParser) NewXmlTokenReader(name string) xml.TokenReader {
+	return &xmlTokenReader{
+		parse: func(enc ManifestEncoder) error {
+			return p.parseXmlWith(name, enc)
+		},
+	}
+}
+
+type xmlTokenReader struct {
+	parse  func(enc ManifestEncoder) error
+	parsed bool
+	err    error
+
+	tokens []xml.Token
+	pos    int
+}
+
+func (r *xmlTokenReader) Token() (xml.Token, error) {
+	if !r.parsed {
+		r.parsed = true
+		r.err = r.parse(r)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	} else if r.pos >= len(r.tokens) {
+		return nil, io.EOF
+	}
+
+	tok := r.tokens[r.pos]
+	r.tokens[r.pos] = nil
+	r.pos++
+	return tok, nil
+}
+
+func (r *xmlTokenReader) EncodeToken(t xml.Token) error {
+	r.tokens = append(r.tokens, xml.CopyToken(t))
+	return nil
+}
+
+func (r *xmlTokenReader) Flush() error {
+	return nil
+}
@@ -0,0 +1,174 @@
+package apkparser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Parts of the configuration a resource varies by, declared in the type spec of the resource table.
+// Android reloads the resource on changes of these parts only.
+type ConfigChanges uint32
+
+// frameworks/base/libs/androidfw/include/androidfw/ResourceTypes.h, ResTable_config CONFIG_*
+const (
+	ConfigChangeMcc                ConfigChanges = 0x0001
+	ConfigChangeMnc                ConfigChanges = 0x0002
+	ConfigChangeLocale             ConfigChanges = 0x0004
+	ConfigChangeTouchscreen        ConfigChanges = 0x0008
+	ConfigChangeKeyboard           ConfigChanges = 0x0010
+	ConfigChangeKeyboardHidden     ConfigChanges = 0x0020
+	ConfigChangeNavigation         ConfigChanges = 0x0040
+	ConfigChangeOrientation        ConfigChanges = 0x0080
+	ConfigChangeDensity            ConfigChanges = 0x0100
+	ConfigChangeScreenSize         ConfigChanges = 0x0200
+	ConfigChangeVersion            ConfigChanges = 0x0400
+	ConfigChangeScreenLayout       ConfigChanges = 0x0800
+	ConfigChangeUiMode             ConfigChanges = 0x1000
+	ConfigChangeSmallestScreenSize ConfigChanges = 0x2000
+	ConfigChangeLayoutDir          ConfigChanges = 0x4000
+	ConfigChangeScreenRound        ConfigChanges = 0x8000
+	ConfigChangeColorMode          ConfigChanges = 0x10000
+	ConfigChangeGrammaticalGender  ConfigChanges = 0x20000
+)
+
+// ResTable_typeSpec flags besides the config changes
+const (
+	typeSpecStagedApi = 0x20000000
+	typeSpecPublic    = 0x40000000
+)
+
+var configChangeNames = []struct {
+	change ConfigChanges
+	name   string
+}{
+	{ConfigChangeMcc, "mcc"},
+	{ConfigChangeMnc, "mnc"},
+	{ConfigChangeLocale, "locale"},
+	{ConfigChangeTouchscreen, "touchscreen"},
+	{ConfigChangeKeyboard, "keyboard"},
+	{ConfigChangeKeyboardHidden, "keyboardHidden"},
+	{ConfigChangeNavigation, "navigation"},
+	{ConfigChangeOrientation, "orientation"},
+	{ConfigChangeDensity, "density"},
+	{ConfigChangeScreenSize, "screenSize"},
+	{ConfigChangeVersion, "version"},
+	{ConfigChangeScreenLayout, "screenLayout"},
+	{ConfigChangeUiMode, "uiMode"},
+	{ConfigChangeSmallestScreenSize, "smallestScreenSize"},
+	{ConfigChangeLayoutDir, "layoutDirection"},
+	{ConfigChangeScreenRound, "screenRound"},
+	{ConfigChangeColorMode, "colorMode"},
+	{ConfigChangeGrammaticalGender, "grammaticalGender"},
+}
+
+// Returns the parts separated by "|" like "locale|density", unknown bits in hex.
+func (c ConfigChanges) String() string {
+	var parts []string
+	for _, n := range configChangeNames {
+		if c&n.change != 0 {
+			parts = append(parts, n.name)
+			c &^= n.change
+		}
+	}
+
+	if c != 0 {
+		parts = append(parts, fmt.Sprintf("0x%x", uint32(c)))
+	}
+	return strings.Join(parts, "|")
+}
+
+// Type spec flags of one resource.
+type ResourceSpec struct {
+	Id      uint32
+	Changes ConfigChanges
+	// Declared as public, e.g. in the framework or a library.
+	Public bool
+	// Public staged API, whose id changes when it's finalized.
+	StagedApi bool
+}
+
+func newResourceSpec(id, flags uint32) ResourceSpec {
+	return ResourceSpec{
+		Id:        id,
+		Changes:   ConfigChanges(flags &^ (typeSpecStagedApi | typeSpecPublic)),
+		Public:    flags&typeSpecPublic != 0,
+		StagedApi: flags&typeSpecStagedApi != 0,
+	}
+}
+
+// Returns the type spec flags of resId.
+func (x *ResourceTable) GetResourceSpec(resId uint32) (res ResourceSpec, err error) {
+	defer recoverPanic(&err)
+
+	resId = x.resolveId(resId)
+
+	group := x.packages[resId>>24]
+	if group == nil {
+		return ResourceSpec{}, fmt.Errorf("Invalid package identifier.")
+	}
+
+	// The specs are complete right after parsing, unlike the configs of lazily parsed types.
+	entryId := resId & 0xFFFF
+	for _, spec := range group.types[uint8(resId>>16)] {
+		if entryId < uint32(len(spec.Entries)) {
+			return newResourceSpec(resId, spec.Entries[entryId]), nil
+		}
+	}
+	return ResourceSpec{}, fmt.Errorf("Resource 0x%08x has no type spec.", resId)
+}
+
+// Returns the type spec flags of all resources in the table ordered by id, for example to compare
+// which resources vary by which configuration parts in two versions of an app.
+func (x *ResourceTable) ResourceSpecs() []ResourceSpec {
+	var res []ResourceSpec
+	for _, pkgId := range x.packageIds() {
+		group := x.packages[pkgId]
+		for typeId := 1; typeId <= int(group.largestTypeId); typeId++ {
+			seen := 0
+			for _, spec := range group.types[uint8(typeId)] {
+				for entryId := seen; entryId < len(spec.Entries); entryId++ {
+					res = append(res, newResourceSpec(pkgId<<24|uint32(typeId)<<16|uint32(entryId), spec.Entries[entryId]))
+				}
+				if len(spec.Entries) > seen {
+					seen = len(spec.Entries)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// Resource declared as public, as it would be listed in public.xml.
+type PublicResource struct {
+	Id uint32
+	// Type and key like "string" and "app_name", empty if the table has no entry for the id.
+	Type string
+	Key  string
+	// Public staged API, whose id changes when it's finalized.
+	StagedApi bool
+}
+
+// Returns true if the entry has the public flag, which aapt sets for resources declared in public.xml.
+func (e *ResourceEntry) IsPublic() bool {
+	return (e.flags & tableEntryPublic) != 0
+}
+
+// Returns resources with stable ids ordered by id, i.e. those with the public type spec flag or entries
+// flagged as public. These are the API of libraries and the framework, and the targets of overlays.
+func (x *ResourceTable) PublicResources() []PublicResource {
+	var res []PublicResource
+	for _, spec := range x.ResourceSpecs() {
+		group := x.packages[spec.Id>>24]
+		e, _ := x.getEntry(group, (spec.Id>>16)&0xFF-1, spec.Id&0xFFFF, ConfigFirst)
+		if !spec.Public && (e == nil || !e.IsPublic()) {
+			continue
+		}
+
+		r := PublicResource{Id: spec.Id, StagedApi: spec.StagedApi}
+		if e != nil {
+			r.Type, r.Key = e.ResourceType, e.Key
+		}
+		res = append(res, r)
+	}
+	return res
+}
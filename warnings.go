@@ -0,0 +1,77 @@
+package apkparser
+
+import "fmt"
+
+// Kind of the anomaly described by Warning.
+type WarningKind int
+
+const (
+	WarnInvalidChunkId            WarningKind = iota // unexpected id of the top chunk
+	WarnUnknownChunk                                 // unknown chunk was skipped
+	WarnChunkNotFullyRead                            // chunk contains more data than was parsed
+	WarnChunkOverflow                                // chunk length points outside of its parent
+	WarnUnusualLayout                                // unusual header or attribute sizes
+	WarnStringCountMismatch                          // string count does not match the string offsets
+	WarnStringPoolPadding                            // extra data between the string offsets and the strings
+	WarnBadStringIndex                               // string index out of bounds
+	WarnDuplicateChunk                               // a chunk which should be present only once was found again
+	WarnPackageCountMismatch                         // number of packages doesn't match resource table header
+	WarnDuplicateZipEntry                            // the same name is present more than once in the zip
+	WarnBrokenZip                                    // central directory could not be read normally, see WarnRecoveredCentralDirectory
+	WarnUnknownCompressionMethod                     // unknown compression method, treated as deflate
+	WarnRecoveredCentralDirectory                    // central directory records were found by scanning, local headers weren't scanned
+	WarnPlainTextXml                                 // XML is in plaintext instead of the binary form, see ParseOptions.PlainTextFallback
+	WarnReferenceLoop                                // resource references form a loop, the value is left unresolved
+	WarnZipExtraData                                 // data before or after the zip, see ParseOptions.LocateZipSpan
+)
+
+// Describes an anomaly which was recovered from during parsing.
+type Warning struct {
+	Kind    WarningKind
+	Message string
+}
+
+func (k WarningKind) String() string {
+	switch k {
+	case WarnInvalidChunkId:
+		return "invalid chunk id"
+	case WarnUnknownChunk:
+		return "unknown chunk"
+	case WarnChunkNotFullyRead:
+		return "chunk not fully read"
+	case WarnChunkOverflow:
+		return "chunk overflow"
+	case WarnUnusualLayout:
+		return "unusual layout"
+	case WarnStringCountMismatch:
+		return "string count mismatch"
+	case WarnStringPoolPadding:
+		return "string pool padding"
+	case WarnBadStringIndex:
+		return "bad string index"
+	case WarnDuplicateChunk:
+		return "duplicate chunk"
+	case WarnPackageCountMismatch:
+		return "package count mismatch"
+	case WarnDuplicateZipEntry:
+		return "duplicate zip entry"
+	case WarnBrokenZip:
+		return "broken zip"
+	case WarnUnknownCompressionMethod:
+		return "unknown compression method"
+	case WarnRecoveredCentralDirectory:
+		return "recovered central directory"
+	case WarnPlainTextXml:
+		return "plaintext xml"
+	case WarnReferenceLoop:
+		return "reference loop"
+	case WarnZipExtraData:
+		return "zip extra data"
+	default:
+		return fmt.Sprintf("warning %d", int(k))
+	}
+}
+
+func (w Warning) String() string {
+	return w.Kind.String() + ": " + w.Message
+}
@@ -0,0 +1,189 @@
+package apkparser
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Element of a parsed binary XML tree, see ParseXmlTree.
+type ManifestElement struct {
+	Name xml.Name
+	Attr []xml.Attr
+	// Concatenated character data directly inside the element.
+	Text     string
+	Children []*ManifestElement
+}
+
+// Parses the binary XML into a tree of elements. The resources are optional and can be nil.
+func ParseXmlTree(r io.Reader, resources *ResourceTable) (*ManifestElement, error) {
+	return ParseXmlTreeEx(r, resources, nil)
+}
+
+// Same as ParseXmlTree, but with opts, which can be nil. With ParseOptions.PartialResults, the tree
+// decoded until an error is returned along with it.
+func ParseXmlTreeEx(r io.Reader, resources *ResourceTable, opts *ParseOptions) (*ManifestElement, error) {
+	builder := &manifestTreeBuilder{}
+	if err := parseXml(r, builder, resources, opts); err != nil {
+		return builder.partialRoot(opts), err
+	}
+
+	if builder.root == nil {
+		return nil, fmt.Errorf("XML has no root element.")
+	}
+	return builder.root, nil
+}
+
+// Returns value of the attribute from the android namespace, or "" if not present.
+func (e *ManifestElement) AndroidAttr(name string) string {
+	return e.AttrValue(androidNamespace, name)
+}
+
+// Returns value of the attribute with the namespace URI and local name, or "" if not present.
+func (e *ManifestElement) AttrValue(space, name string) string {
+	if a := e.findAttr(space, name); a != nil {
+		return a.Value
+	}
+	return ""
+}
+
+func (e *ManifestElement) findAttr(space, name string) *xml.Attr {
+	for i := range e.Attr {
+		if e.Attr[i].Name.Space == space && e.Attr[i].Name.Local == name {
+			return &e.Attr[i]
+		}
+	}
+	return nil
+}
+
+// Returns the first child element with the local name, or nil.
+func (e *ManifestElement) Child(name string) *ManifestElement {
+	for _, c := range e.Children {
+		if c.Name.Local == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Returns all child elements with the local name.
+func (e *ManifestElement) ChildrenNamed(name string) []*ManifestElement {
+	var res []*ManifestElement
+	for _, c := range e.Children {
+		if c.Name.Local == name {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// Returns all descendant elements at the path of local names separated by "/",
+// e.g. "application/activity/intent-filter".
+func (e *ManifestElement) Find(path string) []*ManifestElement {
+	res := []*ManifestElement{e}
+	for _, name := range strings.Split(path, "/") {
+		var next []*ManifestElement
+		for _, el := range res {
+			next = append(next, el.ChildrenNamed(name)...)
+		}
+		res = next
+	}
+	return res
+}
+
+// Writes the element, its text and children into the encoder, without flushing it. The text is written
+// before the children.
+func (e *ManifestElement) Encode(encoder ManifestEncoder) error {
+	if err := encoder.EncodeToken(xml.StartElement{Name: e.Name, Attr: e.Attr}); err != nil {
+		return err
+	}
+	if e.Text != "" {
+		if err := encoder.EncodeToken(xml.CharData(e.Text)); err != nil {
+			return err
+		}
+	}
+	for _, c := range e.Children {
+		if err := c.Encode(encoder); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(xml.EndElement{Name: e.Name})
+}
+
+func (e *ManifestElement) clone() *ManifestElement {
+	res := &ManifestElement{
+		Name:     e.Name,
+		Attr:     append([]xml.Attr(nil), e.Attr...),
+		Text:     e.Text,
+		Children: make([]*ManifestElement, len(e.Children)),
+	}
+	for i, c := range e.Children {
+		res.Children[i] = c.clone()
+	}
+	return res
+}
+
+// Parses the AndroidManifest.xml into a tree.
+func (p *ApkParser) ParseManifestTree() (*ManifestElement, error) {
+	return p.ParseXmlTree("AndroidManifest.xml")
+}
+
+// Parses the binary XML file from the APK into a tree, with resources of the APK.
+func (p *ApkParser) ParseXmlTree(name string) (*ManifestElement, error) {
+	// Each of the entries with the name is parsed into a new tree, a failed one leaves nothing in the next.
+	builder := &manifestTreeBuilder{}
+	err := p.parseXmlEntries(name, func(r io.Reader) error {
+		builder = &manifestTreeBuilder{}
+		return parseXml(r, builder, p.resources, p.opts)
+	})
+	if err != nil {
+		return builder.partialRoot(p.opts), err
+	}
+
+	if builder.root == nil {
+		return nil, fmt.Errorf("%s has no root element.", name)
+	}
+	return builder.root, nil
+}
+
+// ManifestEncoder building the ManifestElement tree.
+type manifestTreeBuilder struct {
+	root  *ManifestElement
+	stack []*ManifestElement
+}
+
+func (b *manifestTreeBuilder) EncodeToken(t xml.Token) error {
+	switch tok := t.(type) {
+	case xml.StartElement:
+		e := &ManifestElement{Name: tok.Name, Attr: tok.Attr}
+		if len(b.stack) != 0 {
+			parent := b.stack[len(b.stack)-1]
+			parent.Children = append(parent.Children, e)
+		} else if b.root == nil {
+			b.root = e
+		}
+		b.stack = append(b.stack, e)
+	case xml.EndElement:
+		if len(b.stack) != 0 {
+			b.stack = b.stack[:len(b.stack)-1]
+		}
+	case xml.CharData:
+		if len(b.stack) != 0 {
+			b.stack[len(b.stack)-1].Text += string(tok)
+		}
+	}
+	return nil
+}
+
+// Returns the root decoded before an error if partial results were requested.
+func (b *manifestTreeBuilder) partialRoot(opts *ParseOptions) *ManifestElement {
+	if !opts.isPartialResults() {
+		return nil
+	}
+	return b.root
+}
+
+func (b *manifestTreeBuilder) Flush() error {
+	return nil
+}
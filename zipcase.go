@@ -0,0 +1,43 @@
+package apkparser
+
+import (
+	"sort"
+	"strings"
+)
+
+// Returns groups of names of the zip which differ only by case, like "classes.dex" and "Classes.dex".
+// Android looks the entries up case-sensitively, while tools extracting the APK to a case-insensitive
+// file system see only one of them. Both the groups and the names in them are sorted.
+func (zr *ZipReader) CaseCollisions() [][]string {
+	byFolded := make(map[string][]string)
+	for name := range zr.File {
+		folded := strings.ToLower(name)
+		byFolded[folded] = append(byFolded[folded], name)
+	}
+
+	var res [][]string
+	for _, names := range byFolded {
+		if len(names) > 1 {
+			sort.Strings(names)
+			res = append(res, names)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i][0] < res[j][0] })
+	return res
+}
+
+// Looks up the file ignoring case, as tools on case-insensitive file systems do. The exact match wins,
+// then the first matching file in the zip order. Returns nil if there is no such file.
+func (zr *ZipReader) FileFold(name string) *ZipReaderFile {
+	if f := zr.File[name]; f != nil {
+		return f
+	}
+
+	for _, f := range zr.FilesOrdered {
+		if strings.EqualFold(f.Name, name) {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package apkparser
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"io"
+	"sort"
+)
+
+// Digest of the content of one entry represented by ZipReaderFile.
+type ZipEntryDigest struct {
+	Sha256 [sha256.Size]byte
+	// Number of uncompressed bytes hashed.
+	Size int64
+	// Error while reading the entry, the digest covers the data read before it.
+	Err error
+	// Results of HashOptions.Extra, nil if none were requested.
+	Extra map[string][]byte
+}
+
+// Hashes the uncompressed content of every entry with this name, including the central directory
+// entries ignored as duplicates. At most limit bytes of each entry are hashed.
+func (zr *ZipReaderFile) SubEntryDigests(limit int64) []ZipEntryDigest {
+	if zr.zipEntry != nil {
+		res := make([]ZipEntryDigest, 0, 1+len(zr.zipDuplicates))
+		methods := append([]uint16{zr.zipEntryMethod}, zr.zipDupMethods...)
+		for i, f := range append([]*zip.File{zr.zipEntry}, zr.zipDuplicates...) {
+			rc, err := zr.openZipFile(f, methods[i])
+			if err != nil {
+				res = append(res, ZipEntryDigest{Err: err})
+				continue
+			}
+			res = append(res, digestZipEntry(rc, limit))
+			rc.Close()
+		}
+		return res
+	}
+
+	entries, _ := zr.SubEntries()
+	res := make([]ZipEntryDigest, 0, len(entries))
+	for _, e := range entries {
+		size := e.CompressedSize
+		if size < 0 {
+			size = 1 << 62
+		}
+
+		r, closer := zr.decompress(e.Method, io.NewSectionReader(&readAtWrapper{zr.zipFile}, e.Offset, size))
+		res = append(res, digestZipEntry(r, limit))
+		if closer != nil {
+			closer.Close()
+		}
+	}
+	return res
+}
+
+// Returns true if there are multiple entries with this name and their content differs.
+func (zr *ZipReaderFile) DuplicatesDiffer(limit int64) bool {
+	digests := zr.SubEntryDigests(limit)
+	for i := 1; i < len(digests); i++ {
+		if digests[i].Sha256 != digests[0].Sha256 || digests[i].Size != digests[0].Size ||
+			(digests[i].Err == nil) != (digests[0].Err == nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns sorted names present multiple times in the ZIP with different content, see
+// ZipReaderFile.DuplicatesDiffer.
+func (zr *ZipReader) DivergentDuplicates(limit int64) []string {
+	var res []string
+	for name, f := range zr.File {
+		if f.subEntryCount() > 1 && f.DuplicatesDiffer(limit) {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func digestZipEntry(r io.Reader, limit int64) (res ZipEntryDigest) {
+	defer recoverPanic(&res.Err)
+
+	h := sha256.New()
+	n, err := io.Copy(h, io.LimitReader(r, limit))
+
+	res = ZipEntryDigest{Size: n, Err: err}
+	h.Sum(res.Sha256[:0])
+	return res
+}
@@ -0,0 +1,129 @@
+package apkparser
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// Attempts to open ZIP starting at offset off of the reader, for example an APK appended to another file
+// or stored inside another archive. Offsets in the ZIP are relative to off. The ZIP ends with the first
+// end of central directory record belonging to it, or at the end of the reader if there's none.
+func OpenZipReaderAtOffset(r io.ReadSeeker, off int64) (*ZipReader, error) {
+	f := &readAtWrapper{r}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := findEmbeddedZipEnd(f, off)
+	if err != nil {
+		return nil, err
+	} else if end == -1 || end > size {
+		end = size
+	}
+	return OpenZipReader(io.NewSectionReader(f, off, end-off))
+}
+
+// Returns sorted offsets of the ZIP archives in the reader, found by their end of central directory
+// records. Offset 0 is included if the reader itself is a ZIP, so are archives stored in it uncompressed.
+func FindEmbeddedZipOffsets(r io.ReadSeeker) ([]int64, error) {
+	return FindEmbeddedZipOffsetsCtx(context.Background(), r)
+}
+
+// Same as FindEmbeddedZipOffsets, but with the context.
+func FindEmbeddedZipOffsetsCtx(ctx context.Context, r io.ReadSeeker) (offsets []int64, err error) {
+	defer recoverPanic(&err)
+
+	f := &readAtWrapper{r}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int64]bool)
+	var res []int64
+	for {
+		eocdOffset, err := findNextSignature(ctx, f, []byte{0x50, 0x4B, 0x05, 0x06})
+		if err != nil {
+			return nil, err
+		} else if eocdOffset == -1 {
+			break
+		}
+
+		if _, err := f.Seek(eocdOffset+4, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		start, _, ok := zipSpanAt(f, eocdOffset)
+		if !ok || seen[start] {
+			continue
+		}
+
+		seen[start] = true
+		res = append(res, start)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res, nil
+}
+
+// Returns the span of the ZIP whose end of central directory record is at eocdOffset, from its first byte
+// to the end of the record's comment. Returns false if the record doesn't point to a central directory
+// whose first record points to a local header, which also excludes empty ZIPs.
+func zipSpanAt(f *readAtWrapper, eocdOffset int64) (start, end int64, ok bool) {
+	var eocd [22]byte
+	if _, err := f.ReadAt(eocd[:], eocdOffset); err != nil {
+		return 0, 0, false
+	}
+
+	// The central directory is right before the record, its offset is relative to the start of the ZIP.
+	cdSize := int64(binary.LittleEndian.Uint32(eocd[12:]))
+	cdOffset := int64(binary.LittleEndian.Uint32(eocd[16:]))
+	start = eocdOffset - cdSize - cdOffset
+	if start < 0 {
+		return 0, 0, false
+	}
+
+	// Empty ZIPs are ignored, a fake record with no central directory could claim any start.
+	// The first central directory record must point to a local header.
+	if cdSize == 0 {
+		return 0, 0, false
+	} else if _, localOffset, _, err := readCentralDirectoryRecord(f, start+cdOffset); err != nil {
+		return 0, 0, false
+	} else if _, ok := localFileDataOffset(f, uint64(start)+localOffset); !ok {
+		return 0, 0, false
+	}
+	return start, eocdOffset + int64(len(eocd)) + int64(binary.LittleEndian.Uint16(eocd[20:])), true
+}
+
+// Returns the end of the ZIP starting at off, or -1 if its end of central directory is not found.
+func findEmbeddedZipEnd(f *readAtWrapper, off int64) (int64, error) {
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return -1, err
+	}
+
+	for {
+		eocdOffset, err := findNextSignature(context.Background(), f, []byte{0x50, 0x4B, 0x05, 0x06})
+		if eocdOffset == -1 || err != nil {
+			return -1, err
+		}
+
+		if _, err := f.Seek(eocdOffset+4, io.SeekStart); err != nil {
+			return -1, err
+		}
+
+		var eocd [22]byte
+		if _, err := f.ReadAt(eocd[:], eocdOffset); err != nil {
+			return -1, nil
+		}
+
+		cdSize := int64(binary.LittleEndian.Uint32(eocd[12:]))
+		cdOffset := int64(binary.LittleEndian.Uint32(eocd[16:]))
+		if eocdOffset-cdSize-cdOffset == off {
+			return eocdOffset + int64(len(eocd)) + int64(binary.LittleEndian.Uint16(eocd[20:])), nil
+		}
+	}
+}
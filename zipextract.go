@@ -0,0 +1,190 @@
+package apkparser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Returned by ZipReader.ExtractAll for entries which would be written outside of the target directory
+// or are symlinks.
+var ErrUnsafeZipEntry = errors.New("unsafe zip entry")
+
+// Returned by ZipReader.ExtractAll for entries whose sanitized name is the same as the name
+// of another extracted file, see ExtractOptions.SanitizeNames.
+var ErrZipEntryCollision = errors.New("zip entry collides with another entry")
+
+// Returned by ZipReader.ExtractAll for entries larger than ExtractOptions.MaxFileSize.
+var ErrZipEntryTooLarge = errors.New("zip entry is too large")
+
+// Options of ZipReader.ExtractAll, nil means defaults.
+type ExtractOptions struct {
+	// Maximum uncompressed size of one file, 0 means no limit.
+	MaxFileSize int64
+
+	// Rewrite names instead of failing on them: ".." and "." components and leading slashes
+	// are removed and characters not allowed by common filesystems are replaced with "_".
+	SanitizeNames bool
+}
+
+func (o *ExtractOptions) maxFileSize() int64 {
+	if o == nil || o.MaxFileSize <= 0 {
+		return -1
+	}
+	return o.MaxFileSize
+}
+
+func (o *ExtractOptions) sanitizeNames() bool {
+	return o != nil && o.SanitizeNames
+}
+
+// Extracts all files into dir, which is created if it doesn't exist. Entries pointing outside of dir
+// and symlinks are refused with ErrUnsafeZipEntry and existing symlinks and other non-regular files
+// in dir are never written through, not even as parent directories. Only the first entry of files
+// with duplicate names is extracted.
+func (zr *ZipReader) ExtractAll(dir string, opts *ExtractOptions) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	seen := make(map[*ZipReaderFile]bool)
+	extracted := make(map[string]string) // names of the extracted files and their raw names
+	for _, f := range zr.FilesOrdered {
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+
+		if err := zr.extractFile(dir, f, opts, extracted); err != nil {
+			return &ZipEntryError{Name: f.RawName, Err: err}
+		}
+	}
+	return nil
+}
+
+func (zr *ZipReader) extractFile(dir string, f *ZipReaderFile, opts *ExtractOptions, extracted map[string]string) error {
+	if hdr := f.ZipHeader(); hdr != nil && hdr.Mode()&os.ModeSymlink != 0 {
+		return ErrUnsafeZipEntry
+	}
+
+	name := f.Name
+	if opts.sanitizeNames() {
+		name = sanitizeZipName(f.RawName)
+	}
+
+	if name == "" || name == "." {
+		return nil
+	}
+
+	if path.IsAbs(name) || filepath.VolumeName(filepath.FromSlash(name)) != "" {
+		return ErrUnsafeZipEntry
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(dir, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ErrUnsafeZipEntry
+	}
+
+	if f.IsDir {
+		return mkdirInside(dir, name)
+	}
+
+	if other, prs := extracted[name]; prs {
+		return fmt.Errorf("%w: %s", ErrZipEntryCollision, other)
+	}
+	extracted[name] = f.RawName
+
+	if err := mkdirInside(dir, path.Dir(name)); err != nil {
+		return err
+	}
+
+	if fi, err := os.Lstat(target); err == nil && !fi.Mode().IsRegular() {
+		return ErrUnsafeZipEntry
+	}
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err = copyZipFile(out, f, opts.maxFileSize()); err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+
+	if err != nil {
+		os.Remove(target)
+	}
+	return err
+}
+
+// Creates the directories of the slash-separated name relative to dir one by one, refusing
+// existing components which are symlinks or not directories.
+func mkdirInside(dir, name string) error {
+	if name == "." {
+		return nil
+	}
+
+	current := dir
+	for _, part := range strings.Split(name, "/") {
+		current = filepath.Join(current, part)
+
+		fi, err := os.Lstat(current)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(current, 0755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !fi.IsDir():
+			return ErrUnsafeZipEntry
+		}
+	}
+	return nil
+}
+
+func copyZipFile(w io.Writer, f *ZipReaderFile, limit int64) error {
+	if err := f.Open(); err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if !f.Next() {
+		return io.ErrUnexpectedEOF
+	}
+
+	if limit < 0 {
+		_, err := io.Copy(w, f)
+		return err
+	}
+
+	n, err := io.Copy(w, io.LimitReader(f, limit+1))
+	if err == nil && n > limit {
+		err = ErrZipEntryTooLarge
+	}
+	return err
+}
+
+// Removes "..", "." and empty components and replaces characters invalid in file names.
+func sanitizeZipName(name string) string {
+	var parts []string
+	for _, p := range strings.Split(strings.ReplaceAll(name, "\\", "/"), "/") {
+		if p == "" || p == "." || p == ".." {
+			continue
+		}
+
+		parts = append(parts, strings.Map(func(r rune) rune {
+			if r < 0x20 || strings.ContainsRune(`<>:"|?*`, r) {
+				return '_'
+			}
+			return r
+		}, p))
+	}
+	return strings.Join(parts, "/")
+}
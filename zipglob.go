@@ -0,0 +1,25 @@
+package apkparser
+
+import (
+	"path"
+	"sort"
+)
+
+// Returns the files whose names match the pattern, sorted by name. The syntax is that of path.Match,
+// so "res/xml/*.xml" doesn't match files in subdirectories of res/xml. Returns path.ErrBadPattern
+// if the pattern is malformed.
+func (zr *ZipReader) Glob(pattern string) ([]*ZipReaderFile, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	var res []*ZipReaderFile
+	for name, f := range zr.File {
+		if matched, _ := path.Match(pattern, name); matched {
+			res = append(res, f)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
@@ -0,0 +1,89 @@
+package apkparser
+
+import (
+	"context"
+	"crypto/sha256"
+	"hash"
+	"io"
+)
+
+// Options of ZipReader.HashEntries and HashZipEntry, nil means defaults.
+type HashOptions struct {
+	// Maximum number of uncompressed bytes hashed of one entry, 0 means no limit.
+	MaxFileSize int64
+
+	// Additional hashes computed from the same data, e.g. fuzzy hashes like ssdeep or TLSH for similarity
+	// search. The results are in ZipEntryDigest.Extra under the same names.
+	Extra map[string]func() hash.Hash
+}
+
+func (o *HashOptions) maxFileSize() int64 {
+	if o == nil || o.MaxFileSize <= 0 {
+		return 1 << 62
+	}
+	return o.MaxFileSize
+}
+
+// Hashes the uncompressed content of the file, its first entry in case of duplicate names. Usable
+// from the WalkZipReader callback to hash entries while streaming the ZIP.
+func HashZipEntry(f *ZipReaderFile, opts *HashOptions) (res ZipEntryDigest) {
+	defer recoverPanic(&res.Err)
+
+	if err := f.Open(); err != nil {
+		return ZipEntryDigest{Err: err}
+	}
+	defer f.Close()
+
+	if !f.Next() {
+		return ZipEntryDigest{Err: io.ErrUnexpectedEOF}
+	}
+
+	h := sha256.New()
+	w := io.Writer(h)
+
+	var extra map[string]hash.Hash
+	if opts != nil && len(opts.Extra) != 0 {
+		extra = make(map[string]hash.Hash, len(opts.Extra))
+		writers := []io.Writer{h}
+		for name, newHash := range opts.Extra {
+			callback(func() { extra[name] = callbackHash{newHash()} })
+			writers = append(writers, extra[name])
+		}
+		w = io.MultiWriter(writers...)
+	}
+
+	n, err := io.Copy(w, io.LimitReader(f, opts.maxFileSize()))
+
+	res = ZipEntryDigest{Size: n, Err: err}
+	h.Sum(res.Sha256[:0])
+	if extra != nil {
+		res.Extra = make(map[string][]byte, len(extra))
+		for name, eh := range extra {
+			res.Extra[name] = eh.Sum(nil)
+		}
+	}
+	return res
+}
+
+// Returns name -> digest of all files in the ZIP, directories are skipped. Only the first entry
+// of files with duplicate names is hashed, see ZipReaderFile.SubEntryDigests for the rest.
+func (zr *ZipReader) HashEntries(opts *HashOptions) map[string]ZipEntryDigest {
+	res, _ := zr.HashEntriesCtx(context.Background(), opts)
+	return res
+}
+
+// Same as HashEntries, but stops when the context is done, returning the digests computed so far.
+func (zr *ZipReader) HashEntriesCtx(ctx context.Context, opts *HashOptions) (map[string]ZipEntryDigest, error) {
+	res := make(map[string]ZipEntryDigest, len(zr.File))
+	for _, f := range zr.FilesOrdered {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		if _, prs := res[f.Name]; prs || f.IsDir {
+			continue
+		}
+		res[f.Name] = HashZipEntry(f, opts)
+	}
+	return res, nil
+}
@@ -0,0 +1,33 @@
+package apkparser
+
+import (
+	"bytes"
+	"os"
+)
+
+// Same as OpenZip, but the file is memory mapped instead of read through the file descriptor, which
+// avoids copying the data to the buffers of the reader and syscalls for each read. Falls back to OpenZip
+// on platforms without mmap support and for empty files. Reading the ZipReader's files after
+// the file is truncated by someone else crashes the program, unlike with OpenZip.
+func OpenZipMmap(path string) (*ZipReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, unmap, err := mmapFile(f)
+	if err != nil {
+		return nil, err
+	} else if data == nil {
+		return OpenZip(path)
+	}
+
+	zr, err := OpenZipReader(bytes.NewReader(data))
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	zr.unmap = unmap
+	return zr, nil
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package apkparser
+
+import "os"
+
+// No mmap on this platform, OpenZipMmap uses OpenZip instead.
+func mmapFile(f *os.File) ([]byte, func() error, error) {
+	return nil, nil, nil
+}
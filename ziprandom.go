@@ -0,0 +1,211 @@
+package apkparser
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Limit of entries inflated into memory by ReadAt and Seek when ParseOptions.MaxAllocBytes is not set.
+const maxRandomAccessSize = 1 << 30
+
+// Random access to the data of one entry, see ZipReaderFile.ReadAt and ZipReaderFile.Seek.
+type zipRandomAccess struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// Reads len(p) bytes at off of the uncompressed data of the current entry (the first one if none is opened),
+// for parsers of formats like ELF or DEX which need random access. Stored entries are read directly from
+// the ZIP, the others are inflated into memory on the first call, up to ParseOptions.MaxAllocBytes or 1 GiB
+// if it's not set, larger entries fail with ErrZipEntryTooLarge. Doesn't change the position of Read.
+func (zr *ZipReaderFile) ReadAt(p []byte, off int64) (n int, err error) {
+	defer recoverPanic(&err)
+
+	ra, err := zr.randomAccess()
+	if err != nil {
+		return 0, err
+	}
+	return ra.r.ReadAt(p, off)
+}
+
+// Sets the position of the next Read in the current entry, with the same cost as ReadAt.
+// The file must be opened.
+func (zr *ZipReaderFile) Seek(offset int64, whence int) (pos int64, err error) {
+	defer recoverPanic(&err)
+
+	if zr.internalReader == nil && zr.zipEntry != nil {
+		return 0, errors.New("File is not opened.")
+	} else if zr.internalReader == nil && zr.curEntry == -1 && !zr.Next() {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	if zr.seeker == nil {
+		ra, err := zr.randomAccess()
+		if err != nil {
+			return 0, err
+		}
+
+		seeker := io.NewSectionReader(ra.r, 0, ra.size)
+		if _, err := seeker.Seek(zr.pos, io.SeekStart); err != nil {
+			return 0, err
+		}
+
+		if zr.internalCloser != nil {
+			zr.internalCloser.Close()
+			zr.internalCloser = nil
+		}
+		zr.internalReader = seeker
+		zr.seeker = seeker
+	}
+
+	pos, err = zr.seeker.Seek(offset, whence)
+	if err == nil {
+		zr.pos = pos
+	}
+	return pos, err
+}
+
+const writeToBufferSize = 1 << 20
+
+// Writes the rest of the current entry to w, see io.WriterTo, so io.Copy from the file is faster. Stored entries
+// which weren't read yet are copied from the ZIP with a large buffer. When there's no checksum to verify,
+// as in broken archives, and the ZIP is a file, w's ReadFrom can use sendfile and similar system calls.
+func (zr *ZipReaderFile) WriteTo(w io.Writer) (n int64, err error) {
+	defer recoverPanic(&err)
+
+	if zr.internalReader == nil && zr.zipEntry == nil && zr.curEntry == -1 && !zr.Next() {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	offset, size, stored := zr.storedData()
+	fresh := zr.pos == 0 && zr.seeker == nil && (zr.internalReader != nil) == (zr.zipEntry != nil)
+	if !stored || !fresh {
+		// Hides WriteTo from io.CopyBuffer.
+		return io.CopyBuffer(w, struct{ io.Reader }{zr}, make([]byte, 32*1024))
+	}
+
+	var src io.Reader = io.NewSectionReader(&readAtWrapper{zr.zipFile}, offset, size)
+	dst, hash := w, crc32.NewIEEE()
+	if zr.zipEntry != nil {
+		dst = io.MultiWriter(w, hash)
+	} else if file, ok := zr.osFile(); ok {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		src = io.LimitReader(file, size)
+	}
+
+	n, err = io.CopyBuffer(dst, src, make([]byte, writeToBufferSize))
+	zr.pos += n
+	if err == nil && n != size {
+		err = io.ErrUnexpectedEOF
+	} else if err == nil && zr.zipEntry != nil {
+		if f, _ := zr.selectedZipFile(); f.CRC32 != 0 && f.CRC32 != hash.Sum32() {
+			err = zip.ErrChecksum
+		}
+	}
+
+	// Further reads return io.EOF.
+	if zr.internalCloser != nil {
+		zr.internalCloser.Close()
+		zr.internalCloser = nil
+	}
+	zr.internalReader = bytes.NewReader(nil)
+	return n, err
+}
+
+// Returns the file the ZIP is read from, if it is one.
+func (zr *ZipReaderFile) osFile() (*os.File, bool) {
+	if wr, ok := zr.zipFile.(*readAtWrapper); ok {
+		file, ok := wr.ReadSeeker.(*os.File)
+		return file, ok
+	}
+	file, ok := zr.zipFile.(*os.File)
+	return file, ok
+}
+
+func (zr *ZipReaderFile) randomAccess() (*zipRandomAccess, error) {
+	if zr.random != nil {
+		return zr.random, nil
+	}
+
+	if offset, size, ok := zr.storedData(); ok {
+		zr.random = &zipRandomAccess{r: io.NewSectionReader(&readAtWrapper{zr.zipFile}, offset, size), size: size}
+		return zr.random, nil
+	}
+
+	var r io.Reader
+	if zr.zipEntry != nil {
+		rc, err := zr.openZipFile(zr.selectedZipFile())
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		r = rc
+	} else {
+		e := zr.currentSubEntry()
+		if e == nil {
+			return nil, errors.New("File has no entries.")
+		}
+
+		size := e.compressedSize()
+		if size < 0 {
+			size = 1 << 62
+		}
+
+		var closer io.Closer
+		r, closer = zr.decompress(e.method, io.NewSectionReader(&readAtWrapper{zr.zipFile}, e.offset, size))
+		if closer != nil {
+			defer closer.Close()
+		}
+	}
+
+	limit := zr.maxAlloc
+	if limit <= 0 {
+		limit = maxRandomAccessSize
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(data)) > limit {
+		return nil, ErrZipEntryTooLarge
+	}
+	zr.random = &zipRandomAccess{r: bytes.NewReader(data), size: int64(len(data))}
+	return zr.random, nil
+}
+
+// Returns location of the data of the current entry (the first one if none is opened) if it's stored
+// with a known size, so it can be read from the ZIP directly.
+func (zr *ZipReaderFile) storedData() (offset, size int64, ok bool) {
+	if zr.zipEntry != nil {
+		f, method := zr.selectedZipFile()
+		if method != zip.Store || zr.decompressors[method] != nil {
+			return 0, 0, false
+		}
+
+		offset, err := f.DataOffset()
+		return offset, int64(f.CompressedSize64), err == nil
+	}
+
+	e := zr.currentSubEntry()
+	if e == nil || e.method != zip.Store || zr.decompressors[e.method] != nil {
+		return 0, 0, false
+	}
+
+	size = e.compressedSize()
+	return e.offset, size, size >= 0
+}
+
+// Returns the compressed size from the recovered central directory or the local header, -1 if it's not known.
+func (e *zipReaderFileSubEntry) compressedSize() int64 {
+	if e.size < 0 && e.header != nil && (e.header.Flags&0x8 == 0 || e.header.CompressedSize64 != 0) {
+		return int64(e.header.CompressedSize64)
+	}
+	return e.size
+}
@@ -2,6 +2,8 @@ package apkparser
 
 import (
 	"archive/zip"
+	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -9,7 +11,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/klauspost/compress/flate"
 )
@@ -17,6 +21,9 @@ import (
 type zipReaderFileSubEntry struct {
 	offset int64
 	method uint16
+	header *zip.FileHeader // parsed from the local file header or recovered central directory
+	size   int64           // compressed size from the recovered central directory, -1 if unknown
+	local  bool            // found by scanning local headers, such entries are in reverse order
 }
 
 // This struct mimics of Reader from archive/zip. It's purpose is to handle
@@ -28,29 +35,66 @@ type ZipReader struct {
 	// multiple times in case of broken/crafted ZIPs
 	FilesOrdered []*ZipReaderFile
 
+	// Anomalies found while opening the archive.
+	Warnings []Warning
+
+	// Entries whose stored names were changed by cleaning them, e.g. "./AndroidManifest.xml",
+	// in the order they were found.
+	AlteredNames []ZipAlteredName
+
+	// Data before and after the ZIP, set only when opened with ParseOptions.LocateZipSpan.
+	// Offsets are relative to the start of the reader, see ExtraData.
+	ExtraRanges []ZipByteRange
+
 	zipFileReader io.ReadSeeker
+	outerReader   *readAtWrapper // the whole reader when only a span of it is the ZIP
 	ownedZipFile  *os.File
+	unmap         func() error // releases the mapping of OpenZipMmap
+	logger        Logger
+	decompressors map[uint16]zip.Decompressor
+	maxAlloc      int64 // ParseOptions.MaxAllocBytes
+}
+
+// Stored name of a ZIP entry and the cleaned name it's available under.
+type ZipAlteredName struct {
+	RawName string
+	Name    string
 }
 
 // This struct mimics of File from archive/zip. The main difference is it can represent
 // multiple actual entries in the ZIP file in case it has more than one with the same name.
 type ZipReaderFile struct {
-	Name  string
-	IsDir bool
+	// Cleaned name, see path.Clean
+	Name string
+	// Name as stored in the ZIP, of the first entry in case there are more of them.
+	RawName string
+	IsDir   bool
 
 	zipFile        io.ReadSeeker
 	internalReader io.Reader
 	internalCloser io.Closer
+	decompressors  map[uint16]zip.Decompressor // shared with the ZipReader
+	maxAlloc       int64                       // limits entries inflated by ReadAt and Seek
 
-	zipEntry *zip.File
+	zipEntry       *zip.File
+	zipEntryMethod uint16      // as stored in the zip, before Android's any-method-is-deflate fixup
+	zipDuplicates  []*zip.File // ignored central directory entries with the same name
+	zipDupMethods  []uint16    // zipEntryMethod of zipDuplicates
+	zipSelected    int         // index into zipEntry and zipDuplicates, see OpenEntry
 
 	entries  []zipReaderFileSubEntry
 	curEntry int
+
+	pos    int64             // position of Read in the current entry
+	random *zipRandomAccess  // set by ReadAt and Seek
+	seeker *io.SectionReader // internalReader after Seek
 }
 
 // Opens the file(s) for reading. After calling open, you should iterate through all possible entries that
 // go by that Filename with for f.Next() { f.Read()... }
-func (zr *ZipReaderFile) Open() error {
+func (zr *ZipReaderFile) Open() (err error) {
+	defer recoverPanic(&err)
+
 	if zr.internalReader != nil {
 		return errors.New("File is already opened.")
 	}
@@ -58,7 +102,8 @@ func (zr *ZipReaderFile) Open() error {
 	if zr.zipEntry != nil {
 		var err error
 		zr.curEntry = 0
-		rc, err := zr.zipEntry.Open()
+		zr.zipSelected = 0
+		rc, err := zr.openZipFile(zr.zipEntry, zr.zipEntryMethod)
 		if err != nil {
 			return err
 		}
@@ -88,16 +133,16 @@ func (zr *ZipReaderFile) Read(p []byte) (int, error) {
 			return 0, err
 		}
 
-		switch zr.entries[zr.curEntry].method {
-		case zip.Store:
-			zr.internalReader = zr.zipFile
-		default: // case zip.Deflate: // Android treats everything but 0 as deflate
-			rc := flate.NewReader(zr.zipFile)
-			zr.internalReader = rc
-			zr.internalCloser = rc
+		var data io.Reader = zr.zipFile
+		if size := zr.entries[zr.curEntry].size; size >= 0 {
+			data = io.LimitReader(zr.zipFile, size)
 		}
+
+		zr.internalReader, zr.internalCloser = zr.decompress(zr.entries[zr.curEntry].method, data)
 	}
-	return zr.internalReader.Read(p)
+	n, err := zr.internalReader.Read(p)
+	zr.pos += int64(n)
+	return n, err
 }
 
 // Moves this reader to the next file represented under it's Name. Returns false if there are no more to read.
@@ -125,17 +170,168 @@ func (zr *ZipReaderFile) Close() error {
 		}
 		zr.internalReader = nil
 	}
+	zr.pos, zr.random, zr.seeker = 0, nil, nil
 	return nil
 }
 
-// Get the file header from ZIP (can return nil with broken archives)
+// Get the file header from ZIP. For broken archives, the header is read from the local file header
+// or recovered central directory record of the current entry (the first one if none is opened)
+// and the sizes and CRC32 might be zero if they can't be determined.
 func (zr *ZipReaderFile) ZipHeader() *zip.FileHeader {
 	if zr.zipEntry != nil {
-		return &zr.zipEntry.FileHeader
+		f, _ := zr.selectedZipFile()
+		return &f.FileHeader
+	}
+
+	if e := zr.currentSubEntry(); e != nil {
+		return e.header
+	}
+	return nil
+}
+
+// Location of the raw (possibly compressed) data of one entry in the ZIP.
+type ZipEntryData struct {
+	Offset         int64
+	CompressedSize int64  // -1 if unknown
+	Method         uint16 // as stored in the zip
+}
+
+// Returns offset of the raw data of the current entry (the first one if none is opened) in the ZIP.
+func (zr *ZipReaderFile) DataOffset() (int64, error) {
+	if zr.zipEntry != nil {
+		f, _ := zr.selectedZipFile()
+		return f.DataOffset()
+	}
+
+	if e := zr.currentSubEntry(); e != nil {
+		return e.offset, nil
+	}
+	return 0, errors.New("File has no entries.")
+}
+
+// Returns data locations of all entries represented by this file, including the central directory entries
+// ignored as duplicates. The entries are in the central directory order, except for archives
+// with unreadable central directory, where the entries found by scanning local headers are in reverse order.
+func (zr *ZipReaderFile) SubEntries() ([]ZipEntryData, error) {
+	if zr.zipEntry != nil {
+		res := make([]ZipEntryData, 0, 1+len(zr.zipDuplicates))
+		for i, f := range append([]*zip.File{zr.zipEntry}, zr.zipDuplicates...) {
+			offset, err := f.DataOffset()
+			if err != nil {
+				return nil, err
+			}
+
+			method := zr.zipEntryMethod
+			if i != 0 {
+				method = zr.zipDupMethods[i-1]
+			}
+
+			res = append(res, ZipEntryData{
+				Offset:         offset,
+				CompressedSize: int64(f.CompressedSize64),
+				Method:         method,
+			})
+		}
+		return res, nil
+	}
+
+	res := make([]ZipEntryData, len(zr.entries))
+	for i, e := range zr.entries {
+		res[i] = ZipEntryData{
+			Offset:         e.offset,
+			CompressedSize: e.compressedSize(),
+			Method:         e.method,
+		}
+	}
+	return res, nil
+}
+
+// Returns indexes into SubEntries of the entry Android's installer and runtime use and of the entry
+// hashed by the JAR (v1) signature verifier. Android versions with the "Master Key" fixes refuse archives
+// with duplicate names, which is signalled by ok being false. Older versions installed the first entry
+// of the central directory, but the verifier hashed the last one.
+func (zr *ZipReaderFile) AndroidEntry() (installer, verifier int, ok bool) {
+	count := zr.subEntryCount()
+	if count == 0 {
+		return -1, -1, false
 	}
+
+	installer, verifier = 0, count-1
+	if zr.zipEntry == nil && zr.entries[0].local {
+		installer, verifier = verifier, installer
+	}
+	return installer, verifier, count == 1
+}
+
+// Returns the number of entries represented by this file, see SubEntries.
+func (zr *ZipReaderFile) EntryCount() int {
+	return zr.subEntryCount()
+}
+
+// Opens the entry with index i into SubEntries for reading, for example one of the duplicates
+// from AndroidEntry. The entry is read with Read directly, without calling Next.
+func (zr *ZipReaderFile) OpenEntry(i int) (err error) {
+	defer recoverPanic(&err)
+
+	if i < 0 || i >= zr.subEntryCount() {
+		return fmt.Errorf("Invalid entry index %d, the file has %d entries.", i, zr.subEntryCount())
+	}
+
+	zr.Close()
+	if zr.zipEntry == nil {
+		zr.curEntry = i
+		return nil
+	}
+
+	zr.zipSelected = i
+	rc, err := zr.openZipFile(zr.selectedZipFile())
+	if err != nil {
+		return err
+	}
+	zr.curEntry = 0
+	zr.internalReader = rc
+	zr.internalCloser = rc
 	return nil
 }
 
+// Returns the uncompressed size of the current entry (the first one if none is opened) from its header,
+// -1 if it's not known, as for entries of broken archives with unresolved data descriptors.
+func (zr *ZipReaderFile) Size() int64 {
+	if zr.zipEntry != nil {
+		f, _ := zr.selectedZipFile()
+		return int64(f.UncompressedSize64)
+	}
+
+	e := zr.currentSubEntry()
+	switch {
+	case e == nil:
+		return -1
+	case e.header != nil && (e.header.Flags&0x8 == 0 || e.header.CompressedSize64 != 0):
+		return int64(e.header.UncompressedSize64)
+	case e.method == zip.Store && e.compressedSize() >= 0:
+		return e.compressedSize()
+	default:
+		return -1
+	}
+}
+
+// Returns the central directory entry selected by OpenEntry and its method as stored in the zip.
+func (zr *ZipReaderFile) selectedZipFile() (*zip.File, uint16) {
+	if zr.zipSelected > 0 && zr.zipSelected <= len(zr.zipDuplicates) {
+		return zr.zipDuplicates[zr.zipSelected-1], zr.zipDupMethods[zr.zipSelected-1]
+	}
+	return zr.zipEntry, zr.zipEntryMethod
+}
+
+func (zr *ZipReaderFile) currentSubEntry() *zipReaderFileSubEntry {
+	if len(zr.entries) == 0 {
+		return nil
+	} else if zr.curEntry >= 0 && zr.curEntry < len(zr.entries) {
+		return &zr.entries[zr.curEntry]
+	}
+	return &zr.entries[0]
+}
+
 // Open, Read all bytes until limit and close the file
 func (zr *ZipReaderFile) ReadAll(limit int64) ([]byte, error) {
 	if err := zr.Open(); err != nil {
@@ -158,6 +354,41 @@ func (zr *ZipReaderFile) ReadAll(limit int64) ([]byte, error) {
 	return nil, lastErr
 }
 
+// Registers a decompressor for entries stored with the method, for example bzip2 or LZMA entries
+// of non-standard packers, see zip.Reader.RegisterDecompressor. Entries with other methods but 0
+// are read as deflate, as Android does.
+func (zr *ZipReader) RegisterDecompressor(method uint16, dcomp zip.Decompressor) {
+	zr.decompressors[method] = dcomp
+}
+
+// Opens the central directory entry with the decompressor registered for its method as stored in the zip.
+func (zr *ZipReaderFile) openZipFile(f *zip.File, method uint16) (io.ReadCloser, error) {
+	dcomp := zr.decompressors[method]
+	if dcomp == nil {
+		return f.Open()
+	}
+
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	return dcomp(raw), nil
+}
+
+// Returns reader of the uncompressed data of a sub-entry and its closer, which is nil for stored entries.
+func (zr *ZipReaderFile) decompress(method uint16, r io.Reader) (io.Reader, io.Closer) {
+	if dcomp := zr.decompressors[method]; dcomp != nil {
+		rc := dcomp(r)
+		return rc, rc
+	} else if method == zip.Store {
+		return r, nil
+	}
+
+	// Android treats everything but 0 as deflate
+	rc := flate.NewReader(r)
+	return rc, rc
+}
+
 // Closes this ZIP archive and all it's ZipReaderFile entries.
 func (zr *ZipReader) Close() error {
 	if zr.zipFileReader == nil {
@@ -174,6 +405,13 @@ func (zr *ZipReader) Close() error {
 		zr.ownedZipFile = nil
 	}
 
+	if zr.unmap != nil {
+		if uerr := zr.unmap(); err == nil {
+			err = uerr
+		}
+		zr.unmap = nil
+	}
+
 	zr.zipFileReader = nil
 	return err
 }
@@ -187,31 +425,38 @@ func (wr *readAtWrapper) ReadAt(b []byte, off int64) (n int, err error) {
 		return readerAt.ReadAt(b, off)
 	}
 
-	oldpos, err := wr.Seek(off, io.SeekCurrent)
+	oldpos, err := wr.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return
 	}
 
-	if _, err = wr.Seek(off, io.SeekStart); err != nil {
-		return
+	// io.ReaderAt must fill b or return an error, and the position is restored either way.
+	if _, err = wr.Seek(off, io.SeekStart); err == nil {
+		n, err = io.ReadFull(wr, b)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
 	}
 
-	if n, err = wr.Read(b); err != nil {
-		return
+	if _, seekErr := wr.Seek(oldpos, io.SeekStart); err == nil {
+		err = seekErr
 	}
-
-	_, err = wr.Seek(oldpos, io.SeekStart)
 	return
 }
 
 // Attempts to open ZIP for reading.
 func OpenZip(path string) (zr *ZipReader, err error) {
+	return OpenZipEx(path, nil)
+}
+
+// Same as OpenZip, but with opts, which can be nil, see OpenZipReaderEx.
+func OpenZipEx(path string, opts *ParseOptions) (zr *ZipReader, err error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 
-	zr, err = OpenZipReader(f)
+	zr, err = OpenZipReaderEx(f, opts)
 	if err != nil {
 		f.Close()
 	} else {
@@ -220,12 +465,30 @@ func OpenZip(path string) (zr *ZipReader, err error) {
 	return
 }
 
+// Attempts to open ZIP held in memory, without any use of the file system. The data must not be modified
+// while the ZipReader is in use.
+func OpenZipBytes(data []byte) (*ZipReader, error) {
+	return OpenZipReader(bytes.NewReader(data))
+}
+
 // Attempts to open ZIP for reading. Might Seek the reader to arbitrary
 // positions.
 func OpenZipReader(zipReader io.ReadSeeker) (zr *ZipReader, err error) {
+	return openZipReader(nil, zipReader)
+}
+
+func openZipReader(opts *ParseOptions, zipReader io.ReadSeeker) (zr *ZipReader, err error) {
+	defer recoverPanic(&err)
+
+	opts = opts.withState()
+	ctx := opts.context()
+
 	zr = &ZipReader{
 		File:          make(map[string]*ZipReaderFile),
 		zipFileReader: zipReader,
+		logger:        opts.logger(),
+		decompressors: make(map[uint16]zip.Decompressor),
+		maxAlloc:      opts.maxAllocBytes(),
 	}
 
 	f := &readAtWrapper{zipReader}
@@ -234,85 +497,270 @@ func OpenZipReader(zipReader io.ReadSeeker) (zr *ZipReader, err error) {
 	zipinfo, err = tryReadZip(f)
 	if err == nil {
 		for i, zf := range zipinfo.File {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+
+			method := zf.Method
+
 			// Android treats anything but 0 as deflate.
 			if zf.Method != zip.Store && zf.Method != zip.Deflate {
+				zr.warn(WarnUnknownCompressionMethod, "%s: method %d", zf.Name, zf.Method)
 				zipinfo.File[i].Method = zip.Deflate
 			}
 
-			cl := path.Clean(zf.Name)
-			if zr.File[cl] == nil {
+			cl := zr.cleanName(zf.Name)
+			if existing := zr.File[cl]; existing != nil {
+				zr.warn(WarnDuplicateZipEntry, "%s", cl)
+				existing.zipDuplicates = append(existing.zipDuplicates, zf)
+				existing.zipDupMethods = append(existing.zipDupMethods, method)
+			} else {
 				zf := &ZipReaderFile{
-					Name:     cl,
-					IsDir:    zf.FileInfo().IsDir(),
-					zipFile:  f,
-					zipEntry: zf,
+					Name:           cl,
+					RawName:        zf.Name,
+					IsDir:          zf.FileInfo().IsDir(),
+					zipFile:        f,
+					zipEntry:       zf,
+					zipEntryMethod: method,
+					decompressors:  zr.decompressors,
+					maxAlloc:       zr.maxAlloc,
 				}
 				zr.File[cl] = zf
 				zr.FilesOrdered = append(zr.FilesOrdered, zf)
 			}
+			opts.progressEntry()
 		}
 		return
 	}
 
+	zr.warn(WarnBrokenZip, "%s", err.Error())
+
+	var recovered bool
+	if recovered, err = zr.recoverCentralDirectory(opts, f); err != nil || recovered {
+		return
+	}
+
 	if _, err = f.Seek(0, io.SeekStart); err != nil {
 		return
 	}
 
 	var off int64
 	for {
-		off, err = findNextFileHeader(f)
+		off, err = findNextFileHeader(ctx, f)
 		if off == -1 || err != nil {
 			return
 		}
 
-		var nameLen, extraLen, method uint16
-		if _, err = f.Seek(off+8, 0); err != nil {
+		var header *zip.FileHeader
+		var fileOffset int64
+		if header, fileOffset, err = readLocalFileHeader(ctx, f, off); err != nil {
 			return
 		}
 
-		if err = binary.Read(f, binary.LittleEndian, &method); err != nil {
-			return
+		fileName := zr.cleanName(header.Name)
+		method := header.Method
+
+		if method != zip.Store && method != zip.Deflate {
+			zr.warn(WarnUnknownCompressionMethod, "%s: method %d", fileName, method)
 		}
 
-		if _, err = f.Seek(off+26, 0); err != nil {
-			return
+		zrf := zr.File[fileName]
+		if zrf != nil {
+			zr.warn(WarnDuplicateZipEntry, "%s", fileName)
+		} else {
+			zrf = &ZipReaderFile{
+				Name:          fileName,
+				RawName:       header.Name,
+				IsDir:         strings.HasSuffix(header.Name, "/"),
+				zipFile:       f,
+				curEntry:      -1,
+				decompressors: zr.decompressors,
+				maxAlloc:      zr.maxAlloc,
+			}
+			zr.File[fileName] = zrf
 		}
+		zr.FilesOrdered = append(zr.FilesOrdered, zrf)
 
-		if err = binary.Read(f, binary.LittleEndian, &nameLen); err != nil {
+		zrf.entries = append([]zipReaderFileSubEntry{zipReaderFileSubEntry{
+			offset: fileOffset,
+			method: method,
+			header: header,
+			size:   -1,
+			local:  true,
+		}}, zrf.entries...)
+		opts.progressEntry()
+
+		if _, err = f.Seek(off+4, 0); err != nil {
 			return
 		}
+	}
+}
 
-		if err = binary.Read(f, binary.LittleEndian, &extraLen); err != nil {
-			return
+// Looks for the central directory records directly, for archives with damaged end of central directory.
+// Only records pointing to a local file header are used. Returns false if none were found.
+func (zr *ZipReader) recoverCentralDirectory(opts *ParseOptions, f *readAtWrapper) (bool, error) {
+	ctx := opts.context()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	var found int
+	for {
+		off, err := findNextSignature(ctx, f, []byte{0x50, 0x4B, 0x01, 0x02})
+		if err != nil {
+			return false, err
+		} else if off == -1 {
+			break
 		}
 
-		buf := make([]byte, nameLen)
-		if _, err = f.ReadAt(buf, off+30); err != nil {
-			return
+		if _, err := f.Seek(off+4, io.SeekStart); err != nil {
+			return false, err
 		}
 
-		fileName := path.Clean(string(buf))
-		fileOffset := off + 30 + int64(nameLen) + int64(extraLen)
+		header, localOffset, _, err := readCentralDirectoryRecord(f, off)
+		if err != nil {
+			continue
+		}
+
+		dataOffset, ok := localFileDataOffset(f, localOffset)
+		if !ok || header.CompressedSize64 > 1<<62 {
+			continue
+		}
+
+		fileName := zr.cleanName(header.Name)
+		if header.Method != zip.Store && header.Method != zip.Deflate {
+			zr.warn(WarnUnknownCompressionMethod, "%s: method %d", fileName, header.Method)
+		}
 
 		zrf := zr.File[fileName]
-		if zrf == nil {
+		if zrf != nil {
+			zr.warn(WarnDuplicateZipEntry, "%s", fileName)
+		} else {
 			zrf = &ZipReaderFile{
-				Name:     fileName,
-				zipFile:  f,
-				curEntry: -1,
+				Name:          fileName,
+				RawName:       header.Name,
+				IsDir:         strings.HasSuffix(header.Name, "/"),
+				zipFile:       f,
+				curEntry:      -1,
+				decompressors: zr.decompressors,
+				maxAlloc:      zr.maxAlloc,
 			}
 			zr.File[fileName] = zrf
 		}
 		zr.FilesOrdered = append(zr.FilesOrdered, zrf)
 
-		zrf.entries = append([]zipReaderFileSubEntry{zipReaderFileSubEntry{
-			offset: fileOffset,
-			method: method,
-		}}, zrf.entries...)
+		zrf.entries = append(zrf.entries, zipReaderFileSubEntry{
+			offset: dataOffset,
+			method: header.Method,
+			header: header,
+			size:   int64(header.CompressedSize64),
+		})
+		found++
+		opts.progressEntry()
+	}
 
-		if _, err = f.Seek(off+4, 0); err != nil {
-			return
-		}
+	if found == 0 {
+		return false, nil
+	}
+
+	zr.warn(WarnRecoveredCentralDirectory, "%d records found", found)
+	return true, nil
+}
+
+// Parses the central directory record at off, returns its header, offset of the local file header
+// and length of the record.
+func readCentralDirectoryRecord(f *readAtWrapper, off int64) (*zip.FileHeader, uint64, int64, error) {
+	const recordLen = 46
+
+	var fixed [recordLen]byte
+	if _, err := f.ReadAt(fixed[:], off); err != nil {
+		return nil, 0, 0, err
+	}
+
+	var rec struct {
+		Signature         uint32
+		CreatorVersion    uint16
+		ReaderVersion     uint16
+		Flags             uint16
+		Method            uint16
+		ModifiedTime      uint16
+		ModifiedDate      uint16
+		CRC32             uint32
+		CompressedSize    uint32
+		UncompressedSize  uint32
+		NameLen           uint16
+		ExtraLen          uint16
+		CommentLen        uint16
+		DiskNumber        uint16
+		InternalAttrs     uint16
+		ExternalAttrs     uint32
+		LocalHeaderOffset uint32
+	}
+	binary.Read(bytes.NewReader(fixed[:]), binary.LittleEndian, &rec)
+
+	if rec.Signature != 0x02014b50 {
+		return nil, 0, 0, fmt.Errorf("Invalid central directory record signature at %d.", off)
+	}
+
+	buf := make([]byte, int(rec.NameLen)+int(rec.ExtraLen)+int(rec.CommentLen))
+	if _, err := f.ReadAt(buf, off+recordLen); err != nil {
+		return nil, 0, 0, err
+	}
+
+	header := &zip.FileHeader{
+		Name:               string(buf[:rec.NameLen]),
+		CreatorVersion:     rec.CreatorVersion,
+		ReaderVersion:      rec.ReaderVersion,
+		Flags:              rec.Flags,
+		Method:             rec.Method,
+		ModifiedTime:       rec.ModifiedTime,
+		ModifiedDate:       rec.ModifiedDate,
+		Modified:           msDosTimeToTime(rec.ModifiedDate, rec.ModifiedTime),
+		CRC32:              rec.CRC32,
+		CompressedSize:     rec.CompressedSize,
+		UncompressedSize:   rec.UncompressedSize,
+		CompressedSize64:   uint64(rec.CompressedSize),
+		UncompressedSize64: uint64(rec.UncompressedSize),
+		Extra:              buf[rec.NameLen : int(rec.NameLen)+int(rec.ExtraLen)],
+		Comment:            string(buf[int(rec.NameLen)+int(rec.ExtraLen):]),
+		ExternalAttrs:      rec.ExternalAttrs,
+	}
+	localOffset := uint64(rec.LocalHeaderOffset)
+	readZip64Extra(header, &localOffset)
+
+	return header, localOffset, recordLen + int64(len(buf)), nil
+}
+
+// Returns offset of the entry data if there's a local file header at off.
+func localFileDataOffset(f *readAtWrapper, off uint64) (int64, bool) {
+	if off > 1<<62 {
+		return 0, false
+	}
+
+	var hdr [30]byte
+	if _, err := f.ReadAt(hdr[:], int64(off)); err != nil || binary.LittleEndian.Uint32(hdr[:]) != 0x04034b50 {
+		return 0, false
+	}
+
+	nameLen := binary.LittleEndian.Uint16(hdr[26:])
+	extraLen := binary.LittleEndian.Uint16(hdr[28:])
+	return int64(off) + 30 + int64(nameLen) + int64(extraLen), true
+}
+
+// Returns the cleaned name, recording it in AlteredNames if it differs from the stored one.
+func (zr *ZipReader) cleanName(raw string) string {
+	name := path.Clean(raw)
+	if raw != "" && name != strings.TrimSuffix(raw, "/") {
+		zr.AlteredNames = append(zr.AlteredNames, ZipAlteredName{RawName: raw, Name: name})
+	}
+	return name
+}
+
+func (zr *ZipReader) warn(kind WarningKind, format string, args ...interface{}) {
+	w := Warning{Kind: kind, Message: fmt.Sprintf(format, args...)}
+	zr.Warnings = append(zr.Warnings, w)
+	if zr.logger != nil {
+		zr.logger.Warning(w)
 	}
 }
 
@@ -338,7 +786,167 @@ func tryReadZip(f *readAtWrapper) (r *zip.Reader, err error) {
 	return
 }
 
-func findNextFileHeader(f io.ReadSeeker) (offset int64, err error) {
+// Parses the local file header at off, returns the header and offset of the entry data.
+func readLocalFileHeader(ctx context.Context, f *readAtWrapper, off int64) (*zip.FileHeader, int64, error) {
+	var hdr struct {
+		Signature        uint32
+		ReaderVersion    uint16
+		Flags            uint16
+		Method           uint16
+		ModifiedTime     uint16
+		ModifiedDate     uint16
+		CRC32            uint32
+		CompressedSize   uint32
+		UncompressedSize uint32
+		NameLen          uint16
+		ExtraLen         uint16
+	}
+
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+
+	if err := binary.Read(f, binary.LittleEndian, &hdr); err != nil {
+		return nil, 0, err
+	}
+
+	buf := make([]byte, int(hdr.NameLen)+int(hdr.ExtraLen))
+	if _, err := f.ReadAt(buf, off+30); err != nil {
+		return nil, 0, err
+	}
+
+	header := &zip.FileHeader{
+		Name:               string(buf[:hdr.NameLen]),
+		ReaderVersion:      hdr.ReaderVersion,
+		Flags:              hdr.Flags,
+		Method:             hdr.Method,
+		ModifiedTime:       hdr.ModifiedTime,
+		ModifiedDate:       hdr.ModifiedDate,
+		Modified:           msDosTimeToTime(hdr.ModifiedDate, hdr.ModifiedTime),
+		CRC32:              hdr.CRC32,
+		CompressedSize:     hdr.CompressedSize,
+		UncompressedSize:   hdr.UncompressedSize,
+		CompressedSize64:   uint64(hdr.CompressedSize),
+		UncompressedSize64: uint64(hdr.UncompressedSize),
+		Extra:              buf[hdr.NameLen:],
+	}
+	readZip64Extra(header, nil)
+
+	dataOffset := off + 30 + int64(hdr.NameLen) + int64(hdr.ExtraLen)
+
+	// Sizes and CRC are in the data descriptor after the data.
+	if hdr.Flags&0x8 != 0 && header.CompressedSize64 == 0 {
+		if err := readDataDescriptor(ctx, f, dataOffset, header); err != nil {
+			return nil, 0, err
+		}
+	}
+	return header, dataOffset, nil
+}
+
+// Fills the 64-bit sizes and localOffset, if not nil, from zip64 extended information extra field.
+func readZip64Extra(header *zip.FileHeader, localOffset *uint64) {
+	extra := header.Extra
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if size > len(extra)-4 {
+			return
+		}
+
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != 0x0001 {
+			continue
+		}
+
+		if header.UncompressedSize == ^uint32(0) && len(field) >= 8 {
+			header.UncompressedSize64 = binary.LittleEndian.Uint64(field)
+			field = field[8:]
+		}
+		if header.CompressedSize == ^uint32(0) && len(field) >= 8 {
+			header.CompressedSize64 = binary.LittleEndian.Uint64(field)
+			field = field[8:]
+		}
+		if localOffset != nil && *localOffset == uint64(^uint32(0)) && len(field) >= 8 {
+			*localOffset = binary.LittleEndian.Uint64(field)
+		}
+		return
+	}
+}
+
+// Looks for the signed data descriptor following the entry data starting at dataOffset, up to the next
+// local file header, so that entries without one don't make each other scan the rest of the file.
+// The descriptor is accepted only if its compressed size matches the distance from dataOffset.
+func readDataDescriptor(ctx context.Context, f *readAtWrapper, dataOffset int64, header *zip.FileHeader) error {
+	descSignature := []byte{0x50, 0x4B, 0x07, 0x08}
+
+	if _, err := f.Seek(dataOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	end, err := findNextFileHeader(ctx, f)
+	if err != nil {
+		return err
+	} else if end == -1 {
+		if end, err = f.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	section := io.NewSectionReader(f, 0, end)
+
+	for start := dataOffset; ; {
+		if _, err := section.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+
+		descOffset, err := findNextSignature(ctx, section, descSignature)
+		if descOffset == -1 || err != nil {
+			return err
+		}
+
+		var desc struct {
+			CRC32            uint32
+			CompressedSize   uint32
+			UncompressedSize uint32
+		}
+		buf := make([]byte, 12)
+		if _, err := f.ReadAt(buf, descOffset+4); err != nil {
+			return err
+		}
+		binary.Read(bytes.NewReader(buf), binary.LittleEndian, &desc)
+
+		if int64(desc.CompressedSize) == descOffset-dataOffset {
+			header.CRC32 = desc.CRC32
+			header.CompressedSize = desc.CompressedSize
+			header.UncompressedSize = desc.UncompressedSize
+			header.CompressedSize64 = uint64(desc.CompressedSize)
+			header.UncompressedSize64 = uint64(desc.UncompressedSize)
+			return nil
+		}
+		start = descOffset + 1
+	}
+}
+
+func msDosTimeToTime(dosDate, dosTime uint16) time.Time {
+	return time.Date(
+		int(dosDate>>9+1980),
+		time.Month(dosDate>>5&0xf),
+		int(dosDate&0x1f),
+		int(dosTime>>11),
+		int(dosTime>>5&0x3f),
+		int(dosTime&0x1f*2),
+		0,
+		time.UTC,
+	)
+}
+
+func findNextFileHeader(ctx context.Context, f io.ReadSeeker) (offset int64, err error) {
+	return findNextSignature(ctx, f, []byte{0x50, 0x4B, 0x03, 0x04})
+}
+
+// Returns offset of the next occurrence of toCmp from the current position, or -1 if there's none.
+// Keeps the position of f.
+func findNextSignature(ctx context.Context, f io.ReadSeeker, toCmp []byte) (offset int64, err error) {
 	start, err := f.Seek(0, 1)
 	if err != nil {
 		return -1, err
@@ -350,12 +958,15 @@ func findNextFileHeader(f io.ReadSeeker) (offset int64, err error) {
 	}()
 
 	buf := make([]byte, 64*1024)
-	toCmp := []byte{0x50, 0x4B, 0x03, 0x04}
 
 	ok := 0
 	offset = start
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return -1, err
+		}
+
 		n, err := f.Read(buf)
 		if err != nil && err != io.EOF {
 			return -1, err
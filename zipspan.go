@@ -0,0 +1,90 @@
+package apkparser
+
+import (
+	"context"
+	"io"
+)
+
+// Range of bytes in a reader, see ZipReader.ExtraRanges.
+type ZipByteRange struct {
+	Offset int64
+	Size   int64
+}
+
+// Same as OpenZipReader, but with opts, which can be nil. Only Context, LocateZipSpan, Progress and Logger apply.
+func OpenZipReaderEx(r io.ReadSeeker, opts *ParseOptions) (zr *ZipReader, err error) {
+	if !opts.isLocateZipSpan() {
+		return openZipReader(opts, r)
+	}
+
+	defer recoverPanic(&err)
+
+	f := &readAtWrapper{r}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, err := findZipSpan(opts.context(), f)
+	if err != nil {
+		return nil, err
+	} else if end == -1 {
+		// No usable end of central directory, the local headers are scanned instead.
+		return openZipReader(opts, r)
+	} else if end > size {
+		end = size
+	}
+
+	if zr, err = openZipReader(opts, io.NewSectionReader(f, start, end-start)); err != nil {
+		return nil, err
+	}
+	zr.outerReader = f
+
+	if start > 0 {
+		zr.ExtraRanges = append(zr.ExtraRanges, ZipByteRange{Offset: 0, Size: start})
+	}
+	if end < size {
+		zr.ExtraRanges = append(zr.ExtraRanges, ZipByteRange{Offset: end, Size: size - end})
+	}
+
+	for _, rng := range zr.ExtraRanges {
+		zr.warn(WarnZipExtraData, "%d bytes at offset %d", rng.Size, rng.Offset)
+	}
+	return zr, nil
+}
+
+// Returns reader of the data in rng of the reader the ZIP was opened from, e.g. one of ExtraRanges.
+// Must not be used after the ZipReader is closed.
+func (zr *ZipReader) ExtraData(rng ZipByteRange) *io.SectionReader {
+	f := zr.outerReader
+	if f == nil {
+		f = &readAtWrapper{zr.zipFileReader}
+	}
+	return io.NewSectionReader(f, rng.Offset, rng.Size)
+}
+
+// Returns the span of the largest ZIP in the reader, which is the APK itself rather than archives stored
+// in it or small ones a loader carries. end is -1 if there's no ZIP.
+func findZipSpan(ctx context.Context, f *readAtWrapper) (start, end int64, err error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, -1, err
+	}
+
+	end = -1
+	for {
+		eocdOffset, err := findNextSignature(ctx, f, []byte{0x50, 0x4B, 0x05, 0x06})
+		if err != nil {
+			return 0, -1, err
+		} else if eocdOffset == -1 {
+			return start, end, nil
+		}
+
+		if _, err := f.Seek(eocdOffset+4, io.SeekStart); err != nil {
+			return 0, -1, err
+		}
+
+		if s, e, ok := zipSpanAt(f, eocdOffset); ok && (end == -1 || e-s >= end-start) {
+			start, end = s, e
+		}
+	}
+}
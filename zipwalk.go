@@ -0,0 +1,169 @@
+package apkparser
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Calls cb for the entries of the ZIP one by one in the order of the central directory, without reading
+// the whole central directory upfront and building the File map like OpenZipReader does. Iteration stops
+// when cb returns false. If the end of central directory can't be found, local file headers are scanned
+// instead.
+//
+// Unlike ZipReader, entries with duplicate names are passed to cb separately. Each ZipReaderFile
+// has exactly one entry and can be read only until cb returns.
+func WalkZipReader(r io.ReadSeeker, cb func(f *ZipReaderFile) bool) error {
+	return WalkZipReaderCtx(context.Background(), r, cb)
+}
+
+// Same as WalkZipReader, but with the context.
+func WalkZipReaderCtx(ctx context.Context, r io.ReadSeeker, cb func(f *ZipReaderFile) bool) (err error) {
+	defer recoverPanic(&err)
+
+	f := &readAtWrapper{r}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	cdOffset, count, ok := findCentralDirectory(f, size)
+	if !ok {
+		return walkLocalHeaders(ctx, f, cb)
+	}
+
+	off := cdOffset
+	for i := uint64(0); i < count; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, localOffset, recordLen, err := readCentralDirectoryRecord(f, off)
+		if err != nil {
+			// Nothing was passed to cb yet, the directory location is probably bogus.
+			if i == 0 {
+				return walkLocalHeaders(ctx, f, cb)
+			}
+			return err
+		}
+		off += recordLen
+
+		dataOffset, ok := localFileDataOffset(f, localOffset)
+		if !ok {
+			return fmt.Errorf("Invalid local file header offset %d of %s", localOffset, header.Name)
+		}
+
+		zrf := &ZipReaderFile{
+			Name:     path.Clean(header.Name),
+			RawName:  header.Name,
+			IsDir:    strings.HasSuffix(header.Name, "/"),
+			zipFile:  f,
+			curEntry: -1,
+			entries: []zipReaderFileSubEntry{{
+				offset: dataOffset,
+				method: header.Method,
+				header: header,
+				size:   int64(header.CompressedSize64),
+			}},
+		}
+
+		var cont bool
+		callback(func() { cont = cb(zrf) })
+		zrf.Close()
+		if !cont {
+			break
+		}
+	}
+	return nil
+}
+
+func walkLocalHeaders(ctx context.Context, f *readAtWrapper, cb func(f *ZipReaderFile) bool) error {
+	var off int64
+	for {
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			return err
+		}
+
+		var err error
+		if off, err = findNextFileHeader(ctx, f); off == -1 || err != nil {
+			return err
+		}
+
+		header, dataOffset, err := readLocalFileHeader(ctx, f, off)
+		if err != nil {
+			return err
+		}
+
+		zrf := &ZipReaderFile{
+			Name:     path.Clean(header.Name),
+			RawName:  header.Name,
+			IsDir:    strings.HasSuffix(header.Name, "/"),
+			zipFile:  f,
+			curEntry: -1,
+			entries: []zipReaderFileSubEntry{{
+				offset: dataOffset,
+				method: header.Method,
+				header: header,
+				size:   -1,
+			}},
+		}
+
+		var cont bool
+		callback(func() { cont = cb(zrf) })
+		zrf.Close()
+		if !cont {
+			return nil
+		}
+		off += 4
+	}
+}
+
+// Looks for the end of central directory record, returns offset of the central directory
+// and the number of its records.
+func findCentralDirectory(f *readAtWrapper, size int64) (int64, uint64, bool) {
+	const eocdLen = 22
+	const maxCommentLen = 0xFFFF
+
+	searchLen := int64(eocdLen + maxCommentLen)
+	if searchLen > size {
+		searchLen = size
+	}
+	if searchLen < eocdLen {
+		return 0, 0, false
+	}
+
+	buf := make([]byte, searchLen)
+	if _, err := f.ReadAt(buf, size-searchLen); err != nil {
+		return 0, 0, false
+	}
+
+	for i := len(buf) - eocdLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(buf[i:]) != 0x06054b50 {
+			continue
+		}
+
+		eocd := buf[i:]
+		count := uint64(binary.LittleEndian.Uint16(eocd[10:]))
+		cdOffset := uint64(binary.LittleEndian.Uint32(eocd[16:]))
+
+		// zip64 end of central directory locator precedes the record
+		if (count == 0xFFFF || cdOffset == 0xFFFFFFFF) && i >= 20 && binary.LittleEndian.Uint32(buf[i-20:]) == 0x07064b50 {
+			var eocd64 [56]byte
+			eocd64Offset := int64(binary.LittleEndian.Uint64(buf[i-20+8:]))
+			if _, err := f.ReadAt(eocd64[:], eocd64Offset); err == nil && binary.LittleEndian.Uint32(eocd64[:]) == 0x06064b50 {
+				count = binary.LittleEndian.Uint64(eocd64[32:])
+				cdOffset = binary.LittleEndian.Uint64(eocd64[48:])
+			}
+		}
+
+		if cdOffset >= uint64(size) {
+			continue
+		}
+		return int64(cdOffset), count, true
+	}
+	return 0, 0, false
+}